@@ -0,0 +1,139 @@
+package reliable
+
+import "time"
+
+// AckState describes the reliable packets read from a peer that have yet to be acked.
+type AckState struct {
+	Pending uint16        // number of reliable packets read since an ack for the newest read packet was last written
+	Oldest  time.Time     // when the oldest pending packet was read
+	Full    bool          // whether or not a full ack bitset of consecutive packets is ready to be acked
+	RTT     time.Duration // smoothed round-trip time to our peer, or zero if not yet sampled
+	AckLost bool          // whether or not a standalone ack was lost since an ack for the newest read packet was written
+	Now     time.Time
+}
+
+// AckPolicy decides when standalone acks are written to a peer. Acks are additionally piggybacked onto every packet
+// written to a peer regardless of policy.
+type AckPolicy interface {
+	// AckOnRead reports whether or not an ack should be written right after a packet is read.
+	AckOnRead(state AckState) bool
+
+	// AckOnUpdate reports whether or not an ack should be written on a periodic update.
+	AckOnUpdate(state AckState) bool
+}
+
+// BitsetAckPolicy writes an ack every time a full ack bitset of consecutive packets was read. It is the default ack
+// policy, and writes the fewest acks, though acks for the tail end of a burst of packets only get written once they
+// are piggybacked onto a packet, or once our peer resends them.
+type BitsetAckPolicy struct{}
+
+func (BitsetAckPolicy) AckOnRead(state AckState) bool { return state.Full }
+func (BitsetAckPolicy) AckOnUpdate(AckState) bool     { return false }
+
+// EveryPacketAckPolicy writes an ack for every packet read, which suits request/response workloads.
+type EveryPacketAckPolicy struct{}
+
+func (EveryPacketAckPolicy) AckOnRead(state AckState) bool { return state.Pending > 0 }
+func (EveryPacketAckPolicy) AckOnUpdate(AckState) bool     { return false }
+
+// EveryNAckPolicy writes an ack once N packets were read since the last ack.
+type EveryNAckPolicy struct{ N uint16 }
+
+func (p EveryNAckPolicy) AckOnRead(state AckState) bool { return state.Full || state.Pending >= p.N }
+func (EveryNAckPolicy) AckOnUpdate(AckState) bool       { return false }
+
+// DelayedAckPolicy holds back acks for up to Delay, such that they may be piggybacked onto packets written in the
+// meantime. Acks are only ever written on updates, so Delay is rounded up to the update period. Acks are no longer
+// held back once a standalone ack is found to be lost.
+type DelayedAckPolicy struct{ Delay time.Duration }
+
+func (DelayedAckPolicy) AckOnRead(state AckState) bool { return state.Full }
+func (p DelayedAckPolicy) AckOnUpdate(state AckState) bool {
+	return state.Pending > 0 && (state.AckLost || state.Now.Sub(state.Oldest) >= p.Delay)
+}
+
+// AdaptiveAckPolicy holds back acks for up to a quarter of the round-trip time to our peer, such that acks are
+// written promptly on fast links and get piggybacked onto other packets more often on slow links. Until the
+// round-trip time is sampled, every packet is acked. Acks are no longer held back once a standalone ack is found to
+// be lost.
+type AdaptiveAckPolicy struct{}
+
+func (AdaptiveAckPolicy) AckOnRead(state AckState) bool {
+	return state.Full || (state.Pending > 0 && state.Now.Sub(state.Oldest) >= state.RTT/4)
+}
+
+func (AdaptiveAckPolicy) AckOnUpdate(state AckState) bool {
+	return state.Pending > 0 && (state.AckLost || state.Now.Sub(state.Oldest) >= state.RTT/4)
+}
+
+func (c *Conn) ackState(now time.Time) AckState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	full := true
+	for i := uint16(0); i < ACKBitsetSize; i++ {
+		if c.rq[(c.lui+i)%uint16(len(c.rq))] != uint32(c.lui+i) {
+			full = false
+			break
+		}
+	}
+
+	return AckState{
+		Pending: c.pendingAcks,
+		Oldest:  c.pendingAcksSince,
+		Full:    full,
+		RTT:     c.rtt,
+		AckLost: c.ackLost,
+		Now:     now,
+	}
+}
+
+// trackPendingAck tracks a reliable packet that was read as pending to be acked.
+func (c *Conn) trackPendingAck() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pendingAcks == 0 {
+		c.pendingAcksSince = time.Now()
+	}
+	if c.pendingAcks < ^uint16(0) {
+		c.pendingAcks++
+	}
+}
+
+// trackAckWritten clears all pending acks should ack cover the newest packet read.
+func (c *Conn) trackAckWritten(ack uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ack == c.ri-1 {
+		c.pendingAcks = 0
+		c.ackLost = false
+	}
+}
+
+// writeAcks writes acks for every full ack bitset of consecutive packets that was read, or a single ack for the
+// newest packet read should there be none.
+func (c *Conn) writeAcks() error {
+	wrote, err := c.writeAcksIfNecessary()
+	if err != nil || wrote {
+		return err
+	}
+
+	c.mu.Lock()
+	pending, ack := c.pendingAcks > 0, c.ri-1
+	c.mu.Unlock()
+
+	if !pending {
+		return nil
+	}
+
+	return c.writeAck(ack)
+}
+
+func (c *Conn) writeAcksOnUpdate() error {
+	if !c.ackPolicy.AckOnUpdate(c.ackState(time.Now())) || c.deferAcks() {
+		return nil
+	}
+	return c.writeAcks()
+}
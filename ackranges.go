@@ -0,0 +1,142 @@
+package reliable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/lithdew/bytesutil"
+	"github.com/lithdew/reliable/sequence"
+	"io"
+	"math"
+)
+
+// Ack range frames are control packets describing which packets out of our entire read buffer were read, rather than
+// only out of the last ACKBitsetSize packets as the ack bitset of every packet does. On very lossy links, or after an
+// outage, they let our peer stop resending packets we have long since read whose acks were lost.
+//
+// A frame carries the sequence number of the newest packet read, followed by the lengths of alternating runs of read
+// and unread packets as uvarints, going back from the newest packet read. The first run is of read packets.
+
+// maxAckRuns is the max number of runs an ack range frame describes. Runs beyond it are left out.
+const maxAckRuns = 64
+
+// appendAckRanges appends an ack range frame describing our read buffer to dst, reporting false should the ack bitset
+// already describe every packet read that the frame would. It must be called with c.mu held.
+func (c *Conn) appendAckRanges(dst []byte) ([]byte, bool) {
+	newest := c.ri - 1
+	size := uint16(len(c.rq))
+
+	if c.rq[newest%size] != uint32(newest) {
+		return dst, false
+	}
+
+	dst = append(dst, byte(controlAckRanges))
+	dst = bytesutil.AppendUint16BE(dst, newest)
+
+	var (
+		runs  int
+		span  uint16 // number of sequence numbers going back from the newest packet covered by whole runs
+		read  = true
+		count uint16
+	)
+
+	for i := uint16(0); i < size; i++ {
+		seq := newest - i
+		if (c.rq[seq%size] == uint32(seq)) == read {
+			count++
+			continue
+		}
+
+		dst = appendUvarint(dst, uint64(count))
+		runs++
+		span += count
+
+		if runs == maxAckRuns {
+			break
+		}
+
+		read, count = !read, 1
+	}
+
+	// A trailing run of unread packets carries no information, and is left out.
+
+	if runs < maxAckRuns && read {
+		dst = appendUvarint(dst, uint64(count))
+		span += count
+	}
+
+	return dst, span > ACKBitsetSize
+}
+
+// writeAckRangesOnUpdate writes an ack range frame to our peer should ack range frames be enabled, should packets
+// have been read since the last frame was written, and should the ack bitset not already describe all of them.
+func (c *Conn) writeAckRangesOnUpdate() error {
+	c.mu.Lock()
+	if !c.ackRanges || !c.rangesPending || c.die {
+		c.mu.Unlock()
+		return nil
+	}
+	c.rangesPending = false
+
+	buf, needed := c.appendAckRanges(c.rangesBuf[:0])
+	c.rangesBuf = buf
+	c.mu.Unlock()
+
+	if !needed {
+		return nil
+	}
+
+	if err := c.writeControl(buf); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write ack range frame: %w", err)
+	}
+
+	c.mu.Lock()
+	c.stats.AckRangeFrames++
+	c.mu.Unlock()
+
+	return nil
+}
+
+// readAckRanges marks all packets an ack range frame from our peer describes as read as acked.
+func (c *Conn) readAckRanges(buf []byte) error {
+	if len(buf) < 2 {
+		return fmt.Errorf("failed to read ack range frame: %w", io.ErrUnexpectedEOF)
+	}
+
+	newest, buf := bytesutil.Uint16BE(buf[:2]), buf[2:]
+
+	c.mu.Lock()
+
+	unwritten := !sequence.LT(newest, c.wi)
+
+	seq, read := newest, true
+	for runs := 0; len(buf) > 0 && runs < maxAckRuns; runs++ {
+		count, n := binary.Uvarint(buf)
+		if n <= 0 || count > math.MaxUint16 {
+			c.mu.Unlock()
+			return fmt.Errorf("failed to read ack range frame: %w", io.ErrUnexpectedEOF)
+		}
+		buf = buf[n:]
+
+		// Packets further back than our write buffer spans are no longer tracked, and need not be acked.
+
+		if read {
+			for j := uint64(0); j < count && j < uint64(len(c.wq)); j++ {
+				c.markAcked(seq-uint16(j), newest)
+			}
+		}
+
+		seq, read = seq-uint16(count), !read
+	}
+
+	c.mu.Unlock()
+
+	c.handleAcks()
+
+	if unwritten {
+		c.trackFault(faultAnomaly)
+	}
+
+	c.trackUnacked()
+
+	return nil
+}
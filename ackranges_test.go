@@ -0,0 +1,56 @@
+package reliable
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAckRanges(t *testing.T) {
+	w := NewConn(reliabletest.NewFaultConn(nil), nil, WithInitialWindowSize(DefaultReadBufferSize))
+	r := NewConn(reliabletest.NewFaultConn(nil), nil, WithAckRanges())
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.WriteReliablePacket(nil))
+	}
+
+	// Packets read recently enough to be described by the ack bitset warrant no ack range frame.
+
+	for seq := uint16(90); seq < 100; seq++ {
+		require.NoError(t, r.Read(PacketHeader{Sequence: seq}, nil))
+	}
+
+	require.NoError(t, r.writeAckRangesOnUpdate())
+	require.EqualValues(t, 0, r.Stats().AckRangeFrames)
+
+	// Older packets read past a gap in the middle of an outage are described by an ack range frame.
+
+	for seq := uint16(0); seq < 10; seq++ {
+		require.NoError(t, r.Read(PacketHeader{Sequence: seq}, nil))
+	}
+
+	r.mu.Lock()
+	frame, needed := r.appendAckRanges(nil)
+	r.mu.Unlock()
+
+	require.True(t, needed)
+	require.Equal(t, []byte{byte(controlAckRanges), 0, 99, 10, 80, 10}, frame)
+
+	require.NoError(t, r.writeAckRangesOnUpdate())
+	require.EqualValues(t, 1, r.Stats().AckRangeFrames)
+
+	require.NoError(t, w.readAckRanges(frame[1:]))
+
+	for seq := 0; seq < 100; seq++ {
+		require.Equal(t, seq < 10 || seq >= 90, w.wqe[seq].acked, seq)
+	}
+	require.EqualValues(t, 10, w.oui)
+	require.EqualValues(t, 0, w.Stats().Anomalies)
+
+	// Frames acking packets we have yet to write are protocol anomalies.
+
+	require.NoError(t, w.readAckRanges([]byte{0, 200, 1}))
+	require.EqualValues(t, 1, w.Stats().Anomalies)
+
+	require.Error(t, w.readAckRanges([]byte{0}))
+}
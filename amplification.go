@@ -0,0 +1,114 @@
+package reliable
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultAmplificationFactor is the max multiple of bytes received from an unvalidated peer that may be sent back
+// to it, as recommended for QUIC.
+const DefaultAmplificationFactor = 3
+
+// Challenges are control packets that validate the address of an amplification-limited peer. A challenge carries a
+// random 64-bit nonce drawn once per conn, and is answered with a challenge echo carrying the same nonce. Only a peer
+// that is reachable at its address may learn the nonce, whereas sequence numbers start at zero and may be acked
+// blindly by a spoofer.
+
+const (
+	challengeNonceSize = 8
+	challengeSize      = 1 + challengeNonceSize
+)
+
+// allowTransmit reports whether or not a packet of size n may be transmitted to our peer without exceeding the
+// amplification limit, tracking it as sent if so. Until our peer echoes the challenge we sent it, or presents a token
+// bound to its address, we may send at most a multiple of the bytes we received from it, such that spoofed source
+// addresses may not be used to reflect amplified traffic onto third parties.
+func (c *Conn) allowTransmit(n int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.amplification > 0 && !c.validated {
+		if c.amplificationSent+uint64(n) > uint64(c.amplification)*c.amplificationRecv {
+			c.stats.AmplificationDrops++
+			return false
+		}
+		c.amplificationSent += uint64(n)
+	}
+
+	return true
+}
+
+// trackReceived tracks n bytes as having been received from our peer towards the amplification limit.
+func (c *Conn) trackReceived(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.amplificationRecv += uint64(n)
+}
+
+// writeChallengeOnUpdate writes a challenge to our peer as of now should it be amplification-limited and yet to be
+// validated, at most once per resend timeout, such that a challenge or its echo being lost only holds up validation
+// until the next one.
+func (c *Conn) writeChallengeOnUpdate(now time.Time) error {
+	c.mu.Lock()
+	if c.amplification == 0 || c.validated || c.die || now.Sub(c.challengeWritten) < c.effectiveResendTimeout() {
+		c.mu.Unlock()
+		return nil
+	}
+	if !c.challenged {
+		if _, err := io.ReadFull(rand.Reader, c.challenge[:]); err != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("failed to draw challenge nonce: %w", err)
+		}
+		c.challenged = true
+	}
+	c.challengeWritten = now
+
+	buf := make([]byte, 0, challengeSize)
+	buf = append(buf, byte(controlChallenge))
+	buf = append(buf, c.challenge[:]...)
+	c.mu.Unlock()
+
+	if err := c.writeControl(buf); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write challenge: %w", err)
+	}
+
+	return nil
+}
+
+// readChallenge echoes a challenge from our peer straight away.
+func (c *Conn) readChallenge(buf []byte) error {
+	if len(buf) < challengeNonceSize {
+		return fmt.Errorf("failed to read challenge: %w", io.ErrUnexpectedEOF)
+	}
+
+	echo := make([]byte, 0, challengeSize)
+	echo = append(echo, byte(controlChallengeEcho))
+	echo = append(echo, buf[:challengeNonceSize]...)
+
+	if err := c.writeControl(echo); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write challenge echo: %w", err)
+	}
+
+	return nil
+}
+
+// readChallengeEcho validates our peer should it have echoed the nonce of the challenge we sent it. Echoes of any
+// other nonce are ignored.
+func (c *Conn) readChallengeEcho(buf []byte) error {
+	if len(buf) < challengeNonceSize {
+		return fmt.Errorf("failed to read challenge echo: %w", io.ErrUnexpectedEOF)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.challenged && subtle.ConstantTimeCompare(buf[:challengeNonceSize], c.challenge[:]) == 1 {
+		c.validated = true
+	}
+
+	return nil
+}
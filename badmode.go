@@ -0,0 +1,64 @@
+package reliable
+
+import "time"
+
+// DefaultBadModeRecoveryTime is how long conditions must stay good by default for a conn to leave bad mode.
+const DefaultBadModeRecoveryTime = 10 * time.Second
+
+const (
+	// badModeLossThreshold is the smoothed fraction of reliable packets that had to be resent above which conditions
+	// are bad.
+	badModeLossThreshold = 0.1
+
+	// badModeResendFactor is how many times longer the resend timeout is while a conn is in bad mode.
+	badModeResendFactor = 2
+)
+
+// trackLoss folds whether or not a reliable packet had to be resent into the smoothed fraction of reliable packets
+// that had to be resent, and into the loss rates of this conn.
+func (c *Conn) trackLoss(lost bool) {
+	c.rates.sampleLoss(time.Now(), lost)
+
+	sample := 0.0
+	if lost {
+		sample = 1
+	}
+	c.loss += (sample - c.loss) / 16
+}
+
+// checkConditions puts this conn into bad mode once the round-trip time to our peer exceeds the congestion threshold
+// set using WithCongestionThresholdRTT, or once too many reliable packets had to be resent, as in reliable.io. The
+// conn leaves bad mode once conditions stay good for the bad mode recovery time. It must be called with c.mu held.
+func (c *Conn) checkConditions(now time.Time) {
+	if c.badRTT == 0 {
+		return
+	}
+
+	switch {
+	case c.rtt > c.badRTT || c.loss > badModeLossThreshold:
+		if !c.bad {
+			c.bad = true
+			c.stats.BadModeEntries++
+		}
+		c.goodSince = time.Time{}
+	case !c.bad:
+	case c.goodSince.IsZero():
+		c.goodSince = now
+	case now.Sub(c.goodSince) >= c.badRecovery:
+		c.bad = false
+		c.goodSince = time.Time{}
+
+		// Writers waiting for the halved window to free up may now be able to write.
+
+		c.ouc.Broadcast()
+	}
+}
+
+// BadMode reports whether or not this conn is in bad mode, in which only half as many packets may be in flight to
+// our peer and unacked packets are resent half as often, such that a congested link is not made worse.
+func (c *Conn) BadMode() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bad
+}
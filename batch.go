@@ -0,0 +1,182 @@
+package reliable
+
+import (
+	"fmt"
+	"golang.org/x/net/ipv4"
+	"net"
+	"sync"
+	"time"
+)
+
+const maxAckBatchSize = 64
+
+// ackBatcher combines standalone acks written by conns that share a single socket into batches which are written out
+// at once, rather than having each ack be written out using its own syscall. Conns in the background batch packets
+// carrying payloads through one of their own as well.
+type ackBatcher struct {
+	conn  net.PacketConn
+	pc    *ipv4.PacketConn // nil should conn not support writing batches
+	pool  BufferPool
+	eh    ErrorHandler
+	track func(took time.Duration, short bool) // tracks the latency of write syscalls
+
+	mu    sync.Mutex // mutex over queued acks
+	bufs  []*Buffer
+	addrs []net.Addr
+	oobs  [][]byte // control messages setting the source address of each ack, if pinned
+
+	fmu  sync.Mutex // mutex over flushing acks
+	out  []*Buffer
+	dst  []net.Addr
+	oob  [][]byte
+	msgs []ipv4.Message
+}
+
+// checkAckDelay panics should standalone acks be held back for ackDelay, which is as long as or longer than the
+// update period, as our peers would then resend packets that were read before their acks were written out.
+func checkAckDelay(ackDelay, updatePeriod time.Duration) {
+	if ackDelay >= updatePeriod {
+		panic("ack delay must be shorter than the update period")
+	}
+}
+
+func newAckBatcher(conn net.PacketConn, pool BufferPool, eh ErrorHandler, track func(time.Duration, bool)) *ackBatcher {
+	b := &ackBatcher{conn: conn, pool: pool, eh: eh, track: track}
+
+	if c, ok := conn.(*net.UDPConn); ok {
+		b.pc = ipv4.NewPacketConn(c)
+	}
+
+	b.msgs = make([]ipv4.Message, maxAckBatchSize)
+	for i := range b.msgs {
+		b.msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	return b
+}
+
+// push queues up a copy of an ack packet to be written to addr along with control message oob, flushing all queued
+// acks should there be enough of them to fill a batch.
+func (b *ackBatcher) push(addr net.Addr, oob []byte, buf []byte) {
+	p := b.pool.Get(len(buf))
+	if p == nil {
+		return // the ack is dropped, as every packet written later carries its acks again
+	}
+	p.B = append(p.B, buf...)
+
+	b.mu.Lock()
+	b.bufs = append(b.bufs, p)
+	b.addrs = append(b.addrs, addr)
+	b.oobs = append(b.oobs, oob)
+	full := len(b.bufs) >= maxAckBatchSize
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+}
+
+func (b *ackBatcher) flush() {
+	b.fmu.Lock()
+	defer b.fmu.Unlock()
+
+	b.mu.Lock()
+	b.out, b.bufs = b.bufs, b.out[:0]
+	b.dst, b.addrs = b.addrs, b.dst[:0]
+	b.oob, b.oobs = b.oobs, b.oob[:0]
+	b.mu.Unlock()
+
+	for start := 0; start < len(b.out); start += len(b.msgs) {
+		end := start + len(b.msgs)
+		if end > len(b.out) {
+			end = len(b.out)
+		}
+
+		b.write(b.out[start:end], b.dst[start:end], b.oob[start:end])
+	}
+
+	for i := range b.out {
+		b.pool.Put(b.out[i])
+		b.out[i], b.dst[i], b.oob[i] = nil, nil, nil
+	}
+}
+
+// drop releases all queued packets without writing them out.
+func (b *ackBatcher) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.bufs {
+		b.pool.Put(b.bufs[i])
+		b.bufs[i], b.addrs[i], b.oobs[i] = nil, nil, nil
+	}
+	b.bufs, b.addrs, b.oobs = b.bufs[:0], b.addrs[:0], b.oobs[:0]
+}
+
+// write writes out acks to their addresses, retrying writes that fail with a transient error up to transmitRetries
+// times with exponential backoff as conns do. Should a batch keep failing to be written, the acks left in it are
+// dropped, as every packet written later carries their acks again.
+func (b *ackBatcher) write(bufs []*Buffer, addrs []net.Addr, oobs [][]byte) {
+	if b.pc == nil {
+		for i := range bufs {
+			if err := b.writeWithRetries(bufs[i].B, oobs[i], addrs[i]); err != nil && !isEOF(err) && b.eh != nil {
+				b.eh(addrs[i], fmt.Errorf("failed to write batched packet: %w", err))
+			}
+		}
+		return
+	}
+
+	msgs := b.msgs[:len(bufs)]
+	for i := range msgs {
+		msgs[i].Buffers[0], msgs[i].OOB, msgs[i].Addr = bufs[i].B, oobs[i], addrs[i]
+	}
+
+	backoff := transmitBackoff
+
+	for attempt := 0; len(msgs) > 0; {
+		start := time.Now()
+		n, err := b.pc.WriteBatch(msgs, 0)
+		b.track(time.Since(start), false)
+
+		msgs = msgs[n:]
+
+		// Retries are counted in a row, such that a batch that makes progress between transient errors keeps on
+		// being written out.
+
+		if err == nil || n > 0 {
+			attempt, backoff = 0, transmitBackoff
+			continue
+		}
+		if isTemporary(err) && attempt < transmitRetries {
+			time.Sleep(backoff)
+			attempt, backoff = attempt+1, backoff*2
+			continue
+		}
+		if !isEOF(err) && b.eh != nil {
+			b.eh(msgs[0].Addr, fmt.Errorf("failed to write batch: %w", err))
+		}
+		break
+	}
+
+	for i := range b.msgs[:len(bufs)] {
+		b.msgs[i].Buffers[0], b.msgs[i].Addr = nil, nil
+	}
+}
+
+// writeWithRetries writes an ack to addr, retrying should the write fail with a transient error.
+func (b *ackBatcher) writeWithRetries(buf, oob []byte, addr net.Addr) error {
+	backoff := transmitBackoff
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		n, err := writeDatagram(b.conn, buf, oob, addr)
+		b.track(time.Since(start), err == nil && n != len(buf))
+
+		if err == nil || attempt == transmitRetries || !isTemporary(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,142 @@
+package reliable
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrCircuitOpen is returned by writes to a conn whose error budget was exceeded, until its circuit breaker closes
+// again.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// ErrErrorBudgetExceeded is the error a conn fails with should it be disconnected for exceeding its error budget.
+var ErrErrorBudgetExceeded = errors.New("error budget exceeded")
+
+type BreakerAction uint8
+
+const (
+	BreakerOpen       BreakerAction = iota // stop writes and resends until the cooldown passes
+	BreakerDisconnect                      // close the conn
+)
+
+// ErrorBudget bounds the number of transmit errors and protocol anomalies a conn may run into per interval before
+// its circuit breaker trips, such that a single broken peer or route does not keep on eating up bandwidth with
+// resends indefinitely. Protocol anomalies are stale packets, acks of packets that were never written, and invalid
+// connect tokens.
+type ErrorBudget struct {
+	TransmitErrors int           // max number of failed writes to our peer per interval, or zero if unlimited
+	Anomalies      int           // max number of protocol anomalies caused by our peer per interval, or zero if unlimited
+	Interval       time.Duration // interval after which counts reset
+	Cooldown       time.Duration // how long writes and resends are stopped for once tripped, or zero for the interval
+
+	// OnTripped is called every time the circuit breaker trips, and decides what happens to the conn. Should it be
+	// nil, writes and resends are stopped until the cooldown passes.
+	OnTripped func(addr net.Addr, usage ErrorBudgetUsage) BreakerAction
+}
+
+type ErrorBudgetUsage struct {
+	TransmitErrors int       // failed writes to our peer in the current interval
+	Anomalies      int       // protocol anomalies caused by our peer in the current interval
+	Since          time.Time // when the current interval started
+}
+
+func (b *ErrorBudget) exceeded(usage ErrorBudgetUsage) bool {
+	return (b.TransmitErrors > 0 && usage.TransmitErrors > b.TransmitErrors) ||
+		(b.Anomalies > 0 && usage.Anomalies > b.Anomalies)
+}
+
+func (b *ErrorBudget) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return b.Interval
+}
+
+// fault is something going wrong with a conn that is charged against its error budget.
+type fault uint8
+
+const (
+	faultTransmit fault = iota // a write to our peer failed
+	faultAnomaly               // our peer sent a packet that it should not have
+)
+
+// trackFault charges f against the error budget of this conn, tripping its circuit breaker should the budget be
+// exceeded. It must be called without c.mu held.
+func (c *Conn) trackFault(f fault) {
+	c.mu.Lock()
+
+	if f == faultTransmit {
+		c.stats.TransmitErrors++
+	} else {
+		c.stats.Anomalies++
+	}
+
+	if c.budget == nil {
+		c.mu.Unlock()
+		return
+	}
+
+	// Faults are not charged while the circuit breaker is open, such that a conn gets a fresh budget every time its
+	// circuit breaker closes.
+
+	now := time.Now()
+	if now.Before(c.breakerUntil) {
+		c.mu.Unlock()
+		return
+	}
+
+	if now.Sub(c.budgetUsage.Since) >= c.budget.Interval {
+		c.budgetUsage = ErrorBudgetUsage{Since: now}
+	}
+
+	if f == faultTransmit {
+		c.budgetUsage.TransmitErrors++
+	} else {
+		c.budgetUsage.Anomalies++
+	}
+
+	if !c.budget.exceeded(c.budgetUsage) {
+		c.mu.Unlock()
+		return
+	}
+
+	usage, fn := c.budgetUsage, c.budget.OnTripped
+
+	c.breakerUntil = now.Add(c.budget.cooldown())
+	c.budgetUsage = ErrorBudgetUsage{Since: c.breakerUntil}
+	c.stats.BreakerTrips++
+
+	c.mu.Unlock()
+
+	action := BreakerOpen
+	if fn != nil {
+		action = fn(c.peer(), usage)
+	}
+
+	if c.onTripped != nil {
+		c.onTripped(action)
+		return
+	}
+
+	if action == BreakerDisconnect {
+		go c.disconnectTripped()
+	}
+}
+
+// disconnectTripped notifies our peer that this conn exceeded its error budget, and then closes this conn. It must
+// not be called from a reader or writer of this conn, as closing this conn waits for them.
+func (c *Conn) disconnectTripped() {
+	if err := c.disconnect(DisconnectErrorBudget, ErrErrorBudgetExceeded.Error()); err != nil {
+		c.reportError(err)
+	}
+}
+
+// breakerOpen reports whether or not writes and resends to our peer are stopped for this conn having exceeded its
+// error budget. Closed conns report false, such that writes to them fail with io.EOF instead.
+func (c *Conn) breakerOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.die && c.budget != nil && time.Now().Before(c.breakerUntil)
+}
@@ -0,0 +1,49 @@
+package reliable
+
+import "time"
+
+// trackRTT folds a round-trip time sample into the smoothed round-trip time to our peer and its mean deviation as in
+// RFC 6298. Samples include however long our peer held back its ack.
+func (c *Conn) trackRTT(sample time.Duration) {
+	c.resendBackoff = 0
+	c.rttSampled = time.Now()
+
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttvar = sample / 2
+		return
+	}
+
+	deviation := c.rtt - sample
+	if deviation < 0 {
+		deviation = -deviation
+	}
+
+	c.rttvar += (deviation - c.rttvar) / 4
+	c.rtt += (sample - c.rtt) / 8
+}
+
+// estimateLatency estimates how long it would take for a reliable packet written now to reach our peer. Should the
+// write need to wait for other writers or for our peer's read buffer to free up, it is estimated to take an extra
+// round trip.
+func (c *Conn) estimateLatency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	latency := c.rtt / 2
+	if c.tickets != c.serving || !c.readerAvailable() {
+		latency += c.rtt
+	}
+
+	return latency
+}
+
+// WriteReliablePacketBudget writes buf reliably to our peer, unless it is estimated that buf would not reach our peer
+// within maxLatency, in which case ErrLatencyBudgetExceeded is returned without writing buf. Callers may then choose
+// to write buf unreliably, or to drop it. Until an ack from our peer is received, writes are never refused.
+func (c *Conn) WriteReliablePacketBudget(buf []byte, maxLatency time.Duration) error {
+	if c.estimateLatency() > maxLatency {
+		return ErrLatencyBudgetExceeded
+	}
+	return c.WriteReliablePacket(buf)
+}
@@ -0,0 +1,160 @@
+package reliable
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Client is a facade over an endpoint that talks to a single server. Should its conn to the server fail or be closed
+// by the server, the next write reconnects to the server by establishing a fresh conn. Its endpoint is available
+// through Endpoint for anything the facade does not cover.
+type Client struct {
+	e      *Endpoint
+	pc     net.PacketConn
+	server net.Addr
+
+	unsubscribe func()
+	connects    uint64 // number of conns to the server that were established
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Dial binds a client to an ephemeral UDP port, and connects it to the server at the UDP address addr. See
+// NewClient.
+func Dial(addr string, ph PacketHandler, opts ...EndpointOption) (*Client, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", addr, err)
+	}
+
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on an ephemeral port: %w", err)
+	}
+
+	return NewClient(pc, server, ph, opts...), nil
+}
+
+// NewClient connects to the server at addr over pc, having ph handle all packets read from the server. opts are
+// applied to the endpoint of the client, and must not set a packet handler.
+func NewClient(pc net.PacketConn, server net.Addr, ph PacketHandler, opts ...EndpointOption) *Client {
+	c := &Client{pc: pc, server: server}
+
+	c.e = NewEndpoint(pc, append(opts[:len(opts):len(opts)], WithPacketHandler(ph))...)
+	c.unsubscribe = c.e.Subscribe(c.track)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.e.Listen()
+	}()
+
+	if conn := c.e.getConn(server, nil); conn != nil {
+		conn.unref()
+	}
+
+	return c
+}
+
+func (c *Client) track(event ConnEvent) {
+	if event.Type == ConnEstablished && event.Addr.String() == c.server.String() {
+		atomic.AddUint64(&c.connects, 1)
+	}
+}
+
+// Reconnects returns the number of times the client reconnected to the server.
+func (c *Client) Reconnects() int {
+	if n := atomic.LoadUint64(&c.connects); n > 0 {
+		return int(n - 1)
+	}
+	return 0
+}
+
+// Endpoint returns the endpoint the client is a facade over.
+func (c *Client) Endpoint() *Endpoint { return c.e }
+
+// Conn returns the conn to the server, or nil should the client not be connected to the server at the moment.
+func (c *Client) Conn() *Conn { return c.e.lookupConn(c.server) }
+
+// LocalAddr returns the local address the client is bound to.
+func (c *Client) LocalAddr() net.Addr { return c.e.Addr() }
+
+// RemoteAddr returns the address of the server.
+func (c *Client) RemoteAddr() net.Addr { return c.server }
+
+// Send writes buf reliably to the server.
+func (c *Client) Send(buf []byte) error { return c.e.WriteReliablePacket(buf, c.server) }
+
+// SendContext writes buf reliably to the server, giving up with ctx.Err() should ctx be done before buf gets its turn
+// to be written.
+func (c *Client) SendContext(ctx context.Context, buf []byte) error {
+	return c.e.WriteReliablePacketContext(ctx, buf, c.server)
+}
+
+// SendUnreliable writes buf unreliably to the server.
+func (c *Client) SendUnreliable(buf []byte) error { return c.e.WriteUnreliablePacket(buf, c.server) }
+
+// SendString writes s reliably to the server.
+func (c *Client) SendString(s string) error { return c.Send([]byte(s)) }
+
+// SendJSON writes the JSON encoding of v reliably to the server.
+func (c *Client) SendJSON(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode json: %w", err)
+	}
+	return c.Send(buf)
+}
+
+// Flush waits until everything written to the server so far is acked. See Conn.Flush.
+func (c *Client) Flush(ctx context.Context) error {
+	conn := c.Conn()
+	if conn == nil {
+		return nil
+	}
+	return conn.Flush(ctx)
+}
+
+// Shutdown flushes the conn to the server, notifies the server that the client is going away, and closes the
+// client. The server is notified and the client is closed regardless should flushing fail, such as should ctx be
+// done first, in which case the error flushing failed with is returned.
+func (c *Client) Shutdown(ctx context.Context) error {
+	err := c.Flush(ctx)
+	if isEOF(err) {
+		err = nil
+	}
+
+	if conn := c.Conn(); conn != nil {
+		_ = conn.CloseWithError(0, "client shutting down")
+	}
+
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Close closes the client straight away, along with its socket.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		err := c.pc.Close()
+		c.wg.Wait()
+		c.unsubscribe()
+
+		if cerr := c.e.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			c.closeErr = fmt.Errorf("failed to close client: %w", err)
+		}
+	})
+
+	return c.closeErr
+}
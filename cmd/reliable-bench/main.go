@@ -0,0 +1,120 @@
+// Command reliable-bench measures how fast reliable packets may be written from one endpoint to another over
+// loopback, and prints out the throughput along with stats of the conn that wrote the packets.
+//
+//	$ reliable-bench -n 100000 -size 1400
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+func main() {
+	n := flag.Int("n", 100000, "number of reliable packets to write")
+	size := flag.Int("size", 1400, "size of each packet in bytes")
+	profile := flag.String("profile", "default", `one of "default", "bulk", or "realtime"`)
+	flag.Parse()
+
+	if err := run(*n, *size, *profile, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type result struct {
+	Packets  int
+	Bytes    int
+	Took     time.Duration
+	Stats    reliable.ConnStats
+	Syscalls reliable.WriteStats
+}
+
+func (r result) print(w io.Writer) {
+	mib := float64(r.Bytes) / 1024 / 1024
+
+	fmt.Fprintf(w, "wrote %d packets (%.2f MiB) in %s: %.0f packets/s, %.2f MiB/s\n",
+		r.Packets, mib, r.Took, float64(r.Packets)/r.Took.Seconds(), mib/r.Took.Seconds())
+	fmt.Fprintf(w, "rtt: %s\n", r.Stats.RTT)
+	fmt.Fprintf(w, "write syscalls: %d (mean %s, max %s)\n", r.Syscalls.Writes, r.Syscalls.Mean(), r.Syscalls.Max)
+	fmt.Fprintf(w, "write waits: %d (total %s)\n", r.Stats.WriteWaits, r.Stats.WriteWaitTotal)
+
+	for _, stage := range []struct {
+		name string
+		h    reliable.LatencyHistogram
+	}{
+		{"queue", r.Stats.Lifecycle.Queue},
+		{"transmit", r.Stats.Lifecycle.Transmit},
+		{"ack", r.Stats.Lifecycle.Ack},
+		{"release", r.Stats.Lifecycle.Release},
+	} {
+		fmt.Fprintf(w, "%-8s p50 <%s p99 <%s\n", stage.name, stage.h.Percentile(50), stage.h.Percentile(99))
+	}
+}
+
+// run writes n reliable packets of size bytes from one endpoint to another over loopback, waiting for all of them
+// to be delivered.
+func run(n, size int, profile string, w io.Writer) error {
+	opts, err := reliable.Config{Profile: profile}.EndpointOptions()
+	if err != nil {
+		return err
+	}
+
+	ca, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	cb, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	var delivered uint64
+
+	a := reliable.NewEndpoint(ca, opts...)
+	b := reliable.NewEndpoint(cb, append(opts, reliable.WithReliablePacketHandler(func(net.Addr, uint16, []byte) {
+		atomic.AddUint64(&delivered, 1)
+	}))...)
+
+	go a.Listen()
+	go b.Listen()
+
+	defer func() {
+		_ = ca.SetDeadline(time.Now().Add(1 * time.Millisecond))
+		_ = cb.SetDeadline(time.Now().Add(1 * time.Millisecond))
+		_ = a.Close()
+		_ = b.Close()
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+
+	data := bytes.Repeat([]byte("x"), size)
+
+	start := time.Now()
+
+	for i := 0; i < n; i++ {
+		if err := a.WriteReliablePacket(data, cb.LocalAddr()); err != nil {
+			return fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+
+	for atomic.LoadUint64(&delivered) < uint64(n) {
+		if time.Since(start) > time.Minute {
+			return fmt.Errorf("timed out with %d of %d packets delivered", atomic.LoadUint64(&delivered), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	r := result{Packets: n, Bytes: n * size, Took: time.Since(start), Syscalls: a.WriteStats()}
+	r.Stats, _ = a.Stats(cb.LocalAddr())
+
+	r.print(w)
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	for _, profile := range []string{"default", "bulk", "realtime"} {
+		var out bytes.Buffer
+		require.NoError(t, run(256, 64, profile, &out))
+		require.Contains(t, out.String(), "wrote 256 packets")
+		require.Contains(t, out.String(), "release")
+	}
+
+	require.Error(t, run(1, 1, "fastest", &bytes.Buffer{}))
+}
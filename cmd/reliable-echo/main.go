@@ -0,0 +1,136 @@
+// Command reliable-echo runs an echo server that writes every packet it reads back to its sender, reliable packets
+// reliably and unreliable packets unreliably, or a client that writes lines read from stdin to an echo server and
+// prints out the replies.
+//
+//	$ reliable-echo -l 127.0.0.1:44444
+//	$ reliable-echo 127.0.0.1:44444
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+func main() {
+	listen := flag.String("l", "", "address to serve echoes on rather than dialing an echo server")
+	flag.Parse()
+
+	var err error
+	if *listen != "" {
+		err = serve(*listen)
+	} else {
+		err = dial(flag.Arg(0), os.Stdin, os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// echo returns an endpoint that writes every packet it reads back to its sender.
+func echo(conn net.PacketConn, opts ...reliable.EndpointOption) *reliable.Endpoint {
+	var endpoint *reliable.Endpoint
+
+	// Reliable packets are echoed on their own goroutine, as writing one blocks until there is room in the window to
+	// write it, and the window only frees up once acks are read.
+
+	reliableHandler := func(addr net.Addr, _ uint16, buf []byte) {
+		buf = append([]byte(nil), buf...)
+
+		go func() {
+			if err := endpoint.WriteReliablePacket(buf, addr); err != nil {
+				log.Printf("%s: failed to echo packet: %s", addr, err)
+			}
+		}()
+	}
+
+	unreliableHandler := func(addr net.Addr, _ uint16, buf []byte) {
+		if err := endpoint.WriteUnreliablePacket(buf, addr); err != nil {
+			log.Printf("%s: failed to echo packet: %s", addr, err)
+		}
+	}
+
+	opts = append(opts,
+		reliable.WithReliablePacketHandler(reliableHandler),
+		reliable.WithUnreliablePacketHandler(unreliableHandler),
+	)
+
+	endpoint = reliable.NewEndpoint(conn, opts...)
+
+	return endpoint
+}
+
+func serve(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer conn.Close()
+
+	log.Printf("%s: Serving echoes.", conn.LocalAddr())
+
+	endpoint := echo(conn)
+	defer endpoint.Close()
+
+	endpoint.Listen()
+
+	return nil
+}
+
+// dial writes every line read from r to the echo server at addr, and writes every reply to w. It returns once
+// replies to every line were received.
+func dial(addr string, r io.Reader, w io.Writer) error {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve echo server: %w", err)
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	replies := make(chan string, 64)
+
+	endpoint := reliable.NewEndpoint(conn, reliable.WithReliablePacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+		replies <- string(buf)
+	}))
+	go endpoint.Listen()
+
+	defer func() {
+		_ = conn.SetDeadline(time.Now().Add(1 * time.Millisecond))
+		_ = endpoint.Close()
+		_ = conn.Close()
+	}()
+
+	sent := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := endpoint.WriteReliablePacket(scanner.Bytes(), raddr); err != nil {
+			return fmt.Errorf("failed to write line: %w", err)
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read lines: %w", err)
+	}
+
+	for i := 0; i < sent; i++ {
+		select {
+		case reply := <-replies:
+			fmt.Fprintln(w, reply)
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("timed out waiting for %d of %d replies", sent-i, sent)
+		}
+	}
+
+	return nil
+}
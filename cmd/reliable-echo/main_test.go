@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEcho(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := echo(conn)
+	go server.Listen()
+
+	defer func() {
+		require.NoError(t, conn.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, server.Close())
+		require.NoError(t, conn.Close())
+	}()
+
+	var out bytes.Buffer
+	require.NoError(t, dial(conn.LocalAddr().String(), strings.NewReader("hello\nworld\n"), &out))
+
+	// Replies may arrive out of order should a packet have been resent.
+
+	replies := strings.Fields(out.String())
+	sort.Strings(replies)
+	require.Equal(t, []string{"hello", "world"}, replies)
+}
@@ -0,0 +1,248 @@
+// Command reliable-probe characterizes the network path to a peer running reliable-echo using the protocol itself,
+// reporting the round-trip time, loss, reordering, path MTU, and achievable throughput to the peer.
+//
+//	$ reliable-probe 127.0.0.1:44444
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// overhead is the number of bytes an IPv4 and UDP header add onto a datagram.
+const overhead = 20 + 8
+
+// Sizes in bytes of the smallest possible header of an unreliable packet, and the largest possible header of a
+// reliable packet.
+const (
+	minUnreliableHeaderSize = 3
+	maxReliableHeaderSize   = 9
+)
+
+type Config struct {
+	Probes     int           // number of reliable packets to measure the round-trip time, loss, and reordering with
+	Timeout    time.Duration // how long to wait for all probes to be echoed
+	MaxPayload int           // size in bytes of the largest payload tried when searching for the path MTU
+	Throughput time.Duration // how long to measure throughput for
+}
+
+var DefaultConfig = Config{
+	Probes:     200,
+	Timeout:    10 * time.Second,
+	MaxPayload: 9000,
+	Throughput: 3 * time.Second,
+}
+
+type Report struct {
+	RTT             time.Duration // smoothed round-trip time
+	Loss            float64       // fraction of probes that had to be resent
+	Reordered       uint64        // number of echoed probes that arrived after a newer one did, including reordering by the peer
+	ReorderDepthMax uint16        // furthest an echoed probe arrived behind the newest one, in packets
+	MaxPayload      int           // size in bytes of the largest unreliable payload that was echoed
+	PathMTU         int           // lower bound of the path MTU in bytes, including IP and UDP headers
+	Throughput      float64       // payload bytes per second that were echoed while writing as fast as possible
+}
+
+func (r Report) print(w io.Writer) {
+	fmt.Fprintf(w, "rtt:         %s\n", r.RTT)
+	fmt.Fprintf(w, "loss:        %.2f%%\n", 100*r.Loss)
+	fmt.Fprintf(w, "reordered:   %d (max depth %d)\n", r.Reordered, r.ReorderDepthMax)
+	fmt.Fprintf(w, "max payload: %d bytes (path mtu >= %d bytes)\n", r.MaxPayload, r.PathMTU)
+	fmt.Fprintf(w, "throughput:  %.2f KiB/s\n", r.Throughput/1024)
+}
+
+func main() {
+	cfg := DefaultConfig
+
+	flag.IntVar(&cfg.Probes, "n", cfg.Probes, "number of probes to measure round-trip time, loss, and reordering with")
+	flag.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "how long to wait for all probes to be echoed")
+	flag.IntVar(&cfg.MaxPayload, "max-payload", cfg.MaxPayload, "largest payload in bytes tried when searching for the path mtu")
+	flag.DurationVar(&cfg.Throughput, "throughput", cfg.Throughput, "how long to measure throughput for")
+	flag.Parse()
+
+	peer, err := net.ResolveUDPAddr("udp", flag.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to resolve peer: %s", err)
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		log.Fatalf("failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	report, err := probe(conn, peer, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report.print(os.Stdout)
+}
+
+// probe characterizes the network path to an echo server at peer.
+func probe(conn net.PacketConn, peer net.Addr, cfg Config) (report Report, err error) {
+	var (
+		echoed  uint64 // payload bytes of reliable packets echoed
+		replies = make(chan int, cfg.Probes)
+		sizes   = make(chan int, 16)
+	)
+
+	endpoint := reliable.NewEndpoint(conn,
+		reliable.WithSentHistorySize(cfg.Probes),
+		reliable.WithReliablePacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+			atomic.AddUint64(&echoed, uint64(len(buf)))
+			select {
+			case replies <- len(buf):
+			default:
+			}
+		}),
+		reliable.WithUnreliablePacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+			select {
+			case sizes <- len(buf):
+			default:
+			}
+		}),
+	)
+	go endpoint.Listen()
+
+	defer func() {
+		_ = conn.SetDeadline(time.Now().Add(1 * time.Millisecond))
+		if cerr := endpoint.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if err := probeLatency(endpoint, peer, cfg, replies, &report); err != nil {
+		return report, err
+	}
+
+	probeMTU(endpoint, peer, cfg, sizes, &report)
+
+	if err := probeThroughput(endpoint, peer, cfg, &echoed, &report); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// probeLatency writes reliable probes to peer, and waits for all of them to be echoed.
+func probeLatency(endpoint *reliable.Endpoint, peer net.Addr, cfg Config, replies <-chan int, report *Report) error {
+	var buf [8]byte
+
+	for i := 0; i < cfg.Probes; i++ {
+		binary.BigEndian.PutUint64(buf[:], uint64(i))
+		if err := endpoint.WriteReliablePacket(buf[:], peer); err != nil {
+			return fmt.Errorf("failed to write probe: %w", err)
+		}
+	}
+
+	timeout := time.After(cfg.Timeout)
+
+	for i := 0; i < cfg.Probes; i++ {
+		select {
+		case <-replies:
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for %d of %d probes to be echoed", cfg.Probes-i, cfg.Probes)
+		}
+	}
+
+	stats, _ := endpoint.Stats(peer)
+
+	report.RTT = stats.RTT
+	report.Reordered = stats.Reordered
+	report.ReorderDepthMax = stats.ReorderDepthMax
+
+	probes, resent := 0, 0
+	for _, r := range endpoint.SentHistory(peer) {
+		if r.Size == 0 { // standalone acks
+			continue
+		}
+		probes++
+		if r.Resends > 0 {
+			resent++
+		}
+	}
+	if probes > 0 {
+		report.Loss = float64(resent) / float64(probes)
+	}
+
+	return nil
+}
+
+// probeMTU binary searches for the largest unreliable payload that gets echoed by peer.
+func probeMTU(endpoint *reliable.Endpoint, peer net.Addr, cfg Config, sizes chan int, report *Report) {
+	wait := 4*report.RTT + 20*time.Millisecond
+
+	echoed := func(size int) bool {
+		buf := make([]byte, size)
+
+		for attempt := 0; attempt < 3; attempt++ {
+			if err := endpoint.WriteUnreliablePacket(buf, peer); err != nil {
+				return false // such as should the payload not fit in a datagram
+			}
+
+			timeout := time.After(wait)
+		await:
+			for {
+				select {
+				case n := <-sizes:
+					if n == size { // otherwise, a late echo of an earlier probe
+						return true
+					}
+				case <-timeout:
+					break await
+				}
+			}
+		}
+
+		return false
+	}
+
+	lo, hi := 0, cfg.MaxPayload+1 // lo is known to be echoed, and hi is known not to be
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if echoed(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	report.MaxPayload = lo
+	report.PathMTU = lo + minUnreliableHeaderSize + overhead
+}
+
+// probeThroughput writes reliable packets to peer as fast as possible, measuring how many payload bytes get echoed.
+func probeThroughput(endpoint *reliable.Endpoint, peer net.Addr, cfg Config, echoed *uint64, report *Report) error {
+	size := report.MaxPayload + minUnreliableHeaderSize - maxReliableHeaderSize
+	if size > 1200 {
+		size = 1200
+	}
+	if size <= 0 {
+		return errors.New("no payload was echoed while searching for the path mtu")
+	}
+
+	buf := make([]byte, size)
+
+	start := atomic.LoadUint64(echoed)
+	began := time.Now()
+
+	for time.Since(began) < cfg.Throughput {
+		if err := endpoint.WriteReliablePacket(buf, peer); err != nil {
+			return fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+
+	report.Throughput = float64(atomic.LoadUint64(echoed)-start) / time.Since(began).Seconds()
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/lithdew/reliable"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	const mtu = 600
+
+	network := reliabletest.NewNetwork(0)
+
+	ca := network.Listen()
+	cb := network.Listen()
+
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), reliabletest.Link{Latency: 1 * time.Millisecond, MTU: mtu})
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), reliabletest.Link{Latency: 1 * time.Millisecond, MTU: mtu})
+
+	var server *reliable.Endpoint
+
+	server = reliable.NewEndpoint(cb,
+		reliable.WithReliablePacketHandler(func(addr net.Addr, _ uint16, buf []byte) {
+			buf = append([]byte(nil), buf...)
+			go func() { _ = server.WriteReliablePacket(buf, addr) }()
+		}),
+		reliable.WithUnreliablePacketHandler(func(addr net.Addr, _ uint16, buf []byte) {
+			_ = server.WriteUnreliablePacket(buf, addr)
+		}),
+	)
+	go server.Listen()
+
+	defer func() {
+		require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, server.Close())
+		require.NoError(t, cb.Close())
+	}()
+
+	cfg := Config{
+		Probes:     32,
+		Timeout:    5 * time.Second,
+		MaxPayload: 2000,
+		Throughput: 100 * time.Millisecond,
+	}
+
+	report, err := probe(ca, cb.LocalAddr(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, ca.Close())
+
+	require.True(t, report.RTT > 0)
+	require.True(t, report.Loss >= 0 && report.Loss <= 1)
+
+	// The largest echoed payload depends on how large of an ack bitset was marshaled alongside it.
+
+	require.True(t, report.MaxPayload >= mtu-7 && report.MaxPayload <= mtu-3, report.MaxPayload)
+	require.True(t, report.Throughput > 0, report)
+}
@@ -0,0 +1,150 @@
+package reliable
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The tests below exercise the guarantees documented on Conn, and are meant to be run with the race detector.
+
+func TestConnConcurrentWriters(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	const (
+		writers = 4
+		packets = 64
+	)
+
+	var delivered uint32
+
+	a, b, cleanup := Pair(WithPacketHandler(func(net.Addr, uint16, []byte) {
+		atomic.AddUint32(&delivered, 1)
+	}))
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	wg.Add(2*writers + 1)
+
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < packets; j++ {
+				require.NoError(t, a.WriteReliablePacket([]byte("reliable")))
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < packets; j++ {
+				require.NoError(t, b.WriteUnreliablePacket([]byte("unreliable")))
+			}
+		}()
+	}
+
+	go func() {
+		defer wg.Done()
+		for j := 0; j < packets; j++ {
+			_ = a.Stats()
+			_ = b.Events()
+			a.ExpectWriteSoon()
+			b.ReportCongestion(0.1)
+		}
+	}()
+
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint32(&delivered) >= writers*packets
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestConnCloseDuringWrites(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	a, _, cleanup := Pair(WithReadBufferSize(16), WithWriteBufferSize(16))
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if err := a.WriteReliablePacket([]byte("hello")); err != nil {
+					require.True(t, isEOF(err))
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	a.Close()
+	wg.Wait()
+
+	require.True(t, isEOF(a.WriteReliablePacket([]byte("hello"))))
+	require.True(t, isEOF(a.WriteUnreliablePacket([]byte("hello"))))
+}
+
+func TestConnCloseFromPacketHandler(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	var (
+		b      *Conn
+		closed = make(chan struct{})
+		once   sync.Once
+	)
+
+	a, b, cleanup := Pair(WithPacketHandler(func(net.Addr, uint16, []byte) {
+		once.Do(func() {
+			b.Close()
+			close(closed)
+		})
+	}))
+	defer cleanup()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello")))
+
+	select {
+	case <-closed:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	require.True(t, isEOF(b.WriteReliablePacket([]byte("hello"))))
+	require.True(t, isEOF(b.Read(PacketHeader{}, nil)))
+}
+
+func TestConnConcurrentReads(t *testing.T) {
+	var delivered uint32
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithPacketHandler(func(net.Addr, uint16, []byte) {
+		atomic.AddUint32(&delivered, 1)
+	}))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 64; j++ {
+				require.NoError(t, c.Read(PacketHeader{Sequence: uint16(j*4 + i)}, []byte("hello")))
+				_ = c.WriteUnreliablePacket([]byte("hello"))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.EqualValues(t, 256, atomic.LoadUint32(&delivered))
+}
@@ -0,0 +1,387 @@
+package reliable
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Duration is a time.Duration that is read from and written to config files as a string, such as "250ms".
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("failed to parse duration: %w", err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config is a plain description of options that may be loaded from a config file, and then converted to options
+// using ConnOptions or EndpointOptions. Fields left as their zero value leave the option they map to as is.
+type Config struct {
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"` // one of "default", "bulk", "realtime", or "lossy"
+
+	ReadBufferSize  uint16 `json:"read_buffer_size,omitempty" yaml:"read_buffer_size,omitempty"`
+	WriteBufferSize uint16 `json:"write_buffer_size,omitempty" yaml:"write_buffer_size,omitempty"`
+
+	ReorderTolerance uint16 `json:"reorder_tolerance,omitempty" yaml:"reorder_tolerance,omitempty"`
+
+	UpdatePeriod  Duration `json:"update_period,omitempty" yaml:"update_period,omitempty"`
+	ResendTimeout Duration `json:"resend_timeout,omitempty" yaml:"resend_timeout,omitempty"` // fixed rather than derived from rtt
+
+	MinResendTimeout Duration `json:"min_resend_timeout,omitempty" yaml:"min_resend_timeout,omitempty"`
+
+	MaxPacketResends int `json:"max_packet_resends,omitempty" yaml:"max_packet_resends,omitempty"` // fail conns once exceeded
+	ResendPacing     int `json:"resend_pacing,omitempty" yaml:"resend_pacing,omitempty"`           // max packets resent per update
+
+	InterleaveResends int `json:"interleave_resends,omitempty" yaml:"interleave_resends,omitempty"` // resends per round of interleaving
+	InterleaveWrites  int `json:"interleave_writes,omitempty" yaml:"interleave_writes,omitempty"`   // fresh writes per round of interleaving
+
+	KeepAlivePeriod    Duration `json:"keepalive_period,omitempty" yaml:"keepalive_period,omitempty"`
+	KeepAliveMaxPeriod Duration `json:"keepalive_max_period,omitempty" yaml:"keepalive_max_period,omitempty"` // makes the period adaptive
+	InactivityTimeout  Duration `json:"inactivity_timeout,omitempty" yaml:"inactivity_timeout,omitempty"`     // fail conns once exceeded
+
+	CongestionThresholdRTT Duration `json:"congestion_threshold_rtt,omitempty" yaml:"congestion_threshold_rtt,omitempty"` // enables bad mode
+	BadModeRecoveryTime    Duration `json:"bad_mode_recovery_time,omitempty" yaml:"bad_mode_recovery_time,omitempty"`
+
+	InitialWindowSize uint16 `json:"initial_window_size,omitempty" yaml:"initial_window_size,omitempty"`
+	NoSlowStart       bool   `json:"no_slow_start,omitempty" yaml:"no_slow_start,omitempty"`
+
+	AckPolicy            string   `json:"ack_policy,omitempty" yaml:"ack_policy,omitempty"`             // one of "bitset", "every_packet", "delayed", or "adaptive"
+	AckPolicyDelay       Duration `json:"ack_policy_delay,omitempty" yaml:"ack_policy_delay,omitempty"` // delay of the "delayed" ack policy
+	AckDelay             Duration `json:"ack_delay,omitempty" yaml:"ack_delay,omitempty"`
+	AckSuppressionWindow Duration `json:"ack_suppression_window,omitempty" yaml:"ack_suppression_window,omitempty"`
+	AckRanges            bool     `json:"ack_ranges,omitempty" yaml:"ack_ranges,omitempty"`
+	UnsequencedAcks      bool     `json:"unsequenced_acks,omitempty" yaml:"unsequenced_acks,omitempty"`
+
+	PassiveRTTPeriod Duration `json:"passive_rtt_period,omitempty" yaml:"passive_rtt_period,omitempty"` // samples rtt off timestamps once exceeded
+
+	EventLogSize    int `json:"event_log_size,omitempty" yaml:"event_log_size,omitempty"`
+	SentHistorySize int `json:"sent_history_size,omitempty" yaml:"sent_history_size,omitempty"`
+
+	AmplificationLimit int `json:"amplification_limit,omitempty" yaml:"amplification_limit,omitempty"`
+
+	FragmentSize int `json:"fragment_size,omitempty" yaml:"fragment_size,omitempty"`
+
+	ReassemblyMaxFragments int      `json:"reassembly_max_fragments,omitempty" yaml:"reassembly_max_fragments,omitempty"`
+	ReassemblyMaxMessages  int      `json:"reassembly_max_messages,omitempty" yaml:"reassembly_max_messages,omitempty"`
+	ReassemblyTimeout      Duration `json:"reassembly_timeout,omitempty" yaml:"reassembly_timeout,omitempty"`
+
+	ProgressFloorRate int      `json:"progress_floor_rate,omitempty" yaml:"progress_floor_rate,omitempty"` // payload bytes per second
+	ProgressGrace     Duration `json:"progress_grace,omitempty" yaml:"progress_grace,omitempty"`
+
+	MaxConns      int `json:"max_conns,omitempty" yaml:"max_conns,omitempty"`
+	MaxGoroutines int `json:"max_goroutines,omitempty" yaml:"max_goroutines,omitempty"`
+	MaxBuffers    int `json:"max_buffers,omitempty" yaml:"max_buffers,omitempty"`
+	MaxBytes      int `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty"`
+
+	ConnIdleTimeout Duration `json:"conn_idle_timeout,omitempty" yaml:"conn_idle_timeout,omitempty"` // evict conns once exceeded
+
+	QuotaSendBytes uint64   `json:"quota_send_bytes,omitempty" yaml:"quota_send_bytes,omitempty"`
+	QuotaRecvBytes uint64   `json:"quota_recv_bytes,omitempty" yaml:"quota_recv_bytes,omitempty"`
+	QuotaInterval  Duration `json:"quota_interval,omitempty" yaml:"quota_interval,omitempty"`
+
+	ErrorBudgetTransmitErrors int      `json:"error_budget_transmit_errors,omitempty" yaml:"error_budget_transmit_errors,omitempty"`
+	ErrorBudgetAnomalies      int      `json:"error_budget_anomalies,omitempty" yaml:"error_budget_anomalies,omitempty"`
+	ErrorBudgetInterval       Duration `json:"error_budget_interval,omitempty" yaml:"error_budget_interval,omitempty"`
+	ErrorBudgetCooldown       Duration `json:"error_budget_cooldown,omitempty" yaml:"error_budget_cooldown,omitempty"`
+	ErrorBudgetDisconnect     bool     `json:"error_budget_disconnect,omitempty" yaml:"error_budget_disconnect,omitempty"` // close conns once tripped rather than pausing them
+
+	RateLimit              float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"` // payload bytes per second per peer
+	RateLimitBurst         int     `json:"rate_limit_burst,omitempty" yaml:"rate_limit_burst,omitempty"`
+	EndpointRateLimit      float64 `json:"endpoint_rate_limit,omitempty" yaml:"endpoint_rate_limit,omitempty"` // payload bytes per second to all peers
+	EndpointRateLimitBurst int     `json:"endpoint_rate_limit_burst,omitempty" yaml:"endpoint_rate_limit_burst,omitempty"`
+
+	PreallocatedBuffers    int `json:"preallocated_buffers,omitempty" yaml:"preallocated_buffers,omitempty"`
+	PreallocatedBufferSize int `json:"preallocated_buffer_size,omitempty" yaml:"preallocated_buffer_size,omitempty"`
+
+	DeliveryDelay   int  `json:"delivery_delay,omitempty" yaml:"delivery_delay,omitempty"` // in ticks
+	OrderedDelivery bool `json:"ordered_delivery,omitempty" yaml:"ordered_delivery,omitempty"`
+
+	ReadBatchSize int `json:"read_batch_size,omitempty" yaml:"read_batch_size,omitempty"`
+	ReadWorkers   int `json:"read_workers,omitempty" yaml:"read_workers,omitempty"`
+	ReadQueueSize int `json:"read_queue_size,omitempty" yaml:"read_queue_size,omitempty"`
+
+	ReadOrdering string `json:"read_ordering,omitempty" yaml:"read_ordering,omitempty"` // one of "fifo" or "parallel"
+
+	TTL            int  `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	PlatformTuning bool `json:"platform_tuning,omitempty" yaml:"platform_tuning,omitempty"`
+	SourcePinning  bool `json:"source_pinning,omitempty" yaml:"source_pinning,omitempty"`
+	ProbeResponder bool `json:"probe_responder,omitempty" yaml:"probe_responder,omitempty"`
+}
+
+// ConnOptions converts this config to options for a conn, skipping options that only apply to endpoints. An error is
+// returned should any option be invalid.
+func (c Config) ConnOptions() ([]ConnOption, error) {
+	opts, err := c.EndpointOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := make([]ConnOption, 0, len(opts))
+	for _, opt := range opts {
+		if opt, ok := opt.(ConnOption); ok {
+			conn = append(conn, opt)
+		}
+	}
+
+	return conn, nil
+}
+
+// EndpointOptions converts this config to options for an endpoint. An error is returned should any option be
+// invalid.
+func (c Config) EndpointOptions() (opts []EndpointOption, err error) {
+	// Options panic when given invalid values, which are config errors here.
+
+	defer func() {
+		if r := recover(); r != nil {
+			opts, err = nil, fmt.Errorf("invalid config: %v", r)
+		}
+	}()
+
+	if c.Profile != "" {
+		profile, err := parseProfile(c.Profile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithProfile(profile))
+	}
+
+	if c.ReadBufferSize != 0 {
+		opts = append(opts, WithReadBufferSize(c.ReadBufferSize))
+	}
+	if c.WriteBufferSize != 0 {
+		opts = append(opts, WithWriteBufferSize(c.WriteBufferSize))
+	}
+
+	if c.ReorderTolerance != 0 {
+		readBufferSize := c.ReadBufferSize
+		if readBufferSize == 0 {
+			readBufferSize = DefaultReadBufferSize
+		}
+		checkReorderTolerance(c.ReorderTolerance, readBufferSize)
+
+		opts = append(opts, WithReorderTolerance(c.ReorderTolerance))
+	}
+
+	if c.UpdatePeriod != 0 {
+		opts = append(opts, WithUpdatePeriod(time.Duration(c.UpdatePeriod)))
+	}
+	if c.ResendTimeout != 0 {
+		opts = append(opts, WithResendTimeout(time.Duration(c.ResendTimeout)))
+	}
+	if c.MinResendTimeout != 0 {
+		opts = append(opts, WithMinResendTimeout(time.Duration(c.MinResendTimeout)))
+	}
+	if c.MaxPacketResends != 0 {
+		opts = append(opts, WithMaxPacketResends(c.MaxPacketResends))
+	}
+	if c.ResendPacing != 0 {
+		opts = append(opts, WithResendPacing(c.ResendPacing))
+	}
+	if c.InterleaveResends != 0 || c.InterleaveWrites != 0 {
+		opts = append(opts, WithRetransmitInterleave(c.InterleaveResends, c.InterleaveWrites))
+	}
+	if c.KeepAliveMaxPeriod != 0 {
+		opts = append(opts, WithAdaptiveKeepAlive(time.Duration(c.KeepAlivePeriod), time.Duration(c.KeepAliveMaxPeriod)))
+	} else if c.KeepAlivePeriod != 0 {
+		opts = append(opts, WithKeepAlivePeriod(time.Duration(c.KeepAlivePeriod)))
+	}
+	if c.InactivityTimeout != 0 {
+		opts = append(opts, WithInactivityTimeout(time.Duration(c.InactivityTimeout)))
+	}
+	if c.CongestionThresholdRTT != 0 {
+		opts = append(opts, WithCongestionThresholdRTT(time.Duration(c.CongestionThresholdRTT)))
+	}
+	if c.BadModeRecoveryTime != 0 {
+		opts = append(opts, WithBadModeRecoveryTime(time.Duration(c.BadModeRecoveryTime)))
+	}
+
+	if c.InitialWindowSize != 0 {
+		opts = append(opts, WithInitialWindowSize(c.InitialWindowSize))
+	}
+	if c.NoSlowStart {
+		opts = append(opts, WithoutSlowStart())
+	}
+
+	if c.AckPolicy != "" {
+		policy, err := parseAckPolicy(c.AckPolicy, time.Duration(c.AckPolicyDelay))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAckPolicy(policy))
+	}
+	if c.AckDelay != 0 {
+		updatePeriod := time.Duration(c.UpdatePeriod)
+		if updatePeriod == 0 {
+			updatePeriod = DefaultUpdatePeriod
+		}
+		checkAckDelay(time.Duration(c.AckDelay), updatePeriod)
+
+		opts = append(opts, WithAckDelay(time.Duration(c.AckDelay)))
+	}
+	if c.AckSuppressionWindow != 0 {
+		opts = append(opts, WithAckSuppressionWindow(time.Duration(c.AckSuppressionWindow)))
+	}
+	if c.AckRanges {
+		opts = append(opts, WithAckRanges())
+	}
+	if c.UnsequencedAcks {
+		opts = append(opts, WithUnsequencedAcks())
+	}
+	if c.PassiveRTTPeriod != 0 {
+		opts = append(opts, WithPassiveRTT(time.Duration(c.PassiveRTTPeriod)))
+	}
+
+	if c.EventLogSize != 0 {
+		opts = append(opts, WithEventLogSize(c.EventLogSize))
+	}
+	if c.SentHistorySize != 0 {
+		opts = append(opts, WithSentHistorySize(c.SentHistorySize))
+	}
+
+	if c.AmplificationLimit != 0 {
+		opts = append(opts, WithAmplificationLimit(c.AmplificationLimit))
+	}
+
+	if c.FragmentSize != 0 {
+		opts = append(opts, WithFragmentSize(c.FragmentSize))
+	}
+
+	if c.ReassemblyMaxFragments != 0 || c.ReassemblyMaxMessages != 0 || c.ReassemblyTimeout != 0 {
+		opts = append(opts, WithReassemblyLimits(ReassemblyLimits{
+			MaxFragments: c.ReassemblyMaxFragments,
+			MaxMessages:  c.ReassemblyMaxMessages,
+			Timeout:      time.Duration(c.ReassemblyTimeout),
+		}))
+	}
+
+	if c.ProgressFloorRate != 0 || c.ProgressGrace != 0 {
+		opts = append(opts, WithProgressWatchdog(c.ProgressFloorRate, time.Duration(c.ProgressGrace)))
+	}
+
+	if c.MaxConns != 0 || c.MaxGoroutines != 0 || c.MaxBuffers != 0 || c.MaxBytes != 0 {
+		opts = append(opts, WithResourceLimits(ResourceLimits{
+			MaxConns:      c.MaxConns,
+			MaxGoroutines: c.MaxGoroutines,
+			MaxBuffers:    c.MaxBuffers,
+			MaxBytes:      c.MaxBytes,
+		}))
+	}
+	if c.ConnIdleTimeout != 0 {
+		opts = append(opts, WithConnIdleTimeout(time.Duration(c.ConnIdleTimeout)))
+	}
+
+	if c.QuotaSendBytes != 0 || c.QuotaRecvBytes != 0 {
+		opts = append(opts, WithQuota(Quota{
+			SendBytes: c.QuotaSendBytes,
+			RecvBytes: c.QuotaRecvBytes,
+			Interval:  time.Duration(c.QuotaInterval),
+		}))
+	}
+
+	if c.ErrorBudgetTransmitErrors != 0 || c.ErrorBudgetAnomalies != 0 {
+		budget := ErrorBudget{
+			TransmitErrors: c.ErrorBudgetTransmitErrors,
+			Anomalies:      c.ErrorBudgetAnomalies,
+			Interval:       time.Duration(c.ErrorBudgetInterval),
+			Cooldown:       time.Duration(c.ErrorBudgetCooldown),
+		}
+		if c.ErrorBudgetDisconnect {
+			budget.OnTripped = func(net.Addr, ErrorBudgetUsage) BreakerAction { return BreakerDisconnect }
+		}
+		opts = append(opts, WithErrorBudget(budget))
+	}
+
+	if c.RateLimit != 0 {
+		opts = append(opts, WithRateLimit(c.RateLimit, c.RateLimitBurst))
+	}
+	if c.EndpointRateLimit != 0 {
+		opts = append(opts, WithEndpointRateLimit(c.EndpointRateLimit, c.EndpointRateLimitBurst))
+	}
+
+	if c.PreallocatedBuffers != 0 {
+		opts = append(opts, WithPreallocation(c.PreallocatedBuffers, c.PreallocatedBufferSize))
+	}
+
+	if c.DeliveryDelay != 0 {
+		opts = append(opts, WithDeliveryDelay(c.DeliveryDelay))
+	}
+
+	if c.OrderedDelivery {
+		opts = append(opts, WithOrderedDelivery())
+	}
+
+	if c.ReadBatchSize != 0 {
+		opts = append(opts, WithReadBatchSize(c.ReadBatchSize))
+	}
+	if c.ReadWorkers != 0 {
+		opts = append(opts, WithReadWorkers(c.ReadWorkers))
+	}
+	if c.ReadQueueSize != 0 {
+		opts = append(opts, WithReadQueueSize(c.ReadQueueSize))
+	}
+	if c.ReadOrdering != "" {
+		ordering, err := parseReadOrdering(c.ReadOrdering)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithReadOrdering(ordering))
+	}
+
+	if c.TTL != 0 {
+		opts = append(opts, WithTTL(c.TTL))
+	}
+	if c.PlatformTuning {
+		opts = append(opts, WithPlatformTuning())
+	}
+	if c.SourcePinning {
+		opts = append(opts, WithSourcePinning())
+	}
+	if c.ProbeResponder {
+		opts = append(opts, WithProbeResponder())
+	}
+
+	return opts, nil
+}
+
+func parseProfile(name string) (Profile, error) {
+	for _, profile := range []Profile{ProfileDefault, ProfileBulk, ProfileRealtime, ProfileLossy} {
+		if profile.String() == name {
+			return profile, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown profile %q", name)
+}
+
+func parseAckPolicy(name string, delay time.Duration) (AckPolicy, error) {
+	switch name {
+	case "bitset":
+		return BitsetAckPolicy{}, nil
+	case "every_packet":
+		return EveryPacketAckPolicy{}, nil
+	case "delayed":
+		return DelayedAckPolicy{Delay: delay}, nil
+	case "adaptive":
+		return AdaptiveAckPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ack policy %q", name)
+	}
+}
+
+func parseReadOrdering(name string) (ReadOrdering, error) {
+	switch name {
+	case "fifo":
+		return ReadOrderingFIFO, nil
+	case "parallel":
+		return ReadOrderingParallel, nil
+	default:
+		return 0, fmt.Errorf("unknown read ordering %q", name)
+	}
+}
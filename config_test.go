@@ -0,0 +1,86 @@
+package reliable
+
+import (
+	"encoding/json"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestConfigEndpointOptions(t *testing.T) {
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"profile": "bulk",
+		"resend_timeout": "250ms",
+		"ack_policy": "delayed",
+		"ack_policy_delay": "20ms",
+		"read_workers": 2,
+		"read_ordering": "parallel",
+		"rate_limit": 1000,
+		"rate_limit_burst": 100
+	}`), &cfg))
+
+	require.Equal(t, Duration(250*time.Millisecond), cfg.ResendTimeout)
+
+	opts, err := cfg.EndpointOptions()
+	require.NoError(t, err)
+
+	conn := reliabletest.NewNetwork(0).Listen()
+	defer conn.Close()
+
+	e := NewEndpoint(conn, opts...)
+	require.Equal(t, 1*time.Millisecond, e.ackDelay) // preset by the bulk profile
+	require.Equal(t, 250*time.Millisecond, e.resendTimeout)
+	require.Equal(t, DelayedAckPolicy{Delay: 20 * time.Millisecond}, e.ackPolicy)
+	require.Equal(t, 2, e.readWorkers)
+	require.Equal(t, ReadOrderingParallel, e.readOrdering)
+	require.Equal(t, &RateLimit{Rate: 1000, Burst: 100}, e.rateLimit)
+
+	buf, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), `"resend_timeout":"250ms"`)
+}
+
+func TestConfigConnOptionsSkipEndpointOptions(t *testing.T) {
+	opts, err := Config{ResendTimeout: Duration(time.Second), ReadWorkers: 2, TTL: 4}.ConnOptions()
+	require.NoError(t, err)
+	require.Len(t, opts, 1)
+
+	c := NewConn(nil, nil, opts...)
+	require.Equal(t, time.Second, c.resendTimeout)
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Profile: "fast"},
+		{AckPolicy: "never"},
+		{ReadOrdering: "random"},
+		{ReadBufferSize: 100},
+		{TTL: 256},
+		{ReorderTolerance: DefaultReadBufferSize * 2},
+		{ErrorBudgetAnomalies: 1},
+		{MaxPacketResends: 256},
+		{ResendPacing: -1},
+		{InterleaveResends: 2},
+		{MinResendTimeout: Duration(-time.Millisecond)},
+		{BadModeRecoveryTime: Duration(-time.Second)},
+		{FragmentSize: -1},
+		{KeepAlivePeriod: Duration(-time.Second)},
+		{KeepAliveMaxPeriod: Duration(time.Second)},
+		{InactivityTimeout: Duration(-time.Second)},
+		{ReassemblyMaxFragments: 257},
+		{ProgressFloorRate: 1024},
+		{MaxConns: -1},
+		{ConnIdleTimeout: Duration(-time.Second)},
+		{PassiveRTTPeriod: Duration(-time.Second)},
+		{AckDelay: Duration(DefaultUpdatePeriod)},
+		{AckDelay: Duration(50 * time.Millisecond), UpdatePeriod: Duration(20 * time.Millisecond)},
+	} {
+		_, err := cfg.EndpointOptions()
+		require.Error(t, err)
+	}
+
+	var d Duration
+	require.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+}
@@ -0,0 +1,289 @@
+// Package conformance verifies that an implementation of the protocol behaves the way this package documents it
+// should on the wire, such that alternative implementations may check that they interoperate with this package.
+//
+// The suite plays the part of a peer of the implementation under test over a simulated network, writing and reading
+// raw packets, and checks that acks, windowing, and retransmissions follow the documented protocol semantics.
+package conformance
+
+import (
+	"github.com/lithdew/reliable"
+	"github.com/lithdew/reliable/reliabletest"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Timeout is how long the suite waits for the implementation under test to do something before failing.
+var Timeout = 1 * time.Second
+
+// Target is an instance of the implementation under test.
+type Target struct {
+	Write func(buf []byte, addr net.Addr) error // writes a reliable packet to addr
+	Close func()                                // stops the instance, which must stop using its socket
+}
+
+// Factory starts an instance of the implementation under test on conn, using the default read buffer size, write
+// buffer size, and resend timeout of this package. deliver must be called with every payload the instance delivers
+// to its application, and copies buf.
+type Factory func(conn net.PacketConn, deliver func(buf []byte)) Target
+
+// Run runs the conformance suite against instances created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("AcksFullBitset", func(t *testing.T) { testAcksFullBitset(t, factory) })
+	t.Run("DeliversOnce", func(t *testing.T) { testDeliversOnce(t, factory) })
+	t.Run("PiggybacksAcks", func(t *testing.T) { testPiggybacksAcks(t, factory) })
+	t.Run("ResendsUnacked", func(t *testing.T) { testResendsUnacked(t, factory) })
+	t.Run("StopsResendingAcked", func(t *testing.T) { testStopsResendingAcked(t, factory) })
+	t.Run("RespectsReadBuffer", func(t *testing.T) { testRespectsReadBuffer(t, factory) })
+}
+
+// harness is a raw peer of an instance of the implementation under test.
+type harness struct {
+	t      *testing.T
+	conn   *reliabletest.PacketConn
+	target Target
+	addr   net.Addr // address of the instance
+
+	mu        sync.Mutex
+	delivered [][]byte
+}
+
+func newHarness(t *testing.T, factory Factory) *harness {
+	network := reliabletest.NewNetwork(0)
+
+	h := &harness{t: t, conn: network.Listen()}
+
+	conn := network.Listen()
+	h.addr = conn.LocalAddr()
+	h.target = factory(conn, func(buf []byte) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.delivered = append(h.delivered, append([]byte(nil), buf...))
+	})
+
+	return h
+}
+
+func (h *harness) close() {
+	h.target.Close()
+	if err := h.conn.Close(); err != nil {
+		h.t.Fatalf("failed to close harness conn: %s", err)
+	}
+}
+
+func (h *harness) write(header reliable.PacketHeader, buf []byte) {
+	h.t.Helper()
+
+	if _, err := h.conn.WriteTo(append(header.AppendTo(nil), buf...), h.addr); err != nil {
+		h.t.Fatalf("failed to write packet: %s", err)
+	}
+}
+
+// read reads the next packet written by the instance, reporting false should none arrive before the deadline.
+func (h *harness) read(deadline time.Time) (header reliable.PacketHeader, buf []byte, ok bool) {
+	h.t.Helper()
+
+	if err := h.conn.SetReadDeadline(deadline); err != nil {
+		h.t.Fatalf("failed to set read deadline: %s", err)
+	}
+
+	b := make([]byte, math.MaxUint16+1)
+
+	n, _, err := h.conn.ReadFrom(b)
+	if err != nil {
+		return header, nil, false
+	}
+
+	header, buf, err = reliable.UnmarshalPacketHeader(b[:n])
+	if err != nil {
+		h.t.Fatalf("instance wrote a malformed packet: %s", err)
+	}
+
+	return header, buf, true
+}
+
+// expect reads packets written by the instance until one satisfies fn, failing should none arrive in time.
+func (h *harness) expect(what string, fn func(header reliable.PacketHeader, buf []byte) bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for {
+		header, buf, ok := h.read(deadline)
+		if !ok {
+			h.t.Fatalf("instance did not write %s in time", what)
+		}
+		if fn(header, buf) {
+			return
+		}
+	}
+}
+
+func (h *harness) deliveries() [][]byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([][]byte(nil), h.delivered...)
+}
+
+func (h *harness) waitForDeliveries(n int) {
+	h.t.Helper()
+
+	for deadline := time.Now().Add(Timeout); len(h.deliveries()) < n; time.Sleep(1 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("instance delivered %d payload(s), expected %d", len(h.deliveries()), n)
+		}
+	}
+}
+
+// testAcksFullBitset checks that a standalone ack is written once a full ack bitset of packets was received.
+func testAcksFullBitset(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+	defer h.close()
+
+	for i := uint16(0); i < reliable.ACKBitsetSize; i++ {
+		h.write(reliable.PacketHeader{Sequence: i, ACK: math.MaxUint16}, []byte{byte(i)})
+	}
+
+	h.expect("an ack for a full ack bitset", func(header reliable.PacketHeader, _ []byte) bool {
+		return header.ACK == reliable.ACKBitsetSize-1 && header.ACKBits == math.MaxUint32
+	})
+}
+
+// testDeliversOnce checks that duplicate reliable packets are delivered once, and then acked.
+func testDeliversOnce(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+	defer h.close()
+
+	h.write(reliable.PacketHeader{Sequence: 0, ACK: math.MaxUint16}, []byte("hello"))
+	h.waitForDeliveries(1)
+
+	h.write(reliable.PacketHeader{Sequence: 0, ACK: math.MaxUint16}, []byte("hello"))
+
+	h.expect("an ack for a duplicate packet", func(header reliable.PacketHeader, _ []byte) bool {
+		return header.ACK == 0 && header.ACKBits&1 == 1
+	})
+
+	if n := len(h.deliveries()); n != 1 {
+		t.Fatalf("instance delivered a duplicate packet %d time(s), expected once", n)
+	}
+}
+
+// testPiggybacksAcks checks that packets written by an instance ack the packets it has received.
+func testPiggybacksAcks(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+	defer h.close()
+
+	for i := uint16(0); i < 3; i++ {
+		h.write(reliable.PacketHeader{Sequence: i, ACK: math.MaxUint16}, nil)
+	}
+	h.waitForDeliveries(3)
+
+	if err := h.target.Write([]byte("hello"), h.conn.LocalAddr()); err != nil {
+		t.Fatalf("failed to write packet: %s", err)
+	}
+
+	h.expect("a packet acking received packets", func(header reliable.PacketHeader, buf []byte) bool {
+		if string(buf) != "hello" {
+			return false
+		}
+		if header.ACK != 2 || header.ACKBits&0b111 != 0b111 {
+			t.Fatalf("packet acked %05d with bits %032b, expected 00002 with the lowest three bits set", header.ACK, header.ACKBits)
+		}
+		return true
+	})
+}
+
+// testResendsUnacked checks that a reliable packet that is not acked gets resent.
+func testResendsUnacked(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+	defer h.close()
+
+	if err := h.target.Write([]byte("hello"), h.conn.LocalAddr()); err != nil {
+		t.Fatalf("failed to write packet: %s", err)
+	}
+
+	h.expect("a reliable packet", func(header reliable.PacketHeader, buf []byte) bool {
+		return !header.Unordered && string(buf) == "hello"
+	})
+
+	h.expect("a resend of an unacked packet", func(header reliable.PacketHeader, buf []byte) bool {
+		return !header.Unordered && header.Sequence == 0 && string(buf) == "hello"
+	})
+}
+
+// testStopsResendingAcked checks that a reliable packet stops being resent once acked.
+func testStopsResendingAcked(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+	defer h.close()
+
+	if err := h.target.Write([]byte("hello"), h.conn.LocalAddr()); err != nil {
+		t.Fatalf("failed to write packet: %s", err)
+	}
+
+	h.expect("a reliable packet", func(header reliable.PacketHeader, buf []byte) bool {
+		return !header.Unordered && string(buf) == "hello"
+	})
+
+	h.write(reliable.PacketHeader{Sequence: 0, ACK: 0, ACKBits: 1, Unordered: true}, nil)
+
+	// Give a resend that raced with our ack a chance to arrive, then make sure nothing is resent for a few resend
+	// timeouts.
+
+	time.Sleep(reliable.DefaultResendTimeout)
+
+	for deadline := time.Now().Add(3 * reliable.DefaultResendTimeout); ; {
+		header, buf, ok := h.read(deadline)
+		if !ok {
+			return
+		}
+		if !header.Unordered && header.Sequence == 0 && string(buf) == "hello" {
+			t.Fatalf("instance resent a packet that was acked")
+		}
+	}
+}
+
+// testRespectsReadBuffer checks that an instance never has more packets in flight than fit in our read buffer.
+func testRespectsReadBuffer(t *testing.T, factory Factory) {
+	h := newHarness(t, factory)
+
+	done := make(chan struct{})
+
+	// Closing the instance unblocks the writer, which is waiting on our read buffer to free up.
+
+	defer func() {
+		h.close()
+		<-done
+	}()
+
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*int(reliable.DefaultReadBufferSize); i++ {
+			if err := h.target.Write(nil, h.conn.LocalAddr()); err != nil {
+				return
+			}
+		}
+	}()
+
+	seen := make(map[uint16]struct{})
+
+	for deadline := time.Now().Add(3 * reliable.DefaultResendTimeout); ; {
+		header, _, ok := h.read(deadline)
+		if !ok {
+			break
+		}
+		if header.Unordered {
+			continue
+		}
+		seen[header.Sequence] = struct{}{}
+	}
+
+	if len(seen) > int(reliable.DefaultReadBufferSize) {
+		t.Fatalf("instance had %d packets in flight, expected at most %d", len(seen), reliable.DefaultReadBufferSize)
+	}
+	if len(seen) == 0 {
+		t.Fatalf("instance did not write any reliable packets")
+	}
+}
@@ -0,0 +1,28 @@
+package conformance
+
+import (
+	"github.com/lithdew/reliable"
+	"go.uber.org/goleak"
+	"net"
+	"testing"
+)
+
+func TestEndpointConformance(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	Run(t, func(conn net.PacketConn, deliver func(buf []byte)) Target {
+		e := reliable.NewEndpoint(conn, reliable.WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+			deliver(buf)
+		}))
+
+		go e.Listen()
+
+		return Target{
+			Write: e.WriteReliablePacket,
+			Close: func() {
+				_ = conn.Close()
+				_ = e.Close()
+			},
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package reliable
+
+// ReportCongestion feeds a congestion signal observed by the application, such as frames failing to decode in time
+// or audio buffers running dry, into this conn. level is how severe the congestion is from 0 to 1, and shrinks the
+// window of packets that may be in flight to our peer by up to half. The window grows back as our peer acks packets,
+// though it never shrinks below the size of an ack bitset so that our peer may keep writing standalone acks.
+func (c *Conn) ReportCongestion(level float64) {
+	if level <= 0 {
+		return
+	}
+	if level > 1 {
+		level = 1
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.CongestionReports++
+
+	cwnd := uint16(float64(c.window()) * (1 - level/2))
+	if cwnd < ACKBitsetSize {
+		cwnd = ACKBitsetSize
+	}
+	c.cwnd = cwnd
+}
@@ -1,50 +1,230 @@
 package reliable
 
 import (
+	"context"
 	"fmt"
-	"github.com/lithdew/seq"
+	"github.com/lithdew/reliable/sequence"
 	"io"
+	"math"
+	"math/bits"
+	"math/rand"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	transmitRetries = 3                     // max number of times a write is retried should it fail with a transient error
+	transmitBackoff = 50 * time.Microsecond // how long we wait before first retrying a write, doubling per retry
+)
+
+// Conn is a reliable.io-style connection to a single peer over a shared socket.
+//
+// All methods of a Conn are safe to call concurrently, unless stated otherwise:
+//
+//   - Any number of goroutines may write at once. Reliable writers are served first come, first served, and wait
+//     their turn should our peer's read buffer be full.
+//   - Read may be called from any number of goroutines, though packets are then only delivered in the order they
+//     were read should Read be called from a single goroutine.
+//   - Close may be called at any time, including while writes are blocked, while Read is delivering a packet, and
+//     from within a packet handler. Blocked writes are woken up, and they along with all later reads and writes
+//     fail with io.EOF.
+//   - Packet handlers are called without any lock held, and may write to or close this conn.
+//   - A Writer is not safe for concurrent use, though any number of writers may be used at once.
 type Conn struct {
 	writeBufferSize uint16 // write buffer size that must be a divisor of 65536
 	readBufferSize  uint16 // read buffer size that must be a divisor of 65536
 
+	reorderTolerance uint16 // number of sequence numbers up to that of the newest packet read still accepted
+
 	updatePeriod  time.Duration // how often time-dependant parts of the protocol get checked
-	resendTimeout time.Duration // how long we wait until unacked packets should be resent
+	resendTimeout time.Duration // how long we wait until unacked packets should be resent, until the rtt is sampled
+
+	minResendTimeout   time.Duration // lower bound of resend timeouts derived from the rtt
+	fixedResendTimeout bool          // whether or not resendTimeout is used regardless of the rtt
+	resendBackoff      uint          // number of times the resend timeout got doubled since the rtt was last sampled
+
+	resendPacing       int    // max number of unacked packets resent per update, or zero if unlimited
+	maxResends         byte   // max number of times an unacked packet is resent
+	failResends        bool   // whether or not this conn fails once an unacked packet was resent maxResends times
+	resendsFailed      bool   // whether or not an unacked packet was resent maxResends times
+	onResendsExhausted func() // called in place of disconnecting once an unacked packet was resent too often if set
+
+	keepAlivePeriod  time.Duration // how long nothing may be written to our peer before a keepalive is, or zero if disabled
+	keepAliveMin     time.Duration // shortest keepalive period should it be adaptive
+	keepAliveMax     time.Duration // longest keepalive period probed for should it be adaptive, or zero if it is fixed
+	keepAliveProven  time.Duration // longest time nothing was written to our peer after which our peer was still heard
+	keepAliveSettled bool          // whether or not an adaptive keepalive period stopped being probed for
+
+	inactivityTimeout time.Duration // how long nothing may be read from our peer before this conn fails, or zero if disabled
+	inactive          bool          // whether or not nothing was read from our peer for the inactivity timeout
+	onInactive        func()        // called in place of disconnecting once nothing was read for the inactivity timeout if set
 
 	conn net.PacketConn
-	addr net.Addr
-	pool *Pool
+	addr atomic.Value // peerAddr of our peer, which changes should our peer migrate to a new address
+	key  string       // key of this conn in the conns of its endpoint, if any
+	src  atomic.Value // sourcePin of the local address datagrams are written from, if pinned
+	pool BufferPool
+	ab   *ackBatcher // batches up standalone acks if set
 
-	ph PacketHandler
-	eh ErrorHandler
+	ph  PacketHandler
+	rph PacketHandler // handles reliable packets in place of ph if set
+	uph PacketHandler // handles unreliable packets in place of ph if set
+	eh  ErrorHandler
+	ah  AckHandler // called once for every reliable packet acked by our peer if set
 
-	mu   sync.Mutex    // mutex over everything
-	die  bool          // is this conn closed?
-	exit chan struct{} // signal channel to close the conn
+	acked []uint16 // sequence numbers of packets acked that are yet to be handed to the ack handler
+
+	provider BufferProvider // provides memory payloads are delivered in if set
+
+	rand *lockedRand // source of all randomness used by the protocol
+
+	el *eventLog    // ring of recent protocol events if enabled
+	sh *sentHistory // ring of records of recently written reliable packets if enabled
+
+	sched   Scheduler      // decides the order in which unacked packets are resent
+	due     []QueuedPacket // unacked packets due to be resent
+	dueBufs []*Buffer      // pooled copies of the contents of unacked packets due to be resent
+
+	mu   sync.Mutex     // mutex over everything
+	die  bool           // is this conn closed?
+	exit chan struct{}  // signal channel to close the conn
+	busy sync.WaitGroup // readers, writers, and Run that Close waits on before releasing pooled buffers
 
 	lui uint16    // last sent packet index that hasn't been sent via an ack yet
 	oui uint16    // oldest sent packet index that hasn't been acked yet
 	ouc sync.Cond // stop writes if the next write given oui may flood our peers read buffer
 	ls  time.Time // last time data was sent to our peer
+	lr  time.Time // last time a packet was read from our peer
 
 	wi uint16 // write index
 	ri uint16 // read index
 
+	wpn uint64 // 64-bit logical number of wi, counting wraps of the sequence space
+	rpn uint64 // 64-bit logical number of ri, counting wraps of the sequence space
+
 	wq []uint32 // write queue
 	rq []uint32 // read queue
 
 	wqe []writtenPacket // write queue entries
+
+	inbox readQueue // datagrams read by an endpoint yet to be processed
+
+	tickets uint64              // total number of tickets handed out to reliable writers
+	serving uint64              // ticket of the reliable writer that is next in line to write
+	skipped map[uint64]struct{} // tickets given up by reliable writers before their turn came
+
+	stalled    bool // whether or not a writer has stalled on a full window since the last update
+	appLimited bool // whether or not writes were limited by the application rather than the window last update
+
+	amplification     int    // max multiple of received bytes sent until our peer is validated, or zero if unlimited
+	amplificationSent uint64 // bytes sent to our peer before it was validated
+	amplificationRecv uint64 // bytes received from our peer before it was validated
+	validated         bool   // whether or not our peer echoed our challenge, or presented a token bound to its address
+
+	challenge        [challengeNonceSize]byte // random nonce our peer must echo to be validated
+	challenged       bool                     // whether or not the challenge nonce was drawn
+	challengeWritten time.Time                // last time a challenge was written to our peer
+
+	quota         *Quota      // bounds payload bytes written to and read from our peer if set
+	quotaUsage    QuotaUsage  // payload bytes written and read in the current quota interval
+	quotaExceeded bool        // whether or not the quota was exceeded in the current quota interval
+	quotaAction   QuotaAction // what to do for the rest of the quota interval once the quota was exceeded
+
+	onQuotaExceeded func() // called once per quota interval should the quota be exceeded if set
+
+	budget       *ErrorBudget        // bounds transmit errors and protocol anomalies per interval if set
+	budgetUsage  ErrorBudgetUsage    // transmit errors and protocol anomalies in the current error budget interval
+	breakerUntil time.Time           // when writes and resends to our peer resume after the error budget was exceeded
+	onTripped    func(BreakerAction) // called in place of acting on the circuit breaker tripping if set
+
+	keyring *Keyring           // verifies connect tokens presented by our peer if set
+	token   *ConnectToken      // valid connect token presented by our peer, if any
+	onToken func(ConnectToken) // called with every valid connect token presented by our peer if set
+
+	tokenKey      uint32                        // id of the token key the connect token our peer presented was minted with
+	tokenKeyState tokenKeyState                 // whether the token key was last reported to be rotated out or retired
+	onTokenKey    func(id uint32, retired bool) // called once the token key is rotated out, and once it is retired, if set
+
+	ackPolicy        AckPolicy // decides when standalone acks are written
+	pendingAcks      uint16    // number of reliable packets read since the newest read packet was last acked
+	pendingAcksSince time.Time // when the oldest pending packet was read
+	ackLost          bool      // whether or not a standalone ack was lost since the newest read packet was last acked
+	unsequencedAcks  bool      // whether or not standalone acks are written without consuming a sequence number
+
+	ackRanges     bool   // whether or not ack range frames are written to our peer
+	rangesPending bool   // whether or not packets were read since the last ack range frame was written
+	rangesBuf     []byte // ack range frame last written
+
+	power        PowerState  // power state reported by the application
+	acksDeferred bool        // whether or not acks were held back while in the background or suppressed
+	coalesced    *ackBatcher // payloads written while in the background, held back until the next update if set
+
+	ackSuppression time.Duration // how long ExpectWriteSoon holds back standalone acks for
+	suppressUntil  time.Time     // when acks held back by ExpectWriteSoon are to be written out
+	suppressTimer  *time.Timer   // writes out acks held back by ExpectWriteSoon once suppressUntil passes
+
+	writeTimer    *time.Timer // fails writes once the write deadline passes, if a write deadline is set
+	writeDeadline uint64      // number of times the write deadline was set, telling stale timers apart
+	writeExpired  bool        // whether or not the write deadline passed
+
+	cwnd uint16 // max number of packets that may be in flight to our peer, which grows as our peer acks packets
+
+	rtt    time.Duration // smoothed round-trip time to our peer, or zero if not yet sampled
+	rttvar time.Duration // mean deviation of round-trip time samples from rtt
+
+	rttSampled       time.Time     // last time the round-trip time was sampled
+	passiveRTTPeriod time.Duration // how long the round-trip time may go unsampled before a timestamp is written, or zero if never
+	tsWritten        time.Time     // when the timestamp yet to be echoed by our peer was written, or zero if none
+
+	loss  float64     // smoothed fraction of reliable packets written that had to be resent
+	rates rateSampler // rates of traffic to and from our peer
+
+	badRTT      time.Duration // rtt above which conditions are bad, or zero if bad mode is disabled
+	badRecovery time.Duration // how long conditions must stay good to leave bad mode
+	bad         bool          // whether or not this conn is in bad mode
+	goodSince   time.Time     // when conditions turned good while in bad mode, or zero if they are bad
+
+	fragmentSize int                        // size of the fragments larger payloads are split into, or zero if never
+	fragmentID   uint16                     // id of the next payload to be split into fragments
+	reassembly   ReassemblyLimits           // bounds on fragments read of payloads yet to be reassembled
+	partial      map[uint16]*partialMessage // payloads of which only some fragments were read so far, by id
+
+	watchdog  *progressFloor       // min throughput of fragmented payloads written and read if set
+	transfers map[uint16]*transfer // fragmented payloads being written, by id
+
+	limiter  *tokenBucket // rate limit on payload bytes written to our peer if set
+	elimiter *tokenBucket // rate limit on payload bytes written by all conns of our endpoint if set
+
+	interleave *interleaver // turns taken between resends and fresh writes if set
+
+	taps  tapSet  // read-only observers of this conn
+	etaps *tapSet // read-only observers of all conns of our endpoint if set
+
+	held    *heldPackets    // packets held back from being delivered until a release tick if set
+	ordered *orderedPackets // reliable packets held back from being delivered until those sent before them are if set
+
+	goroutines int32  // number of goroutines running on behalf of this conn
+	running    uint32 // whether or not Run is running
+
+	refs int32  // number of packets being read or written by our endpoint, which keep this conn from being evicted
+	uses uint32 // number of times our endpoint got this conn to read or write a packet
+
+	onUpdate func()    // called on every update so that our endpoint may count what this conn holds onto if set
+	reported Resources // what our endpoint last counted this conn as holding onto, guarded by the endpoint's mutex
+
+	stats ConnStats
+	ews   *writeStats // write syscall stats of the endpoint this conn belongs to if set
 }
 
 func NewConn(conn net.PacketConn, addr net.Addr, opts ...ConnOption) *Conn {
-	c := &Conn{conn: conn, addr: addr, exit: make(chan struct{})}
+	c := &Conn{conn: conn, exit: make(chan struct{})}
+	c.addr.Store(peerAddr{addr})
 
-	for _, opt := range opts {
+	for i, opt := range opts {
+		checkProfile(i, opt)
 		opt.applyConn(c)
 	}
 
@@ -56,16 +236,54 @@ func NewConn(conn net.PacketConn, addr net.Addr, opts ...ConnOption) *Conn {
 		c.readBufferSize = DefaultReadBufferSize
 	}
 
+	if c.reorderTolerance == 0 {
+		c.reorderTolerance = c.readBufferSize
+	}
+	checkReorderTolerance(c.reorderTolerance, c.readBufferSize)
+
 	if c.resendTimeout == 0 {
 		c.resendTimeout = DefaultResendTimeout
 	}
 
+	if c.badRecovery == 0 {
+		c.badRecovery = DefaultBadModeRecoveryTime
+	}
+
+	if c.minResendTimeout == 0 {
+		c.minResendTimeout = DefaultMinResendTimeout
+	}
+
+	if c.maxResends == 0 {
+		c.maxResends = DefaultMaxPacketResends
+	}
+
 	if c.updatePeriod == 0 {
 		c.updatePeriod = DefaultUpdatePeriod
 	}
 
 	if c.pool == nil {
-		c.pool = new(Pool)
+		c.pool = NewBufferPool(new(Pool))
+	}
+	checkFragmentSize(c.pool, c.fragmentSize)
+
+	if c.sched == nil {
+		c.sched = FIFOScheduler{}
+	}
+
+	if c.rand == nil {
+		c.rand = newLockedRand(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	if c.ackPolicy == nil {
+		c.ackPolicy = BitsetAckPolicy{}
+	}
+
+	if c.cwnd == 0 {
+		c.cwnd = DefaultInitialWindowSize
+	}
+
+	if c.ackSuppression == 0 {
+		c.ackSuppression = DefaultAckSuppressionWindow
 	}
 
 	c.wq = make([]uint32, c.writeBufferSize)
@@ -78,65 +296,278 @@ func NewConn(conn net.PacketConn, addr net.Addr, opts ...ConnOption) *Conn {
 
 	c.ouc.L = &c.mu
 
+	c.appLimited = true
+
+	c.rates = newRateSampler()
+	c.reassembly = c.reassembly.withDefaults()
+
+	c.quotaUsage.Since = time.Now()
+	c.budgetUsage.Since = c.quotaUsage.Since
+	c.ls = c.quotaUsage.Since
+	c.lr = c.quotaUsage.Since
+
 	return c
 }
 
 func (c *Conn) WriteReliablePacket(buf []byte) error {
-	return c.writePacket(true, buf)
+	return c.writePacket(context.Background(), true, buf)
+}
+
+// WriteReliablePacketContext writes buf reliably to our peer, giving up with ctx.Err() should ctx be done before buf
+// gets its turn to be written, such as while waiting for our peer's read buffer to free up. Writes that give up are
+// not assigned a sequence number.
+func (c *Conn) WriteReliablePacketContext(ctx context.Context, buf []byte) error {
+	return c.writePacket(ctx, true, buf)
 }
 
 func (c *Conn) WriteUnreliablePacket(buf []byte) error {
-	return c.writePacket(false, buf)
+	return c.writePacket(context.Background(), false, buf)
+}
+
+func (c *Conn) writePacket(ctx context.Context, reliable bool, buf []byte) error {
+	var err error
+	if c.fragmented(len(buf)) {
+		err = c.writeFragments(ctx, reliable, buf)
+	} else {
+		_, err = c.writeOne(ctx, PacketHeader{Unordered: !reliable}, buf)
+	}
+
+	if err == nil {
+		c.trackWritten(reliable)
+	}
+
+	return err
 }
 
-func (c *Conn) writePacket(reliable bool, buf []byte) error {
+// writeOne writes buf as a single packet to our peer, with the sequence number and acks of header filled in,
+// reporting whether or not buf was queued to be resent as a reliable packet, which it may be even should it fail to be
+// transmitted.
+func (c *Conn) writeOne(ctx context.Context, header PacketHeader, buf []byte) (queued bool, err error) {
+	start := time.Now()
+	reliable := !header.Unordered
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if c.writeDeadlineExceeded() {
+		return false, os.ErrDeadlineExceeded
+	}
+
+	if c.breakerOpen() {
+		return false, ErrCircuitOpen
+	}
+
+	if allowed, disconnect := c.chargeQuota(true, len(buf)); !allowed {
+		if disconnect {
+			c.Close()
+			return false, io.EOF
+		}
+		return false, ErrQuotaExceeded
+	}
+
+	c.takeWriteTurn()
+
+	if !c.throttle(len(buf)) {
+		return false, io.EOF
+	}
+
+	if !c.enter() {
+		return false, io.EOF
+	}
+	defer c.leave()
+
+	// The buffer is gotten before a sequence number is assigned, such that no sequence number is skipped should
+	// buffers run out.
+
+	size := len(buf)
+	if header.Fragment {
+		size += fragmentHeaderSize
+	}
+
+	b, err := c.getBuffer(size)
+	if err != nil {
+		return false, err
+	}
+
 	var (
 		idx     uint16
 		ack     uint16
 		ackBits uint32
-		ok      = true
 	)
 
 	if reliable {
-		idx, ack, ackBits, ok = c.waitForNextWriteDetails()
+		idx, ack, ackBits, err = c.waitForNextWriteDetails(ctx)
 	} else {
+		c.mu.Lock()
 		ack, ackBits = c.nextAckDetails()
+		c.mu.Unlock()
 	}
 
-	if !ok {
-		return io.EOF
+	if err != nil {
+		c.pool.Put(b)
+		return false, err
 	}
 
+	turn := time.Now()
+
 	c.trackAcked(ack)
 
-	if err := c.write(PacketHeader{Sequence: idx, ACK: ack, ACKBits: ackBits, Unordered: !reliable}, buf); err != nil {
-		return err
+	header.Sequence, header.ACK, header.ACKBits = idx, ack, ackBits
+
+	if err := c.writeBuffer(b, header, buf); err != nil {
+		return reliable, err
+	}
+
+	if reliable {
+		c.trackLifecycleWrite(turn.Sub(start), time.Since(turn))
 	}
 
 	//log.Printf("%s: send    (seq=%05d) (ack=%05d) (ack_bits=%032b) (size=%d) (reliable=%t)", c.conn.LocalAddr(), idx, ack, ackBits, len(buf), reliable)
 
-	return nil
+	return reliable, nil
+}
+
+func (c *Conn) readerAvailable() bool {
+	return !sequence.GT(c.wi+1, c.oui+c.window())
+}
+
+// window returns the max number of packets that may be in flight to our peer. It starts off at the initial window
+// size and grows by one for every packet our peer acks until it covers our peer's entire read buffer, such that a
+// fresh peer does not get blasted with a full read buffer's worth of packets at once.
+func (c *Conn) window() uint16 {
+	window := uint16(len(c.rq))
+	if c.cwnd < window {
+		window = c.cwnd
+	}
+
+	// The window is halved while in bad mode, though never below the size of an ack bitset.
+
+	if c.bad && window > ACKBitsetSize {
+		window /= 2
+		if window < ACKBitsetSize {
+			window = ACKBitsetSize
+		}
+	}
+
+	return window
 }
 
-func (c *Conn) waitUntilReaderAvailable() {
-	for !c.die && seq.GT(c.wi+1, c.oui+uint16(len(c.rq))) {
+// waitUntilReaderAvailable waits until it is the turn of the writer holding ticket, and until the next write would
+// not flood our peer's read buffer, or until ctx is done or the write deadline passes. Writers are served in the
+// order they took their tickets.
+func (c *Conn) waitUntilReaderAvailable(ctx context.Context, ticket uint64) {
+	defer c.wakeOnDone(ctx)()
+
+	stalled := false
+
+	for !c.die && !c.writeExpired && ctx.Err() == nil && (ticket != c.serving || !c.readerAvailable()) {
+		if !stalled && ticket == c.serving {
+			c.record(EventStall, c.wi, c.oui, 0, 0)
+			c.stalled = true
+			stalled = true
+		}
+
 		c.ouc.Wait()
 	}
 }
 
-func (c *Conn) waitForNextWriteDetails() (idx uint16, ack uint16, ackBits uint32, ok bool) {
+// wakeOnDone wakes up all writers waiting for their turn once ctx is done, returning a function that stops doing so.
+// It must be called with c.mu held.
+func (c *Conn) wakeOnDone(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stopped := make(chan struct{})
+
+	atomic.AddInt32(&c.goroutines, 1)
+	go func() {
+		defer atomic.AddInt32(&c.goroutines, -1)
+
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.ouc.Broadcast()
+			c.mu.Unlock()
+		case <-stopped:
+		}
+	}()
+
+	return func() { close(stopped) }
+}
+
+func (c *Conn) waitForNextWriteDetails(ctx context.Context) (idx uint16, ack uint16, ackBits uint32, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.waitUntilReaderAvailable()
+	ticket := c.takeTicket()
+	defer c.releaseTicket(ticket)
+
+	return c.waitForTurn(ctx, ticket)
+}
+
+func (c *Conn) takeTicket() (ticket uint64) {
+	ticket, c.tickets = c.tickets, c.tickets+1
+	return ticket
+}
+
+// waitForTurn waits until the writer holding ticket may write the next reliable packet, returning its write
+// details. The writer keeps its turn until it releases its ticket. Should ctx be done first, ctx.Err() is returned
+// without a sequence number having been assigned.
+func (c *Conn) waitForTurn(ctx context.Context, ticket uint64) (idx uint16, ack uint16, ackBits uint32, err error) {
+	if ticket != c.serving || !c.readerAvailable() {
+		start := time.Now()
+		c.waitUntilReaderAvailable(ctx, ticket)
+		c.trackWriteWait(time.Since(start))
+	}
+
+	if c.die {
+		return idx, ack, ackBits, io.EOF
+	}
+
+	if ticket != c.serving || !c.readerAvailable() {
+		if c.writeExpired {
+			return idx, ack, ackBits, os.ErrDeadlineExceeded
+		}
+		return idx, ack, ackBits, ctx.Err()
+	}
 
-	idx, ok = c.nextWriteIndex(), !c.die
+	idx = c.nextWriteIndex()
 	ack, ackBits = c.nextAckDetails()
-	return idx, ack, ackBits, ok
+	return idx, ack, ackBits, nil
 }
 
+// releaseTicket gives up the turn to write of the writer holding ticket, passing it onto the writer holding the next
+// ticket that was not given up. Should it not yet be the turn of the writer, its turn is skipped once it comes.
+func (c *Conn) releaseTicket(ticket uint64) {
+	if ticket != c.serving {
+		if c.skipped == nil {
+			c.skipped = make(map[uint64]struct{})
+		}
+		c.skipped[ticket] = struct{}{}
+		return
+	}
+
+	c.serving++
+	for len(c.skipped) > 0 {
+		if _, ok := c.skipped[c.serving]; !ok {
+			break
+		}
+		delete(c.skipped, c.serving)
+		c.serving++
+	}
+
+	if c.serving != c.tickets {
+		c.ouc.Broadcast()
+	}
+}
+
+// nextWriteIndex, nextAckDetails, and prepareAckBits must be called with c.mu held.
+
 func (c *Conn) nextWriteIndex() (idx uint16) {
 	idx, c.wi = c.wi, c.wi+1
+	c.wpn++
 	return idx
 }
 
@@ -157,9 +588,30 @@ func (c *Conn) prepareAckBits(ack uint16) (ackBits uint32) {
 	return ackBits
 }
 
+// getBuffer returns a buffer to marshal a packet with a payload of n bytes into.
+func (c *Conn) getBuffer(n int) (*Buffer, error) {
+	if !fits(c.pool, maxPacketHeaderSize+n) {
+		return nil, ErrPacketTooLarge
+	}
+
+	b := c.pool.Get(maxPacketHeaderSize + n)
+	if b == nil {
+		return nil, ErrBuffersExhausted
+	}
+
+	return b, nil
+}
+
 func (c *Conn) write(header PacketHeader, buf []byte) error {
-	b := c.pool.Get()
+	b, err := c.getBuffer(len(buf))
+	if err != nil {
+		return err
+	}
+	return c.writeBuffer(b, header, buf)
+}
 
+// writeBuffer marshals and writes a packet into b, which must have been gotten from getBuffer.
+func (c *Conn) writeBuffer(b *Buffer, header PacketHeader, buf []byte) error {
 	b.B = header.AppendTo(b.B)
 	b.B = append(b.B, buf...)
 
@@ -168,22 +620,63 @@ func (c *Conn) write(header PacketHeader, buf []byte) error {
 	}
 
 	if !header.Unordered {
-		c.trackWrite(header.Sequence, b)
+		c.trackWrite(header.Sequence, b, len(buf), header.Empty && len(buf) == 0)
+	}
+
+	c.trackAckWritten(header.ACK)
+
+	ack := header.Empty && len(buf) == 0
+
+	if ack {
+		c.record(EventSendAck, header.Sequence, header.ACK, header.ACKBits, 0)
+	} else {
+		c.record(EventSend, header.Sequence, header.ACK, header.ACKBits, len(buf))
+	}
+
+	kind := wirePayload
+	switch {
+	case ack:
+		kind = wireAck
+	case header.Empty:
+		kind = wireControl
+	}
+
+	if ack && c.ab != nil {
+		if c.allowTransmit(len(b.B)) {
+			c.ab.push(c.peer(), c.sourceOOB(), b.B)
+			c.trackWire(kind, len(b.B), 0)
+		}
+		return nil
 	}
 
-	if err := c.transmit(b.B); err != nil && !isEOF(err) {
+	if bg := c.coalescer(); kind == wirePayload && bg != nil {
+		if c.allowTransmit(len(b.B)) {
+			bg.push(c.peer(), c.sourceOOB(), b.B)
+			c.trackWire(kind, len(b.B), len(buf))
+		}
+		return nil
+	}
+
+	if err := c.transmit(b.B, kind, len(buf)); err != nil && !isEOF(err) {
+		// Reliable packets that failed to be transmitted due to a transient error will get resent.
+
+		if !header.Unordered && isTemporary(err) {
+			return nil
+		}
+
 		return fmt.Errorf("failed to transmit packet: %w", err)
 	}
 
 	return nil
 }
 
-func (c *Conn) trackWrite(idx uint16, buf *Buffer) {
+func (c *Conn) trackWrite(idx uint16, buf *Buffer, size int, ack bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if seq.GT(idx+1, c.wi) {
+	if sequence.GT(idx+1, c.wi) {
 		c.clearWrites(c.wi, idx)
+		c.wpn += uint64(idx + 1 - c.wi)
 		c.wi = idx + 1
 	}
 
@@ -195,7 +688,13 @@ func (c *Conn) trackWrite(idx uint16, buf *Buffer) {
 	c.wqe[i].buf = buf
 	c.wqe[i].acked = false
 	c.wqe[i].written = time.Now()
+	c.wqe[i].sent = c.wqe[i].written
 	c.wqe[i].resent = 0
+	c.wqe[i].ack = ack
+
+	if c.sh != nil {
+		c.sh.sent(unwrapPacketNumber(c.wpn, idx), idx, size, c.wqe[i].written)
+	}
 }
 
 func (c *Conn) clearWrites(start, end uint16) {
@@ -220,38 +719,188 @@ func (c *Conn) clearWrites(start, end uint16) {
 	emptyBufferIndices(second)
 }
 
-func (c *Conn) transmit(buf []byte) error {
-	n, err := c.conn.WriteTo(buf, c.addr)
-	if err == nil && n != len(buf) {
-		err = io.ErrShortWrite
+// transmit writes buf, which is a packet of the given kind carrying payload bytes of application payload, to our
+// peer, retrying with a small backoff should the write fail with a transient error. Writes that still fail are
+// charged against the error budget.
+func (c *Conn) transmit(buf []byte, kind wireKind, payload int) error {
+	if !c.allowTransmit(len(buf)) {
+		return nil
+	}
+
+	err := c.transmitWithRetries(buf)
+	switch {
+	case err == nil:
+		c.trackWire(kind, len(buf), payload)
+	case !isEOF(err):
+		c.trackFault(faultTransmit)
 	}
+
 	return err
 }
 
+func (c *Conn) transmitWithRetries(buf []byte) error {
+	backoff := transmitBackoff
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		n, err := writeDatagram(c.conn, buf, c.sourceOOB(), c.peer())
+		c.trackSyscall(time.Since(start), err == nil && n != len(buf))
+
+		if err == nil && n != len(buf) {
+			err = io.ErrShortWrite
+		}
+		if err == nil || attempt == transmitRetries || !isTemporary(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func (c *Conn) Read(header PacketHeader, buf []byte) error {
-	c.readAckBits(header.ACK, header.ACKBits)
+	buf, deliver, err := c.readPacket(header, buf)
+	if err != nil || !deliver {
+		return err
+	}
 
-	if !header.Unordered && !c.trackRead(header.Sequence) {
-		return nil
+	// The packet handler is called once this conn is no longer marked busy, so that it may close this conn.
+
+	c.deliver(header, buf)
+
+	//log.Printf("%s: recv    (seq=%05d) (ack=%05d) (ack_bits=%032b) (size=%d) (reliable=%t)", c.conn.LocalAddr(), header.Sequence, header.ACK, header.ACKBits, len(buf), !header.Unordered)
+
+	return nil
+}
+
+// readPacket reads a packet from our peer, reporting whether or not its payload should be delivered to the
+// application. Should the packet be the last fragment of a payload to be read, the reassembled payload is returned.
+func (c *Conn) readPacket(header PacketHeader, buf []byte) (payload []byte, deliver bool, err error) {
+	now := time.Now()
+
+	if header.Fragment {
+		admit, anomaly := c.admitFragment(header, now)
+		if anomaly {
+			c.trackFault(faultAnomaly)
+		}
+		if !admit {
+			return nil, false, nil
+		}
 	}
 
-	c.trackUnacked()
+	if !c.admitOrdered(header) {
+		return nil, false, nil
+	}
 
-	if err := c.writeAcksIfNecessary(); err != nil {
-		return fmt.Errorf("failed to write acks when necessary: %w", err)
+	deliver, err = c.read(header, len(buf))
+	if err != nil || !deliver {
+		c.skipOrdered(header)
+		return nil, false, err
 	}
 
 	if header.Empty {
-		return nil
+		c.skipOrdered(header)
+		return nil, false, c.readControl(buf)
 	}
 
-	if c.ph != nil {
-		c.ph(c.addr, header.Sequence, buf)
+	if header.Fragment {
+		if buf, deliver = c.reassemble(header, buf, now); !deliver {
+			c.skipOrdered(header)
+			return nil, false, nil
+		}
 	}
 
-	//log.Printf("%s: recv    (seq=%05d) (ack=%05d) (ack_bits=%032b) (size=%d) (reliable=%t)", c.conn.LocalAddr(), header.Sequence, header.ACK, header.ACKBits, len(buf), !header.Unordered)
+	c.observe(Observation{
+		Event:     Event{Type: EventRecv, Seq: header.Sequence, ACK: header.ACK, ACKBits: header.ACKBits, Size: len(buf)},
+		Delivered: true,
+		Payload:   buf,
+	})
 
-	return nil
+	return buf, true, nil
+}
+
+// provide copies buf into memory from the buffer provider should one be set. Should the memory provided be too small,
+// it is grown by allocating.
+func (c *Conn) provide(buf []byte) []byte {
+	if c.provider == nil {
+		return buf
+	}
+	return append(c.provider(len(buf))[:0], buf...)
+}
+
+func (c *Conn) handlerFor(header PacketHeader) PacketHandler {
+	if header.Unordered && c.uph != nil {
+		return c.uph
+	}
+	if !header.Unordered && c.rph != nil {
+		return c.rph
+	}
+	return c.ph
+}
+
+// read processes the header of a packet from our peer, reporting whether or not the packet should be delivered to
+// the packet handler.
+func (c *Conn) read(header PacketHeader, size int) (deliver bool, err error) {
+	if !c.enter() {
+		return false, io.EOF
+	}
+	defer c.leave()
+
+	c.record(EventRecv, header.Sequence, header.ACK, header.ACKBits, size)
+	c.trackReadWire(header, size)
+	c.trackHeard(time.Now())
+
+	if allowed, disconnect := c.chargeQuota(false, size); !allowed {
+		if disconnect {
+			return false, ErrQuotaExceeded
+		}
+		return false, nil // drop the packet entirely, such that our peer resends it should it be reliable
+	}
+
+	if unwritten := c.readAckBits(header.ACK, header.ACKBits); unwritten {
+		c.trackFault(faultAnomaly)
+	}
+	c.handleAcks()
+
+	if !header.Unordered && !c.inReadWindow(header.Sequence) {
+		// The packet is either a stale resend from more than a read buffer ago, or from a peer that does not respect
+		// our read buffer. Either way, its sequence number can not be told apart from one from a different wrap of
+		// the sequence space, so it is dropped before it may corrupt our read buffer.
+
+		c.trackFault(faultAnomaly)
+
+		return false, nil
+	}
+
+	if !header.Unordered && !c.trackRead(header.Sequence) {
+		// Our peer resent a packet we have already received, meaning that it has yet to receive our ack for it.
+
+		if err := c.writeAck(header.Sequence); err != nil {
+			return false, fmt.Errorf("failed to write ack for duplicate packet: %w", err)
+		}
+
+		return false, nil
+	}
+
+	if !header.Unordered {
+		c.trackPendingAck()
+	}
+
+	c.trackUnacked()
+
+	// Empty packets are only delivered should they carry a control payload.
+
+	deliver = !header.Empty || size > 0
+
+	if !c.ackPolicy.AckOnRead(c.ackState(time.Now())) || c.deferAcks() {
+		return deliver, nil
+	}
+
+	if err := c.writeAcks(); err != nil {
+		return false, fmt.Errorf("failed to write acks when necessary: %w", err)
+	}
+
+	return deliver, nil
 }
 
 func (c *Conn) createAckIfNecessary() (header PacketHeader, needed bool) {
@@ -270,9 +919,17 @@ func (c *Conn) createAckIfNecessary() (header PacketHeader, needed bool) {
 	c.lui = lui
 	c.ls = time.Now()
 
-	c.waitUntilReaderAvailable()
+	// Acks are written from the read path, so they must never wait for our peer's read buffer to free up, as
+	// doing so would stop us from reading the very acks that free it up. Should our peer's read buffer be full,
+	// send the ack without consuming a sequence number.
 
-	header.Sequence, header.ACK = c.nextWriteIndex(), lui-1
+	if c.readerAvailable() && !c.unsequencedAcks {
+		header.Sequence = c.nextWriteIndex()
+	} else {
+		header.Unordered = true
+	}
+
+	header.ACK = lui - 1
 	header.ACKBits = c.prepareAckBits(header.ACK)
 	header.Empty = true
 
@@ -281,41 +938,139 @@ func (c *Conn) createAckIfNecessary() (header PacketHeader, needed bool) {
 	return header, needed
 }
 
-func (c *Conn) writeAcksIfNecessary() error {
+func (c *Conn) writeAck(ack uint16) error {
+	c.mu.Lock()
+	ackBits := c.prepareAckBits(ack)
+	c.mu.Unlock()
+
+	return c.write(PacketHeader{ACK: ack, ACKBits: ackBits, Unordered: true, Empty: true}, nil)
+}
+
+func (c *Conn) writeAcksIfNecessary() (wrote bool, err error) {
 	for {
 		header, needed := c.createAckIfNecessary()
 		if !needed {
-			return nil
+			return wrote, nil
 		}
 
 		//log.Printf("%s: ack     (seq=%05d) (ack=%05d) (ack_bits=%032b)", c.conn.LocalAddr(), header.Sequence, header.ACK, header.ACKBits)
 
 		if err := c.write(header, nil); err != nil {
-			return fmt.Errorf("failed to write ack packet: %w", err)
+			return wrote, fmt.Errorf("failed to write ack packet: %w", err)
 		}
+
+		wrote = true
 	}
 }
 
-func (c *Conn) readAckBits(ack uint16, ackBits uint32) {
+// readAckBits marks the packets acked by ack and ackBits as acked, reporting whether or not any of them were never
+// written to our peer in the first place.
+func (c *Conn) readAckBits(ack uint16, ackBits uint32) (unwritten bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// The newest packet acked is acked by the lowest bit set.
+
+	if ackBits != 0 && !sequence.LT(ack-uint16(bits.TrailingZeros32(ackBits)), c.wi) {
+		unwritten = true
+	}
+
 	for idx := uint16(0); idx < ACKBitsetSize; idx, ackBits = idx+1, ackBits>>1 {
 		if ackBits&1 == 0 {
 			continue
 		}
+		c.markAcked(ack-idx, ack)
+	}
 
-		i := (ack - idx) % uint16(len(c.wq))
-		if c.wq[i] != uint32(ack-idx) || c.wqe[i].acked {
-			continue
-		}
+	return unwritten
+}
 
-		if c.wqe[i].buf != nil {
-			c.pool.Put(c.wqe[i].buf)
-		}
+// handleAcks hands the sequence numbers of packets acked since it was last called to the ack handler. It must be called
+// without c.mu held, such that the ack handler may call back into this conn.
+func (c *Conn) handleAcks() {
+	if c.ah == nil {
+		return
+	}
 
-		c.wqe[i].buf = nil
-		c.wqe[i].acked = true
+	c.mu.Lock()
+	acked := c.acked
+	c.acked = nil
+	c.mu.Unlock()
+
+	for _, seq := range acked {
+		c.ah(c.peer(), seq)
+	}
+}
+
+// markAcked marks the packet of sequence number seq as acked by an ack of the given sequence number, should it have
+// been written and not yet acked. It must be called with c.mu held.
+func (c *Conn) markAcked(seq, ack uint16) {
+	i := seq % uint16(len(c.wq))
+	if c.wq[i] != uint32(seq) || c.wqe[i].acked {
+		return
+	}
+
+	if c.wqe[i].buf != nil {
+		c.pool.Put(c.wqe[i].buf)
+	}
+
+	c.wqe[i].buf = nil
+	c.wqe[i].acked = true
+	c.wqe[i].ackedAt = time.Now()
+	c.stats.PacketsAcked++
+
+	c.stats.Lifecycle.Ack.add(c.wqe[i].ackedAt.Sub(c.wqe[i].sent))
+
+	// Only packets that were never resent are sampled, as it is ambiguous which transmission an ack is for.
+
+	if c.wqe[i].resent == 0 {
+		c.trackRTT(time.Since(c.wqe[i].written))
+		c.trackLoss(false)
+	}
+
+	if c.wqe[i].ack {
+		c.stats.AcksConfirmed++
+	} else if c.ah != nil {
+		c.acked = append(c.acked, seq)
+	}
+
+	if c.sh != nil {
+		c.sh.acked(unwrapPacketNumber(c.wpn, seq), time.Now())
+	}
+
+	if c.cwnd < uint16(len(c.rq)) {
+		c.cwnd++
+	}
+
+	c.record(EventAcked, seq, ack, 0, 0)
+}
+
+// inReadWindow reports whether or not idx lies within the reorder tolerance's worth of sequence numbers up to that of
+// the newest packet read from our peer, or within a read buffer's worth of sequence numbers past it, counting it as
+// stale otherwise.
+func (c *Conn) inReadWindow(idx uint16) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A window spanning the whole sequence space leaves no sequence numbers to be counted as stale.
+
+	if int(c.reorderTolerance)+len(c.rq) > math.MaxUint16 {
+		return true
+	}
+
+	if sequence.InWindow(idx, c.ri-c.reorderTolerance, c.reorderTolerance+uint16(len(c.rq))) {
+		return true
+	}
+	c.stats.Stale++
+
+	return false
+}
+
+// checkReorderTolerance panics should packets be accepted further behind the newest packet than a read buffer of
+// readBufferSize entries is able to deduplicate.
+func checkReorderTolerance(tolerance, readBufferSize uint16) {
+	if tolerance > readBufferSize {
+		panic("reorder tolerance must not exceed the read buffer size")
 	}
 }
 
@@ -326,15 +1081,20 @@ func (c *Conn) trackRead(idx uint16) bool {
 	i := idx % uint16(len(c.rq))
 
 	if c.rq[i] == uint32(idx) { // duplicate packet
+		c.stats.Duplicates++
 		return false
 	}
 
-	if seq.GT(idx+1, c.ri) {
+	if sequence.GT(idx+1, c.ri) {
 		c.clearReads(c.ri, idx)
+		c.rpn += uint64(idx + 1 - c.ri)
 		c.ri = idx + 1
+	} else {
+		c.trackReordered(c.ri - 1 - idx)
 	}
 
 	c.rq[i] = uint32(idx)
+	c.rangesPending = true
 
 	return true
 }
@@ -367,7 +1127,7 @@ func (c *Conn) trackAcked(ack uint16) {
 
 	lui := c.lui
 
-	for lui <= ack {
+	for sequence.LTE(lui, ack) {
 		if c.rq[lui%uint16(len(c.rq))] != uint32(lui) {
 			break
 		}
@@ -383,12 +1143,14 @@ func (c *Conn) trackUnacked() {
 	defer c.mu.Unlock()
 
 	oui := c.oui
+	now := time.Now()
 
 	for {
 		i := oui % uint16(len(c.wq))
 		if c.wq[i] != uint32(oui) || !c.wqe[i].acked {
 			break
 		}
+		c.stats.Lifecycle.Release.add(now.Sub(c.wqe[i].ackedAt))
 		oui++
 	}
 	c.oui = oui
@@ -410,11 +1172,54 @@ func (c *Conn) close() bool {
 	return true
 }
 
+// enter marks this conn as busy, reporting false should this conn be closed.
+func (c *Conn) enter() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.die {
+		return false
+	}
+	c.busy.Add(1)
+
+	return true
+}
+
+func (c *Conn) leave() {
+	c.busy.Done()
+}
+
+// releaseWrites releases the pooled buffers of all written packets.
+func (c *Conn) releaseWrites() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.wqe {
+		if c.wqe[i].buf != nil {
+			c.pool.Put(c.wqe[i].buf)
+		}
+		c.wqe[i].buf = nil
+	}
+
+	emptyBufferIndices(c.wq)
+}
+
+// Close closes this conn, waking up all writers waiting for our peer's read buffer to free up, and then waiting for
+// all in-flight reads, writes, and Run to return before releasing pooled buffers. Writes blocked on the underlying
+// socket are not interrupted, and are expected to be unblocked by closing the socket or setting a deadline on it.
 func (c *Conn) Close() {
 	if !c.close() {
 		return
 	}
 
+	c.stopSuppressTimer()
+	c.stopWriteTimer()
+	c.busy.Wait()
+	c.releaseWrites()
+	c.dropCoalesced()
+	c.dropHeld()
+	c.dropOrdered()
+
 	//c.mu.Lock()
 	//defer c.mu.Unlock()
 
@@ -426,6 +1231,16 @@ func (c *Conn) Close() {
 }
 
 func (c *Conn) Run() {
+	if !c.enter() {
+		return
+	}
+	defer c.leave()
+
+	atomic.AddInt32(&c.goroutines, 1)
+	atomic.StoreUint32(&c.running, 1)
+	defer atomic.AddInt32(&c.goroutines, -1)
+	defer atomic.StoreUint32(&c.running, 0)
+
 	ticker := time.NewTicker(c.updatePeriod)
 	defer ticker.Stop()
 
@@ -434,35 +1249,277 @@ func (c *Conn) Run() {
 		case <-c.exit:
 			return
 		case <-ticker.C:
-			if err := c.retransmitUnackedPackets(); err != nil && c.eh != nil {
-				c.eh(c.addr, err)
+			c.trackAppLimited()
+
+			if err := c.flushDeferredAcks(); err != nil {
+				c.reportError(fmt.Errorf("failed to write deferred acks: %w", err))
+			}
+			c.flushCoalesced()
+			if err := c.writeAcksOnUpdate(); err != nil {
+				c.reportError(fmt.Errorf("failed to write acks on update: %w", err))
+			}
+			if err := c.writeAckRangesOnUpdate(); err != nil {
+				c.reportError(err)
+			}
+			if err := c.writeTimestampOnUpdate(time.Now()); err != nil {
+				c.reportError(err)
+			}
+			if err := c.writeChallengeOnUpdate(time.Now()); err != nil {
+				c.reportError(err)
+			}
+			if err := c.retransmitUnackedPackets(); err != nil {
+				c.reportError(err)
+			}
+
+			c.mu.Lock()
+			c.expirePartialMessages(time.Now())
+			c.mu.Unlock()
+
+			c.checkProgress(time.Now())
+
+			c.checkTokenKey(time.Now())
+
+			if err := c.writeKeepAliveIfIdle(time.Now()); err != nil {
+				c.reportError(err)
+			}
+
+			c.checkInactivity(time.Now())
+
+			if c.onUpdate != nil {
+				c.onUpdate()
 			}
 		}
 	}
 }
 
+// retransmitUnackedPackets resends all unacked packets that are due to be resent. Due packets are copied out while
+// holding the lock, such that a slow or blocking socket write does not stall reads and writes on this conn.
 func (c *Conn) retransmitUnackedPackets() error {
+	if c.breakerOpen() {
+		return nil
+	}
+
+	queue, bufs, exhausted := c.collectDuePackets(time.Now())
+
+	defer func() {
+		for i := range bufs {
+			c.pool.Put(bufs[i])
+			queue[i].Buf, bufs[i] = nil, nil
+		}
+		c.due, c.dueBufs = queue[:0], bufs[:0]
+	}()
+
+	if exhausted {
+		c.resendsExhausted()
+		return nil
+	}
+
+	if c.interleave != nil {
+		c.interleave.beginResends(len(queue))
+		defer c.interleave.endResends()
+	}
+
+	for len(queue) > 0 {
+		j := c.sched.Next(queue)
+		p := queue[j]
+
+		c.takeResendTurn()
+
+		//log.Printf("%s: resend  (seq=%d)", c.conn.LocalAddr(), p.Seq)
+
+		err := c.transmit(p.Buf, wireResend, 0)
+
+		c.pool.Put(bufs[j])
+
+		copy(queue[j:], queue[j+1:])
+		copy(bufs[j:], bufs[j+1:])
+		queue[len(queue)-1].Buf, bufs[len(bufs)-1] = nil, nil
+		queue, bufs = queue[:len(queue)-1], bufs[:len(bufs)-1]
+
+		if err != nil {
+			if isEOF(err) || isTemporary(err) {
+				break
+			}
+			return fmt.Errorf("failed to retransmit unacked packet: %w", err)
+		}
+
+		c.record(EventResend, p.Seq, 0, 0, 0)
+	}
+
+	return nil
+}
+
+// collectDuePackets copies out all unacked packets that are due to be resent, marking them as resent. It reports
+// exhausted the first time it comes across a packet that went unacked after having been resent the max number of
+// times should this conn fail on it, in which case the packets copied out so far are not to be resent.
+func (c *Conn) collectDuePackets(now time.Time) (queue []QueuedPacket, bufs []*Buffer, exhausted bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	queue, bufs = c.due[:0], c.dueBufs[:0]
+
+	c.checkConditions(now)
+
+	resendTimeout := c.effectiveResendTimeout()
+
 	for idx := uint16(0); idx < uint16(len(c.wq)); idx++ {
 		i := (c.oui + idx) % uint16(len(c.wq))
-		if c.wq[i] != uint32(c.oui+idx) || !c.wqe[i].shouldResend(time.Now(), c.resendTimeout) {
+		if c.wq[i] != uint32(c.oui+idx) {
 			continue
 		}
 
-		//log.Printf("%s: resend  (seq=%d)", c.conn.LocalAddr(), c.oui+idx)
-
-		if err := c.transmit(c.wqe[i].buf.B); err != nil {
-			if isEOF(err) {
-				break
+		if c.failResends && c.wqe[i].exhausted(now, resendTimeout, c.maxResends) {
+			if c.resendsFailed {
+				continue
 			}
-			return fmt.Errorf("failed to retransmit unacked packet: %w", err)
+			c.resendsFailed = true
+
+			return queue, bufs, true
+		}
+
+		if !c.wqe[i].shouldResend(now, resendTimeout, c.maxResends) {
+			continue
 		}
 
-		c.wqe[i].written = time.Now()
+		// Packets left over once buffers run out or resends are paced are resent on a later update. Resends
+		// interleaved with fresh writes draw from the rate limits of this conn without waiting on them, such that
+		// packets are also left over once the rate limits are used up rather than stalling this conn's update.
+
+		if c.resendPacing > 0 && len(queue) == c.resendPacing {
+			break
+		}
+
+		b := c.pool.Get(len(c.wqe[i].buf.B))
+		if b == nil {
+			break
+		}
+
+		if c.interleave != nil && !c.tryThrottle(len(c.wqe[i].buf.B)) {
+			c.pool.Put(b)
+			c.stats.RateLimited++
+			break
+		}
+		b.B = append(b.B, c.wqe[i].buf.B...)
+
+		queue = append(queue, QueuedPacket{
+			Seq:     c.oui + idx,
+			Buf:     b.B,
+			Written: c.wqe[i].written,
+			Resent:  c.wqe[i].resent,
+		})
+		bufs = append(bufs, b)
+
+		if c.wqe[i].resent == 0 {
+			c.trackLoss(true)
+		}
+
+		c.wqe[i].written = now
 		c.wqe[i].resent++
+		c.stats.Resends++
+
+		// A standalone ack that our peer did not ack in time was lost, such that our peer is likely to resend the
+		// packets it acked. Acks are then written more eagerly until the newest packet read is acked again.
+
+		if c.wqe[i].ack {
+			c.stats.AcksLost++
+			c.ackLost = true
+		}
+
+		if c.sh != nil {
+			c.sh.resent(unwrapPacketNumber(c.wpn, c.oui+idx), now)
+		}
 	}
 
-	return nil
+	if len(queue) > 0 {
+		c.backOffResends()
+	}
+
+	return queue, bufs, false
+}
+
+func (c *Conn) record(typ EventType, seq, ack uint16, ackBits uint32, size int) {
+	if c.el != nil {
+		c.el.record(typ, seq, ack, ackBits, size)
+	}
+
+	c.observe(Observation{Event: Event{Type: typ, Seq: seq, ACK: ack, ACKBits: ackBits, Size: size}})
+}
+
+// Events returns the most recent protocol events of this conn from oldest to newest, or nil should this conn not
+// have an event log.
+func (c *Conn) Events() []Event {
+	if c.el == nil {
+		return nil
+	}
+	return c.el.snapshot()
+}
+
+func (c *Conn) reportError(err error) {
+	if c.eh == nil {
+		return
+	}
+	if c.el != nil {
+		err = &EventLogError{Err: err, Events: c.el.snapshot()}
+	}
+	c.eh(c.peer(), err)
+}
+
+// trackAppLimited marks whether or not writes since the last update were limited by the application having nothing
+// more to send, rather than by our peer's read buffer being full.
+func (c *Conn) trackAppLimited() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.appLimited = !c.stalled && c.tickets == c.serving
+	c.stalled = false
+}
+
+// trackReordered tracks a packet that arrived depth packets after a newer packet did. It must be called with c.mu
+// held.
+func (c *Conn) trackReordered(depth uint16) {
+	c.stats.Reordered++
+	c.stats.ReorderDepthTotal += uint64(depth)
+	if depth > c.stats.ReorderDepthMax {
+		c.stats.ReorderDepthMax = depth
+	}
+}
+
+// trackWriteWait tracks a reliable write that waited for its turn to write. It must be called with c.mu held.
+func (c *Conn) trackWriteWait(wait time.Duration) {
+	c.stats.WriteWaits++
+	c.stats.WriteWaitTotal += wait
+	if wait > c.stats.WriteWaitMax {
+		c.stats.WriteWaitMax = wait
+	}
+}
+
+func (c *Conn) trackSyscall(took time.Duration, short bool) {
+	c.mu.Lock()
+	c.stats.Syscalls.add(took, short)
+	c.mu.Unlock()
+
+	if c.ews != nil {
+		c.ews.add(took, short)
+	}
+}
+
+// Stats returns a snapshot of statistics of this conn.
+func (c *Conn) Stats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	stats := c.stats
+	stats.AppLimited = c.appLimited
+	stats.RTT = c.rtt
+	stats.BadMode = c.bad
+	stats.Rates1s, stats.Rates10s = c.rates.short.rates(now), c.rates.long.rates(now)
+	stats.BreakerOpen = c.budget != nil && now.Before(c.breakerUntil)
+	stats.InFlight = c.wi - c.oui
+	stats.WritePacketNumber = c.wpn
+	stats.ReadPacketNumber = c.rpn
+	stats.PartialMessages, stats.PartialFragments = c.countPartialMessages()
+
+	return stats
 }
@@ -1,17 +1,31 @@
 package reliable
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/lithdew/reliable/sequence"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func testConnWaitForWriteDetails(inc uint16) func(t testing.TB) {
 	return func(t testing.TB) {
 		defer goleak.VerifyNone(t)
 
-		c := NewConn(nil, nil)
+		c := NewConn(nil, nil, WithoutSlowStart())
 		c.wi = uint16(len(c.rq))
 
 		var wg sync.WaitGroup
@@ -23,7 +37,7 @@ func testConnWaitForWriteDetails(inc uint16) func(t testing.TB) {
 			go func() {
 				defer wg.Done()
 
-				idx, _, _, _ := c.waitForNextWriteDetails()
+				idx, _, _, _ := c.waitForNextWriteDetails(context.Background())
 				ch <- idx
 			}()
 		}
@@ -57,3 +71,2476 @@ func TestConnWaitForWriteDetails(t *testing.T) {
 	testConnWaitForWriteDetails(2)(t)
 	testConnWaitForWriteDetails(4)(t)
 }
+
+type recordingPacketConn struct {
+	net.PacketConn
+	writes [][]byte
+}
+
+func (c *recordingPacketConn) WriteTo(buf []byte, _ net.Addr) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), buf...))
+	return len(buf), nil
+}
+
+type newestFirstScheduler struct{}
+
+func (newestFirstScheduler) Next(queue []QueuedPacket) int { return len(queue) - 1 }
+
+func TestConnRetransmitsInScheduledOrder(t *testing.T) {
+	pc := &recordingPacketConn{}
+
+	c := NewConn(pc, nil, WithScheduler(newestFirstScheduler{}), WithResendTimeout(time.Nanosecond))
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+
+	pc.writes = pc.writes[:0]
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Len(t, pc.writes, 4)
+
+	for i, buf := range pc.writes {
+		header, _, err := UnmarshalPacketHeader(buf)
+		require.NoError(t, err)
+		require.EqualValues(t, 3-i, header.Sequence)
+	}
+}
+
+type blockingPacketConn struct {
+	net.PacketConn
+	block   bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingPacketConn) WriteTo(buf []byte, _ net.Addr) (int, error) {
+	if c.block {
+		c.started <- struct{}{}
+		<-c.release
+	}
+	return len(buf), nil
+}
+
+func TestConnRetransmitsOutsideLock(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	pc := &blockingPacketConn{started: make(chan struct{}), release: make(chan struct{})}
+
+	c := NewConn(pc, nil, WithResendTimeout(time.Nanosecond))
+	require.NoError(t, c.WriteReliablePacket(nil))
+
+	pc.block = true
+	time.Sleep(time.Millisecond)
+
+	errs := make(chan error)
+	go func() { errs <- c.retransmitUnackedPackets() }()
+
+	<-pc.started
+
+	// The conn must remain usable while a retransmission is blocked on the socket.
+
+	c.readAckBits(0, 1)
+	require.True(t, c.wqe[0].acked)
+
+	close(pc.release)
+	require.NoError(t, <-errs)
+}
+
+func TestConnReportsTransmitErrors(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.FailWrite(1, syscall.ECONNREFUSED)
+	pc.ShortWrite(2)
+	pc.FailWrite(3, io.EOF)
+
+	c := NewConn(pc, nil)
+
+	require.True(t, errors.Is(c.WriteUnreliablePacket(nil), syscall.ECONNREFUSED))
+	require.True(t, errors.Is(c.WriteUnreliablePacket([]byte("hello")), io.ErrShortWrite))
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+}
+
+func TestConnRetriesTransientTransmitErrors(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.FailWrite(1, syscall.ENOBUFS)
+	pc.FailWrite(2, syscall.EAGAIN)
+
+	c := NewConn(pc, nil)
+
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+	require.Equal(t, 3, pc.Writes())
+
+	// Unreliable packets are given up on once all retries are exhausted, while reliable packets are left to be resent.
+
+	for i := 4; i < 4+2*(transmitRetries+1); i++ {
+		pc.FailWrite(i, syscall.ENOBUFS)
+	}
+
+	require.True(t, errors.Is(c.WriteUnreliablePacket(nil), syscall.ENOBUFS))
+	require.NoError(t, c.WriteReliablePacket(nil))
+	require.Equal(t, 3+2*(transmitRetries+1), pc.Writes())
+}
+
+func TestConnAdaptiveResendTimeout(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Until the rtt is sampled, the default resend timeout is used.
+
+	require.Equal(t, DefaultResendTimeout, c.currentResendTimeout())
+
+	// The resend timeout starts off at three times the first sample, and converges to the rtt as samples settle.
+
+	c.trackRTT(40 * time.Millisecond)
+	require.Equal(t, 120*time.Millisecond, c.currentResendTimeout())
+
+	for i := 0; i < 100; i++ {
+		c.trackRTT(40 * time.Millisecond)
+	}
+	require.Equal(t, 40*time.Millisecond, c.rtt)
+	require.InDelta(t, 40*time.Millisecond, c.currentResendTimeout(), float64(time.Millisecond))
+
+	// Samples that vary stretch the resend timeout.
+
+	for i := 0; i < 100; i++ {
+		c.trackRTT(time.Duration(20+40*(i%2)) * time.Millisecond)
+	}
+	require.Greater(t, int64(c.currentResendTimeout()), int64(100*time.Millisecond))
+
+	// Resend timeouts do not go below the min resend timeout.
+
+	for i := 0; i < 100; i++ {
+		c.trackRTT(time.Millisecond)
+	}
+	require.Equal(t, DefaultMinResendTimeout, c.currentResendTimeout())
+
+	// The resend timeout doubles each time packets get resent until the rtt is sampled again.
+
+	c.backOffResends()
+	require.Equal(t, 2*DefaultMinResendTimeout, c.currentResendTimeout())
+
+	for i := 0; i < 10; i++ {
+		c.backOffResends()
+	}
+	require.Equal(t, 8*DefaultMinResendTimeout, c.currentResendTimeout())
+
+	c.trackRTT(time.Millisecond)
+	require.Equal(t, DefaultMinResendTimeout, c.currentResendTimeout())
+
+	// A fixed resend timeout is used regardless of the rtt.
+
+	fixed := NewConn(reliabletest.NewFaultConn(nil), nil, WithResendTimeout(time.Second))
+	fixed.trackRTT(time.Millisecond)
+	require.Equal(t, time.Second, fixed.currentResendTimeout())
+	require.Equal(t, time.Millisecond, fixed.RTT())
+}
+
+func TestConnBadMode(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil,
+		WithCongestionThresholdRTT(100*time.Millisecond),
+		WithBadModeRecoveryTime(time.Second),
+		WithResendTimeout(10*time.Millisecond),
+	)
+
+	start := time.Now()
+
+	// An rtt above the threshold puts the conn into bad mode, halving the window and stretching resends.
+
+	c.mu.Lock()
+	require.Equal(t, DefaultInitialWindowSize, c.window())
+	c.trackRTT(200 * time.Millisecond)
+	c.checkConditions(start)
+	require.Equal(t, DefaultInitialWindowSize/2, c.window())
+	c.mu.Unlock()
+
+	require.True(t, c.BadMode())
+	require.NoError(t, c.WriteReliablePacket(nil))
+
+	written := c.wqe[0].written
+
+	queue, _, _ := c.collectDuePackets(written.Add(15 * time.Millisecond))
+	require.Empty(t, queue)
+
+	queue, bufs, _ := c.collectDuePackets(written.Add(20 * time.Millisecond))
+	require.Len(t, queue, 1)
+	c.pool.Put(bufs[0])
+
+	// The conn only leaves bad mode once conditions stay good for the recovery time.
+
+	c.mu.Lock()
+	for i := 0; i < 100; i++ {
+		c.trackRTT(10 * time.Millisecond)
+	}
+	c.loss = 0
+
+	c.checkConditions(start)
+	require.True(t, c.bad)
+	c.checkConditions(start.Add(999 * time.Millisecond))
+	require.True(t, c.bad)
+	c.checkConditions(start.Add(time.Second))
+	require.False(t, c.bad)
+	require.Equal(t, DefaultInitialWindowSize, c.window())
+
+	// Too many packets having to be resent puts the conn back into bad mode.
+
+	for i := 0; i < 10; i++ {
+		c.trackLoss(true)
+	}
+	c.checkConditions(start.Add(time.Second))
+	require.True(t, c.bad)
+	c.mu.Unlock()
+
+	require.EqualValues(t, 2, c.Stats().BadModeEntries)
+	require.True(t, c.Stats().BadMode)
+
+	// Without a congestion threshold, conns never go into bad mode.
+
+	d := NewConn(reliabletest.NewFaultConn(nil), nil)
+	d.trackRTT(time.Hour)
+	d.trackLoss(true)
+	d.checkConditions(start)
+	require.False(t, d.BadMode())
+}
+
+func TestConnWriteFragments(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithFragmentSize(4))
+	require.NoError(t, c.WriteReliablePacket([]byte("hi")))
+	require.NoError(t, c.WriteReliablePacket([]byte("hello world")))
+	require.Equal(t, 4, pc.Writes())
+
+	// Payloads larger than the fragment size are split into fragments that each take up a sequence number.
+
+	var payload []byte
+	for seq := uint16(1); seq <= 3; seq++ {
+		header, buf, err := UnmarshalPacketHeader(c.wqe[seq].buf.B)
+		require.NoError(t, err)
+		require.True(t, header.Fragment)
+		require.Equal(t, seq, header.Sequence)
+		require.EqualValues(t, 0, header.FragmentID)
+		require.EqualValues(t, seq-1, header.FragmentIndex)
+		require.EqualValues(t, 2, header.FragmentLast)
+		payload = append(payload, buf...)
+	}
+	require.Equal(t, "hello world", string(payload))
+	require.EqualValues(t, 1, c.Stats().FragmentedWrites)
+
+	// Payloads that would take more fragments than there may be fail to be written.
+
+	require.Equal(t, ErrMessageTooLarge, c.WriteUnreliablePacket(make([]byte, 4*MaxMessageFragments+1)))
+	require.Equal(t, 4, pc.Writes())
+
+	// Fragments along with their headers must fit in a buffer of the buffer pool.
+
+	prealloc := WithPreallocation(1, maxPacketHeaderSize+fragmentHeaderSize+4)
+
+	require.NotPanics(t, func() { NewConn(pc, nil, prealloc, WithFragmentSize(4)) })
+	require.Panics(t, func() { NewConn(pc, nil, prealloc, WithFragmentSize(5)) })
+
+	conn := reliabletest.NewNetwork(0).Listen()
+	defer conn.Close()
+
+	require.NotPanics(t, func() { NewEndpoint(conn, prealloc, WithFragmentSize(4)) })
+	require.Panics(t, func() { NewEndpoint(conn, prealloc, WithFragmentSize(5)) })
+}
+
+func TestConnOversizePolicy(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithFragmentSize(4))
+
+	// Payloads too large to fit in a single packet may be refused rather than split into fragments.
+
+	require.Equal(t, ErrPacketTooLarge, c.WriteReliablePacketPolicy([]byte("hello world"), OversizeError))
+	require.NoError(t, c.WriteReliablePacketPolicy([]byte("hi"), OversizeError))
+	require.Equal(t, 1, pc.Writes())
+
+	w := c.Writer()
+	require.NoError(t, w.WriteReliablePacket([]byte("a")))
+	require.Equal(t, ErrPacketTooLarge, w.WriteUnreliablePacketPolicy([]byte("hello world"), OversizeError))
+	require.Len(t, w.staged, 1)
+
+	// Packets staged before a payload may be flushed out before its fragments are written on their own.
+
+	require.NoError(t, w.WriteReliablePacketPolicy([]byte("hello world"), OversizeFlush))
+	require.Empty(t, w.staged)
+	require.Equal(t, 5, pc.Writes())
+
+	// Or the fragments of a payload may be staged alongside them, to be written out in the same batch.
+
+	require.NoError(t, w.WriteReliablePacket([]byte("b")))
+	require.NoError(t, w.WriteReliablePacketPolicy([]byte("hello world"), OversizeFragment))
+	require.Len(t, w.staged, 4)
+	require.Equal(t, 5, pc.Writes())
+
+	require.NoError(t, w.Flush())
+	require.Equal(t, 9, pc.Writes())
+
+	var payload []byte
+	for seq := uint16(6); seq <= 8; seq++ {
+		header, buf, err := UnmarshalPacketHeader(c.wqe[seq].buf.B)
+		require.NoError(t, err)
+		require.True(t, header.Fragment)
+		require.Equal(t, seq, header.Sequence)
+		require.EqualValues(t, 1, header.FragmentID)
+		require.EqualValues(t, seq-6, header.FragmentIndex)
+		require.EqualValues(t, 2, header.FragmentLast)
+		payload = append(payload, buf...)
+	}
+	require.Equal(t, "hello world", string(payload))
+
+	stats := c.Stats()
+	require.EqualValues(t, 2, stats.FragmentedWrites)
+	require.EqualValues(t, 5, stats.ReliableWrites)
+
+	// Should fragments staged alongside others fail to be written, our peer is told to drop the fragments of their
+	// payload that it was sent.
+
+	pc.FailWrite(pc.Writes()+2, syscall.EINVAL)
+	require.NoError(t, w.WriteReliablePacketPolicy([]byte("hello world"), OversizeFragment))
+	require.True(t, errors.Is(w.Flush(), syscall.EINVAL))
+	require.Equal(t, 12, pc.Writes())
+	require.False(t, w.flushed.Fragment)
+
+	// Should fragments fail to be staged, those of their payload that were staged are unstaged.
+
+	d := NewConn(pc, nil, WithPreallocation(2, maxPacketHeaderSize+fragmentHeaderSize+4), WithFragmentSize(4))
+
+	w = d.Writer()
+	require.Equal(t, ErrBuffersExhausted, w.WriteReliablePacketPolicy([]byte("hello world"), OversizeFragment))
+	require.Empty(t, w.staged)
+	require.NoError(t, w.WriteReliablePacketPolicy([]byte("hello"), OversizeFragment))
+	require.Len(t, w.staged, 2)
+	require.Equal(t, 12, pc.Writes())
+}
+
+func TestConnProgressWatchdog(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	var errs []error
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil,
+		WithFragmentSize(4),
+		WithProgressWatchdog(100, time.Second),
+		WithErrorHandler(func(_ net.Addr, err error) { errs = append(errs, err) }),
+	)
+	defer c.Close()
+
+	// Our peer's read buffer is full, so a fragmented payload being written makes no progress until it is failed.
+
+	c.wi = uint16(len(c.rq))
+
+	done := make(chan error, 1)
+	go func() { done <- c.WriteReliablePacket([]byte("hello world")) }()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.transfers) == 1
+	}, time.Second, time.Millisecond)
+
+	c.checkProgress(time.Now())
+	require.Len(t, done, 0)
+
+	c.checkProgress(time.Now().Add(time.Second))
+
+	select {
+	case err := <-done:
+		var stalled *StalledTransferError
+		require.True(t, errors.As(err, &stalled))
+		require.EqualValues(t, 0, stalled.ID)
+		require.Zero(t, stalled.Bytes)
+	case <-time.After(time.Second):
+		t.Fatal("stalled transfer was not failed")
+	}
+
+	require.EqualValues(t, len(c.rq), c.wi)
+	require.Empty(t, c.transfers)
+
+	// Partially read payloads are kept while enough of them is read per grace period, and dropped once they stall.
+
+	header := PacketHeader{Unordered: true, Fragment: true, FragmentID: 7, FragmentLast: 2}
+	require.NoError(t, c.Read(header, make([]byte, 200)))
+
+	now := time.Now().Add(time.Second)
+	c.checkProgress(now)
+	require.Len(t, c.partial, 1)
+	require.Empty(t, errs)
+
+	c.checkProgress(now.Add(time.Second))
+	require.Empty(t, c.partial)
+	require.Len(t, errs, 1)
+	require.Equal(t, &StalledTransferError{ID: 7, Bytes: 200}, errs[0])
+
+	require.EqualValues(t, 2, c.Stats().TransfersStalled)
+}
+
+func TestConnReassembly(t *testing.T) {
+	var delivered []string
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil,
+		WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) { delivered = append(delivered, string(buf)) }),
+		WithReassemblyLimits(ReassemblyLimits{MaxFragments: 4, MaxMessages: 2, Timeout: time.Second}),
+	)
+
+	fragment := func(seq, id uint16, index, last uint8) PacketHeader {
+		return PacketHeader{
+			Sequence: seq, Unordered: seq == 0,
+			Fragment: true, FragmentID: id, FragmentIndex: index, FragmentLast: last,
+		}
+	}
+
+	// Payloads are delivered once all of their fragments are read, in whatever order they were read.
+
+	require.NoError(t, c.Read(fragment(0, 1, 1, 1), []byte("world")))
+	require.NoError(t, c.Read(fragment(0, 1, 1, 1), []byte("world")))
+	require.Empty(t, delivered)
+
+	stats := c.Stats()
+	require.Equal(t, 1, stats.PartialMessages)
+	require.Equal(t, 1, stats.PartialFragments)
+
+	require.NoError(t, c.Read(fragment(0, 1, 0, 1), []byte("hello ")))
+	require.Equal(t, []string{"hello world"}, delivered)
+
+	stats = c.Stats()
+	require.Zero(t, stats.PartialMessages)
+	require.Zero(t, stats.PartialFragments)
+
+	// Reliable fragments that are resent are not reassembled again.
+
+	require.NoError(t, c.Read(fragment(1, 2, 0, 1), []byte("a")))
+	require.NoError(t, c.Read(fragment(2, 2, 1, 1), []byte("b")))
+	require.NoError(t, c.Read(fragment(1, 2, 0, 1), []byte("a")))
+	require.Equal(t, []string{"hello world", "ab"}, delivered)
+	require.Empty(t, c.partial)
+	require.EqualValues(t, 2, c.Stats().Reassembled)
+
+	// Fragments of payloads split into too many fragments, or that do not match the other fragments of their payload,
+	// are dropped as anomalies.
+
+	require.NoError(t, c.Read(fragment(0, 3, 0, 4), []byte("a")))
+	require.NoError(t, c.Read(fragment(0, 4, 0, 1), []byte("a")))
+	require.NoError(t, c.Read(fragment(0, 4, 1, 2), []byte("a")))
+	require.EqualValues(t, 2, c.Stats().Anomalies)
+
+	// Once there are too many partial payloads, the unreliable one read from the longest ago makes room for others.
+
+	require.NoError(t, c.Read(fragment(3, 5, 0, 1), []byte("a")))
+	require.NoError(t, c.Read(fragment(4, 6, 0, 1), []byte("a")))
+	require.Len(t, c.partial, 2)
+	require.NotContains(t, c.partial, uint16(4))
+	require.EqualValues(t, 1, c.Stats().ReassemblyDrops)
+
+	// Reliable fragments there is no room for are dropped without being read, such that they get resent.
+
+	require.NoError(t, c.Read(fragment(5, 7, 0, 1), []byte("a")))
+	require.NotContains(t, c.partial, uint16(7))
+	require.NotEqual(t, uint32(5), c.rq[5])
+
+	// Partial reliable payloads are never dropped for timing out, as their fragments were already acked and so will
+	// not be resent. A fragment arriving after the reassembly timeout still completes its payload.
+
+	c.mu.Lock()
+	c.expirePartialMessages(time.Now().Add(time.Second))
+	c.mu.Unlock()
+
+	require.Len(t, c.partial, 2)
+	require.EqualValues(t, 1, c.Stats().ReassemblyDrops)
+
+	require.NoError(t, c.Read(fragment(6, 5, 1, 1), []byte("b")))
+	require.Equal(t, []string{"hello world", "ab", "ab"}, delivered)
+
+	require.NoError(t, c.Read(fragment(5, 7, 0, 1), []byte("a")))
+	require.Contains(t, c.partial, uint16(7))
+	require.NoError(t, c.Read(fragment(7, 7, 1, 1), []byte("b")))
+	require.NotContains(t, c.partial, uint16(7))
+
+	// Partial unreliable payloads that had no fragment read for the reassembly timeout are dropped.
+
+	require.NoError(t, c.Read(fragment(0, 8, 0, 1), []byte("a")))
+	require.Contains(t, c.partial, uint16(8))
+
+	stats = c.Stats()
+	require.Equal(t, 2, stats.PartialMessages)
+	require.Equal(t, 2, stats.PartialFragments)
+
+	c.mu.Lock()
+	c.expirePartialMessages(time.Now().Add(time.Second))
+	c.mu.Unlock()
+
+	require.NotContains(t, c.partial, uint16(8))
+	require.Contains(t, c.partial, uint16(6))
+	require.EqualValues(t, 2, c.Stats().ReassemblyDrops)
+
+	// Partial reliable payloads are dropped once our peer aborts them. Fragments of them read late are acked but not
+	// reassembled, until a fragment written after the abort reuses their id.
+
+	require.NoError(t, c.readControl([]byte{byte(controlAbort), 0, 6, 0, 9}))
+	require.True(t, c.partial[6].aborted)
+
+	stats = c.Stats()
+	require.EqualValues(t, 3, stats.ReassemblyDrops)
+	require.Zero(t, stats.PartialMessages)
+	require.Zero(t, stats.PartialFragments)
+
+	require.NoError(t, c.Read(fragment(9, 6, 1, 1), []byte("b")))
+	require.EqualValues(t, 9, c.rq[9])
+	require.Len(t, delivered, 4)
+
+	require.NoError(t, c.Read(fragment(10, 6, 0, 1), []byte("c")))
+	require.False(t, c.partial[6].aborted)
+	require.NoError(t, c.Read(fragment(11, 6, 1, 1), []byte("d")))
+	require.Equal(t, "cd", delivered[4])
+	require.EqualValues(t, 3, c.Stats().ReassemblyDrops)
+
+	require.Error(t, c.readControl([]byte{byte(controlAbort), 0, 6, 0}))
+
+	// Should the abort be lost, partial reliable payloads are dropped once their newest fragment falls more than two
+	// read buffers behind the newest packet read.
+
+	require.NoError(t, c.Read(fragment(12, 9, 0, 1), []byte("a")))
+
+	c.mu.Lock()
+	c.rpn = 12 + 2*uint64(len(c.rq))
+	c.ri = uint16(c.rpn)
+	c.expirePartialMessages(time.Now())
+	require.Contains(t, c.partial, uint16(9))
+
+	c.rpn++
+	c.ri++
+	c.expirePartialMessages(time.Now())
+	require.NotContains(t, c.partial, uint16(9))
+	c.mu.Unlock()
+
+	require.EqualValues(t, 4, c.Stats().ReassemblyDrops)
+}
+
+// abortingConn cancels the context set using arm once the reliable fragment it was armed for gets written to it.
+type abortingConn struct {
+	net.PacketConn
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func (c *abortingConn) arm(cancel context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cancel = cancel
+}
+
+func (c *abortingConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(buf, addr)
+
+	header, _, herr := UnmarshalPacketHeader(buf)
+	if herr == nil && header.Fragment && !header.Unordered {
+		c.mu.Lock()
+		if c.cancel != nil {
+			c.cancel()
+			c.cancel = nil
+		}
+		c.mu.Unlock()
+	}
+
+	return n, err
+}
+
+func TestConnAbortsPartialFragments(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+	ca, cb := network.Listen(), network.Listen()
+	pc := &abortingConn{PacketConn: ca}
+
+	var (
+		mu        sync.Mutex
+		delivered []string
+	)
+
+	a := NewConn(pc, cb.LocalAddr(), WithFragmentSize(4))
+	b := NewConn(cb, ca.LocalAddr(), WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, string(buf))
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	go func() { defer wg.Done(); a.Run() }()
+	go func() { defer wg.Done(); b.Run() }()
+	go func() { defer wg.Done(); readPair(a, pc) }()
+	go func() { defer wg.Done(); readPair(b, cb) }()
+
+	defer func() {
+		a.Close()
+		b.Close()
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+		wg.Wait()
+	}()
+
+	// Writes cancelled after the first fragment of their payload was written leave as many partial payloads as our
+	// peer is able to reassemble at once, were our peer not told to drop them.
+
+	for i := 0; i < DefaultMaxPartialMessages; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		pc.arm(cancel)
+		require.Equal(t, context.Canceled, a.WriteReliablePacketContext(ctx, []byte("hello world!")))
+	}
+
+	// Payloads written afterwards still get reassembled.
+
+	for i := 0; i <= DefaultMaxPartialMessages; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte(fmt.Sprintf("payload %04d", i))))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) == DefaultMaxPartialMessages+1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i, payload := range delivered {
+		require.Equal(t, fmt.Sprintf("payload %04d", i), payload)
+	}
+	require.Zero(t, a.Stats().Resends)
+}
+
+func TestConnResendPacing(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithResendPacing(2), WithResendTimeout(time.Millisecond))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	// Packets left over once the pace is reached are resent on the next update.
+
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Equal(t, 6, pc.Writes())
+	require.EqualValues(t, 1, c.wqe[0].resent)
+	require.EqualValues(t, 0, c.wqe[2].resent)
+
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Equal(t, 8, pc.Writes())
+	require.EqualValues(t, 1, c.wqe[2].resent)
+}
+
+func TestInterleaverTakesTurns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	il := newInterleaver(2, 1)
+	il.beginResends(4)
+
+	var written int32
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			il.write()
+			atomic.AddInt32(&written, 1)
+		}
+	}()
+
+	// The first fresh write takes its turn straight away, after which fresh writes wait for two resends each.
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&written) == 1 }, time.Second, time.Millisecond)
+	require.Never(t, func() bool { return atomic.LoadInt32(&written) > 1 }, 10*time.Millisecond, time.Millisecond)
+
+	require.False(t, il.resend())
+	require.False(t, il.resend())
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&written) == 2 }, time.Second, time.Millisecond)
+
+	require.False(t, il.resend())
+	require.Never(t, func() bool { return atomic.LoadInt32(&written) > 2 }, 10*time.Millisecond, time.Millisecond)
+
+	// Once no resends are due, fresh writes no longer wait.
+
+	il.endResends()
+	<-done
+}
+
+func TestConnRetransmitInterleave(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithRetransmitInterleave(1, 1), WithResendTimeout(20*time.Millisecond),
+		WithRateLimit(1, 8*100))
+	defer c.Close()
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, c.WriteReliablePacket(make([]byte, 100)))
+	}
+
+	time.Sleep(25 * time.Millisecond)
+
+	// Fresh writes used up the rate limit, so resends are left for a later update rather than waiting for tokens.
+
+	start := time.Now()
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	require.Equal(t, 8, pc.Writes())
+	require.Zero(t, c.Stats().Resends)
+
+	// Packets left over are still due, and are resent once the rate limit allows for them.
+
+	refill := func() {
+		c.limiter.mu.Lock()
+		c.limiter.tokens = float64(c.limiter.limit.Burst)
+		c.limiter.mu.Unlock()
+	}
+
+	refill()
+	require.NoError(t, c.retransmitUnackedPackets())
+
+	resent := c.Stats().Resends
+	require.NotZero(t, resent)
+	require.Less(t, resent, uint64(8))
+
+	refill()
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.EqualValues(t, 8, c.Stats().Resends)
+	require.Equal(t, 16, pc.Writes())
+}
+
+func TestConnReportsRetransmitErrors(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.FailWrite(3, syscall.ECONNREFUSED)
+
+	c := NewConn(pc, nil, WithResendTimeout(time.Nanosecond))
+	require.NoError(t, c.WriteReliablePacket(nil))
+	require.NoError(t, c.WriteReliablePacket(nil))
+
+	time.Sleep(time.Millisecond)
+
+	// The first unacked packet fails to be resent, while the second is left to be resent on the next update.
+
+	require.True(t, errors.Is(c.retransmitUnackedPackets(), syscall.ECONNREFUSED))
+	require.Equal(t, 3, pc.Writes())
+
+	pc.FailWrite(4, io.EOF)
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Equal(t, 4, pc.Writes())
+}
+
+func TestConnWriteReliablePacketContext(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	// Our peer's read buffer is full, so writers block until they give up.
+
+	c.wi = uint16(len(c.rq))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.Equal(t, context.DeadlineExceeded, c.WriteReliablePacketContext(ctx, nil))
+	require.EqualValues(t, len(c.rq), c.wi)
+	require.Equal(t, c.tickets, c.serving)
+
+	// A writer that gives up while waiting behind another writer has its turn skipped once it comes.
+
+	first := make(chan error, 1)
+	go func() { first <- c.WriteReliablePacket(nil) }()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.tickets == c.serving+1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	second := make(chan error, 1)
+	go func() { second <- c.WriteReliablePacketContext(ctx, nil) }()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.tickets == c.serving+2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.Equal(t, context.Canceled, <-second)
+
+	c.mu.Lock()
+	c.oui = c.wi
+	c.ouc.Broadcast()
+	c.mu.Unlock()
+
+	require.NoError(t, <-first)
+	require.NoError(t, c.WriteReliablePacket(nil))
+
+	require.EqualValues(t, len(c.rq)+2, c.wi)
+	require.Equal(t, c.tickets, c.serving)
+	require.Empty(t, c.skipped)
+
+	require.Equal(t, context.Canceled, c.WriteReliablePacketContext(ctx, nil))
+}
+
+func TestConnSetWriteDeadline(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+	defer c.Close()
+
+	// Our peer's read buffer is full, so a blocked writer fails once the deadline set after it blocked passes.
+
+	c.wi = uint16(len(c.rq))
+
+	done := make(chan error, 1)
+	go func() { done <- c.WriteReliablePacket(nil) }()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.tickets == c.serving+1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, c.SetWriteDeadline(time.Now().Add(10*time.Millisecond)))
+
+	select {
+	case err := <-done:
+		require.Equal(t, os.ErrDeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("write blocked past its deadline")
+	}
+
+	require.EqualValues(t, len(c.rq), c.wi)
+	require.Equal(t, c.tickets, c.serving)
+
+	// Writes fail up front until the deadline is cleared.
+
+	require.Equal(t, os.ErrDeadlineExceeded, c.WriteUnreliablePacket(nil))
+
+	w := c.Writer()
+	require.NoError(t, w.WriteUnreliablePacket(nil))
+	require.Equal(t, os.ErrDeadlineExceeded, w.Flush())
+
+	require.NoError(t, c.SetWriteDeadline(time.Time{}))
+
+	c.mu.Lock()
+	c.oui = c.wi
+	c.mu.Unlock()
+
+	require.NoError(t, c.WriteReliablePacket(nil))
+}
+
+func TestConnCloseUnblocksWaitersAndReleasesBuffers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithUpdatePeriod(time.Millisecond), WithResendTimeout(time.Millisecond))
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Run()
+	}()
+
+	// Our peer never acks, so writers eventually block waiting for our peer's read buffer to free up.
+
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := c.WriteReliablePacket([]byte("hello")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.Close()
+	wg.Wait()
+
+	close(errs)
+	for err := range errs {
+		require.Equal(t, io.EOF, err)
+	}
+
+	for i := range c.wqe {
+		require.Nil(t, c.wqe[i].buf)
+	}
+
+	require.Equal(t, io.EOF, c.WriteUnreliablePacket(nil))
+	require.Equal(t, io.EOF, c.Read(PacketHeader{Unordered: true}, nil))
+}
+
+func TestConnCloseWaitsForBlockedWrites(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	pc := &blockingPacketConn{block: true, started: make(chan struct{}), release: make(chan struct{})}
+	c := NewConn(pc, nil)
+
+	errs := make(chan error)
+	go func() { errs <- c.WriteReliablePacket(nil) }()
+
+	<-pc.started
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("conn closed while a write was still blocked on the socket")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(pc.release)
+
+	<-closed
+	require.NoError(t, <-errs)
+	require.Nil(t, c.wqe[0].buf)
+}
+
+func TestConnServesWritersInOrder(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(nil, nil, WithoutSlowStart())
+	c.wi = uint16(len(c.rq))
+
+	var wg sync.WaitGroup
+
+	results := make([]uint16, 8)
+
+	for i := 0; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			idx, _, _, err := c.waitForNextWriteDetails(context.Background())
+			require.NoError(t, err)
+			results[i] = idx
+		}(i)
+
+		// Wait for the writer to take its ticket before starting the next one.
+
+		for {
+			c.mu.Lock()
+			tickets := c.tickets
+			c.mu.Unlock()
+
+			if tickets == uint64(i+1) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < len(results); i++ {
+		c.mu.Lock()
+		c.oui++
+		c.ouc.Broadcast()
+		c.mu.Unlock()
+	}
+
+	wg.Wait()
+
+	for i, idx := range results {
+		require.EqualValues(t, len(c.rq)+i, idx)
+	}
+
+	stats := c.Stats()
+	require.EqualValues(t, len(results), stats.WriteWaits)
+	require.NotZero(t, stats.WriteWaitMax)
+	require.GreaterOrEqual(t, int64(stats.WriteWaitTotal), int64(stats.WriteWaitMax))
+}
+
+func TestConnDefersAcksInBackground(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil)
+	require.NoError(t, c.SetPowerState(PowerBackground))
+
+	for i := uint16(0); i < ACKBitsetSize; i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: i}, nil))
+	}
+	require.Zero(t, pc.Writes())
+
+	require.NoError(t, c.SetPowerState(PowerForeground))
+	require.Equal(t, 1, pc.Writes())
+
+	for i := uint16(ACKBitsetSize); i < 2*ACKBitsetSize; i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: i}, nil))
+	}
+	require.Equal(t, 2, pc.Writes())
+}
+
+func TestConnCoalescesWritesInBackground(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil)
+	require.NoError(t, c.SetPowerState(PowerBackground))
+
+	// Payloads written while in the background are held back until the next update, which writes them out together.
+
+	for update := 0; update < 3; update++ {
+		for i := 0; i < 4; i++ {
+			require.NoError(t, c.WriteReliablePacket([]byte("reliable")))
+			require.NoError(t, c.WriteUnreliablePacket([]byte("unreliable")))
+		}
+		require.Equal(t, 8*update, pc.Writes())
+
+		c.flushCoalesced()
+		require.Equal(t, 8*(update+1), pc.Writes())
+	}
+
+	c.flushCoalesced()
+	require.Equal(t, 24, pc.Writes())
+
+	// Payloads held back are written out once this conn is brought back to the foreground, after which payloads are
+	// written out immediately.
+
+	require.NoError(t, c.WriteReliablePacket([]byte("reliable")))
+	require.Equal(t, 24, pc.Writes())
+
+	require.NoError(t, c.SetPowerState(PowerForeground))
+	require.Equal(t, 25, pc.Writes())
+
+	require.NoError(t, c.WriteUnreliablePacket([]byte("unreliable")))
+	require.Equal(t, 26, pc.Writes())
+
+	stats := c.Stats()
+	require.EqualValues(t, 26, stats.Overhead.Datagrams)
+	require.EqualValues(t, 13, stats.ReliableWrites)
+
+	// Payloads held back on a UDP socket are written out in a single batch per update.
+
+	ca := newPacketConn(t, "127.0.0.1:0")
+	defer ca.Close()
+
+	cb := newPacketConn(t, "127.0.0.1:0")
+	defer cb.Close()
+
+	c = NewConn(ca, cb.LocalAddr())
+	require.NoError(t, c.SetPowerState(PowerBackground))
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("reliable")))
+	}
+	require.Zero(t, c.Stats().Syscalls.Writes)
+
+	c.flushCoalesced()
+	require.EqualValues(t, 1, c.Stats().Syscalls.Writes)
+
+	// Payloads still held back once this conn is closed are dropped.
+
+	require.NoError(t, c.WriteUnreliablePacket([]byte("unreliable")))
+	c.Close()
+	c.flushCoalesced()
+	require.EqualValues(t, 1, c.Stats().Syscalls.Writes)
+}
+
+func TestConnTracksAppLimited(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(nil, nil, WithoutSlowStart())
+	require.True(t, c.Stats().AppLimited)
+
+	c.wi = uint16(len(c.rq))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.waitForNextWriteDetails(context.Background())
+	}()
+
+	for {
+		c.mu.Lock()
+		stalled := c.stalled
+		c.mu.Unlock()
+
+		if stalled {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.trackAppLimited()
+	require.False(t, c.Stats().AppLimited)
+
+	c.trackAppLimited()
+	require.False(t, c.Stats().AppLimited) // the writer is still waiting
+
+	c.mu.Lock()
+	c.oui++
+	c.ouc.Broadcast()
+	c.mu.Unlock()
+
+	<-done
+
+	c.trackAppLimited()
+	require.True(t, c.Stats().AppLimited)
+}
+
+func TestConnAmplificationLimit(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithAmplificationLimit(DefaultAmplificationFactor))
+	c.trackReceived(16)
+
+	payload := make([]byte, 16)
+
+	// Packet headers here are eight bytes, so exactly two packets fit within three times the bytes received.
+
+	require.NoError(t, c.WriteReliablePacket(payload))
+	require.NoError(t, c.WriteReliablePacket(payload))
+	require.Equal(t, 2, pc.Writes())
+
+	require.NoError(t, c.WriteReliablePacket(payload))
+	require.Equal(t, 2, pc.Writes())
+	require.EqualValues(t, 1, c.Stats().AmplificationDrops)
+
+	// Acks may be forged blindly by a spoofer, so they do not validate our peer.
+
+	c.readAckBits(0, 1)
+
+	require.NoError(t, c.WriteReliablePacket(payload))
+	require.Equal(t, 2, pc.Writes())
+	require.False(t, c.validated)
+
+	// Echoes of any nonce other than that of the challenge we sent our peer are ignored as well.
+
+	c.trackReceived(16)
+	require.NoError(t, c.writeChallengeOnUpdate(time.Now()))
+	require.Equal(t, 3, pc.Writes())
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlChallengeEcho)}, make([]byte, challengeNonceSize)...)))
+	require.False(t, c.validated)
+
+	// Once our peer echoes the challenge we sent it, it is validated and no longer limited.
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlChallengeEcho)}, c.challenge[:]...)))
+	require.True(t, c.validated)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.WriteReliablePacket(payload))
+	}
+	require.Equal(t, 7, pc.Writes())
+}
+
+func TestConnEchoesChallenge(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	c := NewConn(pc, nil)
+
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	require.NoError(t, c.readControl(append([]byte{byte(controlChallenge)}, nonce...)))
+	require.Equal(t, 1, pc.Writes())
+
+	require.Error(t, c.readControl([]byte{byte(controlChallenge), 1}))
+	require.Error(t, c.readControl([]byte{byte(controlChallengeEcho), 1}))
+}
+
+func TestConnQuota(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	var exceeded []QuotaUsage
+
+	c := NewConn(pc, nil, WithQuota(Quota{
+		SendBytes: 8,
+		RecvBytes: 8,
+		Interval:  50 * time.Millisecond,
+		OnExceeded: func(_ net.Addr, usage QuotaUsage) QuotaAction {
+			exceeded = append(exceeded, usage)
+			return QuotaThrottle
+		},
+	}))
+
+	payload := make([]byte, 4)
+
+	require.NoError(t, c.WriteUnreliablePacket(payload))
+	require.NoError(t, c.WriteUnreliablePacket(payload))
+	require.Equal(t, ErrQuotaExceeded, c.WriteUnreliablePacket(payload))
+	require.Equal(t, ErrQuotaExceeded, c.WriteUnreliablePacket(nil))
+	require.Equal(t, 2, pc.Writes())
+
+	// Reads are dropped while throttled, and the callback is only called once per interval.
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true}, payload))
+	require.Len(t, exceeded, 1)
+	require.EqualValues(t, 8, exceeded[0].SendBytes)
+
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, c.WriteUnreliablePacket(payload))
+	require.Equal(t, 3, pc.Writes())
+}
+
+func TestConnQuotaDisconnect(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithQuota(Quota{
+		RecvBytes:  4,
+		OnExceeded: func(net.Addr, QuotaUsage) QuotaAction { return QuotaDisconnect },
+	}))
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true}, make([]byte, 4)))
+	require.Equal(t, ErrQuotaExceeded, c.Read(PacketHeader{Unordered: true}, make([]byte, 1)))
+
+	require.Equal(t, io.EOF, c.WriteUnreliablePacket(nil))
+}
+
+func TestConnErrorBudgetOpensCircuit(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.FailWrite(1, syscall.ECONNREFUSED)
+	pc.FailWrite(2, syscall.ECONNREFUSED)
+
+	c := NewConn(pc, nil, WithResendTimeout(time.Nanosecond), WithErrorBudget(ErrorBudget{
+		TransmitErrors: 1,
+		Interval:       time.Minute,
+		Cooldown:       50 * time.Millisecond,
+	}))
+
+	require.Error(t, c.WriteUnreliablePacket(nil))
+	require.Error(t, c.WriteUnreliablePacket(nil))
+
+	// Once tripped, neither writes nor resends make it to the socket until the cooldown passes.
+
+	require.Equal(t, ErrCircuitOpen, c.WriteReliablePacket(nil))
+	require.NoError(t, c.retransmitUnackedPackets())
+
+	w := c.Writer()
+	require.NoError(t, w.WriteReliablePacket(nil))
+	require.Equal(t, ErrCircuitOpen, w.Flush())
+
+	require.Equal(t, 2, pc.Writes())
+
+	stats := c.Stats()
+	require.EqualValues(t, 2, stats.TransmitErrors)
+	require.EqualValues(t, 1, stats.BreakerTrips)
+	require.True(t, stats.BreakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+
+	require.NoError(t, c.WriteReliablePacket(nil))
+	require.Equal(t, 3, pc.Writes())
+	require.False(t, c.Stats().BreakerOpen)
+}
+
+func TestConnErrorBudgetDisconnect(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	tripped := make(chan ErrorBudgetUsage, 1)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithReadBufferSize(64), WithErrorBudget(ErrorBudget{
+		Anomalies: 1,
+		Interval:  time.Minute,
+		OnTripped: func(_ net.Addr, usage ErrorBudgetUsage) BreakerAction {
+			tripped <- usage
+			return BreakerDisconnect
+		},
+	}))
+
+	// Our peer acks a packet that was never written to it, and then sends a packet from more than a read buffer ahead.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, ACK: 10, ACKBits: 1}, nil))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1 + 64}, nil))
+
+	usage := <-tripped
+	require.Equal(t, 2, usage.Anomalies)
+	require.EqualValues(t, 2, c.Stats().Anomalies)
+
+	require.Eventually(t, func() bool {
+		return c.WriteUnreliablePacket(nil) == io.EOF
+	}, time.Second, time.Millisecond)
+}
+
+func TestConnOverheadStats(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithResendTimeout(time.Nanosecond))
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	require.NoError(t, c.WriteUnreliablePacket([]byte("hey")))
+
+	s := c.Stats().Overhead
+	require.EqualValues(t, 2, s.Datagrams)
+	require.EqualValues(t, 8, s.PayloadBytes)
+
+	reliableHeader := s.HeaderBytes - uint64(len(PacketHeader{Unordered: true}.AppendTo(nil)))
+	require.NotZero(t, reliableHeader)
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.NoError(t, c.writeAck(0))
+
+	s = c.Stats().Overhead
+	require.EqualValues(t, 4, s.Datagrams)
+	require.EqualValues(t, 8, s.PayloadBytes)
+	require.EqualValues(t, reliableHeader+5, s.ResentBytes)
+	require.NotZero(t, s.AckBytes)
+	require.Equal(t, s.PayloadBytes+s.HeaderBytes+s.ResentBytes+s.AckBytes, s.WireBytes())
+	require.Greater(t, s.Amplification(), 1.0)
+
+	require.NoError(t, c.CloseWithError(0, "bye"))
+	require.NotZero(t, c.Stats().Overhead.ControlBytes)
+}
+
+func TestConnWriteReliablePacketBudget(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil)
+	require.NoError(t, c.WriteReliablePacketBudget(nil, 0))
+
+	c.mu.Lock()
+	c.trackRTT(20 * time.Millisecond)
+	c.mu.Unlock()
+
+	require.EqualValues(t, 20*time.Millisecond, c.Stats().RTT)
+
+	require.NoError(t, c.WriteReliablePacketBudget(nil, 10*time.Millisecond))
+	require.Equal(t, ErrLatencyBudgetExceeded, c.WriteReliablePacketBudget(nil, 5*time.Millisecond))
+
+	// A full window costs an extra round trip.
+
+	c.mu.Lock()
+	c.wi = c.oui + uint16(len(c.rq))
+	c.mu.Unlock()
+
+	require.Equal(t, ErrLatencyBudgetExceeded, c.WriteReliablePacketBudget(nil, 10*time.Millisecond))
+	require.Equal(t, 2, pc.Writes())
+}
+
+func TestConnTracksReorderDepth(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	for _, idx := range []uint16{0, 3, 1, 2, 5, 4} {
+		require.NoError(t, c.Read(PacketHeader{Sequence: idx}, nil))
+	}
+
+	// A duplicate is not reordered.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1}, nil))
+
+	stats := c.Stats()
+	require.EqualValues(t, 3, stats.Reordered)
+	require.EqualValues(t, 2, stats.ReorderDepthMax)
+	require.EqualValues(t, 4, stats.ReorderDepthTotal)
+	require.InDelta(t, 4.0/3.0, stats.ReorderDepthMean(), 1e-9)
+}
+
+func TestConnSlowStart(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+	require.EqualValues(t, DefaultInitialWindowSize, c.window())
+
+	for i := uint16(0); i < DefaultInitialWindowSize; i++ {
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+	require.False(t, c.readerAvailable())
+
+	// Every acked packet grows the window by one, on top of freeing up its own slot.
+
+	require.NoError(t, c.Read(PacketHeader{ACK: ACKBitsetSize - 1, ACKBits: math.MaxUint32, Unordered: true}, nil))
+	require.EqualValues(t, DefaultInitialWindowSize+ACKBitsetSize, c.window())
+	require.EqualValues(t, ACKBitsetSize, c.oui)
+
+	for i := uint16(0); i < 2*ACKBitsetSize; i++ {
+		require.True(t, c.readerAvailable())
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+	require.False(t, c.readerAvailable())
+
+	require.EqualValues(t, len(c.rq), NewConn(nil, nil, WithoutSlowStart()).window())
+}
+
+func TestConnHandlersByReliability(t *testing.T) {
+	var reliable, unreliable, fallback int
+
+	c := NewConn(
+		reliabletest.NewFaultConn(nil),
+		nil,
+		WithPacketHandler(func(net.Addr, uint16, []byte) { fallback++ }),
+		WithReliablePacketHandler(func(net.Addr, uint16, []byte) { reliable++ }),
+	)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, []byte("a")))
+	require.NoError(t, c.Read(PacketHeader{Unordered: true}, []byte("b")))
+
+	c.uph = func(net.Addr, uint16, []byte) { unreliable++ }
+	require.NoError(t, c.Read(PacketHeader{Unordered: true}, []byte("c")))
+
+	require.Equal(t, 1, reliable)
+	require.Equal(t, 1, unreliable)
+	require.Equal(t, 1, fallback)
+}
+
+func TestConnAckPolicies(t *testing.T) {
+	reads := func(policy AckPolicy, count uint16) int {
+		pc := reliabletest.NewFaultConn(nil)
+		c := NewConn(pc, nil, WithAckPolicy(policy))
+		for i := uint16(0); i < count; i++ {
+			require.NoError(t, c.Read(PacketHeader{Sequence: i}, nil))
+		}
+		return pc.Writes()
+	}
+
+	require.Equal(t, 1, reads(BitsetAckPolicy{}, ACKBitsetSize+4))
+	require.Equal(t, ACKBitsetSize+4, reads(EveryPacketAckPolicy{}, ACKBitsetSize+4))
+	require.Equal(t, 3, reads(EveryNAckPolicy{N: 4}, 12))
+	require.Equal(t, 0, reads(DelayedAckPolicy{Delay: time.Hour}, 4))
+	require.Equal(t, 4, reads(AdaptiveAckPolicy{}, 4))
+}
+
+func TestConnDelayedAckPolicyAcksOnUpdate(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	c := NewConn(pc, nil, WithAckPolicy(DelayedAckPolicy{Delay: time.Millisecond}))
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, nil))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1}, nil))
+	require.NoError(t, c.writeAcksOnUpdate())
+	require.Zero(t, pc.Writes())
+
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, c.writeAcksOnUpdate())
+	require.Equal(t, 1, pc.Writes())
+
+	// Nothing is pending once the newest packet was acked.
+
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, c.writeAcksOnUpdate())
+	require.Equal(t, 1, pc.Writes())
+}
+
+func TestConnDropsStalePackets(t *testing.T) {
+	count := 0
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithReadBufferSize(64), WithPacketHandler(func(net.Addr, uint16, []byte) {
+		count++
+	}))
+
+	// Start right before the sequence space wraps around.
+
+	c.ri, c.lui = math.MaxUint16-9, math.MaxUint16-9
+
+	for i := uint16(0); i < 100; i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: c.ri}, nil))
+	}
+	require.Equal(t, 100, count)
+
+	for _, idx := range []uint16{c.ri - 65, c.ri + 64, c.ri + math.MaxInt16 + 1} {
+		require.NoError(t, c.Read(PacketHeader{Sequence: idx}, nil))
+	}
+	require.Equal(t, 100, count)
+	require.EqualValues(t, 3, c.Stats().Stale)
+
+	// The oldest and newest sequence numbers within a read buffer of the newest packet are still accepted.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: c.ri - 64}, nil))
+	require.NoError(t, c.Read(PacketHeader{Sequence: c.ri + 63}, nil))
+	require.Equal(t, 101, count)
+	require.EqualValues(t, 3, c.Stats().Stale)
+}
+
+func TestConnReorderTolerance(t *testing.T) {
+	count := 0
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithReadBufferSize(64), WithReorderTolerance(32),
+		WithPacketHandler(func(net.Addr, uint16, []byte) { count++ }),
+	)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 60}, nil))
+
+	// Packets any older than the reorder tolerance are stale, even though they would fit in the read buffer.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 60 - 32}, nil))
+	require.EqualValues(t, 1, c.Stats().Stale)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 60 - 31}, nil))
+	require.Equal(t, 2, count)
+
+	require.Panics(t, func() { WithReorderTolerance(ACKBitsetSize - 1) })
+	require.Panics(t, func() { NewConn(nil, nil, WithReadBufferSize(64), WithReorderTolerance(128)) })
+}
+
+func TestConnTracksWriteSyscalls(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.ShortWrite(2)
+
+	var ews writeStats
+
+	c := NewConn(pc, nil, withWriteStats{ws: &ews})
+
+	for i := 0; i < 3; i++ {
+		_ = c.WriteUnreliablePacket([]byte("hello"))
+	}
+
+	stats := c.Stats().Syscalls
+	require.EqualValues(t, 3, stats.Writes)
+	require.EqualValues(t, 1, stats.ShortWrites)
+	require.LessOrEqual(t, int64(stats.Max), int64(stats.Total))
+	require.Equal(t, stats, ews.snapshot())
+
+	var total uint64
+	for _, count := range stats.Latency {
+		total += count
+	}
+	require.EqualValues(t, stats.Writes, total)
+
+	// Latencies are sorted into power-of-two buckets in microseconds, with the last bucket catching the rest.
+
+	var s WriteStats
+	for _, took := range []time.Duration{0, 999 * time.Nanosecond, 1 * time.Microsecond, 3 * time.Microsecond, 4 * time.Microsecond, 1 * time.Hour} {
+		s.add(took, false)
+	}
+	require.EqualValues(t, 2, s.Latency[0])
+	require.EqualValues(t, 1, s.Latency[1])
+	require.EqualValues(t, 1, s.Latency[2])
+	require.EqualValues(t, 1, s.Latency[3])
+	require.EqualValues(t, 1, s.Latency[WriteLatencyBuckets-1])
+	require.Equal(t, 1*time.Hour, s.Max)
+}
+
+func TestConnRateLimit(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithRateLimit(1000, 100))
+
+	// Bursts are written right away.
+
+	require.NoError(t, c.WriteUnreliablePacket(make([]byte, 100)))
+	require.EqualValues(t, 0, c.Stats().RateLimited)
+
+	start := time.Now()
+	require.NoError(t, c.WriteUnreliablePacket(make([]byte, 10)))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(5*time.Millisecond))
+	require.EqualValues(t, 1, c.Stats().RateLimited)
+
+	// Writes made past the burst are delayed, though acks are not.
+
+	errs := make(chan error)
+	go func() { errs <- c.WriteReliablePacket(make([]byte, 1000)) }()
+
+	require.Eventually(t, func() bool { return c.Stats().RateLimited == 2 }, 1*time.Second, 1*time.Millisecond)
+
+	writes := pc.Writes()
+	require.NoError(t, c.writeAck(0))
+	require.Equal(t, writes+1, pc.Writes())
+
+	c.Close()
+	require.True(t, errors.Is(<-errs, io.EOF))
+}
+
+func TestConnWriterFlushesBatchesInOneTurn(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithoutSlowStart())
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	for i := 0; i < 4; i++ {
+		go func(id byte) {
+			defer wg.Done()
+
+			w := c.Writer()
+			for j := 0; j < writerBatchSize; j++ {
+				require.NoError(t, w.WriteReliablePacket([]byte{id}))
+			}
+			require.NoError(t, w.Flush())
+		}(byte(i))
+	}
+
+	wg.Wait()
+
+	require.EqualValues(t, 4*writerBatchSize, c.wi)
+
+	// Packets staged by each writer were assigned consecutive sequence numbers.
+
+	for start := 0; start < 4*writerBatchSize; start += writerBatchSize {
+		id := c.wqe[start].buf.B[len(c.wqe[start].buf.B)-1]
+		for idx := start; idx < start+writerBatchSize; idx++ {
+			require.Equal(t, id, c.wqe[idx].buf.B[len(c.wqe[idx].buf.B)-1])
+		}
+	}
+
+	// Unreliable packets are staged alongside reliable ones, and nothing is left staged after a flush.
+
+	w := c.Writer()
+	require.NoError(t, w.WriteUnreliablePacket([]byte("hello")))
+	require.NoError(t, w.WriteReliablePacket([]byte("world")))
+	require.Len(t, w.staged, 2)
+	require.NoError(t, w.Flush())
+	require.Empty(t, w.staged)
+	require.EqualValues(t, 4*writerBatchSize+1, c.wi)
+
+	c.Close()
+	require.NoError(t, w.WriteReliablePacket(nil))
+	require.True(t, errors.Is(w.Flush(), io.EOF))
+}
+
+func TestConnExpectWriteSoonSuppressesAcks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithAckPolicy(EveryPacketAckPolicy{}), WithAckSuppressionWindow(20*time.Millisecond))
+	defer c.Close()
+
+	// Acks held back get piggybacked onto the packet that was hinted to be written soon.
+
+	c.ExpectWriteSoon()
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, nil))
+	require.Equal(t, 0, pc.Writes())
+
+	require.NoError(t, c.WriteReliablePacket(nil))
+	require.Equal(t, 1, pc.Writes())
+
+	time.Sleep(40 * time.Millisecond)
+	require.Equal(t, 1, pc.Writes())
+
+	// Acks held back are written out once the window passes should nothing have been written.
+
+	c.ExpectWriteSoon()
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1}, nil))
+	require.Equal(t, 1, pc.Writes())
+
+	require.Eventually(t, func() bool { return pc.Writes() == 2 }, 1*time.Second, 1*time.Millisecond)
+
+	// Without a hint, acks are written right away.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 2}, nil))
+	require.Equal(t, 3, pc.Writes())
+}
+
+func TestConnCloseWithError(t *testing.T) {
+	pc := &recordingPacketConn{}
+
+	a := NewConn(pc, nil)
+	require.NoError(t, a.CloseWithError(3, string(bytes.Repeat([]byte("x"), 2*MaxCloseReasonSize))))
+	require.True(t, errors.Is(a.WriteReliablePacket(nil), io.EOF))
+	require.Len(t, pc.writes, 1)
+
+	header, buf, err := UnmarshalPacketHeader(pc.writes[0])
+	require.NoError(t, err)
+	require.True(t, header.Empty)
+
+	b := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	err = b.Read(header, buf)
+
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	require.EqualValues(t, 3, closeErr.Code)
+	require.Len(t, closeErr.Reason, MaxCloseReasonSize)
+	require.True(t, errors.Is(b.WriteReliablePacket(nil), io.EOF))
+
+	// Control packets of unknown types are ignored, and truncated close notifications are rejected.
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+	require.NoError(t, c.Read(header, []byte{0xFF}))
+	require.Error(t, c.Read(header, []byte{byte(controlClose), 0x00}))
+}
+
+func TestConnPreallocation(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithPreallocation(4, 64), WithoutSlowStart())
+
+	require.True(t, errors.Is(c.WriteUnreliablePacket(make([]byte, 64)), ErrPacketTooLarge))
+
+	// Unacked reliable packets hold onto their buffers until they are acked.
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	}
+	require.True(t, errors.Is(c.WriteReliablePacket([]byte("hello")), ErrBuffersExhausted))
+	require.True(t, errors.Is(c.Writer().WriteReliablePacket([]byte("hello")), ErrBuffersExhausted))
+	require.EqualValues(t, 4, c.wi)
+
+	require.NoError(t, c.Read(PacketHeader{ACK: 3, ACKBits: 0xF, Unordered: true}, nil))
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	require.EqualValues(t, 5, c.wi)
+
+	// Writes do not allocate once buffers are preallocated.
+
+	allocs := testing.AllocsPerRun(100, func() {
+		require.NoError(t, c.WriteUnreliablePacket([]byte("hello")))
+	})
+	require.Zero(t, allocs)
+}
+
+type countingPool struct {
+	BufferPool
+
+	mu    sync.Mutex
+	sizes []int
+	out   int
+}
+
+func (p *countingPool) Get(size int) *Buffer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sizes = append(p.sizes, size)
+	p.out++
+
+	return p.BufferPool.Get(size)
+}
+
+func (p *countingPool) Put(b *Buffer) {
+	p.mu.Lock()
+	p.out--
+	p.mu.Unlock()
+
+	p.BufferPool.Put(b)
+}
+
+func TestConnBufferPool(t *testing.T) {
+	pool := &countingPool{BufferPool: NewBufferPool(new(Pool))}
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithBufferPool(pool))
+
+	// Buffers are gotten with the size of the packet to be marshaled into them, and are put back once acked.
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	require.Equal(t, []int{maxPacketHeaderSize + 5}, pool.sizes)
+	require.Equal(t, 1, pool.out)
+
+	require.NoError(t, c.Read(PacketHeader{ACK: 0, ACKBits: 1, Unordered: true}, nil))
+	require.Equal(t, 0, pool.out)
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	c.Close()
+	require.Equal(t, 0, pool.out)
+}
+
+func TestConnReportCongestion(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithoutSlowStart())
+	require.EqualValues(t, len(c.rq), c.window())
+
+	c.ReportCongestion(0)
+	require.EqualValues(t, len(c.rq), c.window())
+
+	c.ReportCongestion(0.5)
+	require.EqualValues(t, len(c.rq)*3/4, c.window())
+
+	c.ReportCongestion(2)
+	require.EqualValues(t, len(c.rq)*3/8, c.window())
+
+	for i := 0; i < 8; i++ {
+		c.ReportCongestion(1)
+	}
+	require.EqualValues(t, ACKBitsetSize, c.window())
+	require.EqualValues(t, 10, c.Stats().CongestionReports)
+
+	// The window grows back as packets get acked.
+
+	for i := uint16(0); i < ACKBitsetSize; i++ {
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+	require.NoError(t, c.Read(PacketHeader{ACK: ACKBitsetSize - 1, ACKBits: math.MaxUint32, Unordered: true}, nil))
+	require.EqualValues(t, 2*ACKBitsetSize, c.window())
+}
+
+func TestConnDeliveryDelay(t *testing.T) {
+	var delivered []string
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithDeliveryDelay(2), WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+		delivered = append(delivered, string(buf))
+	}))
+	defer c.Close()
+
+	// Packets read during a tick are delivered two ticks later, in the order they were read.
+
+	buf := []byte("a")
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, buf))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, Unordered: true}, []byte("b")))
+	buf[0] = 'z' // held packets are copied
+	require.Empty(t, delivered)
+
+	c.Tick()
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1}, []byte("c")))
+	require.Empty(t, delivered)
+
+	c.Tick()
+	require.Equal(t, []string{"a", "b"}, delivered)
+
+	c.Tick()
+	require.Equal(t, []string{"a", "b", "c"}, delivered)
+
+	c.Tick()
+	require.Len(t, delivered, 3)
+
+	require.Panics(t, func() { WithDeliveryDelay(0) })
+}
+
+func TestConnPacketNumbersCountWraps(t *testing.T) {
+	var numbers []uint64
+
+	var c *Conn
+	c = NewConn(reliabletest.NewFaultConn(nil), nil, WithPacketHandler(func(_ net.Addr, seq uint16, _ []byte) {
+		numbers = append(numbers, c.ReadPacketNumber(seq))
+	}))
+	defer c.Close()
+
+	// Start right before the sequence space wraps around for the third time.
+
+	c.ri, c.lui, c.rpn = math.MaxUint16-1, math.MaxUint16-1, 2<<16|(math.MaxUint16-1)
+	c.wi, c.oui, c.wpn = math.MaxUint16-1, math.MaxUint16-1, 2<<16|(math.MaxUint16-1)
+
+	for _, seq := range []uint16{math.MaxUint16 - 1, 1, math.MaxUint16, 0} {
+		require.NoError(t, c.Read(PacketHeader{Sequence: seq}, []byte("hello")))
+	}
+	require.Equal(t, []uint64{2<<16 | (math.MaxUint16 - 1), 3<<16 | 1, 2<<16 | math.MaxUint16, 3 << 16}, numbers)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	}
+	require.EqualValues(t, 2<<16|math.MaxUint16, c.WritePacketNumber(math.MaxUint16))
+	require.EqualValues(t, 3<<16|1, c.WritePacketNumber(1))
+
+	stats := c.Stats()
+	require.EqualValues(t, 3<<16|2, stats.ReadPacketNumber)
+	require.EqualValues(t, 3<<16|2, stats.WritePacketNumber)
+}
+
+func TestConnSentHistory(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithSentHistorySize(4), WithResendTimeout(time.Millisecond))
+	defer c.Close()
+
+	require.Nil(t, NewConn(nil, nil).SentHistory())
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, c.WriteReliablePacket(bytes.Repeat([]byte("x"), i)))
+	}
+	require.NoError(t, c.WriteUnreliablePacket([]byte("unreliable")))
+
+	// Only the four most recent reliable packets are kept.
+
+	history := c.SentHistory()
+	require.Len(t, history, 4)
+	for i, r := range history {
+		require.EqualValues(t, i+2, r.Seq)
+		require.EqualValues(t, i+2, r.Number)
+		require.Equal(t, i+2, r.Size)
+		require.False(t, r.Sent.IsZero())
+		require.True(t, r.Acked.IsZero())
+	}
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, ACK: 3, ACKBits: 0b11}, nil))
+
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, c.retransmitUnackedPackets())
+
+	history = c.SentHistory()
+	require.False(t, history[0].Acked.IsZero())
+	require.False(t, history[1].Acked.IsZero())
+	require.Zero(t, history[1].Resends)
+	require.True(t, history[1].Lost.IsZero())
+
+	require.True(t, history[2].Acked.IsZero())
+	require.Equal(t, 1, history[2].Resends)
+	require.False(t, history[2].Lost.IsZero())
+}
+
+func TestConnTracksAcksOfAcks(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithResendTimeout(time.Millisecond))
+	defer c.Close()
+
+	// A full ack bitset of packets read has a standalone ack, which consumes a sequence number, be written for it.
+
+	for i := uint16(0); i < ACKBitsetSize; i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: i}, nil))
+	}
+	require.EqualValues(t, 1, c.wi)
+	require.False(t, c.ackState(time.Now()).AckLost)
+
+	// Our peer not acking the ack in time has it be deemed lost.
+
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.EqualValues(t, 1, c.Stats().AcksLost)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: ACKBitsetSize}, nil))
+
+	state := c.ackState(time.Now())
+	require.True(t, state.AckLost)
+	require.True(t, DelayedAckPolicy{Delay: time.Hour}.AckOnUpdate(state))
+
+	// Acks are no longer deemed lost once the newest packet read is acked again, and our peer acking the ack has it
+	// be confirmed.
+
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+	require.False(t, c.ackState(time.Now()).AckLost)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: ACKBitsetSize + 1, ACK: 0, ACKBits: 1}, nil))
+	require.EqualValues(t, 1, c.Stats().AcksConfirmed)
+	require.EqualValues(t, 1, c.Stats().AcksLost)
+}
+
+func TestConnUnsequencedAcks(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithResendTimeout(time.Millisecond), WithUnsequencedAcks())
+	defer c.Close()
+
+	// A full ack bitset of packets read has a standalone ack be written for it without consuming a sequence number,
+	// such that it is never resent.
+
+	for i := uint16(0); i < ACKBitsetSize; i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: i}, nil))
+	}
+	require.EqualValues(t, 0, c.wi)
+	require.EqualValues(t, ACKBitsetSize, c.lui)
+
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.Zero(t, c.Stats().Resends)
+	require.Zero(t, c.Stats().AcksLost)
+}
+
+func TestConnBufferProvider(t *testing.T) {
+	arena := make([]byte, 0, 16)
+
+	var delivered [][]byte
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil,
+		WithBufferProvider(func(size int) []byte {
+			buf := arena[len(arena) : len(arena)+size]
+			arena = arena[:len(arena)+size]
+			return buf
+		}),
+		WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+			delivered = append(delivered, buf)
+		}),
+	)
+	defer c.Close()
+
+	buf := []byte("hello")
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, buf))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1}, []byte("world")))
+	buf[0] = 'j'
+
+	// Payloads land in memory owned by the application, and outlive the packet handler.
+
+	require.Equal(t, [][]byte{[]byte("hello"), []byte("world")}, delivered)
+	require.Equal(t, "helloworld", string(arena))
+	require.Equal(t, &arena[0], &delivered[0][0])
+}
+
+func TestConnLifecycleStats(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	}
+	require.NoError(t, c.WriteUnreliablePacket([]byte("hello")))
+
+	stats := c.Stats().Lifecycle
+	require.EqualValues(t, 3, stats.Queue.Count)
+	require.EqualValues(t, 3, stats.Transmit.Count)
+	require.Zero(t, stats.Ack.Count)
+
+	// Packet 1 being acked before packet 0 holds back its slot from being released until packet 0 is acked.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, ACK: 1, ACKBits: 1}, nil))
+	require.EqualValues(t, 1, c.Stats().Lifecycle.Ack.Count)
+	require.Zero(t, c.Stats().Lifecycle.Release.Count)
+
+	time.Sleep(2 * time.Millisecond)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1, ACK: 0, ACKBits: 1}, nil))
+
+	stats = c.Stats().Lifecycle
+	require.EqualValues(t, 2, stats.Ack.Count)
+	require.EqualValues(t, 2, stats.Release.Count)
+	require.GreaterOrEqual(t, int64(stats.Release.Percentile(100)), int64(2*time.Millisecond))
+	require.GreaterOrEqual(t, int64(stats.Ack.Percentile(100)), int64(stats.Ack.Percentile(0)))
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	var h LatencyHistogram
+	require.Zero(t, h.Percentile(50))
+
+	for i := 0; i < 99; i++ {
+		h.add(3 * time.Microsecond)
+	}
+	h.add(time.Hour)
+
+	require.Equal(t, 4*time.Microsecond, h.Percentile(50))
+	require.Equal(t, 4*time.Microsecond, h.Percentile(98))
+	require.Equal(t, time.Duration(1<<(LatencyBuckets-1))*time.Microsecond, h.Percentile(100))
+	require.Equal(t, (99*3*time.Microsecond+time.Hour)/100, h.Mean())
+}
+
+func TestConnRandSourceIsReproducible(t *testing.T) {
+	a := NewConn(reliabletest.NewFaultConn(nil), nil, WithRandSource(rand.NewSource(1)))
+	defer a.Close()
+
+	b := NewConn(reliabletest.NewFaultConn(nil), nil, WithRandSource(rand.NewSource(1)))
+	defer b.Close()
+
+	for i := 0; i < 100; i++ {
+		d := a.rand.jitter(100*time.Millisecond, 0.25)
+		require.Equal(t, d, b.rand.jitter(100*time.Millisecond, 0.25))
+		require.True(t, d >= 75*time.Millisecond && d <= 125*time.Millisecond, d)
+	}
+
+	// Conns of an endpoint share its source.
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	e := NewEndpoint(ca, WithRandSource(rand.NewSource(1)))
+	go e.Listen()
+
+	require.Same(t, e.rand, e.getConn(cb.LocalAddr(), nil).rand)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, e.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestRateWindow(t *testing.T) {
+	s := newRateSampler()
+
+	// A steady rate of 100 packets per second is fully reported after a few windows, and half reported after a
+	// window's worth of it.
+
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		s.wrote(now, 10)
+		s.read(now, 5)
+		s.sampleLoss(now, i%4 == 0)
+	}
+
+	end := start.Add(10 * time.Second)
+
+	short, long := s.short.rates(end), s.long.rates(end)
+	require.InDelta(t, 100, short.WritePackets, 1)
+	require.InDelta(t, 1000, short.WriteBytes, 10)
+	require.InDelta(t, 100, short.ReadPackets, 1)
+	require.InDelta(t, 500, short.ReadBytes, 5)
+	require.InDelta(t, 0.25, short.Loss, 0.01)
+	require.InDelta(t, 100*(1-math.Exp(-1)), long.WritePackets, 1)
+	require.InDelta(t, 0.25, long.Loss, 0.01)
+
+	// Rates decay once traffic stops, though the loss rate holds.
+
+	short = s.short.rates(end.Add(time.Second))
+	require.InDelta(t, 100*math.Exp(-1), short.WritePackets, 1)
+	require.InDelta(t, 0.25, short.Loss, 0.01)
+
+	// Rates are not decayed for times before the last event.
+
+	last := start.Add(9990 * time.Millisecond)
+	require.Equal(t, s.short.rates(last), s.short.rates(start))
+}
+
+func TestConnRates(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	require.Zero(t, c.Stats().Rates1s)
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, ACK: 0, ACKBits: 1}, []byte("hi")))
+
+	stats := c.Stats()
+	require.Greater(t, stats.Rates1s.WritePackets, 0.0)
+	require.Greater(t, stats.Rates1s.WriteBytes, stats.Rates1s.WritePackets)
+	require.InDelta(t, 1, stats.Rates1s.ReadPackets, 0.01)
+	require.InDelta(t, 2, stats.Rates1s.ReadBytes, 0.01)
+	require.Zero(t, stats.Rates1s.Loss)
+	require.InDelta(t, 0.1, stats.Rates10s.ReadPackets, 0.01)
+}
+
+func TestConnStatsDoNotAllocate(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil,
+		WithPreallocation(64, 64), WithoutSlowStart(), WithEventLogSize(64), WithSentHistorySize(64),
+	)
+	defer c.Close()
+
+	payload := []byte("hello")
+
+	var seq uint16
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		require.NoError(t, c.WriteReliablePacket(payload))
+		require.NoError(t, c.Read(PacketHeader{ACK: c.wi - 1, ACKBits: 0xFFFFFFFF, Unordered: true}, nil))
+		require.NoError(t, c.Read(PacketHeader{Sequence: seq}, payload))
+		seq++
+
+		_ = c.Stats()
+	})
+	require.Zero(t, allocs)
+}
+
+func BenchmarkConnStats(b *testing.B) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil,
+		WithPreallocation(64, 64), WithoutSlowStart(), WithEventLogSize(64), WithSentHistorySize(64),
+	)
+	defer c.Close()
+
+	payload := []byte("hello")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteReliablePacket(payload); err != nil {
+			b.Fatal(err)
+		}
+		if err := c.Read(PacketHeader{ACK: c.wi - 1, ACKBits: 0xFFFFFFFF, Unordered: true}, nil); err != nil {
+			b.Fatal(err)
+		}
+		if err := c.Read(PacketHeader{Sequence: uint16(i)}, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	_ = c.Stats()
+}
+
+func TestConnKeepAlive(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithKeepAlivePeriod(time.Second))
+
+	// Keepalives are only written once nothing was written for the keepalive period, jitter aside.
+
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now()))
+	require.Equal(t, 0, pc.Writes())
+
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now().Add(800*time.Millisecond)))
+	require.Equal(t, 1, pc.Writes())
+
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now().Add(1200*time.Millisecond)))
+	require.Equal(t, 2, pc.Writes())
+	require.EqualValues(t, 1, c.Stats().KeepAlives)
+
+	// Keepalives consume no sequence numbers, and so are never resent.
+
+	require.EqualValues(t, 0, c.wi)
+
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now().Add(800*time.Millisecond)))
+	require.Equal(t, 2, pc.Writes())
+
+	// The keepalive period is stretched while in the background.
+
+	require.NoError(t, c.SetPowerState(PowerBackground))
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now().Add(2*time.Second)))
+	require.Equal(t, 2, pc.Writes())
+
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now().Add(5*time.Second)))
+	require.Equal(t, 3, pc.Writes())
+}
+
+func TestConnInactivityTimeout(t *testing.T) {
+	var timeouts int
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithInactivityTimeout(time.Second), withInactivityHook{fn: func() {
+		timeouts++
+	}})
+
+	c.checkInactivity(time.Now().Add(800 * time.Millisecond))
+	require.Equal(t, 0, timeouts)
+
+	// Packets written to our peer do not count as activity of our peer, while packets read from it do.
+
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+	require.NoError(t, c.writeKeepAliveIfIdle(time.Now()))
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, c.Read(PacketHeader{Unordered: true}, nil))
+
+	c.checkInactivity(time.Now().Add(995 * time.Millisecond))
+	require.Equal(t, 0, timeouts)
+
+	c.checkInactivity(time.Now().Add(time.Second))
+	c.checkInactivity(time.Now().Add(2 * time.Second))
+	require.Equal(t, 1, timeouts)
+}
+
+func TestConnParameters(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithKeepAlivePeriod(time.Second), WithFragmentSize(1000))
+
+	p := c.Parameters()
+	require.Equal(t, DefaultInitialWindowSize, p.Window)
+	require.Equal(t, DefaultInitialWindowSize, p.CongestionWindow)
+	require.Equal(t, DefaultReadBufferSize, p.PeerReadBuffer)
+	require.Equal(t, DefaultResendTimeout, p.ResendTimeout)
+	require.Equal(t, time.Second, p.KeepAlivePeriod)
+	require.Equal(t, 1000, p.FragmentSize)
+	require.False(t, p.Authenticated)
+
+	// Parameters derived from conditions of the link differ from those configured once conditions are sampled.
+
+	c.mu.Lock()
+	c.trackRTT(20 * time.Millisecond)
+	c.mu.Unlock()
+
+	p = c.Parameters()
+	require.Equal(t, 20*time.Millisecond, p.RTT)
+	require.Equal(t, 10*time.Millisecond, p.RTTVar)
+	require.Equal(t, 60*time.Millisecond, p.ResendTimeout)
+
+	require.NoError(t, c.SetPowerState(PowerBackground))
+
+	p = c.Parameters()
+	require.Equal(t, PowerBackground, p.PowerState)
+	require.Equal(t, 4*60*time.Millisecond, p.ResendTimeout)
+	require.Equal(t, 4*time.Second, p.KeepAlivePeriod)
+}
+
+func TestConnAdaptiveKeepAlive(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithAdaptiveKeepAlive(time.Second, 4*time.Second))
+
+	// keepAlive writes a keepalive once nothing was written for silence, with our peer last heard from heard into it.
+
+	keepAlive := func(silence, heard time.Duration) {
+		start := time.Now().Add(-time.Hour)
+		c.ls, c.lr = start, start.Add(heard)
+		require.NoError(t, c.writeKeepAliveIfIdle(start.Add(silence)))
+	}
+
+	// The keepalive period grows while our peer is still heard from towards the end of each silence.
+
+	keepAlive(1200*time.Millisecond, 1000*time.Millisecond)
+	require.Equal(t, 1500*time.Millisecond, c.Parameters().KeepAlivePeriod)
+
+	keepAlive(1700*time.Millisecond, 1500*time.Millisecond)
+	require.Equal(t, 2250*time.Millisecond, c.Parameters().KeepAlivePeriod)
+	require.False(t, c.Parameters().KeepAliveSettled)
+
+	// Once our peer goes unheard from, the period settles on the longest silence our peer was heard after, less the
+	// jitter.
+
+	keepAlive(2500*time.Millisecond, 1000*time.Millisecond)
+	require.Equal(t, 1350*time.Millisecond, c.Parameters().KeepAlivePeriod)
+	require.True(t, c.Parameters().KeepAliveSettled)
+
+	keepAlive(1600*time.Millisecond, 1500*time.Millisecond)
+	require.Equal(t, 1350*time.Millisecond, c.Parameters().KeepAlivePeriod)
+
+	// The period settles on the max period should our peer keep being heard from.
+
+	c = NewConn(reliabletest.NewFaultConn(nil), nil, WithAdaptiveKeepAlive(time.Second, 2*time.Second))
+
+	keepAlive(1200*time.Millisecond, 1000*time.Millisecond)
+	keepAlive(1700*time.Millisecond, 1500*time.Millisecond)
+	require.Equal(t, 2*time.Second, c.Parameters().KeepAlivePeriod)
+	require.True(t, c.Parameters().KeepAliveSettled)
+}
+
+func TestConnStatsCountTraffic(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithResendTimeout(time.Millisecond))
+
+	require.NoError(t, c.WriteReliablePacket([]byte("a")))
+	require.NoError(t, c.WriteReliablePacket([]byte("b")))
+	require.NoError(t, c.WriteUnreliablePacket([]byte("c")))
+
+	stats := c.Stats()
+	require.EqualValues(t, 2, stats.ReliableWrites)
+	require.EqualValues(t, 1, stats.UnreliableWrites)
+	require.EqualValues(t, 2, stats.InFlight)
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, []byte("hi")))
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0}, []byte("hi")))
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 0, ACKBits: 1}, []byte("hey")))
+
+	stats = c.Stats()
+	require.EqualValues(t, 3, stats.PacketsRead)
+	require.EqualValues(t, 2, stats.ReliableReads)
+	require.EqualValues(t, 1, stats.UnreliableReads)
+	require.EqualValues(t, 7, stats.BytesRead)
+	require.EqualValues(t, 1, stats.Duplicates)
+	require.EqualValues(t, 1, stats.PacketsAcked)
+	require.EqualValues(t, 1, stats.InFlight)
+
+	time.Sleep(2 * time.Millisecond)
+
+	require.NoError(t, c.retransmitUnackedPackets())
+	require.EqualValues(t, 1, c.Stats().Resends)
+
+	// Packets staged by a writer are counted as they are flushed.
+
+	w := c.Writer()
+	require.NoError(t, w.WriteReliablePacket([]byte("d")))
+	require.NoError(t, w.WriteUnreliablePacket([]byte("e")))
+	require.EqualValues(t, 2, c.Stats().ReliableWrites)
+	require.NoError(t, w.Flush())
+
+	stats = c.Stats()
+	require.EqualValues(t, 3, stats.ReliableWrites)
+	require.EqualValues(t, 2, stats.UnreliableWrites)
+}
+
+func TestConnAckHandler(t *testing.T) {
+	var (
+		c     *Conn
+		acked []uint16
+	)
+
+	// The ack handler is called outside of the mutex of the conn, such that it may call back into the conn.
+
+	c = NewConn(reliabletest.NewFaultConn(nil), nil, WithAckHandler(func(_ net.Addr, seq uint16) {
+		acked = append(acked, seq)
+		c.Stats()
+	}))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.WriteReliablePacket(nil))
+	}
+	require.NoError(t, c.WriteUnreliablePacket(nil))
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 1, ACKBits: 0b11}, nil))
+	require.Equal(t, []uint16{1, 0}, acked)
+
+	// Packets are only handed to the ack handler the first time they are acked.
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 2, ACKBits: 0b111}, nil))
+	require.Equal(t, []uint16{1, 0, 2}, acked)
+}
+
+func TestConnOrderedDelivery(t *testing.T) {
+	var (
+		reliable   []uint16
+		unreliable int
+		payloads   [][]byte
+	)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithOrderedDelivery(),
+		WithReliablePacketHandler(func(_ net.Addr, seq uint16, buf []byte) {
+			reliable = append(reliable, seq)
+			payloads = append(payloads, append([]byte(nil), buf...))
+		}),
+		WithUnreliablePacketHandler(func(_ net.Addr, _ uint16, _ []byte) {
+			unreliable++
+		}),
+	)
+	defer c.Close()
+
+	seqs := make([]uint16, 64)
+	for i := range seqs {
+		seqs[i] = uint16(i)
+	}
+	rand.New(rand.NewSource(0)).Shuffle(len(seqs), func(i, j int) { seqs[i], seqs[j] = seqs[j], seqs[i] })
+
+	// Held packets have their payloads copied, as the buffer they were read from gets reused. Packet 7 is a
+	// standalone ack, which is never delivered and does not hold back the packets sent after it.
+
+	buf := make([]byte, 2)
+	for i, seq := range seqs {
+		if seq == 7 {
+			require.NoError(t, c.Read(PacketHeader{Sequence: seq, Empty: true}, nil))
+		} else {
+			buf[0], buf[1] = byte(seq>>8), byte(seq)
+			require.NoError(t, c.Read(PacketHeader{Sequence: seq}, buf))
+		}
+
+		require.NoError(t, c.Read(PacketHeader{Unordered: true}, nil))
+		require.Equal(t, i+1, unreliable)
+	}
+
+	require.Len(t, reliable, 63)
+	for i := 1; i < len(reliable); i++ {
+		require.True(t, sequence.GT(reliable[i], reliable[i-1]))
+	}
+	for i, seq := range reliable {
+		require.Equal(t, []byte{byte(seq >> 8), byte(seq)}, payloads[i])
+	}
+
+	// Resends of packets that were already delivered are not delivered again.
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 3}, buf))
+	require.Len(t, reliable, 63)
+}
+
+func TestConnResources(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	queues := indexBytes * (len(c.wq) + len(c.rq))
+	require.Equal(t, Resources{Conns: 1, Bytes: queues}, c.Resources())
+
+	// Reliable packets hold onto their pooled buffers until they are acked.
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	}
+
+	r := c.Resources()
+	require.Equal(t, 3, r.Buffers)
+	require.Greater(t, r.Bytes, queues)
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 2, ACKBits: 0b111}, nil))
+	require.Equal(t, Resources{Conns: 1, Bytes: queues}, c.Resources())
+
+	// Run takes up a goroutine along with its update ticker.
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run()
+	}()
+
+	require.Eventually(t, func() bool {
+		r := c.Resources()
+		return r.Goroutines == 1 && r.Timers == 1
+	}, 1*time.Second, time.Millisecond)
+
+	c.Close()
+	<-done
+
+	require.Zero(t, c.Resources().Goroutines)
+}
+
+func TestConnFlush(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	require.NoError(t, c.Flush(context.Background()))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, c.Flush(ctx))
+
+	// Flushing waits for all writes to be acked, rather than just the oldest of them.
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.Flush(context.Background()) }()
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 1, ACKBits: 0b11}, nil))
+	select {
+	case err := <-errs:
+		t.Fatalf("flushed with a packet yet to be acked: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.NoError(t, c.Read(PacketHeader{Unordered: true, ACK: 2, ACKBits: 0b1}, nil))
+	require.NoError(t, <-errs)
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+	go func() { errs <- c.Flush(context.Background()) }()
+
+	c.Close()
+	require.Equal(t, io.EOF, <-errs)
+}
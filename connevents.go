@@ -0,0 +1,102 @@
+package reliable
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+type ConnEventType uint8
+
+const (
+	ConnEstablished     ConnEventType = iota // a conn to a peer was created
+	ConnClosed                               // a conn to a peer was closed by us, such as when the endpoint shut down
+	ConnFailed                               // a conn to a peer was closed due to an error
+	ConnRateLimited                          // a conn to a peer exceeded its quota
+	ConnPeerClosed                           // a conn was closed by its peer, with Err being a *CloseError
+	ConnMigrated                             // a conn migrated to a new address of its peer, with Addr being the new address
+	ConnCircuitOpened                        // a conn exceeded its error budget, and stopped writes and resends for a while
+	ConnAuthenticated                        // a conn's peer presented a valid connect token
+	ConnTokenKeyRotated                      // the key a conn's peer's connect token was minted with was rotated out
+	ConnTokenKeyRetired                      // the key a conn's peer's connect token was minted with was retired
+	ConnRefused                              // a conn to a new peer was not created, with Err being ErrResourceLimit
+	ConnEvicted                              // a conn was closed for having been idle for too long
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case ConnEstablished:
+		return "established"
+	case ConnClosed:
+		return "closed"
+	case ConnFailed:
+		return "failed"
+	case ConnRateLimited:
+		return "rate_limited"
+	case ConnPeerClosed:
+		return "peer_closed"
+	case ConnMigrated:
+		return "migrated"
+	case ConnCircuitOpened:
+		return "circuit_opened"
+	case ConnAuthenticated:
+		return "authenticated"
+	case ConnTokenKeyRotated:
+		return "token_key_rotated"
+	case ConnTokenKeyRetired:
+		return "token_key_retired"
+	case ConnRefused:
+		return "refused"
+	case ConnEvicted:
+		return "evicted"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+type ConnEvent struct {
+	Type ConnEventType
+	Addr net.Addr
+	Err  error // error that caused the conn to fail, if any
+	Time time.Time
+}
+
+// Subscribe registers fn to be called with every conn event of this endpoint, returning a function that unregisters
+// it. fn is called synchronously from the goroutine that caused the event, so it must not block.
+func (e *Endpoint) Subscribe(fn func(event ConnEvent)) (unsubscribe func()) {
+	e.smu.Lock()
+	defer e.smu.Unlock()
+
+	id := e.nextSub
+	e.nextSub++
+
+	if e.subs == nil {
+		e.subs = make(map[uint64]func(ConnEvent))
+	}
+	e.subs[id] = fn
+
+	return func() {
+		e.smu.Lock()
+		defer e.smu.Unlock()
+
+		delete(e.subs, id)
+	}
+}
+
+func (e *Endpoint) emit(typ ConnEventType, addr net.Addr, err error) {
+	e.smu.RLock()
+	subs := make([]func(ConnEvent), 0, len(e.subs))
+	for _, fn := range e.subs {
+		subs = append(subs, fn)
+	}
+	e.smu.RUnlock()
+
+	if len(subs) == 0 {
+		return
+	}
+
+	event := ConnEvent{Type: typ, Addr: addr, Err: err, Time: time.Now()}
+	for _, fn := range subs {
+		fn(event)
+	}
+}
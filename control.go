@@ -0,0 +1,151 @@
+package reliable
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lithdew/bytesutil"
+	"io"
+	"net"
+	"time"
+)
+
+// Control packets are unreliable, empty packets that carry a payload. Peers that do not know of control packets
+// only read the acks off of them, as empty packets are never delivered to the packet handler.
+
+type controlType uint8
+
+const (
+	controlClose         controlType = iota // our peer closed its conn, followed by a 16-bit code and a reason
+	controlToken                            // our peer presented a connect token, followed by the token
+	controlAckRanges                        // our peer described which packets it read, followed by an ack range frame
+	controlTimestamp                        // our peer asked for an echo, followed by the time it asked at
+	controlTimestampEcho                    // our peer echoed a timestamp, followed by the timestamp and how long it held it
+	controlChallenge                        // our peer asked to validate our address, followed by a nonce
+	controlChallengeEcho                    // our peer echoed a challenge, followed by its nonce
+	controlAbort                            // our peer gave up on a fragmented payload, followed by its id and last sequence
+)
+
+// MaxCloseReasonSize is the max number of bytes of a close reason sent to our peer. Longer reasons are truncated.
+const MaxCloseReasonSize = 1024
+
+// CloseError is returned by reads from a peer that closed its conn using CloseWithError, carrying the code and
+// reason the peer closed the conn with.
+type CloseError struct {
+	Code   uint16
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if reason := e.DisconnectReason(); reason != DisconnectApplication {
+		return fmt.Sprintf("peer closed conn (reason=%s): %s", reason, e.Reason)
+	}
+	return fmt.Sprintf("peer closed conn (code=%d): %s", e.Code, e.Reason)
+}
+
+// CloseWithError notifies our peer that this conn is being closed along with a code and reason, and then closes
+// this conn. The notification is sent once and unreliably, so our peer may not receive it.
+func (c *Conn) CloseWithError(code uint16, reason string) error {
+	if len(reason) > MaxCloseReasonSize {
+		reason = reason[:MaxCloseReasonSize]
+	}
+
+	buf := make([]byte, 0, 3+len(reason))
+	buf = append(buf, byte(controlClose))
+	buf = bytesutil.AppendUint16BE(buf, code)
+	buf = append(buf, reason...)
+
+	err := c.writeControl(buf)
+	c.Close()
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to write close notification: %w", err)
+	}
+
+	return nil
+}
+
+// writeControl writes a control packet to our peer. Control packets are neither rate limited nor charged against
+// quotas, such that they are never held up by bulk data.
+func (c *Conn) writeControl(buf []byte) error {
+	if !c.enter() {
+		return io.EOF
+	}
+	defer c.leave()
+
+	c.mu.Lock()
+	ack, ackBits := c.nextAckDetails()
+	c.mu.Unlock()
+
+	c.trackAcked(ack)
+
+	return c.write(PacketHeader{ACK: ack, ACKBits: ackBits, Unordered: true, Empty: true}, buf)
+}
+
+// readControl handles a control packet from our peer. Control packets of unknown types are ignored, such that new
+// types may be introduced without breaking older peers.
+func (c *Conn) readControl(buf []byte) error {
+	typ, buf := controlType(buf[0]), buf[1:]
+
+	switch typ {
+	case controlClose:
+		if len(buf) < 2 {
+			return fmt.Errorf("failed to read close notification: %w", io.ErrUnexpectedEOF)
+		}
+
+		err := &CloseError{Code: bytesutil.Uint16BE(buf[:2]), Reason: string(buf[2:])}
+		c.Close()
+
+		return err
+	case controlToken:
+		c.readToken(buf)
+		return nil
+	case controlAckRanges:
+		return c.readAckRanges(buf)
+	case controlTimestamp:
+		return c.readTimestamp(buf, time.Now())
+	case controlTimestampEcho:
+		return c.readTimestampEcho(buf, time.Now())
+	case controlChallenge:
+		return c.readChallenge(buf)
+	case controlChallengeEcho:
+		return c.readChallengeEcho(buf)
+	case controlAbort:
+		return c.readAbort(buf)
+	default:
+		return nil
+	}
+}
+
+// CloseWithError notifies every peer that this endpoint is being closed along with a code and reason, and then
+// closes this endpoint.
+func (e *Endpoint) CloseWithError(code uint16, reason string) error {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.CloseWithError(code, reason); err != nil && e.eh != nil {
+			e.eh(conn.peer(), err)
+		}
+	}
+
+	return e.Close()
+}
+
+// CloseConnWithError notifies the peer at addr that its conn is being closed along with a code and reason, and then
+// closes the conn. It does nothing should there be no conn to addr.
+func (e *Endpoint) CloseConnWithError(addr net.Addr, code uint16, reason string) error {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return nil
+	}
+
+	err := conn.CloseWithError(code, reason)
+	e.clearConn(conn, nil)
+
+	return err
+}
@@ -0,0 +1,65 @@
+package reliable
+
+import "time"
+
+// SetWriteDeadline sets the deadline for writes to our peer, after which pending and future writes fail with
+// os.ErrDeadlineExceeded rather than waiting for their turn, such as while our peer's read buffer is full. Writes
+// that fail are not assigned a sequence number. A deadline may be extended past its expiry for writes to resume, and
+// a zero t means writes do not time out.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writeTimer != nil {
+		c.writeTimer.Stop()
+		c.writeTimer = nil
+	}
+
+	c.writeDeadline++
+	c.writeExpired = false
+
+	if t.IsZero() {
+		return nil
+	}
+
+	d := time.Until(t)
+	if d <= 0 {
+		c.expireWrites()
+		return nil
+	}
+
+	deadline := c.writeDeadline
+
+	c.writeTimer = time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.writeDeadline == deadline {
+			c.expireWrites()
+		}
+	})
+
+	return nil
+}
+
+// expireWrites fails all writes waiting for their turn. It must be called with c.mu held.
+func (c *Conn) expireWrites() {
+	c.writeExpired = true
+	c.ouc.Broadcast()
+}
+
+func (c *Conn) writeDeadlineExceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writeExpired
+}
+
+func (c *Conn) stopWriteTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writeTimer != nil {
+		c.writeTimer.Stop()
+	}
+}
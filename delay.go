@@ -0,0 +1,118 @@
+package reliable
+
+import "sync"
+
+// heldPacket is a packet read from our peer that is held back from being delivered until a release tick.
+type heldPacket struct {
+	release uint64
+	header  PacketHeader
+	buf     *Buffer
+}
+
+// heldPackets holds back packets read from our peer until the application advances a number of ticks.
+type heldPackets struct {
+	mu      sync.Mutex
+	delay   uint64       // number of ticks packets are held back for
+	tick    uint64       // current tick
+	packets []heldPacket // packets held back in the order they were read
+}
+
+// deliver hands a packet read from our peer to its packet handler, holding back reliable packets until all reliable
+// packets sent before them were delivered should delivery be ordered.
+func (c *Conn) deliver(header PacketHeader, buf []byte) {
+	if c.ordered != nil && !header.Unordered {
+		c.order(orderedPacket{header: header, buf: buf})
+		return
+	}
+	c.deliverNow(header, buf)
+}
+
+// deliverNow hands a packet read from our peer to its packet handler, or holds it back until a release tick should
+// delivery be delayed.
+func (c *Conn) deliverNow(header PacketHeader, buf []byte) {
+	ph := c.handlerFor(header)
+	if ph == nil {
+		return
+	}
+
+	if c.held == nil {
+		ph(c.peer(), header.Sequence, c.provide(buf))
+		return
+	}
+
+	b := c.pool.Get(len(buf))
+	if b == nil {
+		c.mu.Lock()
+		c.stats.HeldDrops++
+		c.mu.Unlock()
+		return
+	}
+	b.B = append(b.B[:0], buf...)
+
+	c.held.mu.Lock()
+	c.held.packets = append(c.held.packets, heldPacket{release: c.held.tick + c.held.delay, header: header, buf: b})
+	c.held.mu.Unlock()
+}
+
+// Tick advances this conn by a single tick, delivering all packets held back by WithDeliveryDelay that are due for
+// release to their packet handlers in the order they were read. Tick does nothing if delivery is not delayed.
+func (c *Conn) Tick() {
+	if c.held == nil {
+		return
+	}
+
+	c.held.mu.Lock()
+	c.held.tick++
+
+	n := 0
+	for n < len(c.held.packets) && c.held.packets[n].release <= c.held.tick {
+		n++
+	}
+
+	due := make([]heldPacket, n)
+	copy(due, c.held.packets)
+
+	rest := copy(c.held.packets, c.held.packets[n:])
+	for i := rest; i < len(c.held.packets); i++ {
+		c.held.packets[i] = heldPacket{}
+	}
+	c.held.packets = c.held.packets[:rest]
+	c.held.mu.Unlock()
+
+	for _, p := range due {
+		if ph := c.handlerFor(p.header); ph != nil {
+			ph(c.peer(), p.header.Sequence, c.provide(p.buf.B))
+		}
+		c.pool.Put(p.buf)
+	}
+}
+
+// dropHeld drops all packets held back from being delivered.
+func (c *Conn) dropHeld() {
+	if c.held == nil {
+		return
+	}
+
+	c.held.mu.Lock()
+	defer c.held.mu.Unlock()
+
+	for i, p := range c.held.packets {
+		c.pool.Put(p.buf)
+		c.held.packets[i] = heldPacket{}
+	}
+	c.held.packets = c.held.packets[:0]
+}
+
+// Tick advances all conns of this endpoint by a single tick. See Conn.Tick.
+func (e *Endpoint) Tick() {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Tick()
+	}
+}
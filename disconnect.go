@@ -0,0 +1,56 @@
+package reliable
+
+import "fmt"
+
+// DisconnectReason is why a conn was closed. Reasons other than DisconnectApplication are sent to our peer in close
+// notifications as close codes reserved for this library, such that our peer may tell being kicked apart from the
+// network dying.
+type DisconnectReason uint8
+
+const (
+	DisconnectApplication       DisconnectReason = iota // closed by the application using CloseWithError
+	DisconnectIdleTimeout                               // nothing was heard from the peer for too long
+	DisconnectMaxRetries                                // packets went unacked after being resent too many times
+	DisconnectProtocolViolation                         // the peer sent packets that were malformed
+	DisconnectEvicted                                   // evicted to make room for other peers
+	DisconnectMigrated                                  // the peer moved over to a different address
+	DisconnectErrorBudget                               // too many writes failed or too many anomalies were caused
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectApplication:
+		return "application"
+	case DisconnectIdleTimeout:
+		return "idle_timeout"
+	case DisconnectMaxRetries:
+		return "max_retries"
+	case DisconnectProtocolViolation:
+		return "protocol_violation"
+	case DisconnectEvicted:
+		return "evicted"
+	case DisconnectMigrated:
+		return "migrated"
+	case DisconnectErrorBudget:
+		return "error_budget"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(r))
+	}
+}
+
+// CloseCodeReserved is the first of the close codes reserved for disconnect reasons. Applications should only close
+// conns using codes below it.
+const CloseCodeReserved uint16 = 0xFF00
+
+// DisconnectReason returns why our peer closed its conn.
+func (e *CloseError) DisconnectReason() DisconnectReason {
+	if e.Code < CloseCodeReserved {
+		return DisconnectApplication
+	}
+	return DisconnectReason(e.Code - CloseCodeReserved)
+}
+
+// disconnect notifies our peer that this conn is being closed for the given reason, and then closes this conn.
+func (c *Conn) disconnect(reason DisconnectReason, text string) error {
+	return c.CloseWithError(CloseCodeReserved+uint16(reason), text)
+}
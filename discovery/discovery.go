@@ -0,0 +1,100 @@
+// Package discovery announces and discovers peers on a LAN using small announce packets sent over UDP broadcast or
+// multicast. Discovered peers may then be written to using a reliable.Endpoint.
+//
+// Announce packets are unreliable packets whose payload is prefixed with a magic string, such that other packets
+// read off of the same broadcast or multicast address are ignored. Discovery should use its own socket, as
+// announce packets read by a reliable.Endpoint would be delivered to its packet handler.
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"math"
+	"net"
+	"time"
+)
+
+// Magic prefixes the payload of every announce packet.
+const Magic = "reliable/discovery/1"
+
+// MaxDataSize is the max number of bytes of data that may be announced, keeping announce packets within a single
+// datagram on any network.
+const MaxDataSize = 512
+
+// ErrDataTooLarge is returned when announcing data that is larger than MaxDataSize.
+var ErrDataTooLarge = errors.New("announced data too large")
+
+// Announcement is data announced by a peer.
+type Announcement struct {
+	Addr net.Addr // address the announce packet was sent from
+	Data []byte   // data announced by the peer
+}
+
+var pool reliable.Pool
+
+// Broadcast returns the IPv4 limited broadcast address at port.
+func Broadcast(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+}
+
+// Announce writes a single announce packet carrying data to dst, which is usually a broadcast or multicast address.
+func Announce(conn net.PacketConn, dst net.Addr, data []byte) error {
+	if len(data) > MaxDataSize {
+		return ErrDataTooLarge
+	}
+
+	b := pool.Get()
+	defer pool.Put(b)
+
+	b.B = reliable.PacketHeader{Unordered: true}.AppendTo(b.B)
+	b.B = append(b.B, Magic...)
+	b.B = append(b.B, data...)
+
+	if _, err := conn.WriteTo(b.B, dst); err != nil {
+		return fmt.Errorf("failed to write announce packet: %w", err)
+	}
+
+	return nil
+}
+
+// AnnounceEvery writes an announce packet carrying data to dst every interval until exit is closed, returning the
+// first error that occurs.
+func AnnounceEvery(conn net.PacketConn, dst net.Addr, data []byte, interval time.Duration, exit <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := Announce(conn, dst, data); err != nil {
+			return err
+		}
+
+		select {
+		case <-exit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discover reads announce packets from conn, calling fn with every announcement read until conn fails to be read
+// from, such as when it gets closed. Packets that are not announce packets are ignored. The data of an announcement
+// is only valid until fn returns.
+func Discover(conn net.PacketConn, fn func(announcement Announcement)) error {
+	buf := make([]byte, math.MaxUint16+1)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("failed to read announce packet: %w", err)
+		}
+
+		header, payload, err := reliable.UnmarshalPacketHeader(buf[:n])
+		if err != nil || !header.Unordered || header.Empty || !bytes.HasPrefix(payload, []byte(Magic)) {
+			continue
+		}
+
+		fn(Announcement{Addr: addr, Data: payload[len(Magic):]})
+	}
+}
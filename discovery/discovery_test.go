@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"github.com/lithdew/reliable"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+	defer ca.Close()
+
+	announcements := make(chan Announcement, 16)
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- Discover(cb, func(announcement Announcement) {
+			announcement.Data = append([]byte(nil), announcement.Data...)
+			announcements <- announcement
+		})
+	}()
+
+	// Packets that are not announce packets are ignored.
+
+	_, err := ca.WriteTo(reliable.PacketHeader{Unordered: true}.AppendTo([]byte(nil)), cb.LocalAddr())
+	require.NoError(t, err)
+
+	_, err = ca.WriteTo([]byte("garbage"), cb.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, Announce(ca, cb.LocalAddr(), []byte("game server")))
+
+	select {
+	case announcement := <-announcements:
+		require.Equal(t, ca.LocalAddr(), announcement.Addr)
+		require.Equal(t, []byte("game server"), announcement.Data)
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for announcement")
+	}
+
+	exit := make(chan struct{})
+	close(exit)
+	require.NoError(t, AnnounceEvery(ca, cb.LocalAddr(), nil, time.Hour, exit))
+	require.Empty(t, (<-announcements).Data)
+
+	require.Equal(t, ErrDataTooLarge, Announce(ca, cb.LocalAddr(), make([]byte, MaxDataSize+1)))
+
+	require.NoError(t, cb.Close())
+	require.Error(t, <-errs)
+	require.Empty(t, announcements)
+}
+
+func TestBroadcast(t *testing.T) {
+	require.Equal(t, &net.UDPAddr{IP: net.IPv4bcast, Port: 4444}, Broadcast(4444))
+}
@@ -1,8 +1,12 @@
 package reliable
 
 import (
-	"io"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/ipv4"
 	"math"
+	"math/rand"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -12,32 +16,157 @@ import (
 type PacketHandler func(addr net.Addr, seq uint16, buf []byte)
 type ErrorHandler func(addr net.Addr, err error)
 
+// AckHandler is called once for every reliable packet written to a peer the first time the peer acks it, with the
+// sequence number the packet was written with. It is called outside of the mutex of the conn, such that it may call
+// back into the conn.
+type AckHandler func(addr net.Addr, seq uint16)
+
+// BufferProvider returns application-owned memory with capacity for at least size bytes, which a payload of size bytes
+// is copied into before being delivered to a packet handler. The payload then outlives the handler.
+type BufferProvider func(size int) []byte
+
+// BatchPacketHandler is called once with all packets delivered from a peer out of a single batch of datagrams read
+// by an endpoint, in the order they were read. The payloads of packets are only valid until the handler returns, unless
+// a buffer provider is set.
+type BatchPacketHandler func(addr net.Addr, packets []Packet)
+
+type Packet struct {
+	Seq      uint16
+	Number   uint64 // 64-bit logical number of a reliable packet, which unlike Seq does not wrap around
+	Reliable bool
+	Buf      []byte
+}
+
 type Endpoint struct {
 	writeBufferSize uint16 // write buffer size that must be a divisor of 65536
 	readBufferSize  uint16 // read buffer size that must be a divisor of 65536
 
-	updatePeriod  time.Duration // how often time-dependant parts of the protocol get checked
-	resendTimeout time.Duration // how long we wait until unacked packets should be resent
+	reorderTolerance uint16 // number of sequence numbers up to that of the newest packet read still accepted, if set
+
+	updatePeriod     time.Duration // how often time-dependant parts of the protocol get checked
+	resendTimeout    time.Duration // how long we wait until unacked packets should be resent, or zero if derived from rtt
+	minResendTimeout time.Duration // lower bound of resend timeouts derived from rtt, or zero if the default
+	maxResends       byte          // max number of times an unacked packet is resent before its conn fails, if set
+	resendPacing     int           // max number of unacked packets resent to each peer per update, or zero if unlimited
+
+	keepAlivePeriod   time.Duration // how long nothing may be written to a peer before a keepalive is, or zero if disabled
+	keepAliveMax      time.Duration // longest keepalive period probed for should it be adaptive, or zero if it is fixed
+	inactivityTimeout time.Duration // how long nothing may be read from a peer before its conn fails, or zero if disabled
+
+	badRTT      time.Duration // rtt above which conditions are bad, or zero if bad mode is disabled
+	badRecovery time.Duration // how long conditions must stay good to leave bad mode, or zero if the default
+
+	readBatchSize int // max number of datagrams read from the socket at once
+	readWorkers   int // number of goroutines processing datagrams read from the socket
+	readQueueSize int // max number of datagrams queued up for a single conn before further datagrams are dropped
+
+	readOrdering ReadOrdering // whether or not datagrams from a single peer are processed in the order they were read
+
+	lockThreads bool         // whether or not goroutines of the read loop are locked to their own os threads
+	pin         ThreadPinner // called from each goroutine of the read loop once locked to its os thread if set
+	meters      []loopMeter  // utilization of the reader followed by each read worker
+
+	sched Scheduler // decides the order in which unacked packets are resent to each peer
+
+	eventLogSize int // number of recent protocol events kept per conn, or zero if they should not be kept
+
+	sentHistorySize int // number of recently written reliable packets kept records of per conn, or zero if none
+
+	amplification int // max multiple of bytes received that may be sent to unvalidated peers, or zero if unlimited
+
+	initialWindowSize uint16 // max number of packets in flight to a fresh peer before any of them are acked
+
+	ackPolicy AckPolicy // decides when standalone acks are written to each peer
+
+	ackRanges bool // whether or not ack range frames are written to each peer
+
+	passiveRTTPeriod time.Duration // how long round-trip times to each peer may go unsampled before timestamps are written
+
+	unsequencedAcks bool // whether or not standalone acks are written to each peer without consuming sequence numbers
+
+	quota *Quota // bounds payload bytes written to and read from each peer if set
+
+	fragmentSize int               // size of the fragments larger payloads are split into, or zero if never
+	reassembly   *ReassemblyLimits // bounds on fragments read from each peer of payloads yet to be reassembled if set
+	watchdog     *progressFloor    // min throughput of fragmented payloads written to and read from each peer if set
+
+	errorBudget *ErrorBudget // bounds transmit errors and protocol anomalies per interval of each peer if set
+
+	keyring *Keyring // verifies connect tokens presented by peers if set
+
+	rateLimit *RateLimit   // rate limit on payload bytes written to each peer if set
+	limiter   *tokenBucket // rate limit on payload bytes written to all peers combined if set
+
+	interleave *interleaveRatio // turns taken between resends and fresh writes to each peer if set
+
+	tunePlatform bool // whether or not os-specific fixes should be applied to the socket
+
+	ttl int // ip ttl or hop limit of datagrams written to the socket, or zero to leave it as is
+
+	pinning bool // whether or not datagrams are written to each peer from the local address it last targeted
+
+	ackDelay time.Duration // max amount of time standalone acks are held back to be written out in batches
+
+	ackSuppression time.Duration // how long Conn.ExpectWriteSoon holds back standalone acks for
+
+	deliveryDelay int // number of ticks received packets are held back for before being delivered, or zero if not held
+
+	orderedDelivery bool // whether or not reliable packets are delivered strictly in the order they were sent
 
 	mu sync.Mutex
 	wg sync.WaitGroup
 
-	pool *Pool
+	probeResponder bool // whether or not probes from peers are answered
+
+	pmu       sync.Mutex                 // mutex over probes awaiting a response
+	probes    map[uint32]chan probeReply // probes awaiting a response by id
+	nextProbe uint32                     // id of the next probe
+
+	smu     sync.RWMutex               // mutex over subscribers to conn events
+	subs    map[uint64]func(ConnEvent) // subscribers to conn events
+	nextSub uint64                     // id of the next subscriber to conn events
+
+	rs   readScheduler // schedules conns with datagrams queued up to be processed by workers
+	ab   *ackBatcher   // batches up standalone acks written by conns if ackDelay is set
+	ws   writeStats    // latency of write syscalls made by all conns and the ack batcher
+	taps tapSet        // read-only observers of all conns
+
+	pool BufferPool
+
+	ph   PacketHandler
+	rph  PacketHandler      // handles reliable packets in place of ph if set
+	uph  PacketHandler      // handles unreliable packets in place of ph if set
+	bph  BatchPacketHandler // handles batches of packets in place of ph, rph, and uph if set
+	rawh RawPacketHandler   // handles raw datagrams, which are otherwise treated as packets, if set
+	eh   ErrorHandler
+	ah   AckHandler // called once for every reliable packet acked by a peer if set
+
+	provider BufferProvider // provides memory payloads are delivered in if set
 
-	ph PacketHandler
-	eh ErrorHandler
+	rand *lockedRand // source of all randomness used by the protocol, shared by all conns
+
+	keyer Keyer // maps peers onto the keys of their conns
 
 	addr  net.Addr
 	conn  net.PacketConn
 	conns map[string]*Conn
 
-	closing uint32
+	closing  uint32
+	draining uint32 // whether or not this endpoint is shutting down, having its conns drain their unacked packets
+
+	resourceLimits *ResourceLimits // bounds what this endpoint and all of its conns hold onto if set
+	held           Resources       // what all conns were last counted as holding onto, guarded by mu
+	goroutines     int32           // number of goroutines running on behalf of this endpoint, not counting its conns
+
+	connIdleTimeout time.Duration // how long conns may go without writing or reading a packet before being evicted
+	evictions       uint64        // number of conns evicted for being idle
 }
 
 func NewEndpoint(conn net.PacketConn, opts ...EndpointOption) *Endpoint {
 	e := &Endpoint{conn: conn, addr: conn.LocalAddr(), conns: make(map[string]*Conn)}
 
-	for _, opt := range opts {
+	for i, opt := range opts {
+		checkProfile(i, opt)
 		opt.applyEndpoint(e)
 	}
 
@@ -49,44 +178,256 @@ func NewEndpoint(conn net.PacketConn, opts ...EndpointOption) *Endpoint {
 		e.readBufferSize = DefaultReadBufferSize
 	}
 
-	if e.resendTimeout == 0 {
-		e.resendTimeout = DefaultResendTimeout
+	if e.reorderTolerance != 0 {
+		checkReorderTolerance(e.reorderTolerance, e.readBufferSize)
 	}
 
 	if e.updatePeriod == 0 {
 		e.updatePeriod = DefaultUpdatePeriod
 	}
 
+	if e.keyer == nil {
+		e.keyer = AddrKeyer{}
+	}
+
+	if e.rand == nil {
+		e.rand = newLockedRand(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	if e.readBatchSize == 0 {
+		e.readBatchSize = DefaultReadBatchSize
+	}
+
+	if e.readWorkers == 0 {
+		e.readWorkers = DefaultReadWorkers
+	}
+
+	e.meters = make([]loopMeter, e.readWorkers+1)
+
+	if e.readQueueSize == 0 {
+		e.readQueueSize = DefaultReadQueueSize
+	}
+
 	if e.pool == nil {
-		e.pool = new(Pool)
+		e.pool = NewBufferPool(new(Pool))
+	}
+	checkFragmentSize(e.pool, e.fragmentSize)
+
+	if e.ackDelay > 0 {
+		checkAckDelay(e.ackDelay, e.updatePeriod)
+		e.ab = newAckBatcher(e.conn, e.pool, e.eh, e.ws.add)
+	}
+
+	if e.ttl > 0 {
+		if err := setTTL(e.conn, e.ttl); err != nil && e.eh != nil {
+			e.eh(e.addr, err)
+		}
 	}
 
+	if e.pinning {
+		if err := enableSourcePinning(e.conn); err != nil {
+			e.pinning = false
+			if e.eh != nil {
+				e.eh(e.addr, err)
+			}
+		}
+	}
+
+	if e.tunePlatform {
+		if err := tunePlatform(e.conn); err != nil && e.eh != nil {
+			e.eh(e.addr, err)
+		}
+	}
+
+	e.rs.cond.L = &e.rs.mu
+
 	return e
 }
 
-func (e *Endpoint) getConn(addr net.Addr) *Conn {
-	id := addr.String()
+// getConn returns the conn to addr, creating it should it not exist. buf is the datagram read from addr should the
+// conn be looked up as a result of receiving a packet from addr, in which case addr is yet to be validated. The conn
+// returned is kept from being evicted until the packet it was gotten for is read or written and unref is called.
+func (e *Endpoint) getConn(addr net.Addr, buf []byte) *Conn {
+	inbound := buf != nil
+
+	id := e.keyer.Key(addr, buf)
+
+	conn, created := e.findOrCreateConn(addr, id, inbound)
+	switch {
+	case conn == nil && !e.drainingOrClosing():
+		e.emit(ConnRefused, addr, ErrResourceLimit)
+	case created:
+		e.emit(ConnEstablished, addr, nil)
+	case conn != nil && inbound && conn.migrate(addr):
+		e.emit(ConnMigrated, addr, nil)
+	}
+	return conn
+}
 
+func (e *Endpoint) findOrCreateConn(addr net.Addr, id string, inbound bool) (conn *Conn, created bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	conn := e.conns[id]
+	conn = e.conns[id]
 	if conn == nil {
-		if atomic.LoadUint32(&e.closing) == 1 {
-			return nil
+		if e.drainingOrClosing() || !e.admitConn() {
+			return nil, false
 		}
 
-		conn = NewConn(
-			e.conn,
-			addr,
+		opts := []ConnOption{
 			WithWriteBufferSize(e.writeBufferSize),
 			WithReadBufferSize(e.readBufferSize),
 			WithUpdatePeriod(e.updatePeriod),
-			WithResendTimeout(e.resendTimeout),
 			WithBufferPool(e.pool),
 			WithPacketHandler(e.ph),
+			WithReliablePacketHandler(e.rph),
+			WithUnreliablePacketHandler(e.uph),
 			WithErrorHandler(e.eh),
-		)
+			WithAckHandler(e.ah),
+			WithBufferProvider(e.provider),
+			WithScheduler(e.sched),
+			WithAckPolicy(e.ackPolicy),
+			withAckBatcher{ab: e.ab},
+			withRand{rand: e.rand},
+			withWriteStats{ws: &e.ws},
+			withTaps{taps: &e.taps},
+			withQuotaExceededHook{fn: func() { e.emit(ConnRateLimited, conn.peer(), nil) }},
+		}
+
+		if e.fragmentSize != 0 {
+			opts = append(opts, WithFragmentSize(e.fragmentSize))
+		}
+
+		if e.reassembly != nil {
+			opts = append(opts, WithReassemblyLimits(*e.reassembly))
+		}
+
+		if e.watchdog != nil {
+			opts = append(opts, WithProgressWatchdog(e.watchdog.rate, e.watchdog.grace))
+		}
+
+		if e.quota != nil {
+			opts = append(opts, WithQuota(*e.quota))
+		}
+
+		if e.errorBudget != nil {
+			opts = append(opts, WithErrorBudget(*e.errorBudget), withBreakerHook{fn: func(action BreakerAction) {
+				e.tripped(conn, action)
+			}})
+		}
+
+		if e.resendTimeout != 0 {
+			opts = append(opts, WithResendTimeout(e.resendTimeout))
+		}
+
+		if e.minResendTimeout != 0 {
+			opts = append(opts, WithMinResendTimeout(e.minResendTimeout))
+		}
+
+		if e.badRTT != 0 {
+			opts = append(opts, WithCongestionThresholdRTT(e.badRTT))
+		}
+
+		if e.badRecovery != 0 {
+			opts = append(opts, WithBadModeRecoveryTime(e.badRecovery))
+		}
+
+		if e.resendPacing != 0 {
+			opts = append(opts, WithResendPacing(e.resendPacing))
+		}
+
+		if e.keepAliveMax != 0 {
+			opts = append(opts, WithAdaptiveKeepAlive(e.keepAlivePeriod, e.keepAliveMax))
+		} else if e.keepAlivePeriod != 0 {
+			opts = append(opts, WithKeepAlivePeriod(e.keepAlivePeriod))
+		}
+
+		if e.inactivityTimeout != 0 {
+			opts = append(opts, WithInactivityTimeout(e.inactivityTimeout), withInactivityHook{fn: func() {
+				e.inactive(conn)
+			}})
+		}
+
+		if e.maxResends != 0 {
+			opts = append(opts, withMaxPacketResends{maxResends: e.maxResends}, withResendsHook{fn: func() {
+				e.exhausted(conn)
+			}})
+		}
+
+		if e.rateLimit != nil {
+			opts = append(opts, withRateLimit{limit: *e.rateLimit})
+		}
+
+		if e.limiter != nil {
+			opts = append(opts, withSharedRateLimit{limiter: e.limiter})
+		}
+
+		if e.interleave != nil {
+			opts = append(opts, withRetransmitInterleave{ratio: *e.interleave})
+		}
+
+		if e.ackRanges {
+			opts = append(opts, WithAckRanges())
+		}
+
+		if e.unsequencedAcks {
+			opts = append(opts, WithUnsequencedAcks())
+		}
+
+		if e.passiveRTTPeriod != 0 {
+			opts = append(opts, WithPassiveRTT(e.passiveRTTPeriod))
+		}
+
+		if e.keyring != nil {
+			opts = append(opts, WithKeyring(e.keyring), withTokenHook{fn: func(ConnectToken) {
+				e.emit(ConnAuthenticated, conn.peer(), nil)
+			}}, withTokenKeyHook{fn: func(_ uint32, retired bool) {
+				if retired {
+					e.emit(ConnTokenKeyRetired, conn.peer(), ErrTokenUnknownKey)
+					return
+				}
+				e.emit(ConnTokenKeyRotated, conn.peer(), nil)
+			}})
+		}
+
+		if e.ackSuppression != 0 {
+			opts = append(opts, WithAckSuppressionWindow(e.ackSuppression))
+		}
+
+		if e.deliveryDelay > 0 {
+			opts = append(opts, WithDeliveryDelay(e.deliveryDelay))
+		}
+
+		if e.orderedDelivery && e.bph == nil {
+			opts = append(opts, WithOrderedDelivery())
+		}
+
+		if e.reorderTolerance != 0 {
+			opts = append(opts, WithReorderTolerance(e.reorderTolerance))
+		}
+
+		if e.initialWindowSize != 0 {
+			opts = append(opts, withInitialWindowSize{initialWindowSize: e.initialWindowSize})
+		}
+
+		if inbound && e.amplification > 0 {
+			opts = append(opts, WithAmplificationLimit(e.amplification))
+		}
+
+		if e.eventLogSize > 0 {
+			opts = append(opts, WithEventLogSize(e.eventLogSize))
+		}
+
+		if e.sentHistorySize > 0 {
+			opts = append(opts, WithSentHistorySize(e.sentHistorySize))
+		}
+
+		if e.resourceLimits != nil {
+			opts = append(opts, withUpdateHook{fn: func() { e.countConn(conn) }})
+		}
+
+		conn = NewConn(e.conn, addr, opts...)
+		conn.key = id
 
 		e.wg.Add(1)
 		go func() {
@@ -94,35 +435,133 @@ func (e *Endpoint) getConn(addr net.Addr) *Conn {
 			conn.Run()
 		}()
 
-		e.conns[id] = conn
+		e.addConn(conn)
+		created = true
 	}
 
-	return conn
+	conn.ref()
+
+	return conn, created
 }
 
-func (e *Endpoint) clearConn(addr net.Addr) {
-	id := addr.String()
+// tripped emits that the circuit breaker of conn tripped, disconnecting and clearing conn should action say so.
+func (e *Endpoint) tripped(conn *Conn, action BreakerAction) {
+	e.emit(ConnCircuitOpened, conn.peer(), ErrErrorBudgetExceeded)
+
+	if action != BreakerDisconnect {
+		return
+	}
+
+	// The breaker trips from within reads and writes of conn, which closing conn waits on.
+
+	go func() {
+		conn.disconnectTripped()
+		e.clearConn(conn, ErrErrorBudgetExceeded)
+	}()
+}
+
+// exhausted disconnects and clears conn, which gave up on resending an unacked packet to its peer.
+func (e *Endpoint) exhausted(conn *Conn) {
+	// Resends are exhausted from within Run of conn, which closing conn waits on.
+
+	go func() {
+		conn.disconnectExhausted()
+		e.clearConn(conn, ErrTooManyRetransmits)
+	}()
+}
 
+// inactive disconnects and clears conn, whose peer was not heard from for the inactivity timeout.
+func (e *Endpoint) inactive(conn *Conn) {
+	// Inactivity is checked for from within Run of conn, which closing conn waits on.
+
+	go func() {
+		conn.disconnectInactive()
+		e.clearConn(conn, ErrPeerTimeout)
+	}()
+}
+
+func (e *Endpoint) clearConn(conn *Conn, err error) {
 	e.mu.Lock()
-	conn := e.conns[id]
-	delete(e.conns, id)
+	cleared := e.conns[conn.key] == conn
+	if cleared {
+		e.removeConn(conn)
+	}
 	e.mu.Unlock()
 
 	conn.Close()
+
+	if !cleared {
+		return
+	}
+
+	var closeErr *CloseError
+
+	switch {
+	case err == nil:
+		e.emit(ConnClosed, conn.peer(), nil)
+	case errors.As(err, &closeErr):
+		e.emit(ConnPeerClosed, conn.peer(), closeErr)
+	default:
+		e.emit(ConnFailed, conn.peer(), err)
+	}
 }
 
 func (e *Endpoint) clearConns() {
 	e.mu.Lock()
 	conns := make([]*Conn, 0, len(e.conns))
-	for id, conn := range e.conns {
+	for _, conn := range e.conns {
 		conns = append(conns, conn)
-		delete(e.conns, id)
+		e.removeConn(conn)
 	}
 	e.mu.Unlock()
 
 	for _, conn := range conns {
 		conn.Close()
+		e.emit(ConnClosed, conn.peer(), nil)
+	}
+}
+
+// Stats returns a snapshot of statistics of the conn to addr, reporting false should there be no conn to addr.
+func (e *Endpoint) Stats(addr net.Addr) (ConnStats, bool) {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return ConnStats{}, false
+	}
+	return conn.Stats(), true
+}
+
+// AllStats returns the stats of every conn of this endpoint, keyed by the address of its peer.
+func (e *Endpoint) AllStats() map[string]ConnStats {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	stats := make(map[string]ConnStats, len(conns))
+	for _, conn := range conns {
+		stats[conn.peer().String()] = conn.Stats()
+	}
+	return stats
+}
+
+// Events returns the most recent protocol events of the conn to addr from oldest to newest, or nil should there be
+// no conn to addr or should event logs not be enabled.
+func (e *Endpoint) Events(addr net.Addr) []Event {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return nil
 	}
+	return conn.Events()
+}
+
+// WriteStats returns how long write syscalls made to the socket took, across all conns that are and have been
+// associated to this endpoint.
+func (e *Endpoint) WriteStats() WriteStats {
+	return e.ws.snapshot()
 }
 
 func (e *Endpoint) Addr() net.Addr {
@@ -130,21 +569,45 @@ func (e *Endpoint) Addr() net.Addr {
 }
 
 func (e *Endpoint) WriteReliablePacket(buf []byte, addr net.Addr) error {
-	conn := e.getConn(addr)
+	conn := e.getConn(addr, nil)
 	if conn == nil {
-		return io.EOF
+		return e.errNoConn()
 	}
+	defer conn.unref()
+
 	return conn.WriteReliablePacket(buf)
 }
 
+func (e *Endpoint) WriteReliablePacketContext(ctx context.Context, buf []byte, addr net.Addr) error {
+	conn := e.getConn(addr, nil)
+	if conn == nil {
+		return e.errNoConn()
+	}
+	defer conn.unref()
+
+	return conn.WriteReliablePacketContext(ctx, buf)
+}
+
 func (e *Endpoint) WriteUnreliablePacket(buf []byte, addr net.Addr) error {
-	conn := e.getConn(addr)
+	conn := e.getConn(addr, nil)
 	if conn == nil {
-		return io.EOF
+		return e.errNoConn()
 	}
+	defer conn.unref()
+
 	return conn.WriteUnreliablePacket(buf)
 }
 
+func (e *Endpoint) WriteReliablePacketBudget(buf []byte, addr net.Addr, maxLatency time.Duration) error {
+	conn := e.getConn(addr, nil)
+	if conn == nil {
+		return e.errNoConn()
+	}
+	defer conn.unref()
+
+	return conn.WriteReliablePacketBudget(buf, maxLatency)
+}
+
 func (e *Endpoint) Listen() {
 	e.mu.Lock()
 	e.wg.Add(1)
@@ -152,34 +615,300 @@ func (e *Endpoint) Listen() {
 
 	defer e.wg.Done()
 
-	var (
-		n    int
-		addr net.Addr
-		err  error
-	)
+	defer e.pinThread(ReadLoopReader)()
+	e.meters[0].started()
+
+	var workers sync.WaitGroup
+	workers.Add(e.readWorkers)
+
+	atomic.AddInt32(&e.goroutines, 1)
+	defer atomic.AddInt32(&e.goroutines, -1)
+
+	for i := 0; i < e.readWorkers; i++ {
+		atomic.AddInt32(&e.goroutines, 1)
+		go func(i int) {
+			defer atomic.AddInt32(&e.goroutines, -1)
+			defer workers.Done()
+			defer e.pinThread(i)()
+			e.work(&e.meters[i+1])
+		}(i)
+	}
+
+	var flusher sync.WaitGroup
+
+	exit := make(chan struct{})
+
+	if e.ab != nil {
+		flusher.Add(1)
+		atomic.AddInt32(&e.goroutines, 1)
+		go func() {
+			defer atomic.AddInt32(&e.goroutines, -1)
+			defer flusher.Done()
+			e.flushAcks(exit)
+		}()
+	}
+
+	if e.connIdleTimeout > 0 {
+		flusher.Add(1)
+		atomic.AddInt32(&e.goroutines, 1)
+		go func() {
+			defer atomic.AddInt32(&e.goroutines, -1)
+			defer flusher.Done()
+			e.sweepIdleConns(exit)
+		}()
+	}
+
+	conn, ok := e.conn.(*net.UDPConn)
+	switch {
+	case ok && e.readBatchSize > 1:
+		e.readBatches(ipv4.NewPacketConn(conn))
+	case ok && e.pinning:
+		e.readPinned(conn)
+	default:
+		e.read()
+	}
+
+	e.rs.close()
+	workers.Wait()
 
+	// Flush acks written by workers while they were draining out their queues.
+
+	close(exit)
+	flusher.Wait()
+
+	e.clearConns()
+}
+
+func (e *Endpoint) read() {
 	buf := make([]byte, math.MaxUint16+1)
 	for {
-		n, addr, err = e.conn.ReadFrom(buf)
+		n, addr, err := e.conn.ReadFrom(buf)
 		if err != nil {
-			break
+			return
 		}
 
-		conn := e.getConn(addr)
-		if conn == nil {
-			break
+		began := time.Now()
+		if !e.dispatch(addr, buf[:n], nil) {
+			return
 		}
+		e.meters[0].track(began)
+	}
+}
 
-		header, buf, err := UnmarshalPacketHeader(buf[:n])
-		if err == nil {
-			err = conn.Read(header, buf)
+// readPinned reads datagrams along with the local addresses they were sent to, such that the source address of
+// datagrams written to each peer may be pinned.
+func (e *Endpoint) readPinned(conn *net.UDPConn) {
+	buf := make([]byte, math.MaxUint16+1)
+	oob := newDstBuffer(e.addr)
+	for {
+		n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			return
+		}
+
+		began := time.Now()
+		if !e.dispatch(addr, buf[:n], parseDst(e.addr, oob[:oobn])) {
+			return
+		}
+		e.meters[0].track(began)
+	}
+}
+
+func (e *Endpoint) readBatches(conn *ipv4.PacketConn) {
+	msgs := make([]ipv4.Message, e.readBatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, math.MaxUint16+1)}
+		if e.pinning {
+			msgs[i].OOB = newDstBuffer(e.addr)
 		}
+	}
+
+	for {
+		n, err := conn.ReadBatch(msgs, 0)
 		if err != nil {
-			e.clearConn(addr)
+			return
 		}
+
+		began := time.Now()
+		for i := 0; i < n; i++ {
+			dst := parseDst(e.addr, msgs[i].OOB[:msgs[i].NN])
+			if !e.dispatch(msgs[i].Addr, msgs[i].Buffers[0][:msgs[i].N], dst) {
+				return
+			}
+		}
+		e.meters[0].track(began)
 	}
+}
 
-	e.clearConns()
+// dispatch queues up a copy of buf to be processed by a worker on behalf of the conn associated to addr. It reports
+// false should the endpoint be closing. Datagrams are dropped should the conn already have too many queued up.
+func (e *Endpoint) dispatch(addr net.Addr, buf []byte, dst net.IP) bool {
+	if e.dispatchProbe(addr, buf) || e.dispatchRaw(addr, buf) {
+		return true
+	}
+
+	conn := e.getConn(addr, buf)
+	if conn == nil {
+		return atomic.LoadUint32(&e.closing) == 0 // datagrams from new peers are dropped while at resource limits
+	}
+
+	if dst != nil {
+		conn.pinSource(dst)
+	}
+
+	// Datagrams are dropped should the buffer pool run out of buffers, such as preallocated ones.
+
+	b := e.pool.Get(len(buf))
+	if b == nil {
+		conn.unref()
+		return true
+	}
+	b.B = append(b.B, buf...)
+
+	// Queued datagrams keep the conn from being evicted until they are processed.
+
+	queued, schedule := conn.inbox.push(b, e.readQueueSize)
+	if !queued {
+		e.pool.Put(b)
+		conn.unref()
+		return true
+	}
+
+	if schedule {
+		e.rs.schedule(conn)
+	}
+
+	return true
+}
+
+func (e *Endpoint) work(meter *loopMeter) {
+	meter.started()
+
+	var (
+		bufs    []*Buffer
+		packets []Packet
+	)
+
+	for {
+		conn, ok := e.rs.next()
+		if !ok {
+			return
+		}
+
+		began := time.Now()
+
+		// Without ordering, only the oldest datagram is taken off the queue of the conn, and the conn is handed out
+		// again right away such that other workers may process datagrams queued up behind it meanwhile.
+
+		if e.readOrdering == ReadOrderingParallel {
+			buf, pending := conn.inbox.pop()
+			if pending {
+				e.rs.schedule(conn)
+			}
+			bufs = append(bufs[:0], buf)
+		} else {
+			bufs = conn.inbox.drain(bufs[:0])
+		}
+
+		if e.bph != nil {
+			packets = e.processBatch(conn, bufs, packets[:0])
+		}
+
+		for i, buf := range bufs {
+			if e.bph == nil {
+				e.process(conn, buf.B)
+			}
+			e.pool.Put(buf)
+			bufs[i] = nil
+			conn.unref()
+		}
+
+		if e.readOrdering == ReadOrderingFIFO && conn.inbox.done() {
+			e.rs.schedule(conn)
+		}
+
+		meter.track(began)
+	}
+}
+
+func (e *Endpoint) flushAcks(exit chan struct{}) {
+	ticker := time.NewTicker(e.ackDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exit:
+			e.ab.flush()
+			return
+		case <-ticker.C:
+			e.ab.flush()
+		}
+	}
+}
+
+func (e *Endpoint) process(conn *Conn, buf []byte) {
+	header, buf, deliver := e.readPacket(conn, buf)
+	if !deliver {
+		return
+	}
+
+	conn.deliver(header, buf)
+}
+
+// processBatch reads packets out of bufs, and delivers all of them to the batch packet handler at once. The packets
+// delivered point into bufs.
+func (e *Endpoint) processBatch(conn *Conn, bufs []*Buffer, packets []Packet) []Packet {
+	for _, buf := range bufs {
+		header, payload, deliver := e.readPacket(conn, buf.B)
+		if deliver {
+			packet := Packet{Seq: header.Sequence, Reliable: !header.Unordered, Buf: conn.provide(payload)}
+			if packet.Reliable {
+				packet.Number = conn.ReadPacketNumber(header.Sequence)
+			}
+			packets = append(packets, packet)
+		}
+	}
+
+	if len(packets) > 0 {
+		e.bph(conn.peer(), packets)
+	}
+
+	for i := range packets {
+		packets[i] = Packet{}
+	}
+
+	return packets
+}
+
+// readPacket reads a packet from conn's peer out of buf, reporting whether or not its payload should be delivered.
+// conn is cleared should the packet be malformed or fail to be read.
+func (e *Endpoint) readPacket(conn *Conn, buf []byte) (header PacketHeader, payload []byte, deliver bool) {
+	conn.trackReceived(len(buf))
+
+	header, payload, err := UnmarshalPacketHeader(buf)
+	if err != nil {
+		// Our peer is told that it sent a malformed packet, such that it does not keep on writing to a conn that is
+		// no more.
+
+		conn.reportError(fmt.Errorf("failed to process packet: %w", err))
+		if derr := conn.disconnect(DisconnectProtocolViolation, "malformed packet"); derr != nil {
+			conn.reportError(derr)
+		}
+		e.clearConn(conn, err)
+
+		return header, payload, false
+	}
+
+	payload, deliver, err = conn.readPacket(header, payload)
+	if err != nil {
+		var closeErr *CloseError
+		if !isEOF(err) && !errors.Is(err, ErrQuotaExceeded) && !errors.As(err, &closeErr) {
+			conn.reportError(fmt.Errorf("failed to process packet: %w", err))
+		}
+		e.clearConn(conn, err)
+	}
+
+	return header, payload, deliver
 }
 
 func (e *Endpoint) Close() error {
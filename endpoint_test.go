@@ -2,12 +2,23 @@ package reliable
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lithdew/bytesutil"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/lithdew/reliable/sequence"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
+	"golang.org/x/net/ipv4"
+	"io"
+	"math/rand"
 	"net"
+	"reflect"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -16,6 +27,12 @@ func newPacketConn(t testing.TB, addr string) net.PacketConn {
 	t.Helper()
 	conn, err := net.ListenPacket("udp", addr)
 	require.NoError(t, err)
+
+	// Loopback datagrams are dropped should a socket's read buffer overflow, which easily happens with the default
+	// read buffer size while our tests spam packets without waiting on acks.
+
+	require.NoError(t, conn.(*net.UDPConn).SetReadBuffer(4*1024*1024))
+
 	return conn
 }
 
@@ -178,3 +195,1728 @@ func TestEndpointWriteReliablePacketEndToEnd(t *testing.T) {
 		require.NoError(t, b.WriteReliablePacket(data, a.Addr()))
 	}
 }
+
+func TestEndpointBatchesAcks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	actual := uint64(0)
+	expected := uint64(512)
+
+	handler := func(_ net.Addr, seq uint16, buf []byte) {
+		atomic.AddUint64(&actual, 1)
+	}
+
+	ca := newPacketConn(t, "127.0.0.1:0")
+	cb := newPacketConn(t, "127.0.0.1:0")
+
+	// Acks may not be held back for as long as an update, as our peers would resend packets that were read.
+
+	require.Panics(t, func() { NewEndpoint(ca, WithAckDelay(DefaultUpdatePeriod)) })
+	require.Panics(t, func() { NewEndpoint(ca, WithUpdatePeriod(20*time.Millisecond), WithAckDelay(50*time.Millisecond)) })
+	require.NotPanics(t, func() {
+		NewEndpoint(ca, WithUpdatePeriod(200*time.Millisecond), WithAckDelay(150*time.Millisecond))
+	})
+
+	// Every packet is acked with a standalone ack, such that there are plenty of acks to be batched.
+
+	opts := []EndpointOption{
+		WithPacketHandler(handler),
+		WithAckDelay(1 * time.Millisecond),
+		WithAckPolicy(EveryPacketAckPolicy{}),
+	}
+
+	a := NewEndpoint(ca, opts...)
+	b := NewEndpoint(cb, opts...)
+
+	go a.Listen()
+	go b.Listen()
+
+	defer func() {
+		require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+
+		require.EqualValues(t, expected*2, atomic.LoadUint64(&actual))
+	}()
+
+	for i := uint64(0); i < expected; i++ {
+		data := strconv.AppendUint(nil, i, 10)
+
+		require.NoError(t, a.WriteReliablePacket(data, b.Addr()))
+		require.NoError(t, b.WriteReliablePacket(data, a.Addr()))
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadUint64(&actual) == expected*2 }, 5*time.Second, time.Millisecond)
+
+	// Acks were written out in batches, taking fewer syscalls than there were acks written.
+
+	for _, e := range [...]struct {
+		e    *Endpoint
+		peer net.Addr
+	}{{a, b.Addr()}, {b, a.Addr()}} {
+		stats, ok := e.e.Stats(e.peer)
+		require.True(t, ok)
+
+		acks := stats.Overhead.Datagrams - stats.ReliableWrites - stats.Resends
+		require.NotZero(t, acks)
+		require.Less(t, e.e.WriteStats().Writes-stats.ReliableWrites-stats.Resends, acks)
+	}
+}
+
+func TestAckBatcherRetriesTransientErrors(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+	pc.FailWrite(1, syscall.ENOBUFS)
+	pc.FailWrite(2, syscall.EAGAIN)
+
+	var errs []error
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	b := newAckBatcher(pc, NewBufferPool(new(Pool)), func(_ net.Addr, err error) { errs = append(errs, err) }, new(writeStats).add)
+
+	// Acks that fail to be written with a transient error are retried rather than dropped along with the rest of the
+	// batch.
+
+	b.push(addr, nil, []byte("a"))
+	b.push(addr, nil, []byte("b"))
+	b.flush()
+
+	require.Equal(t, 4, pc.Writes())
+	require.Empty(t, errs)
+
+	// Acks are given up on and reported once all retries are exhausted.
+
+	for i := 5; i < 5+transmitRetries+1; i++ {
+		pc.FailWrite(i, syscall.ENOBUFS)
+	}
+
+	b.push(addr, nil, []byte("c"))
+	b.push(addr, nil, []byte("d"))
+	b.flush()
+
+	require.Equal(t, 4+transmitRetries+2, pc.Writes())
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], syscall.ENOBUFS))
+}
+
+func TestEndpointPreservesPeerOrdering(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	var (
+		mu         sync.Mutex
+		violations []string // recorded rather than asserted on, as the handler is called off the test goroutine
+	)
+
+	last := make(map[string]uint64)
+	count := uint64(0)
+
+	handler := func(addr net.Addr, _ uint16, buf []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		idx, err := strconv.ParseUint(string(buf), 10, 64)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s: %v", addr, err))
+			return
+		}
+
+		if prev, exists := last[addr.String()]; exists && idx <= prev {
+			violations = append(violations, fmt.Sprintf("%s: read %d after %d", addr, idx, prev))
+		}
+		last[addr.String()] = idx
+		count++
+	}
+
+	ca := newPacketConn(t, "127.0.0.1:0")
+	cb := newPacketConn(t, "127.0.0.1:0")
+	cc := newPacketConn(t, "127.0.0.1:0")
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb)
+	c := NewEndpoint(cc, WithPacketHandler(handler), WithReadWorkers(4), WithReadBatchSize(4))
+
+	go a.Listen()
+	go b.Listen()
+	go c.Listen()
+
+	defer func() {
+		// Make sure c has started reading before its socket is closed.
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return count > 0
+		}, 1*time.Second, 1*time.Millisecond)
+
+		require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, cc.SetDeadline(time.Now().Add(1*time.Millisecond)))
+
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+		require.NoError(t, c.Close())
+
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+		require.NoError(t, cc.Close())
+
+		require.NotZero(t, count)
+		require.Empty(t, violations)
+	}()
+
+	for i := uint64(0); i < 1024; i++ {
+		data := strconv.AppendUint(nil, i, 10)
+
+		require.NoError(t, a.WriteUnreliablePacket(data, c.Addr()))
+		require.NoError(t, b.WriteUnreliablePacket(data, c.Addr()))
+	}
+}
+
+func TestEndpointPlatformTuning(t *testing.T) {
+	conn := newPacketConn(t, "127.0.0.1:0")
+	defer conn.Close()
+
+	var errs []error
+
+	NewEndpoint(conn, WithPlatformTuning(), WithErrorHandler(func(_ net.Addr, err error) { errs = append(errs, err) }))
+	require.Empty(t, errs)
+}
+
+func TestEndpointConnEvents(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var mu sync.Mutex
+	var events []ConnEventType
+
+	b := NewEndpoint(cb)
+
+	unsubscribe := b.Subscribe(func(event ConnEvent) {
+		require.Equal(t, ca.LocalAddr(), event.Addr)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		events = append(events, event.Type)
+	})
+
+	go b.Listen()
+
+	seen := func(count int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(events) == count
+		}
+	}
+
+	_, err := ca.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), cb.LocalAddr())
+	require.NoError(t, err)
+	require.Eventually(t, seen(1), 1*time.Second, 1*time.Millisecond)
+
+	_, err = ca.WriteTo([]byte{byte(FlagFragment)}, cb.LocalAddr())
+	require.NoError(t, err)
+	require.Eventually(t, seen(2), 1*time.Second, 1*time.Millisecond)
+
+	_, err = ca.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), cb.LocalAddr())
+	require.NoError(t, err)
+	require.Eventually(t, seen(3), 1*time.Second, 1*time.Millisecond)
+
+	require.NoError(t, cb.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+
+	require.Equal(t, []ConnEventType{ConnEstablished, ConnFailed, ConnEstablished, ConnClosed}, events)
+
+	unsubscribe()
+	require.Empty(t, b.subs)
+}
+
+func TestEndpointFailsConnsOnceResendsAreExhausted(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	// Nobody reads from cb, such that packets written to it go unacked.
+
+	errs := make(chan error, 16)
+
+	a := NewEndpoint(ca,
+		WithMaxPacketResends(2),
+		WithUpdatePeriod(time.Millisecond),
+		WithResendTimeout(time.Millisecond),
+		WithErrorHandler(func(_ net.Addr, err error) { errs <- err }),
+	)
+
+	events := make(chan ConnEvent, 16)
+	a.Subscribe(func(event ConnEvent) { events <- event })
+
+	go a.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Equal(t, ConnEstablished, (<-events).Type)
+
+	event := <-events
+	require.Equal(t, ConnFailed, event.Type)
+	require.Equal(t, ErrTooManyRetransmits, event.Err)
+	require.Equal(t, ErrTooManyRetransmits, <-errs)
+	require.Nil(t, a.lookupConn(cb.LocalAddr()))
+
+	// The conn was told that it gave up on our peer, and a fresh conn is created for later writes.
+
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Second)))
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := cb.ReadFrom(buf)
+		require.NoError(t, err)
+
+		header, payload, err := UnmarshalPacketHeader(buf[:n])
+		require.NoError(t, err)
+
+		if !header.Unordered || len(payload) == 0 || controlType(payload[0]) != controlClose {
+			continue
+		}
+
+		closeErr := &CloseError{Code: bytesutil.Uint16BE(payload[1:3])}
+		require.Equal(t, DisconnectMaxRetries, closeErr.DisconnectReason())
+		break
+	}
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Equal(t, ConnEstablished, (<-events).Type)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointKeyerMigratesConns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cc, cb := network.Listen(), network.Listen(), network.Listen()
+
+	// Datagrams from ca and cc are keyed as being from the same peer, as though a NAT rebound the port of ca to cc.
+
+	keyer := KeyerFunc(func(addr net.Addr, _ []byte) string {
+		if addr.String() == cc.LocalAddr().String() {
+			return ca.LocalAddr().String()
+		}
+		return addr.String()
+	})
+
+	b := NewEndpoint(cb, WithKeyer(keyer))
+
+	events := make(chan ConnEvent, 16)
+	b.Subscribe(func(event ConnEvent) { events <- event })
+
+	go b.Listen()
+
+	next := func() ConnEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(1 * time.Second):
+			t.Fatal("timed out waiting for conn event")
+			return ConnEvent{}
+		}
+	}
+
+	_, err := ca.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), cb.LocalAddr())
+	require.NoError(t, err)
+
+	event := next()
+	require.Equal(t, ConnEstablished, event.Type)
+	require.Equal(t, ca.LocalAddr(), event.Addr)
+
+	_, err = cc.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), cb.LocalAddr())
+	require.NoError(t, err)
+
+	event = next()
+	require.Equal(t, ConnMigrated, event.Type)
+	require.Equal(t, cc.LocalAddr(), event.Addr)
+
+	b.mu.Lock()
+	require.Len(t, b.conns, 1)
+	b.mu.Unlock()
+
+	// Packets written to the peer follow it to its new address.
+
+	require.NoError(t, b.WriteUnreliablePacket([]byte("hello"), ca.LocalAddr()))
+	require.NoError(t, cc.SetReadDeadline(time.Now().Add(1*time.Second)))
+
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := cc.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, cb.LocalAddr(), addr)
+
+		header, payload, err := UnmarshalPacketHeader(buf[:n])
+		require.NoError(t, err)
+
+		if !header.Empty {
+			require.EqualValues(t, "hello", payload)
+			break
+		}
+	}
+
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, b.Close())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cc.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointThreadPinning(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var (
+		mu     sync.Mutex
+		pinned []int
+	)
+
+	b := NewEndpoint(cb, WithReadWorkers(2), WithThreadPinning(func(worker int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pinned = append(pinned, worker)
+	}))
+
+	require.Equal(t, ReadLoopStats{Workers: make([]LoopUtilization, 2)}, b.ReadLoopStats())
+
+	go b.Listen()
+
+	for i := 0; i < 8; i++ {
+		_, err := ca.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), cb.LocalAddr())
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		stats := b.ReadLoopStats()
+		return stats.Reader.Busy > 0 && stats.Workers[0].Busy+stats.Workers[1].Busy > 0
+	}, 1*time.Second, 1*time.Millisecond)
+
+	stats := b.ReadLoopStats()
+	require.True(t, stats.Reader.Total >= stats.Reader.Busy)
+	require.True(t, stats.Reader.Fraction() > 0 && stats.Reader.Fraction() <= 1)
+
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, b.Close())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.ElementsMatch(t, []int{ReadLoopReader, 0, 1}, pinned)
+}
+
+func TestEndpointSourcePinning(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	for _, readBatchSize := range []int{1, 8} {
+		cb, err := net.ListenPacket("udp4", "0.0.0.0:0")
+		require.NoError(t, err)
+
+		b := NewEndpoint(cb, WithSourcePinning(), WithReadBatchSize(readBatchSize), WithErrorHandler(func(_ net.Addr, err error) {
+			t.Error(err)
+		}))
+		go b.Listen()
+
+		ca := newPacketConn(t, "127.0.0.1:0")
+
+		// Replies are sent from the address our peer targeted, rather than from the address the routing table picks.
+
+		dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: cb.LocalAddr().(*net.UDPAddr).Port}
+
+		_, err = ca.WriteTo(PacketHeader{Unordered: true}.AppendTo(nil), dst)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			_, ok := b.Stats(ca.LocalAddr())
+			return ok
+		}, 1*time.Second, 1*time.Millisecond)
+
+		require.NoError(t, b.WriteUnreliablePacket([]byte("hello"), ca.LocalAddr()))
+		require.NoError(t, ca.SetReadDeadline(time.Now().Add(1*time.Second)))
+
+		buf := make([]byte, 1500)
+		n, addr, err := ca.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, dst.String(), addr.String())
+
+		_, payload, err := UnmarshalPacketHeader(buf[:n])
+		require.NoError(t, err)
+		require.EqualValues(t, "hello", payload)
+
+		require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+		require.NoError(t, b.Close())
+
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+	}
+}
+
+func TestEndpointRateLimitIsShared(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca, WithEndpointRateLimit(1000, 100))
+	go a.Listen()
+
+	require.NoError(t, a.WriteUnreliablePacket(make([]byte, 100), cb.LocalAddr()))
+
+	start := time.Now()
+	require.NoError(t, a.WriteUnreliablePacket(make([]byte, 10), cc.LocalAddr()))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(5*time.Millisecond))
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, cc.Close())
+}
+
+func TestEndpointCloseWithError(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb)
+
+	events := make(chan ConnEvent, 16)
+	b.Subscribe(func(event ConnEvent) { events <- event })
+
+	go a.Listen()
+	go b.Listen()
+
+	next := func() ConnEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(1 * time.Second):
+			t.Fatal("timed out waiting for conn event")
+			return ConnEvent{}
+		}
+	}
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Equal(t, ConnEstablished, next().Type)
+
+	require.NoError(t, a.CloseConnWithError(cb.LocalAddr(), 7, "kicked: server restart"))
+
+	event := next()
+	require.Equal(t, ConnPeerClosed, event.Type)
+	require.Equal(t, &CloseError{Code: 7, Reason: "kicked: server restart"}, event.Err)
+
+	// Closing a whole endpoint notifies every peer.
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Equal(t, ConnEstablished, next().Type)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.CloseWithError(9, "shutting down"))
+
+	event = next()
+	require.Equal(t, ConnPeerClosed, event.Type)
+	require.Equal(t, &CloseError{Code: 9, Reason: "shutting down"}, event.Err)
+
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, b.Close())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointBatchPacketHandler(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var mu sync.Mutex
+	var received []string
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb, WithBatchPacketHandler(func(addr net.Addr, packets []Packet) {
+		require.Equal(t, ca.LocalAddr(), addr)
+		require.NotEmpty(t, packets)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, packet := range packets {
+			require.True(t, packet.Reliable)
+			require.EqualValues(t, packet.Seq, packet.Number)
+			received = append(received, string(packet.Buf))
+		}
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	expected := make([]string, 0, 128)
+	for i := 0; i < cap(expected); i++ {
+		expected = append(expected, strconv.Itoa(i))
+		require.NoError(t, a.WriteReliablePacket([]byte(expected[i]), cb.LocalAddr()))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == len(expected)
+	}, 1*time.Second, 1*time.Millisecond)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+
+	require.Equal(t, expected, received)
+}
+
+func TestEndpointTTL(t *testing.T) {
+	conn := newPacketConn(t, "127.0.0.1:0")
+	defer conn.Close()
+
+	var errs []error
+
+	e := NewEndpoint(conn, WithTTL(1), WithErrorHandler(func(_ net.Addr, err error) { errs = append(errs, err) }))
+	require.Empty(t, errs)
+
+	ttl, err := ipv4.NewPacketConn(conn.(*net.UDPConn)).TTL()
+	require.NoError(t, err)
+	require.Equal(t, 1, ttl)
+
+	require.NoError(t, e.SetTTL(64))
+
+	ttl, err = ipv4.NewPacketConn(conn.(*net.UDPConn)).TTL()
+	require.NoError(t, err)
+	require.Equal(t, 64, ttl)
+
+	require.True(t, errors.Is(NewEndpoint(reliabletest.NewNetwork(0).Listen()).SetTTL(1), ErrTTLUnsupported))
+}
+
+func TestEndpointNotifiesProtocolViolation(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	b := NewEndpoint(cb)
+	go b.Listen()
+
+	defer func() {
+		require.NoError(t, cb.Close())
+		require.NoError(t, b.Close())
+		require.NoError(t, ca.Close())
+	}()
+
+	_, err := ca.WriteTo([]byte{byte(FlagFragment), 0, 0}, cb.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, ca.SetReadDeadline(time.Now().Add(1*time.Second)))
+
+	buf := make([]byte, 1500)
+	n, _, err := ca.ReadFrom(buf)
+	require.NoError(t, err)
+
+	header, payload, err := UnmarshalPacketHeader(buf[:n])
+	require.NoError(t, err)
+	require.True(t, header.Empty)
+	require.Equal(t, byte(controlClose), payload[0])
+
+	closeErr := &CloseError{Code: bytesutil.Uint16BE(payload[1:3]), Reason: string(payload[3:])}
+	require.Equal(t, DisconnectProtocolViolation, closeErr.DisconnectReason())
+	require.Equal(t, "peer closed conn (reason=protocol_violation): malformed packet", closeErr.Error())
+
+	require.Equal(t, DisconnectApplication, (&CloseError{Code: CloseCodeReserved - 1}).DisconnectReason())
+}
+
+func TestEndpointRawPacketHandler(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var b *Endpoint
+	b = NewEndpoint(cb, WithRawPacketHandler(func(addr net.Addr, buf []byte) {
+		require.NoError(t, b.WriteRawPacket(append([]byte{RawPacketMarker}, "pong"...), addr))
+	}))
+	go b.Listen()
+
+	defer func() {
+		require.NoError(t, cb.Close())
+		require.NoError(t, b.Close())
+		require.NoError(t, ca.Close())
+	}()
+
+	_, err := ca.WriteTo(append([]byte{RawPacketMarker}, "ping"...), cb.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, ca.SetReadDeadline(time.Now().Add(1*time.Second)))
+
+	buf := make([]byte, 1500)
+	n, _, err := ca.ReadFrom(buf)
+	require.NoError(t, err)
+	require.True(t, IsRawPacket(buf[:n]))
+	require.EqualValues(t, "pong", buf[1:n])
+
+	// Raw datagrams are handled without a conn being created for their source address.
+
+	_, ok := b.Stats(ca.LocalAddr())
+	require.False(t, ok)
+	require.EqualValues(t, 1, b.WriteStats().Writes)
+}
+
+func TestEndpointProbe(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb, WithProbeResponder())
+
+	go a.Listen()
+	go b.Listen()
+
+	defer func() {
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+		require.NoError(t, cc.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	result, err := a.Probe(ctx, cb.LocalAddr())
+	require.NoError(t, err)
+	require.True(t, result.RTT >= 0 && result.RTT < time.Second)
+	require.True(t, result.Offset > -time.Second && result.Offset < time.Second)
+
+	// Probes are answered without conns being created on either end.
+
+	_, ok := a.Stats(cb.LocalAddr())
+	require.False(t, ok)
+	_, ok = b.Stats(ca.LocalAddr())
+	require.False(t, ok)
+
+	// Probes to peers that do not respond to them give up once their context is done.
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = a.Probe(ctx, cc.LocalAddr())
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestEndpointResendTimeoutConvergesToRTT(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Latency: 20 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb, WithAckPolicy(EveryPacketAckPolicy{}))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	conn := a.lookupConn(cb.LocalAddr())
+
+	require.Eventually(t, func() bool {
+		if err := a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()); err != nil {
+			return false
+		}
+
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+
+		timeout := conn.currentResendTimeout()
+		return timeout >= 40*time.Millisecond && timeout < 50*time.Millisecond
+	}, 10*time.Second, 5*time.Millisecond)
+
+	rtt := conn.RTT()
+	require.True(t, rtt >= 40*time.Millisecond && rtt < 50*time.Millisecond, rtt)
+	require.Zero(t, conn.Stats().Overhead.ResentBytes)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointBadMode(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Latency: 30 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	a := NewEndpoint(ca, WithCongestionThresholdRTT(50*time.Millisecond), WithUpdatePeriod(10*time.Millisecond))
+	b := NewEndpoint(cb, WithAckPolicy(EveryPacketAckPolicy{}))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	conn := a.lookupConn(cb.LocalAddr())
+
+	// A round-trip time above the congestion threshold puts the conn into bad mode.
+
+	require.Eventually(t, conn.BadMode, 5*time.Second, time.Millisecond)
+	require.EqualValues(t, 1, conn.Stats().BadModeEntries)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointReassemblesLargePayloadsOverLossyLinks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Loss: 0.1, MTU: 1200}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	payload := make([]byte, 200*1024)
+	_, err := rand.New(rand.NewSource(0)).Read(payload)
+	require.NoError(t, err)
+
+	var (
+		mu       sync.Mutex
+		received [][]byte
+	)
+
+	a := NewEndpoint(ca, WithFragmentSize(1024), WithUpdatePeriod(10*time.Millisecond))
+	b := NewEndpoint(cb, WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, append([]byte(nil), buf...))
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket(payload, cb.LocalAddr()))
+
+	delivered := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	require.Eventually(t, func() bool { return delivered() == 1 }, 10*time.Second, time.Millisecond)
+
+	// The payload is delivered intact exactly once, even after all of its fragments are acked.
+
+	require.Eventually(t, func() bool {
+		conn := a.lookupConn(cb.LocalAddr())
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return conn.oui == conn.wi
+	}, 10*time.Second, time.Millisecond)
+
+	require.Equal(t, 1, delivered())
+	require.Equal(t, payload, received[0])
+	require.NotZero(t, a.lookupConn(cb.LocalAddr()).Stats().Overhead.ResentBytes)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+func TestEndpointReportsConnsOnRotatedTokenKeys(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	k := NewKeyring(TokenKey{ID: 1, Secret: []byte("old secret")})
+
+	var mu sync.Mutex
+	var events []ConnEventType
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb, WithKeyring(k), WithUpdatePeriod(time.Millisecond))
+
+	b.Subscribe(func(event ConnEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if event.Type != ConnEstablished {
+			events = append(events, event.Type)
+		}
+	})
+
+	seen := func(types ...ConnEventType) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return reflect.DeepEqual(types, events)
+		}
+	}
+
+	go a.Listen()
+	go b.Listen()
+
+	defer func() {
+		require.NoError(t, ca.Close())
+		require.NoError(t, cb.Close())
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+	}()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	conn := a.lookupConn(cb.LocalAddr())
+	require.NotNil(t, conn)
+
+	present := func() {
+		token, err := k.Mint(ConnectToken{Expires: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.NoError(t, conn.PresentToken(token))
+	}
+
+	present()
+	require.Eventually(t, seen(ConnAuthenticated), 1*time.Second, 1*time.Millisecond)
+	require.Empty(t, b.StaleConns())
+
+	// Peers still authenticated with the old key are reported once it is rotated out, and again once it is retired,
+	// unless they present a token minted with the new key during the overlap period.
+
+	k.RotateWithOverlap(TokenKey{ID: 2, Secret: []byte("new secret")}, 100*time.Millisecond)
+
+	require.Eventually(t, seen(ConnAuthenticated, ConnTokenKeyRotated), 1*time.Second, 1*time.Millisecond)
+	require.Equal(t, []net.Addr{ca.LocalAddr()}, b.StaleConns())
+
+	require.Eventually(t, seen(ConnAuthenticated, ConnTokenKeyRotated, ConnTokenKeyRetired), 1*time.Second, 1*time.Millisecond)
+
+	present()
+	require.Eventually(t, func() bool { return len(b.StaleConns()) == 0 }, 1*time.Second, 1*time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	require.True(t, seen(ConnAuthenticated, ConnTokenKeyRotated, ConnTokenKeyRetired, ConnAuthenticated)())
+}
+
+func TestEndpointKeepsIdleConnsAlive(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+	fb := reliabletest.NewFaultConn(cb)
+
+	a := NewEndpoint(ca, WithKeepAlivePeriod(20*time.Millisecond), WithUpdatePeriod(5*time.Millisecond))
+	b := NewEndpoint(fb)
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Eventually(t, func() bool { return fb.Reads() >= 1 }, 1*time.Second, 1*time.Millisecond)
+
+	// Our peer keeps hearing from us while we have nothing to write.
+
+	reads := fb.Reads()
+	time.Sleep(200 * time.Millisecond)
+	require.GreaterOrEqual(t, fb.Reads()-reads, 3)
+
+	conn := a.lookupConn(cb.LocalAddr())
+	require.NotNil(t, conn)
+	require.GreaterOrEqual(t, conn.Stats().KeepAlives, uint64(3))
+	require.EqualValues(t, 1, conn.Stats().WritePacketNumber)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointParallelReadOrdering(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	for _, ordering := range []ReadOrdering{ReadOrderingFIFO, ReadOrderingParallel} {
+		t.Run(ordering.String(), func(t *testing.T) {
+			network := reliabletest.NewNetwork(0)
+
+			ca, cb := network.Listen(), network.Listen()
+
+			var (
+				running int32
+				peak    int32
+				handled int32
+			)
+
+			handler := func(net.Addr, uint16, []byte) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&handled, 1)
+			}
+
+			a := NewEndpoint(ca)
+			b := NewEndpoint(cb, WithPacketHandler(handler), WithReadWorkers(4), WithReadOrdering(ordering))
+
+			go a.Listen()
+			go b.Listen()
+
+			for i := 0; i < 64; i++ {
+				require.NoError(t, a.WriteUnreliablePacket([]byte("hello"), cb.LocalAddr()))
+			}
+
+			require.Eventually(t, func() bool { return atomic.LoadInt32(&handled) == 64 }, 5*time.Second, 1*time.Millisecond)
+
+			require.NoError(t, ca.Close())
+			require.NoError(t, cb.Close())
+			require.NoError(t, a.Close())
+			require.NoError(t, b.Close())
+
+			// Handlers for a single peer are only ever invoked one at a time should datagrams be processed in order.
+
+			if ordering == ReadOrderingFIFO {
+				require.EqualValues(t, 1, peak)
+			} else {
+				require.Greater(t, peak, int32(1))
+			}
+		})
+	}
+}
+
+func TestEndpointFailsInactiveConns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+
+	var mu sync.Mutex
+	var failed []net.Addr
+
+	b := NewEndpoint(cb, WithInactivityTimeout(50*time.Millisecond), WithUpdatePeriod(5*time.Millisecond))
+	b.Subscribe(func(event ConnEvent) {
+		if event.Type != ConnFailed {
+			return
+		}
+
+		require.Equal(t, ErrPeerTimeout, event.Err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		failed = append(failed, event.Addr)
+	})
+
+	// Peers that write keepalives are kept around.
+
+	c := NewEndpoint(cc, WithKeepAlivePeriod(10*time.Millisecond), WithUpdatePeriod(5*time.Millisecond))
+
+	go b.Listen()
+	go c.Listen()
+
+	_, err := ca.WriteTo(PacketHeader{Unordered: true}.AppendTo([]byte("hello")), cb.LocalAddr())
+	require.NoError(t, err)
+	require.NoError(t, c.WriteUnreliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failed) == 1
+	}, 1*time.Second, 1*time.Millisecond)
+
+	require.Equal(t, []net.Addr{ca.LocalAddr()}, failed)
+	require.Nil(t, b.lookupConn(ca.LocalAddr()))
+
+	// The silent peer is notified of having timed out, should it still be around.
+
+	buf := make([]byte, 64)
+	require.NoError(t, ca.SetReadDeadline(time.Now().Add(1*time.Second)))
+	n, _, err := ca.ReadFrom(buf)
+	require.NoError(t, err)
+
+	header, rest, err := UnmarshalPacketHeader(buf[:n])
+	require.NoError(t, err)
+	require.True(t, header.Empty)
+	require.Equal(t, byte(controlClose), rest[0])
+
+	time.Sleep(100 * time.Millisecond)
+	require.NotNil(t, b.lookupConn(cc.LocalAddr()))
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, cc.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, c.Close())
+}
+
+func TestEndpointAdaptiveKeepAliveFindsBindingTimeout(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	// a sits behind a NAT whose binding expires should a write nothing to b for 100ms.
+
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), reliabletest.Link{BindingTimeout: 100 * time.Millisecond})
+
+	a := NewEndpoint(ca, WithAdaptiveKeepAlive(20*time.Millisecond, time.Second), WithUpdatePeriod(time.Millisecond))
+	b := NewEndpoint(cb, WithKeepAlivePeriod(5*time.Millisecond), WithUpdatePeriod(time.Millisecond))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteUnreliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	conn := a.lookupConn(cb.LocalAddr())
+	require.NotNil(t, conn)
+
+	require.Eventually(t, func() bool { return conn.Parameters().KeepAliveSettled }, 5*time.Second, 1*time.Millisecond)
+
+	period := conn.Parameters().KeepAlivePeriod
+	require.Greater(t, int64(period), int64(40*time.Millisecond))
+	require.Less(t, int64(period), int64(100*time.Millisecond))
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointAllStats(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca)
+
+	go a.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.NoError(t, a.WriteUnreliablePacket([]byte("hello"), cc.LocalAddr()))
+
+	stats := a.AllStats()
+	require.Len(t, stats, 2)
+	require.EqualValues(t, 1, stats[cb.LocalAddr().String()].ReliableWrites)
+	require.EqualValues(t, 1, stats[cc.LocalAddr().String()].UnreliableWrites)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, cc.Close())
+	require.NoError(t, a.Close())
+}
+
+func TestEndpointAckHandler(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var mu sync.Mutex
+	acked := make(map[uint16]int)
+
+	a := NewEndpoint(ca, WithAckHandler(func(addr net.Addr, seq uint16) {
+		require.Equal(t, cb.LocalAddr(), addr)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		acked[seq]++
+	}))
+	b := NewEndpoint(cb, WithAckPolicy(EveryPacketAckPolicy{}))
+
+	go a.Listen()
+	go b.Listen()
+
+	for i := 0; i < 64; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(acked) == 64
+	}, 1*time.Second, 1*time.Millisecond)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	for seq := uint16(0); seq < 64; seq++ {
+		require.Equal(t, 1, acked[seq])
+	}
+}
+
+func TestEndpointOrderedDeliveryOverLossyLinks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Loss: 0.2, Latency: 2 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	var (
+		mu   sync.Mutex
+		seqs []uint16
+	)
+
+	a := NewEndpoint(ca, WithUpdatePeriod(10*time.Millisecond), WithResendTimeout(20*time.Millisecond))
+	b := NewEndpoint(cb, WithOrderedDelivery(), WithReadWorkers(4), WithReadOrdering(ReadOrderingParallel),
+		WithPacketHandler(func(_ net.Addr, seq uint16, _ []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			seqs = append(seqs, seq)
+		}))
+
+	go a.Listen()
+	go b.Listen()
+
+	for i := 0; i < 128; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	// Packets read ahead of lost packets are held back until the lost packets are resent.
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seqs) == 128
+	}, 10*time.Second, time.Millisecond)
+
+	require.NotZero(t, a.lookupConn(cb.LocalAddr()).Stats().Resends)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	for i := 1; i < len(seqs); i++ {
+		require.True(t, sequence.GT(seqs[i], seqs[i-1]))
+	}
+}
+
+func TestEndpointUnsequencedAcksOverLossyLinks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Loss: 0.2, Latency: 2 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[uint16]struct{})
+	)
+
+	a := NewEndpoint(ca, WithUpdatePeriod(10*time.Millisecond), WithResendTimeout(20*time.Millisecond))
+	b := NewEndpoint(cb, WithUnsequencedAcks(), WithPacketHandler(func(_ net.Addr, seq uint16, _ []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[seq] = struct{}{}
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	for i := 0; i < 128; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	// Packets whose acks were lost are resent, and their duplicates acked again, until all of them are acked.
+
+	require.Eventually(t, func() bool {
+		conn := a.lookupConn(cb.LocalAddr())
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return conn.oui == conn.wi
+	}, 10*time.Second, time.Millisecond)
+
+	conn := b.lookupConn(ca.LocalAddr())
+	conn.mu.Lock()
+	wi := conn.wi
+	conn.mu.Unlock()
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	require.Len(t, received, 128)
+	require.EqualValues(t, 0, wi)
+}
+
+func TestEndpointResourceLimits(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca, WithResourceLimits(ResourceLimits{MaxConns: 1}))
+	b := NewEndpoint(cb)
+	c := NewEndpoint(cc)
+
+	var (
+		mu      sync.Mutex
+		refused []net.Addr
+	)
+
+	defer a.Subscribe(func(event ConnEvent) {
+		if event.Type != ConnRefused {
+			return
+		}
+		require.Equal(t, ErrResourceLimit, event.Err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		refused = append(refused, event.Addr)
+	})()
+
+	listening := func(endpoints ...*Endpoint) func() bool {
+		return func() bool {
+			for _, e := range endpoints {
+				if atomic.LoadInt32(&e.goroutines) == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	go a.Listen()
+	go b.Listen()
+	go c.Listen()
+
+	require.Eventually(t, listening(a, b, c), time.Second, time.Millisecond)
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	// Conns to new peers are refused once the endpoint is at its limits, be they written to or read from.
+
+	require.Equal(t, ErrResourceLimit, a.WriteReliablePacket([]byte("hello"), cc.LocalAddr()))
+	require.NoError(t, c.WriteReliablePacket([]byte("hello"), ca.LocalAddr()))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(refused) >= 2
+	}, 1*time.Second, time.Millisecond)
+
+	r := a.Resources()
+	require.Equal(t, 1, r.Conns)
+	require.GreaterOrEqual(t, r.Goroutines, 2) // the read loop, its workers, and the update loop of the conn
+	require.Equal(t, 1, r.Timers)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, cc.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, c.Close())
+
+	require.Zero(t, a.Resources())
+	require.Equal(t, cc.LocalAddr(), refused[0])
+
+	// Conns created at once to new peers never go past the limits.
+
+	cd := network.Listen()
+
+	d := NewEndpoint(cd, WithResourceLimits(ResourceLimits{MaxConns: 4}))
+	go d.Listen()
+
+	require.Eventually(t, listening(d), time.Second, time.Millisecond)
+
+	var (
+		wg      sync.WaitGroup
+		written int32
+	)
+
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if d.WriteUnreliablePacket([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 1}) == nil {
+				atomic.AddInt32(&written, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 4, written)
+	require.Equal(t, 4, d.Resources().Conns)
+
+	require.NoError(t, cd.Close())
+	require.NoError(t, d.Close())
+
+	require.Zero(t, d.held)
+}
+
+func TestEndpointFlushesOverLossyLinks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Loss: 0.2, Latency: 2 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[uint16]struct{})
+	)
+
+	a := NewEndpoint(ca, WithUpdatePeriod(10*time.Millisecond), WithResendTimeout(20*time.Millisecond))
+	b := NewEndpoint(cb, WithPacketHandler(func(_ net.Addr, seq uint16, _ []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[seq] = struct{}{}
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	for i := 0; i < 128; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.lookupConn(cb.LocalAddr()).FlushAndClose(ctx))
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+
+	require.Len(t, received, 128)
+}
+
+func TestEndpointShutdownDrainsUnackedPackets(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.Link{Loss: 0.2, Latency: 2 * time.Millisecond}
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[uint16]struct{})
+	)
+
+	a := NewEndpoint(ca, WithUpdatePeriod(10*time.Millisecond), WithResendTimeout(20*time.Millisecond))
+	b := NewEndpoint(cb, WithPacketHandler(func(_ net.Addr, seq uint16, _ []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[seq] = struct{}{}
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	for i := 0; i < 128; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Shutdown(ctx))
+	require.Equal(t, io.EOF, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	require.NoError(t, cb.Close())
+	require.NoError(t, b.Close())
+
+	require.Len(t, received, 128)
+}
+
+func TestEndpointShutdownReportsUnconfirmedPeers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb, cc := network.Listen(), network.Listen(), network.Listen()
+	network.SetLink(ca.LocalAddr(), cc.LocalAddr(), reliabletest.Link{Loss: 1})
+
+	a := NewEndpoint(ca, WithUpdatePeriod(10*time.Millisecond))
+	b := NewEndpoint(cb, WithUpdatePeriod(10*time.Millisecond))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cc.LocalAddr()))
+
+	// Only the peer that never received its packet is reported.
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := a.Shutdown(ctx)
+
+	var serr *ShutdownError
+	require.True(t, errors.As(err, &serr))
+	require.Equal(t, []net.Addr{cc.LocalAddr()}, serr.Unconfirmed)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	require.NoError(t, cb.Close())
+	require.NoError(t, cc.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointEvictsIdleConns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var received int32
+
+	a := NewEndpoint(ca, WithConnIdleTimeout(40*time.Millisecond))
+	b := NewEndpoint(cb, WithPacketHandler(func(net.Addr, uint16, []byte) { atomic.AddInt32(&received, 1) }))
+
+	evicted := make(chan ConnEvent, 1)
+	defer a.Subscribe(func(event ConnEvent) {
+		if event.Type != ConnEvicted {
+			return
+		}
+		select {
+		case evicted <- event:
+		default:
+		}
+	})()
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	// Once idle, the conn is evicted, and our peer is notified such that it clears its conn as well.
+
+	event := <-evicted
+	require.Equal(t, cb.LocalAddr().String(), event.Addr.String())
+	require.Nil(t, a.lookupConn(cb.LocalAddr()))
+	require.EqualValues(t, 1, a.Evictions())
+	require.Eventually(t, func() bool { return b.lookupConn(ca.LocalAddr()) == nil }, time.Second, time.Millisecond)
+
+	// Writing to an evicted peer creates a fresh conn to it.
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 2 }, time.Second, time.Millisecond)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointEvictionSparesConnsInUse(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca, WithConnIdleTimeout(time.Hour))
+
+	// Conns gotten to read or write a packet are not evicted until the packet is read or written.
+
+	conn := a.getConn(cb.LocalAddr(), nil)
+	a.evictIdleConns(time.Now().Add(time.Hour))
+	require.Same(t, conn, a.lookupConn(cb.LocalAddr()))
+	require.Zero(t, a.Evictions())
+
+	conn.unref()
+	a.evictIdleConns(time.Now().Add(time.Hour))
+	require.Nil(t, a.lookupConn(cb.LocalAddr()))
+	require.EqualValues(t, 1, a.Evictions())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+}
+
+func TestEndpointEvictionNeverDropsPackets(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	var received int32
+
+	a := NewEndpoint(ca, WithConnIdleTimeout(4*time.Millisecond), WithPacketHandler(func(net.Addr, uint16, []byte) {
+		atomic.AddInt32(&received, 1)
+	}))
+	b := NewEndpoint(cb)
+
+	go a.Listen()
+	go b.Listen()
+
+	// Packets are written around the idle timeout, such that they race with conns being evicted. Each packet is
+	// either read by the conn about to be evicted or by a fresh conn.
+
+	var sent int32
+	for i := 0; i < 200; i++ {
+		if b.WriteUnreliablePacket([]byte("hello"), ca.LocalAddr()) == nil {
+			sent++
+		}
+		time.Sleep(time.Duration(rand.Intn(8000)) * time.Microsecond)
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&received) == sent }, time.Second, time.Millisecond)
+	require.NotZero(t, a.Evictions())
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointPassiveRTTForReceiveOnlyPeers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), reliabletest.Link{Latency: 5 * time.Millisecond})
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), reliabletest.Link{Latency: 5 * time.Millisecond})
+
+	a := NewEndpoint(ca, WithUpdatePeriod(5*time.Millisecond))
+	b := NewEndpoint(cb, WithUpdatePeriod(5*time.Millisecond), WithPassiveRTT(20*time.Millisecond))
+
+	go a.Listen()
+	go b.Listen()
+
+	// Too few packets are written for our peer to write standalone acks that sample its round-trip time.
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	require.Eventually(t, func() bool {
+		stats, ok := b.Stats(ca.LocalAddr())
+		return ok && stats.PassiveRTTSamples > 0
+	}, time.Second, time.Millisecond)
+
+	stats, _ := b.Stats(ca.LocalAddr())
+	require.GreaterOrEqual(t, int64(stats.RTT), int64(10*time.Millisecond))
+
+	stats, _ = a.Stats(cb.LocalAddr())
+	require.NotZero(t, stats.RTT)
+	require.Zero(t, stats.PassiveRTTSamples)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
+
+func TestEndpointValidatesPeersByChallenge(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca, WithUpdatePeriod(5*time.Millisecond))
+	b := NewEndpoint(cb, WithUpdatePeriod(5*time.Millisecond), WithAmplificationLimit(DefaultAmplificationFactor))
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	// Our peer echoes the challenge written to it on the next update, validating it.
+
+	require.Eventually(t, func() bool {
+		params, ok := b.Parameters(ca.LocalAddr())
+		return ok && params.Validated
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+}
@@ -0,0 +1,36 @@
+package reliable
+
+import "github.com/lithdew/reliable/sequence"
+
+// Sequence numbers wrap around every 65536 packets. For long sessions, every reliable packet is additionally assigned
+// a 64-bit logical packet number that counts the number of times the sequence space wrapped around, which is never
+// written to the wire. Both peers derive it from the 16-bit sequence number instead, which is unambiguous as reliable
+// packets more than a read buffer away from the newest packet are dropped.
+
+// WritePacketNumber returns the 64-bit logical packet number of the reliable packet most recently written to our
+// peer with sequence number seq. Acks written by this conn also consume packet numbers.
+func (c *Conn) WritePacketNumber(seq uint16) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return unwrapPacketNumber(c.wpn, seq)
+}
+
+// ReadPacketNumber returns the 64-bit logical packet number of the reliable packet with sequence number seq that was
+// most recently read from our peer, or that is about to be read from our peer. It is meant to be called from within
+// packet handlers.
+func (c *Conn) ReadPacketNumber(seq uint16) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return unwrapPacketNumber(c.rpn, seq)
+}
+
+// unwrapPacketNumber returns the logical packet number of seq given the logical packet number that follows the
+// newest one.
+func unwrapPacketNumber(next uint64, seq uint16) uint64 {
+	if next == 0 {
+		return uint64(seq)
+	}
+	return sequence.Unwrap(next-1, seq)
+}
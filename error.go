@@ -4,8 +4,17 @@ import (
 	"errors"
 	"io"
 	"net"
+	"syscall"
 )
 
+// ErrQuotaExceeded is returned by writes to a conn that exceeded its quota and was throttled, and by reads from a
+// conn that exceeded its quota and should be disconnected.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrLatencyBudgetExceeded is returned by writes with a latency budget that are estimated to not reach their
+// destination in time.
+var ErrLatencyBudgetExceeded = errors.New("latency budget exceeded")
+
 func isEOF(err error) bool {
 	if errors.Is(err, io.EOF) {
 		return true
@@ -23,3 +32,9 @@ func isEOF(err error) bool {
 
 	return false
 }
+
+// isTemporary reports whether or not err is a transient socket error, such as the socket buffer being full, that is
+// likely to go away should the write be retried shortly.
+func isTemporary(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK)
+}
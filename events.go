@@ -0,0 +1,119 @@
+package reliable
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType uint8
+
+const (
+	EventSend    EventType = iota // a packet was sent to our peer
+	EventSendAck                  // a standalone ack was sent to our peer
+	EventRecv                     // a packet was received from our peer
+	EventAcked                    // a packet we sent was acked by our peer
+	EventResend                   // an unacked packet was resent to our peer
+	EventStall                    // a write had to wait for our peer's read buffer to free up
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSend:
+		return "send"
+	case EventSendAck:
+		return "send_ack"
+	case EventRecv:
+		return "recv"
+	case EventAcked:
+		return "acked"
+	case EventResend:
+		return "resend"
+	case EventStall:
+		return "stall"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+type Event struct {
+	Time    time.Time
+	Type    EventType
+	Seq     uint16
+	ACK     uint16
+	ACKBits uint32
+	Size    int // size of the packet payload in bytes
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf(
+		"%s %-8s (seq=%05d) (ack=%05d) (ack_bits=%032b) (size=%d)",
+		e.Time.Format("15:04:05.000000"), e.Type, e.Seq, e.ACK, e.ACKBits, e.Size,
+	)
+}
+
+// eventLog is a fixed-size ring of the most recent protocol events of a conn.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int  // index the next event is recorded at
+	full   bool // whether or not the ring has wrapped around
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]Event, size)}
+}
+
+func (l *eventLog) record(typ EventType, seq, ack uint16, ackBits uint32, size int) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = Event{Time: now, Type: typ, Seq: seq, ACK: ack, ACKBits: ackBits, Size: size}
+
+	l.next++
+	if l.next == len(l.events) {
+		l.next, l.full = 0, true
+	}
+}
+
+// snapshot returns a copy of all recorded events from oldest to newest.
+func (l *eventLog) snapshot() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]Event(nil), l.events[:l.next]...)
+	}
+
+	events := make([]Event, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+	events = append(events, l.events[:l.next]...)
+
+	return events
+}
+
+// EventLogError is reported to an error handler in place of an error that occurred on a conn with an event log,
+// carrying a snapshot of the most recent events of the conn at the time the error occurred.
+type EventLogError struct {
+	Err    error
+	Events []Event
+}
+
+func (e *EventLogError) Error() string {
+	var b strings.Builder
+
+	b.WriteString(e.Err.Error())
+	for _, event := range e.Events {
+		b.WriteString("\n\t")
+		b.WriteString(event.String())
+	}
+
+	return b.String()
+}
+
+func (e *EventLogError) Unwrap() error {
+	return e.Err
+}
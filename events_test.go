@@ -0,0 +1,56 @@
+package reliable
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestEventLogWrapsAround(t *testing.T) {
+	l := newEventLog(4)
+	require.Empty(t, l.snapshot())
+
+	for i := uint16(0); i < 3; i++ {
+		l.record(EventSend, i, 0, 0, 0)
+	}
+
+	events := l.snapshot()
+	require.Len(t, events, 3)
+	for i, event := range events {
+		require.EqualValues(t, i, event.Seq)
+	}
+
+	for i := uint16(3); i < 10; i++ {
+		l.record(EventSend, i, 0, 0, 0)
+	}
+
+	events = l.snapshot()
+	require.Len(t, events, 4)
+	for i, event := range events {
+		require.EqualValues(t, 6+i, event.Seq)
+	}
+}
+
+func TestConnRecordsEvents(t *testing.T) {
+	require.Nil(t, NewConn(nil, nil).Events())
+
+	c := NewConn(nil, nil, WithEventLogSize(8))
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, Unordered: true}, []byte("hello")))
+
+	events := c.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, EventRecv, events[0].Type)
+	require.Equal(t, 5, events[0].Size)
+
+	var reported error
+	c.eh = func(_ net.Addr, err error) { reported = err }
+	c.reportError(io.EOF)
+
+	var logErr *EventLogError
+	require.True(t, errors.As(reported, &logErr))
+	require.True(t, errors.Is(reported, io.EOF))
+	require.Equal(t, events, logErr.Events)
+}
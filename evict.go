@@ -0,0 +1,103 @@
+package reliable
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// idleConn is a conn that was found idle, along with how many times it was gotten by its endpoint beforehand.
+type idleConn struct {
+	conn *Conn
+	uses uint32
+}
+
+// ref marks this conn as gotten by its endpoint to read or write a packet, keeping it from being evicted until unref
+// is called. It must be called with the mutex of its endpoint held.
+func (c *Conn) ref() {
+	atomic.AddInt32(&c.refs, 1)
+	atomic.AddUint32(&c.uses, 1)
+}
+
+// unref marks a packet this conn was gotten to read or write as read or written.
+func (c *Conn) unref() { atomic.AddInt32(&c.refs, -1) }
+
+// idleFor returns how long nothing was written to nor read from our peer as of now.
+func (c *Conn) idleFor(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := c.lr
+	if c.ls.After(last) {
+		last = c.ls
+	}
+	return now.Sub(last)
+}
+
+// Evictions returns the number of conns this endpoint evicted for being idle for the idle timeout set using
+// WithConnIdleTimeout.
+func (e *Endpoint) Evictions() uint64 { return atomic.LoadUint64(&e.evictions) }
+
+// sweepIdleConns evicts idle conns every quarter of the idle timeout until exit is closed, such that conns are evicted
+// within a quarter of the idle timeout past having gone idle.
+func (e *Endpoint) sweepIdleConns(exit chan struct{}) {
+	ticker := time.NewTicker(e.connIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+			if !e.drainingOrClosing() {
+				e.evictIdleConns(time.Now())
+			}
+		}
+	}
+}
+
+// evictIdleConns notifies and then closes and clears every conn that was idle for the idle timeout as of now. Conns
+// are only evicted should they not have been gotten since they were found idle, and should no packet they were gotten
+// for be waiting to be read or written, such that packets from or to an evicted peer are never dropped. Such packets
+// instead have a fresh conn be created for them.
+func (e *Endpoint) evictIdleConns(now time.Time) {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	var idle []idleConn
+	for _, conn := range conns {
+		uses := atomic.LoadUint32(&conn.uses)
+		if conn.idleFor(now) >= e.connIdleTimeout {
+			idle = append(idle, idleConn{conn: conn, uses: uses})
+		}
+	}
+
+	if len(idle) == 0 {
+		return
+	}
+
+	evicted := idle[:0]
+
+	e.mu.Lock()
+	for _, c := range idle {
+		if e.conns[c.conn.key] != c.conn || atomic.LoadInt32(&c.conn.refs) != 0 || atomic.LoadUint32(&c.conn.uses) != c.uses {
+			continue
+		}
+		e.removeConn(c.conn)
+		evicted = append(evicted, c)
+	}
+	e.mu.Unlock()
+
+	for _, c := range evicted {
+		atomic.AddUint64(&e.evictions, 1)
+
+		if err := c.conn.disconnect(DisconnectEvicted, "evicted for being idle"); err != nil && e.eh != nil {
+			e.eh(c.conn.peer(), err)
+		}
+
+		e.emit(ConnEvicted, c.conn.peer(), nil)
+	}
+}
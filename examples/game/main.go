@@ -0,0 +1,267 @@
+// Command game runs a small game server that tracks the positions of players, or a client that moves a player
+// around at random. Clients write their moves to the server as reliable packets, and the server writes snapshots of
+// the positions of all players to every client as unreliable packets once per tick.
+//
+//	$ go run ./examples/game -l 127.0.0.1:44444
+//	$ go run ./examples/game 127.0.0.1:44444
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TickRate is how many snapshots the server writes to every client per second.
+const TickRate = 20
+
+// Player is the position of a player in a snapshot.
+type Player struct {
+	ID   uint16
+	X, Y int32
+}
+
+// Server tracks the positions of players, moving them as their clients write moves to it.
+type Server struct {
+	endpoint *reliable.Endpoint
+
+	mu      sync.Mutex
+	players map[string]*Player
+	addrs   map[string]net.Addr
+	nextID  uint16
+}
+
+func NewServer(conn net.PacketConn) *Server {
+	s := &Server{players: make(map[string]*Player), addrs: make(map[string]net.Addr)}
+
+	s.endpoint = reliable.NewEndpoint(conn, reliable.WithReliablePacketHandler(s.move))
+
+	// Players join once their client writes its first move, and leave once their conn is closed.
+
+	s.endpoint.Subscribe(func(event reliable.ConnEvent) {
+		if event.Type == reliable.ConnEstablished {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.players, event.Addr.String())
+		delete(s.addrs, event.Addr.String())
+	})
+
+	return s
+}
+
+// move moves the player of the client at addr by the move in buf.
+func (s *Server) move(addr net.Addr, _ uint16, buf []byte) {
+	if len(buf) != 2 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	player := s.players[addr.String()]
+	if player == nil {
+		player = &Player{ID: s.nextID}
+		s.nextID++
+
+		s.players[addr.String()] = player
+		s.addrs[addr.String()] = addr
+	}
+
+	player.X += int32(int8(buf[0]))
+	player.Y += int32(int8(buf[1]))
+}
+
+// Tick writes a snapshot of the positions of all players to every client.
+func (s *Server) Tick() error {
+	s.mu.Lock()
+	players := make([]Player, 0, len(s.players))
+	addrs := make([]net.Addr, 0, len(s.addrs))
+	for id, player := range s.players {
+		players = append(players, *player)
+		addrs = append(addrs, s.addrs[id])
+	}
+	s.mu.Unlock()
+
+	snapshot := appendSnapshot(nil, players)
+
+	for _, addr := range addrs {
+		if err := s.endpoint.WriteUnreliablePacket(snapshot, addr); err != nil {
+			return fmt.Errorf("failed to write snapshot to %s: %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) Listen() { s.endpoint.Listen() }
+
+func (s *Server) Close() error { return s.endpoint.Close() }
+
+// Client moves a player around on a server, and keeps the most recent snapshot the server wrote to it.
+type Client struct {
+	endpoint *reliable.Endpoint
+	server   net.Addr
+
+	mu       sync.Mutex
+	snapshot []Player
+}
+
+func NewClient(conn net.PacketConn, server net.Addr) *Client {
+	c := &Client{server: server}
+	c.endpoint = reliable.NewEndpoint(conn, reliable.WithUnreliablePacketHandler(c.update))
+	return c
+}
+
+// update keeps the snapshot in buf should it be well-formed.
+func (c *Client) update(_ net.Addr, _ uint16, buf []byte) {
+	players, err := readSnapshot(buf)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshot = players
+}
+
+// Move moves our player by dx and dy.
+func (c *Client) Move(dx, dy int8) error {
+	return c.endpoint.WriteReliablePacket([]byte{byte(dx), byte(dy)}, c.server)
+}
+
+// Snapshot returns the positions of all players as of the most recent snapshot, sorted by their ids.
+func (c *Client) Snapshot() []Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Player(nil), c.snapshot...)
+}
+
+func (c *Client) Listen() { c.endpoint.Listen() }
+
+func (c *Client) Close() error { return c.endpoint.Close() }
+
+// A snapshot is the number of players as a 16-bit integer, followed by the 16-bit id and the 32-bit x and y
+// position of each player. All integers are big-endian.
+
+func appendSnapshot(dst []byte, players []Player) []byte {
+	dst = append(dst, 0, 0)
+	binary.BigEndian.PutUint16(dst[len(dst)-2:], uint16(len(players)))
+
+	for _, player := range players {
+		var buf [10]byte
+		binary.BigEndian.PutUint16(buf[0:2], player.ID)
+		binary.BigEndian.PutUint32(buf[2:6], uint32(player.X))
+		binary.BigEndian.PutUint32(buf[6:10], uint32(player.Y))
+		dst = append(dst, buf[:]...)
+	}
+
+	return dst
+}
+
+func readSnapshot(buf []byte) ([]Player, error) {
+	if len(buf) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	n := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) != n*10 {
+		return nil, errors.New("snapshot size does not match its number of players")
+	}
+
+	players := make([]Player, n)
+	for i := range players {
+		players[i] = Player{
+			ID: binary.BigEndian.Uint16(buf[0:2]),
+			X:  int32(binary.BigEndian.Uint32(buf[2:6])),
+			Y:  int32(binary.BigEndian.Uint32(buf[6:10])),
+		}
+		buf = buf[10:]
+	}
+
+	sort.Slice(players, func(i, j int) bool { return players[i].ID < players[j].ID })
+
+	return players, nil
+}
+
+func main() {
+	listen := flag.String("l", "", "address to serve the game on rather than joining a game")
+	flag.Parse()
+
+	var err error
+	if *listen != "" {
+		err = serve(*listen)
+	} else {
+		err = join(flag.Arg(0))
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer conn.Close()
+
+	log.Printf("%s: Serving game.", conn.LocalAddr())
+
+	s := NewServer(conn)
+	defer s.Close()
+
+	go s.Listen()
+
+	for range time.Tick(time.Second / TickRate) {
+		if err := s.Tick(); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return nil
+}
+
+func join(addr string) error {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve server: %w", err)
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer conn.Close()
+
+	c := NewClient(conn, server)
+	defer c.Close()
+
+	go c.Listen()
+
+	for range time.Tick(time.Second / TickRate) {
+		if err := c.Move(int8(rand.Intn(3)-1), int8(rand.Intn(3)-1)); err != nil {
+			return fmt.Errorf("failed to move: %w", err)
+		}
+		log.Printf("%s: %v", conn.LocalAddr(), c.Snapshot())
+	}
+
+	return nil
+}
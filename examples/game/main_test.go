@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestGame(t *testing.T) {
+	network := reliabletest.NewNetwork(0)
+
+	cs, ca, cb := network.Listen(), network.Listen(), network.Listen()
+
+	s := NewServer(cs)
+	a := NewClient(ca, cs.LocalAddr())
+	b := NewClient(cb, cs.LocalAddr())
+
+	go s.Listen()
+	go a.Listen()
+	go b.Listen()
+
+	defer func() {
+		for _, conn := range []*reliabletest.PacketConn{cs, ca, cb} {
+			require.NoError(t, conn.Close())
+		}
+		require.NoError(t, s.Close())
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+	}()
+
+	require.NoError(t, a.Move(1, 2))
+	require.NoError(t, a.Move(1, 2))
+	require.NoError(t, b.Move(-1, 0))
+
+	// Snapshots are written unreliably, so the server keeps on ticking until both clients see both moves.
+
+	expected := [][2]int32{{2, 4}, {-1, 0}}
+
+	require.Eventually(t, func() bool {
+		if err := s.Tick(); err != nil {
+			return false
+		}
+
+		for _, c := range []*Client{a, b} {
+			snapshot := c.Snapshot()
+			if len(snapshot) != 2 {
+				return false
+			}
+
+			positions := make(map[[2]int32]bool)
+			for _, player := range snapshot {
+				positions[[2]int32{player.X, player.Y}] = true
+			}
+			if !positions[expected[0]] || !positions[expected[1]] {
+				return false
+			}
+		}
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSnapshot(t *testing.T) {
+	players := []Player{{ID: 1, X: -5, Y: 7}, {ID: 0, X: 1 << 20, Y: -(1 << 20)}}
+
+	decoded, err := readSnapshot(appendSnapshot(nil, players))
+	require.NoError(t, err)
+	require.Equal(t, []Player{players[1], players[0]}, decoded)
+
+	_, err = readSnapshot([]byte{0, 2, 0})
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package reliable
+
+import (
+	"context"
+	"io"
+
+	"github.com/lithdew/reliable/sequence"
+)
+
+// Flush waits until every reliable packet written to our peer before Flush was called is acked. It returns ctx.Err()
+// should ctx be done first, or io.EOF should this conn be closed first, such as after failing on resends being
+// exhausted.
+func (c *Conn) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defer c.wakeOnDone(ctx)()
+
+	wi := c.wi
+
+	for !c.die && ctx.Err() == nil && sequence.LT(c.oui, wi) {
+		c.ouc.Wait()
+	}
+
+	switch {
+	case !sequence.LT(c.oui, wi):
+		return nil
+	case c.die:
+		return io.EOF
+	default:
+		return ctx.Err()
+	}
+}
+
+// FlushAndClose flushes this conn, and then closes it regardless of whether or not flushing succeeded. See Flush.
+func (c *Conn) FlushAndClose(ctx context.Context) error {
+	err := c.Flush(ctx)
+	c.Close()
+	return err
+}
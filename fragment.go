@@ -0,0 +1,376 @@
+package reliable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lithdew/bytesutil"
+	"io"
+	"time"
+)
+
+// fragmentHeaderSize is the number of bytes the fragment header following the header of a fragment takes up: the
+// 16-bit id of the payload the fragment belongs to, the index of the fragment, and the index of the last fragment.
+const fragmentHeaderSize = 2 + 1 + 1
+
+// fragmentAbortSize is the number of bytes a fragment abort control packet takes up: its type, the 16-bit id of the
+// payload given up on, and the sequence number of the newest reliable packet written as of giving up on it.
+const fragmentAbortSize = 1 + 2 + 2
+
+// MaxMessageFragments is the max number of fragments a payload may be split into.
+const MaxMessageFragments = 256
+
+const (
+	DefaultMaxPartialMessages = 16
+	DefaultReassemblyTimeout  = 5 * time.Second
+)
+
+var (
+	// ErrMessageTooLarge is returned by writes of payloads that would be split into more than MaxMessageFragments
+	// fragments.
+	ErrMessageTooLarge = errors.New("payload too large to be split into fragments")
+
+	// ErrInvalidFragment is returned when decoding the header of a fragment that is a control packet, or whose index
+	// lies past that of the last fragment of its payload.
+	ErrInvalidFragment = errors.New("invalid fragment")
+)
+
+// ReassemblyLimits bounds the memory taken up by fragments read from a peer of payloads that are yet to be
+// reassembled. Fields left as zero leave their limits at their defaults.
+type ReassemblyLimits struct {
+	MaxFragments int           // max number of fragments a payload read may be split into, up to MaxMessageFragments
+	MaxMessages  int           // max number of payloads that may be partially read at once
+	Timeout      time.Duration // how long a partially read unreliable payload is kept for since its last fragment was read
+}
+
+func (l ReassemblyLimits) withDefaults() ReassemblyLimits {
+	if l.MaxFragments == 0 {
+		l.MaxFragments = MaxMessageFragments
+	}
+	if l.MaxMessages == 0 {
+		l.MaxMessages = DefaultMaxPartialMessages
+	}
+	if l.Timeout == 0 {
+		l.Timeout = DefaultReassemblyTimeout
+	}
+	return l
+}
+
+// partialMessage is a payload of which only some fragments were read so far.
+type partialMessage struct {
+	reliable bool
+	aborted  bool      // whether or not our peer gave up on writing the payload, such that its late fragments are dropped
+	frags    [][]byte  // copies of the fragments read so far, indexed by fragment index
+	count    int       // number of fragments read so far
+	size     int       // total number of bytes of fragments read so far
+	updated  time.Time // when a fragment was last read
+	progress progress  // throughput at which fragments are read, checked by the watchdog if set
+
+	// last is the logical packet number of the newest fragment read of a reliable payload, or of the newest reliable
+	// packet written before it was aborted, which tells it apart from payloads written later on that reuse its id.
+	last uint64
+}
+
+// checkFragmentSize panics should fragments of fragmentSize bytes along with their headers not fit in the buffers of
+// pool, as every write of a payload split into fragments would otherwise fail with ErrPacketTooLarge.
+func checkFragmentSize(pool BufferPool, fragmentSize int) {
+	if fragmentSize > 0 && !fits(pool, maxPacketHeaderSize+fragmentHeaderSize+fragmentSize) {
+		panic("fragment size along with its headers must fit in a buffer of the buffer pool")
+	}
+}
+
+// fragmented reports whether or not a payload of n bytes is to be split into fragments.
+func (c *Conn) fragmented(n int) bool {
+	return c.fragmentSize > 0 && n > c.fragmentSize
+}
+
+// writeFragments splits buf into fragments of the fragment size, and writes each of them as its own packet. The
+// fragments of a reliable payload are each assigned their own sequence number, and are acked and resent separately.
+func (c *Conn) writeFragments(ctx context.Context, reliable bool, buf []byte) error {
+	header, count, err := c.nextFragmentHeader(reliable, len(buf))
+	if err != nil {
+		return err
+	}
+
+	ctx, t, done := c.watchTransfer(ctx, header.FragmentID)
+	defer done()
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * c.fragmentSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		header.FragmentIndex = uint8(i)
+
+		if queued, err := c.writeOne(ctx, header, buf[i*c.fragmentSize:end]); err != nil {
+			if reliable && (queued || i > 0) {
+				c.abortFragments(header.FragmentID)
+			}
+			return c.transferError(t, header.FragmentID, err)
+		}
+
+		if t != nil {
+			c.mu.Lock()
+			t.bytes = end
+			c.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// transferError returns a StalledTransferError in place of err should the watchdog have failed the write of
+// transfer t of the payload with the given id.
+func (c *Conn) transferError(t *transfer, id uint16, err error) error {
+	if t == nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.stalled {
+		return &StalledTransferError{ID: id, Bytes: t.bytes}
+	}
+	return err
+}
+
+// nextFragmentHeader assigns an id to a payload of n bytes to be split into fragments of the fragment size, returning
+// the header its fragments share along with how many fragments it is split into.
+func (c *Conn) nextFragmentHeader(reliable bool, n int) (header PacketHeader, count int, err error) {
+	count = (n + c.fragmentSize - 1) / c.fragmentSize
+	if count > MaxMessageFragments {
+		return header, 0, ErrMessageTooLarge
+	}
+
+	c.mu.Lock()
+	id := c.fragmentID
+	c.fragmentID++
+	c.stats.FragmentedWrites++
+	c.mu.Unlock()
+
+	header = PacketHeader{Unordered: !reliable, Fragment: true, FragmentID: id, FragmentLast: uint8(count - 1)}
+
+	return header, count, nil
+}
+
+// admitFragment reports whether or not there is room to reassemble the payload the fragment described by header
+// belongs to, making room by dropping the partial unreliable or aborted payload that was read from the longest ago
+// should there be too many partial payloads. Fragments that are not admitted are dropped without being read, such that
+// reliable fragments get resent. It reports anomaly should the fragment not match the other fragments of its payload.
+func (c *Conn) admitFragment(header PacketHeader, now time.Time) (admit bool, anomaly bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expirePartialMessages(now)
+
+	if int(header.FragmentLast) >= c.reassembly.MaxFragments {
+		return false, true
+	}
+
+	if p := c.partial[header.FragmentID]; p != nil {
+		switch {
+		case p.reliable && !header.Unordered && c.supersedes(p, unwrapPacketNumber(c.rpn, header.Sequence)):
+			// The fragment belongs to a payload written after p that reuses its id, so p is dropped in its favor.
+
+			c.dropPartialMessage(header.FragmentID, p)
+		case p.reliable == header.Unordered:
+			return false, true
+		case p.aborted:
+			return true, false // read only to be acked and dropped, such that our peer stops resending it
+		case len(p.frags) != int(header.FragmentLast)+1:
+			return false, true
+		default:
+			return true, false
+		}
+	}
+
+	if len(c.partial) < c.reassembly.MaxMessages {
+		return true, false
+	}
+
+	var (
+		oldest   *partialMessage
+		oldestID uint16
+	)
+
+	for id, p := range c.partial {
+		if (!p.reliable || p.aborted) && (oldest == nil || p.updated.Before(oldest.updated)) {
+			oldest, oldestID = p, id
+		}
+	}
+
+	if oldest == nil {
+		return false, false
+	}
+
+	c.dropPartialMessage(oldestID, oldest)
+
+	return true, false
+}
+
+// supersedes reports whether or not the reliable fragment with logical packet number number was written after the
+// partial reliable payload p was either aborted, or fell behind the read window. It must be called with c.mu held.
+func (c *Conn) supersedes(p *partialMessage, number uint64) bool {
+	if p.aborted {
+		return number > p.last
+	}
+	return c.behindReadWindow(p, number)
+}
+
+// behindReadWindow reports whether or not the newest fragment of the partial reliable payload p lies more than two
+// read buffers' worth of packets behind the packet with logical number next: one read buffer for the rest of its
+// fragments to have been written in, and another for our peer to have resent them in. It must be called with c.mu
+// held.
+func (c *Conn) behindReadWindow(p *partialMessage, next uint64) bool {
+	return next > p.last+2*uint64(len(c.rq))
+}
+
+// countPartialMessages returns how many payloads are being reassembled, and how many of their fragments were read so
+// far. Payloads our peer aborted are not counted. It must be called with c.mu held.
+func (c *Conn) countPartialMessages() (messages, fragments int) {
+	for _, p := range c.partial {
+		if p.aborted {
+			continue
+		}
+		messages, fragments = messages+1, fragments+p.count
+	}
+	return messages, fragments
+}
+
+// dropPartialMessage drops the partial payload p with the given id. It must be called with c.mu held.
+func (c *Conn) dropPartialMessage(id uint16, p *partialMessage) {
+	delete(c.partial, id)
+	if !p.aborted {
+		c.stats.ReassemblyDrops++
+	}
+}
+
+// reassemble adds the fragment buf described by header to its payload, returning the payload once all of its
+// fragments were read. Fragments of aborted payloads are dropped.
+func (c *Conn) reassemble(header PacketHeader, buf []byte, now time.Time) (payload []byte, complete bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.partial[header.FragmentID]
+	if p == nil {
+		if c.partial == nil {
+			c.partial = make(map[uint16]*partialMessage)
+		}
+		p = &partialMessage{
+			reliable: !header.Unordered,
+			frags:    make([][]byte, int(header.FragmentLast)+1),
+			progress: progress{since: now},
+		}
+		c.partial[header.FragmentID] = p
+	}
+
+	if p.aborted {
+		return nil, false
+	}
+
+	// Unreliable fragments are not deduplicated by sequence number, and so may be read more than once.
+
+	if p.frags[header.FragmentIndex] != nil {
+		return nil, false
+	}
+
+	if p.reliable {
+		if number := unwrapPacketNumber(c.rpn, header.Sequence); number > p.last {
+			p.last = number
+		}
+	}
+
+	p.frags[header.FragmentIndex] = append(make([]byte, 0, len(buf)), buf...)
+	p.count++
+	p.size += len(buf)
+	p.updated = now
+
+	if p.count < len(p.frags) {
+		return nil, false
+	}
+
+	delete(c.partial, header.FragmentID)
+	c.stats.Reassembled++
+
+	payload = make([]byte, 0, p.size)
+	for _, frag := range p.frags {
+		payload = append(payload, frag...)
+	}
+
+	return payload, true
+}
+
+// expirePartialMessages drops partial unreliable payloads that had no fragment read for the reassembly timeout, such
+// that a fragment that never arrives does not leak memory. Partial reliable payloads are not timed out, as their
+// fragments were already acked and would never be resent. They are instead dropped once our peer aborts them, or once
+// their newest fragment falls behind the read window, should the abort have been lost. It must be called with c.mu
+// held.
+func (c *Conn) expirePartialMessages(now time.Time) {
+	for id, p := range c.partial {
+		if p.reliable && c.behindReadWindow(p, c.rpn) || !p.reliable && now.Sub(p.updated) >= c.reassembly.Timeout {
+			c.dropPartialMessage(id, p)
+		}
+	}
+}
+
+// abortFragments notifies our peer that the reliable payload with the given id is not to be written in full, such
+// that our peer drops the fragments it read of it, along with those it has yet to read, which were all written no
+// later than the newest reliable packet. The notification is sent once and unreliably.
+func (c *Conn) abortFragments(id uint16) {
+	c.mu.Lock()
+	seq := c.wi - 1
+	c.mu.Unlock()
+
+	buf := make([]byte, 0, fragmentAbortSize)
+	buf = append(buf, byte(controlAbort))
+	buf = bytesutil.AppendUint16BE(buf, id)
+	buf = bytesutil.AppendUint16BE(buf, seq)
+
+	if err := c.writeControl(buf); err != nil && !isEOF(err) {
+		c.reportError(fmt.Errorf("failed to write fragment abort: %w", err))
+	}
+}
+
+// readAbort drops the partial reliable payload our peer aborted, keeping it around without its fragments until they
+// fall behind the read window, such that fragments of it read late are dropped rather than reassembled.
+func (c *Conn) readAbort(buf []byte) error {
+	if len(buf) < 4 {
+		return fmt.Errorf("failed to read fragment abort: %w", io.ErrUnexpectedEOF)
+	}
+
+	id := bytesutil.Uint16BE(buf[:2])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := unwrapPacketNumber(c.rpn, bytesutil.Uint16BE(buf[2:4]))
+
+	p := c.partial[id]
+	switch {
+	case p == nil:
+		if len(c.partial) >= c.reassembly.MaxMessages {
+			return nil
+		}
+		if c.partial == nil {
+			c.partial = make(map[uint16]*partialMessage)
+		}
+		p = &partialMessage{reliable: true, last: last}
+		c.partial[id] = p
+	case !p.reliable || p.aborted:
+		return nil
+	default:
+		c.stats.ReassemblyDrops++
+	}
+
+	if last > p.last {
+		p.last = last
+	}
+
+	p.aborted = true
+	p.frags, p.count, p.size = nil, 0, 0
+	p.updated = time.Now()
+
+	return nil
+}
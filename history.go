@@ -0,0 +1,93 @@
+package reliable
+
+import (
+	"net"
+	"time"
+)
+
+// SentPacket is a record of a reliable packet written to our peer, meant for building custom congestion controllers
+// and for offline analysis.
+type SentPacket struct {
+	Seq     uint16
+	Number  uint64    // 64-bit logical packet number
+	Size    int       // size of the packet payload in bytes, or zero for acks
+	Sent    time.Time // when the packet was first written
+	Acked   time.Time // when the packet was acked, or zero if it has yet to be
+	Lost    time.Time // when the packet was first deemed lost and resent, or zero if it never was
+	Resends int       // total number of times the packet was resent
+}
+
+// sentHistory is a fixed-size ring of records of the most recent reliable packets written to our peer, indexed by
+// their logical packet numbers. It must only be accessed with c.mu held.
+type sentHistory struct {
+	records []SentPacket
+}
+
+func newSentHistory(size int) *sentHistory {
+	return &sentHistory{records: make([]SentPacket, size)}
+}
+
+func (h *sentHistory) sent(number uint64, seq uint16, size int, now time.Time) {
+	h.records[number%uint64(len(h.records))] = SentPacket{Seq: seq, Number: number, Size: size, Sent: now}
+}
+
+// lookup returns the record of the packet with the given logical packet number, or nil should it have been evicted
+// from the ring.
+func (h *sentHistory) lookup(number uint64) *SentPacket {
+	r := &h.records[number%uint64(len(h.records))]
+	if r.Sent.IsZero() || r.Number != number {
+		return nil
+	}
+	return r
+}
+
+func (h *sentHistory) acked(number uint64, now time.Time) {
+	if r := h.lookup(number); r != nil && r.Acked.IsZero() {
+		r.Acked = now
+	}
+}
+
+func (h *sentHistory) resent(number uint64, now time.Time) {
+	if r := h.lookup(number); r != nil {
+		if r.Lost.IsZero() {
+			r.Lost = now
+		}
+		r.Resends++
+	}
+}
+
+// SentHistory returns the records of the most recent reliable packets written to our peer from oldest to newest, or
+// nil should this conn not keep a sent-packet history.
+func (c *Conn) SentHistory() []SentPacket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sh == nil {
+		return nil
+	}
+
+	start := uint64(0)
+	if c.wpn > uint64(len(c.sh.records)) {
+		start = c.wpn - uint64(len(c.sh.records))
+	}
+
+	records := make([]SentPacket, 0, c.wpn-start)
+	for number := start; number < c.wpn; number++ {
+		if r := c.sh.lookup(number); r != nil {
+			records = append(records, *r)
+		}
+	}
+
+	return records
+}
+
+// SentHistory returns the records of the most recent reliable packets written to addr from oldest to newest, or nil
+// should there be no conn to addr or should sent-packet histories not be enabled.
+func (e *Endpoint) SentHistory(addr net.Addr) []SentPacket {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return nil
+	}
+	return conn.SentHistory()
+}
@@ -0,0 +1,49 @@
+package reliable
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPeerTimeout is the error a conn fails with should nothing be read from its peer for the inactivity timeout set
+// using WithInactivityTimeout, which most likely means that our peer is gone.
+var ErrPeerTimeout = errors.New("nothing was heard from peer for too long")
+
+// trackHeard tracks a packet as having been read from our peer as of now. Packets written to our peer, such as acks
+// and keepalives, do not count as our peer being active.
+func (c *Conn) trackHeard(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lr = now
+}
+
+// checkInactivity fails this conn with ErrPeerTimeout should nothing have been read from our peer for the inactivity
+// timeout as of now. It is called once per conn.
+func (c *Conn) checkInactivity(now time.Time) {
+	c.mu.Lock()
+	if c.inactivityTimeout == 0 || c.inactive || now.Sub(c.lr) < c.inactivityTimeout {
+		c.mu.Unlock()
+		return
+	}
+	c.inactive = true
+	c.mu.Unlock()
+
+	c.reportError(ErrPeerTimeout)
+
+	if c.onInactive != nil {
+		c.onInactive()
+		return
+	}
+
+	go c.disconnectInactive()
+}
+
+// disconnectInactive notifies our peer, should it still be around, that nothing was heard from it for too long, and
+// then closes this conn. It must not be called from a reader or writer of this conn, as closing this conn waits for
+// them.
+func (c *Conn) disconnectInactive() {
+	if err := c.disconnect(DisconnectIdleTimeout, ErrPeerTimeout.Error()); err != nil {
+		c.reportError(err)
+	}
+}
@@ -0,0 +1,127 @@
+package reliable
+
+import "sync"
+
+// interleaver takes turns between resends of unacked packets and fresh writes of this conn while both compete to be
+// transmitted, such that neither starves the other. Turns are taken in rounds of up to resends resends and writes
+// fresh writes. Should only one of them be competing, it takes its turns straight away.
+type interleaver struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	resends int // max number of resends per round
+	writes  int // max number of fresh writes per round
+
+	resent  int // number of resends that took their turn this round
+	written int // number of fresh writes that took their turn this round
+
+	pendingResends int // number of resends due that have yet to take their turn
+	waitingWrites  int // number of fresh writes waiting for their turn
+}
+
+func newInterleaver(resends, writes int) *interleaver {
+	il := &interleaver{resends: resends, writes: writes}
+	il.cond.L = &il.mu
+	return il
+}
+
+// mayResend reports whether or not a resend may take its turn. It must be called with il.mu held.
+func (il *interleaver) mayResend() bool { return il.waitingWrites == 0 || il.resent < il.resends }
+
+// mayWrite reports whether or not a fresh write may take its turn. It must be called with il.mu held.
+func (il *interleaver) mayWrite() bool { return il.pendingResends == 0 || il.written < il.writes }
+
+// took counts a turn taken, starting a new round should both resends and fresh writes have taken all of their turns
+// this round, or should the turn not have been competed for. It must be called with il.mu held.
+func (il *interleaver) took(resend, competed bool) {
+	switch {
+	case !competed:
+		il.resent, il.written = 0, 0
+		return
+	case resend:
+		il.resent++
+	default:
+		il.written++
+	}
+
+	if il.resent >= il.resends && il.written >= il.writes {
+		il.resent, il.written = 0, 0
+	}
+}
+
+// beginResends marks n resends as due, such that fresh writes take turns with them.
+func (il *interleaver) beginResends(n int) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.pendingResends = n
+}
+
+// endResends marks all resends that have yet to take their turn as no longer due, such as once resending unacked
+// packets gives up early.
+func (il *interleaver) endResends() {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.pendingResends = 0
+	il.cond.Broadcast()
+}
+
+// resend waits for the turn of a resend marked as due, reporting whether or not it had to wait.
+func (il *interleaver) resend() (waited bool) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	for !il.mayResend() {
+		waited = true
+		il.cond.Wait()
+	}
+
+	il.took(true, il.waitingWrites > 0)
+	il.pendingResends--
+	il.cond.Broadcast()
+
+	return waited
+}
+
+// write waits for the turn of a fresh write, reporting whether or not it had to wait. A fresh write only ever waits
+// for the resends left in the current round to take their turns.
+func (il *interleaver) write() (waited bool) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.waitingWrites++
+	for !il.mayWrite() {
+		waited = true
+		il.cond.Wait()
+	}
+	il.waitingWrites--
+
+	il.took(false, il.pendingResends > 0)
+	il.cond.Broadcast()
+
+	return waited
+}
+
+// takeWriteTurn waits for the turn of a fresh write of this conn should resends and fresh writes be interleaved.
+func (c *Conn) takeWriteTurn() {
+	if c.interleave == nil || !c.interleave.write() {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.WritesInterleaved++
+	c.mu.Unlock()
+}
+
+// takeResendTurn waits for the turn of a resend of this conn should resends and fresh writes be interleaved. The
+// resend already drew from the rate limits of this conn when it was found due.
+func (c *Conn) takeResendTurn() {
+	if c.interleave == nil || !c.interleave.resend() {
+		return
+	}
+
+	c.mu.Lock()
+	c.stats.ResendsInterleaved++
+	c.mu.Unlock()
+}
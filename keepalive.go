@@ -0,0 +1,87 @@
+package reliable
+
+import (
+	"fmt"
+	"time"
+)
+
+// keepAliveJitter is the fraction of the keepalive period keepalives are jittered by, such that conns of an endpoint
+// that went idle at once do not all write their keepalives on the same update.
+const keepAliveJitter = 0.1
+
+// keepAliveDue reports whether or not nothing was written to our peer for the keepalive period as of now. While in
+// the background, the keepalive period is stretched as resends are.
+func (c *Conn) keepAliveDue(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keepAlivePeriod == 0 {
+		return false
+	}
+
+	return now.Sub(c.ls) >= c.rand.jitter(c.effectiveKeepAlivePeriod(), keepAliveJitter)
+}
+
+// effectiveKeepAlivePeriod returns the keepalive period stretched for being in the background. It must be called with
+// c.mu held.
+func (c *Conn) effectiveKeepAlivePeriod() time.Duration {
+	if c.power == PowerBackground {
+		return c.keepAlivePeriod * backgroundResendFactor
+	}
+	return c.keepAlivePeriod
+}
+
+// writeKeepAliveIfIdle writes a keepalive to our peer should nothing have been written to it for the keepalive
+// period, such that our peer may tell us apart from being gone and NAT mappings along the way do not expire. A
+// keepalive is an empty unordered packet carrying our latest acks, so it consumes no sequence number and is never
+// resent.
+func (c *Conn) writeKeepAliveIfIdle(now time.Time) error {
+	if !c.keepAliveDue(now) {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.adaptKeepAlive(now)
+	ack := c.ri - 1
+	ackBits := c.prepareAckBits(ack)
+	c.stats.KeepAlives++
+	c.mu.Unlock()
+
+	if err := c.write(PacketHeader{ACK: ack, ACKBits: ackBits, Unordered: true, Empty: true}, nil); err != nil {
+		return fmt.Errorf("failed to write keepalive: %w", err)
+	}
+
+	return nil
+}
+
+// adaptKeepAlive probes for how long a NAT binding between us and our peer lasts without us writing anything to it,
+// and is called with c.mu held right before a keepalive is written. Our peer having been heard from during the last
+// min keepalive period of the silence shows that the binding outlived the silence so far, in which case the keepalive
+// period is grown by half up to the max keepalive period. Otherwise, the binding is taken to have expired, and the
+// keepalive period settles on the longest silence the binding was shown to outlive, less the jitter. Probing relies
+// on our peer writing to us at least every min keepalive period, such as by writing keepalives itself.
+func (c *Conn) adaptKeepAlive(now time.Time) {
+	if c.keepAliveMax == 0 || c.keepAliveSettled {
+		return
+	}
+
+	if c.lr.After(c.ls) && now.Sub(c.lr) < c.keepAliveMin {
+		if proven := c.lr.Sub(c.ls); proven > c.keepAliveProven {
+			c.keepAliveProven = proven
+		}
+
+		c.keepAlivePeriod += c.keepAlivePeriod / 2
+		if c.keepAlivePeriod >= c.keepAliveMax {
+			c.keepAlivePeriod = c.keepAliveMax
+			c.keepAliveSettled = true
+		}
+
+		return
+	}
+
+	c.keepAlivePeriod = c.keepAliveProven - time.Duration(keepAliveJitter*float64(c.keepAliveProven))
+	if c.keepAlivePeriod < c.keepAliveMin {
+		c.keepAlivePeriod = c.keepAliveMin
+	}
+	c.keepAliveSettled = true
+}
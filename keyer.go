@@ -0,0 +1,50 @@
+package reliable
+
+import "net"
+
+// Keyer maps the peers of an Endpoint onto the keys of their conns. Should a datagram read from a new address map
+// onto the key of an existing conn, the conn migrates to the new address such that packets written to the peer follow
+// it, as is needed behind load balancers and NATs that rewrite source addresses. Keys should hence be unguessable
+// should they not be derived from addresses alone, as anyone that knows a key may take its conn over.
+type Keyer interface {
+	// Key returns the key of the conn that datagram buf read from addr belongs to. buf is nil when looking up the
+	// conn to addr rather than reading from it, such as when writing to addr.
+	Key(addr net.Addr, buf []byte) string
+}
+
+// KeyerFunc adapts a function into a Keyer.
+type KeyerFunc func(addr net.Addr, buf []byte) string
+
+func (fn KeyerFunc) Key(addr net.Addr, buf []byte) string { return fn(addr, buf) }
+
+// AddrKeyer keys conns by the address of their peer. It is the default Keyer of an Endpoint.
+type AddrKeyer struct{}
+
+func (AddrKeyer) Key(addr net.Addr, _ []byte) string { return addr.String() }
+
+// peerAddr wraps the address of a peer so that addresses of different types may be stored in the same atomic.Value.
+type peerAddr struct{ net.Addr }
+
+// peer returns the address of our peer.
+func (c *Conn) peer() net.Addr {
+	return c.addr.Load().(peerAddr).Addr
+}
+
+// migrate has all packets be written to our peer at addr from now on, reporting whether or not addr is new.
+func (c *Conn) migrate(addr net.Addr) bool {
+	if c.peer().String() == addr.String() {
+		return false
+	}
+	c.addr.Store(peerAddr{addr})
+	return true
+}
+
+// lookupConn returns the conn to addr, or nil should there be none.
+func (e *Endpoint) lookupConn(addr net.Addr) *Conn {
+	key := e.keyer.Key(addr, nil)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.conns[key]
+}
@@ -0,0 +1,79 @@
+package reliable
+
+import "time"
+
+// LatencyBuckets is the number of buckets lifecycle latencies are sorted into. Bucket i counts latencies under 2^i
+// microseconds, with the last bucket also counting all latencies that were any longer.
+const LatencyBuckets = 24
+
+// LatencyHistogram is a histogram of latencies with exponentially growing buckets.
+type LatencyHistogram struct {
+	Count uint64        // total number of latencies recorded
+	Total time.Duration // sum of all latencies recorded
+
+	Buckets [LatencyBuckets]uint64 // number of latencies per bucket
+}
+
+// Mean returns the mean of all latencies recorded.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th percentile latency, with p ranging from 0 to 100.
+// It returns zero should no latencies have been recorded.
+func (h LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(p / 100 * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+
+	seen := uint64(0)
+	for i, n := range h.Buckets {
+		seen += n
+		if seen > rank {
+			return time.Duration(1<<uint(i)) * time.Microsecond
+		}
+	}
+
+	return time.Duration(1<<uint(LatencyBuckets-1)) * time.Microsecond
+}
+
+func (h *LatencyHistogram) add(took time.Duration) {
+	h.Count++
+	h.Total += took
+	h.Buckets[latencyBucket(took, LatencyBuckets)]++
+}
+
+// latencyBucket returns which of n exponentially growing buckets took falls into.
+func latencyBucket(took time.Duration, n int) int {
+	bucket := 0
+	for us := took / time.Microsecond; us > 0 && bucket < n-1; us >>= 1 {
+		bucket++
+	}
+	return bucket
+}
+
+// LifecycleStats breaks down how long reliable packets written to our peer spent in each stage of their lifecycle,
+// telling apart latency caused by waiting to write, by the network, or by our peer being slow to ack.
+type LifecycleStats struct {
+	Queue    LatencyHistogram // from starting to be written to being assigned a sequence number, such as while waiting on rate limits or our peer's read buffer
+	Transmit LatencyHistogram // from being assigned a sequence number to being written to the socket
+	Ack      LatencyHistogram // from first being written to the socket to being acked by our peer
+	Release  LatencyHistogram // from being acked to having its slot in the write buffer released, which waits for all older packets to be acked
+}
+
+// trackLifecycleWrite tracks how long a reliable packet spent queued up and being transmitted.
+func (c *Conn) trackLifecycleWrite(queued, transmitted time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Lifecycle.Queue.add(queued)
+	c.stats.Lifecycle.Transmit.add(transmitted)
+}
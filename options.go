@@ -1,6 +1,10 @@
 package reliable
 
-import "time"
+import (
+	"math"
+	"math/rand"
+	"time"
+)
 
 const (
 	DefaultWriteBufferSize uint16 = 256
@@ -8,6 +12,16 @@ const (
 
 	DefaultUpdatePeriod  = 100 * time.Millisecond
 	DefaultResendTimeout = 100 * time.Millisecond
+
+	DefaultMinResendTimeout = 10 * time.Millisecond
+
+	DefaultInitialWindowSize uint16 = 64
+
+	DefaultAckSuppressionWindow = 5 * time.Millisecond
+
+	DefaultReadBatchSize = 8
+	DefaultReadWorkers   = 4
+	DefaultReadQueueSize = 1024
 )
 
 type ConnOption interface {
@@ -23,12 +37,32 @@ type Option interface {
 	EndpointOption
 }
 
-type withBufferPool struct{ pool *Pool }
+type withPreallocation struct{ count, size int }
+
+func (o withPreallocation) applyConn(c *Conn)         { c.pool = newFixedPool(o.count, o.size) }
+func (o withPreallocation) applyEndpoint(e *Endpoint) { e.pool = newFixedPool(o.count, o.size) }
+
+// WithPreallocation allocates count buffers of size bytes each up front, which are used in place of a buffer pool.
+// No buffers are allocated afterwards: writes fail with ErrBuffersExhausted while every buffer is in use, and with
+// ErrPacketTooLarge should a packet not fit in a buffer. An endpoint shares its buffers amongst all of its conns,
+// and drops datagrams read from its socket while every buffer is in use.
+func WithPreallocation(count, size int) Option {
+	if count <= 0 {
+		panic("number of preallocated buffers must be positive")
+	}
+	if size <= maxPacketHeaderSize {
+		panic("preallocated buffers must be larger than a packet header")
+	}
+	return withPreallocation{count: count, size: size}
+}
+
+type withBufferPool struct{ pool BufferPool }
 
 func (o withBufferPool) applyConn(c *Conn)         { c.pool = o.pool }
 func (o withBufferPool) applyEndpoint(e *Endpoint) { e.pool = o.pool }
 
-func WithBufferPool(pool *Pool) Option { return withBufferPool{pool: pool} }
+// WithBufferPool sets the pool buffers are gotten from. An endpoint shares its pool amongst all of its conns.
+func WithBufferPool(pool BufferPool) Option { return withBufferPool{pool: pool} }
 
 type withWriteBufferSize struct{ writeBufferSize uint16 }
 
@@ -54,6 +88,175 @@ func WithReadBufferSize(readBufferSize uint16) Option {
 	return withReadBufferSize{readBufferSize: readBufferSize}
 }
 
+type withReorderTolerance struct{ reorderTolerance uint16 }
+
+func (o withReorderTolerance) applyConn(c *Conn)         { c.reorderTolerance = o.reorderTolerance }
+func (o withReorderTolerance) applyEndpoint(e *Endpoint) { e.reorderTolerance = o.reorderTolerance }
+
+// WithReorderTolerance sets how many sequence numbers, counting back from and including that of the newest reliable
+// packet read from a peer, packets may still be accepted with. Older packets are dropped as stale. It must be at
+// least the size of an ack bitset, such that packets our peer may still resend are not dropped, and may not exceed
+// the read buffer size, which it defaults to.
+func WithReorderTolerance(reorderTolerance uint16) Option {
+	if reorderTolerance < ACKBitsetSize {
+		panic("reorder tolerance must be at least the size of an ack bitset")
+	}
+	return withReorderTolerance{reorderTolerance: reorderTolerance}
+}
+
+type withMaxPacketResends struct{ maxResends byte }
+
+func (o withMaxPacketResends) applyConn(c *Conn) {
+	c.maxResends, c.failResends = o.maxResends, true
+}
+func (o withMaxPacketResends) applyEndpoint(e *Endpoint) { e.maxResends = o.maxResends }
+
+// WithMaxPacketResends fails each conn with ErrTooManyRetransmits should an unacked packet go unacked for a resend
+// timeout after having been resent maxResends times, notifying its peer and closing it, such that conns to peers
+// that are gone do not linger. Without it, unacked packets stop being resent after DefaultMaxPacketResends resends.
+func WithMaxPacketResends(maxResends int) Option {
+	if maxResends < 1 || maxResends > math.MaxUint8 {
+		panic("max packet resends must be between 1 and 255")
+	}
+	return withMaxPacketResends{maxResends: byte(maxResends)}
+}
+
+type withResendPacing struct{ resendPacing int }
+
+func (o withResendPacing) applyConn(c *Conn)         { c.resendPacing = o.resendPacing }
+func (o withResendPacing) applyEndpoint(e *Endpoint) { e.resendPacing = o.resendPacing }
+
+// WithResendPacing limits the number of unacked packets resent to each peer per update, such that a peer recovering
+// from an outage is not blasted with every packet that went unacked during it at once. Packets left over are resent
+// on later updates.
+func WithResendPacing(resendPacing int) Option {
+	if resendPacing < 1 {
+		panic("resend pacing must be positive")
+	}
+	return withResendPacing{resendPacing: resendPacing}
+}
+
+type withRetransmitInterleave struct{ ratio interleaveRatio }
+
+func (o withRetransmitInterleave) applyConn(c *Conn) {
+	c.interleave = newInterleaver(o.ratio.resends, o.ratio.writes)
+}
+func (o withRetransmitInterleave) applyEndpoint(e *Endpoint) { r := o.ratio; e.interleave = &r }
+
+// interleaveRatio is how many resends are transmitted to a peer for every so many fresh writes.
+type interleaveRatio struct{ resends, writes int }
+
+// WithRetransmitInterleave has resends of unacked packets and fresh writes to each peer take turns while both compete
+// to be transmitted, at up to resends resends for every writes fresh writes. By default, every packet due to be resent
+// is resent on each update in one go regardless of fresh writes waiting. Resends then also draw from the rate limits
+// set using WithRateLimit and WithEndpointRateLimit, such that they share the same pacing budget as fresh writes.
+// Resends never wait on the rate limits, and are instead left for a later update should the rate limits be used up.
+func WithRetransmitInterleave(resends, writes int) Option {
+	if resends < 1 || writes < 1 {
+		panic("retransmit interleave ratio must be positive")
+	}
+	return withRetransmitInterleave{ratio: interleaveRatio{resends: resends, writes: writes}}
+}
+
+type withKeepAlivePeriod struct{ period time.Duration }
+
+func (o withKeepAlivePeriod) applyConn(c *Conn)         { c.keepAlivePeriod = o.period }
+func (o withKeepAlivePeriod) applyEndpoint(e *Endpoint) { e.keepAlivePeriod = o.period }
+
+// WithKeepAlivePeriod has each conn write a keepalive to its peer should nothing have been written to it for period,
+// such that idle peers keep hearing from each other and NAT mappings between them do not expire. Keepalives carry
+// the latest acks, and consume no sequence numbers. The period is jittered slightly, and is stretched while a conn is
+// in the background.
+func WithKeepAlivePeriod(period time.Duration) Option {
+	if period <= 0 {
+		panic("keepalive period must be positive")
+	}
+	return withKeepAlivePeriod{period: period}
+}
+
+type withInactivityTimeout struct{ timeout time.Duration }
+
+func (o withInactivityTimeout) applyConn(c *Conn)         { c.inactivityTimeout = o.timeout }
+func (o withInactivityTimeout) applyEndpoint(e *Endpoint) { e.inactivityTimeout = o.timeout }
+
+// WithInactivityTimeout fails each conn with ErrPeerTimeout should nothing be read from its peer for timeout,
+// notifying its peer and closing it, such that conns to peers that vanished do not linger. Only packets read from the
+// peer count as activity, so the timeout should be a few times the keepalive period of the peer.
+func WithInactivityTimeout(timeout time.Duration) Option {
+	if timeout <= 0 {
+		panic("inactivity timeout must be positive")
+	}
+	return withInactivityTimeout{timeout: timeout}
+}
+
+type withAdaptiveKeepAlive struct{ min, max time.Duration }
+
+func (o withAdaptiveKeepAlive) applyConn(c *Conn) {
+	c.keepAlivePeriod, c.keepAliveMin, c.keepAliveMax = o.min, o.min, o.max
+}
+func (o withAdaptiveKeepAlive) applyEndpoint(e *Endpoint) {
+	e.keepAlivePeriod, e.keepAliveMax = o.min, o.max
+}
+
+// WithAdaptiveKeepAlive has each conn write keepalives as WithKeepAlivePeriod does, though rather than at a fixed
+// period, it probes for how long the NAT binding between it and its peer lasts by growing the period from min up to
+// max while its peer is still heard from, and then settles on the longest period found to be safe. Probing relies on
+// the peer writing to the conn at least every min, such as by writing keepalives itself at a fixed period of min.
+func WithAdaptiveKeepAlive(min, max time.Duration) Option {
+	if min <= 0 || max < min {
+		panic("adaptive keepalive periods must be positive, with max being at least min")
+	}
+	return withAdaptiveKeepAlive{min: min, max: max}
+}
+
+type withFragmentSize struct{ fragmentSize int }
+
+func (o withFragmentSize) applyConn(c *Conn)         { c.fragmentSize = o.fragmentSize }
+func (o withFragmentSize) applyEndpoint(e *Endpoint) { e.fragmentSize = o.fragmentSize }
+
+// WithFragmentSize has payloads larger than fragmentSize bytes split into fragments of fragmentSize bytes, which are
+// each written as their own packet and reassembled by our peer before being delivered. It should be small enough
+// for a fragment along with its headers to fit within a single datagram on the path to our peer.
+func WithFragmentSize(fragmentSize int) Option {
+	if fragmentSize < 1 {
+		panic("fragment size must be positive")
+	}
+	return withFragmentSize{fragmentSize: fragmentSize}
+}
+
+type withReassemblyLimits struct{ limits ReassemblyLimits }
+
+func (o withReassemblyLimits) applyConn(c *Conn)         { c.reassembly = o.limits }
+func (o withReassemblyLimits) applyEndpoint(e *Endpoint) { l := o.limits; e.reassembly = &l }
+
+func WithReassemblyLimits(limits ReassemblyLimits) Option {
+	if limits.MaxFragments < 0 || limits.MaxFragments > MaxMessageFragments {
+		panic("max fragments must be between 0 and 256")
+	}
+	if limits.MaxMessages < 0 {
+		panic("max partial messages must not be negative")
+	}
+	if limits.Timeout < 0 {
+		panic("reassembly timeout must not be negative")
+	}
+	return withReassemblyLimits{limits: limits}
+}
+
+type withProgressWatchdog struct{ floor progressFloor }
+
+func (o withProgressWatchdog) applyConn(c *Conn)         { f := o.floor; c.watchdog = &f }
+func (o withProgressWatchdog) applyEndpoint(e *Endpoint) { f := o.floor; e.watchdog = &f }
+
+// WithProgressWatchdog fails fragmented payloads written or read at fewer than rate payload bytes per second over a
+// grace period, checked once per update. Writes of failed payloads give up on writing the rest of their fragments
+// and return a StalledTransferError, while partially read payloads are dropped and reported to the error handler.
+func WithProgressWatchdog(rate int, grace time.Duration) Option {
+	if rate <= 0 || grace <= 0 {
+		panic("progress floor rate and grace period must be positive")
+	}
+	return withProgressWatchdog{floor: progressFloor{rate: rate, grace: grace}}
+}
+
 type withPacketHandler struct{ ph PacketHandler }
 
 func (o withPacketHandler) applyConn(c *Conn)         { c.ph = o.ph }
@@ -61,6 +264,58 @@ func (o withPacketHandler) applyEndpoint(e *Endpoint) { e.ph = o.ph }
 
 func WithPacketHandler(ph PacketHandler) Option { return withPacketHandler{ph: ph} }
 
+type withAckHandler struct{ ah AckHandler }
+
+func (o withAckHandler) applyConn(c *Conn)         { c.ah = o.ah }
+func (o withAckHandler) applyEndpoint(e *Endpoint) { e.ah = o.ah }
+
+// WithAckHandler sets a handler that is called once for every reliable packet written the first time our peer acks
+// it, such as to learn once a message was delivered. Standalone acks are not handed to it, while each fragment of a
+// reliable payload split into fragments is.
+func WithAckHandler(ah AckHandler) Option { return withAckHandler{ah: ah} }
+
+type withBufferProvider struct{ provider BufferProvider }
+
+func (o withBufferProvider) applyConn(c *Conn)         { c.provider = o.provider }
+func (o withBufferProvider) applyEndpoint(e *Endpoint) { e.provider = o.provider }
+
+// WithBufferProvider sets a provider of application-owned memory that payloads are copied into before being delivered
+// to packet handlers, such that they outlive the handler.
+func WithBufferProvider(provider BufferProvider) Option {
+	return withBufferProvider{provider: provider}
+}
+
+type withRand struct{ rand *lockedRand }
+
+func (o withRand) applyConn(c *Conn)         { c.rand = o.rand }
+func (o withRand) applyEndpoint(e *Endpoint) { e.rand = o.rand }
+
+// WithRandSource sets the source of all randomness used by the protocol, such as for jittering timers, such that
+// simulations and tests may be made reproducible. An endpoint shares the source with all of its conns. By default, a
+// source seeded with the current time is used.
+func WithRandSource(src rand.Source) Option {
+	if src == nil {
+		panic("rand source must not be nil")
+	}
+	return withRand{rand: newLockedRand(src)}
+}
+
+type withReliablePacketHandler struct{ ph PacketHandler }
+
+func (o withReliablePacketHandler) applyConn(c *Conn)         { c.rph = o.ph }
+func (o withReliablePacketHandler) applyEndpoint(e *Endpoint) { e.rph = o.ph }
+
+// WithReliablePacketHandler sets a handler for reliable packets that is called in place of the packet handler.
+func WithReliablePacketHandler(ph PacketHandler) Option { return withReliablePacketHandler{ph: ph} }
+
+type withUnreliablePacketHandler struct{ ph PacketHandler }
+
+func (o withUnreliablePacketHandler) applyConn(c *Conn)         { c.uph = o.ph }
+func (o withUnreliablePacketHandler) applyEndpoint(e *Endpoint) { e.uph = o.ph }
+
+// WithUnreliablePacketHandler sets a handler for unreliable packets that is called in place of the packet handler.
+func WithUnreliablePacketHandler(ph PacketHandler) Option { return withUnreliablePacketHandler{ph: ph} }
+
 type withErrorHandler struct{ eh ErrorHandler }
 
 func (o withErrorHandler) applyConn(c *Conn)         { c.eh = o.eh }
@@ -82,12 +337,500 @@ func WithUpdatePeriod(updatePeriod time.Duration) Option {
 
 type withResendTimeout struct{ resendTimeout time.Duration }
 
-func (o withResendTimeout) applyConn(c *Conn)         { c.resendTimeout = o.resendTimeout }
+func (o withResendTimeout) applyConn(c *Conn) {
+	c.resendTimeout, c.fixedResendTimeout = o.resendTimeout, true
+}
 func (o withResendTimeout) applyEndpoint(e *Endpoint) { e.resendTimeout = o.resendTimeout }
 
+// WithResendTimeout fixes how long unacked packets go unacked before being resent, rather than deriving it from the
+// round-trip time to our peer.
 func WithResendTimeout(resendTimeout time.Duration) Option {
 	if resendTimeout == 0 {
 		panic("ack timeout of zero is not supported yet")
 	}
 	return withResendTimeout{resendTimeout: resendTimeout}
 }
+
+type withCongestionThresholdRTT struct{ rtt time.Duration }
+
+func (o withCongestionThresholdRTT) applyConn(c *Conn)         { c.badRTT = o.rtt }
+func (o withCongestionThresholdRTT) applyEndpoint(e *Endpoint) { e.badRTT = o.rtt }
+
+// WithCongestionThresholdRTT enables bad mode, which a conn is put into once the round-trip time to its peer exceeds
+// rtt or once more than a tenth of its reliable packets had to be resent. While in bad mode, half as many packets may
+// be in flight to the peer and unacked packets are resent half as often.
+func WithCongestionThresholdRTT(rtt time.Duration) Option {
+	if rtt <= 0 {
+		panic("congestion threshold rtt must be positive")
+	}
+	return withCongestionThresholdRTT{rtt: rtt}
+}
+
+type withBadModeRecoveryTime struct{ recovery time.Duration }
+
+func (o withBadModeRecoveryTime) applyConn(c *Conn)         { c.badRecovery = o.recovery }
+func (o withBadModeRecoveryTime) applyEndpoint(e *Endpoint) { e.badRecovery = o.recovery }
+
+// WithBadModeRecoveryTime sets how long conditions must stay good for a conn to leave bad mode.
+func WithBadModeRecoveryTime(recovery time.Duration) Option {
+	if recovery <= 0 {
+		panic("bad mode recovery time must be positive")
+	}
+	return withBadModeRecoveryTime{recovery: recovery}
+}
+
+type withMinResendTimeout struct{ minResendTimeout time.Duration }
+
+func (o withMinResendTimeout) applyConn(c *Conn)         { c.minResendTimeout = o.minResendTimeout }
+func (o withMinResendTimeout) applyEndpoint(e *Endpoint) { e.minResendTimeout = o.minResendTimeout }
+
+// WithMinResendTimeout sets the lower bound of resend timeouts derived from the round-trip time to our peer, which
+// also raises the initial resend timeout used until the round-trip time is sampled.
+func WithMinResendTimeout(minResendTimeout time.Duration) Option {
+	if minResendTimeout <= 0 {
+		panic("min resend timeout must be positive")
+	}
+	return withMinResendTimeout{minResendTimeout: minResendTimeout}
+}
+
+type withEventLogSize struct{ eventLogSize int }
+
+func (o withEventLogSize) applyConn(c *Conn)         { c.el = newEventLog(o.eventLogSize) }
+func (o withEventLogSize) applyEndpoint(e *Endpoint) { e.eventLogSize = o.eventLogSize }
+
+func WithEventLogSize(eventLogSize int) Option {
+	if eventLogSize <= 0 {
+		panic("event log size must be greater than zero")
+	}
+	return withEventLogSize{eventLogSize: eventLogSize}
+}
+
+type withSentHistorySize struct{ size int }
+
+func (o withSentHistorySize) applyConn(c *Conn)         { c.sh = newSentHistory(o.size) }
+func (o withSentHistorySize) applyEndpoint(e *Endpoint) { e.sentHistorySize = o.size }
+
+// WithSentHistorySize keeps records of the given number of most recent reliable packets written to each peer, which
+// may be read using Conn.SentHistory.
+func WithSentHistorySize(size int) Option {
+	if size <= 0 {
+		panic("sent history size must be greater than zero")
+	}
+	return withSentHistorySize{size: size}
+}
+
+type withScheduler struct{ sched Scheduler }
+
+func (o withScheduler) applyConn(c *Conn)         { c.sched = o.sched }
+func (o withScheduler) applyEndpoint(e *Endpoint) { e.sched = o.sched }
+
+func WithScheduler(sched Scheduler) Option { return withScheduler{sched: sched} }
+
+type withReadBatchSize struct{ readBatchSize int }
+
+func (o withReadBatchSize) applyEndpoint(e *Endpoint) { e.readBatchSize = o.readBatchSize }
+
+func WithReadBatchSize(readBatchSize int) EndpointOption {
+	if readBatchSize <= 0 {
+		panic("read batch size must be greater than zero")
+	}
+	return withReadBatchSize{readBatchSize: readBatchSize}
+}
+
+type withReadWorkers struct{ readWorkers int }
+
+func (o withReadWorkers) applyEndpoint(e *Endpoint) { e.readWorkers = o.readWorkers }
+
+func WithReadWorkers(readWorkers int) EndpointOption {
+	if readWorkers <= 0 {
+		panic("number of read workers must be greater than zero")
+	}
+	return withReadWorkers{readWorkers: readWorkers}
+}
+
+type withReadOrdering struct{ ordering ReadOrdering }
+
+func (o withReadOrdering) applyEndpoint(e *Endpoint) { e.readOrdering = o.ordering }
+
+// WithReadOrdering sets whether or not the datagrams read from a single peer are processed by read workers in the
+// order they were read. By default they are, such that packet handlers are invoked in order for each peer even for
+// unordered packets. ReadOrderingParallel instead has workers process datagrams from a single peer at once, trading
+// ordered handler invocation for throughput from peers that send a lot.
+func WithReadOrdering(ordering ReadOrdering) EndpointOption {
+	if ordering > ReadOrderingParallel {
+		panic("unknown read ordering")
+	}
+	return withReadOrdering{ordering: ordering}
+}
+
+type withReadQueueSize struct{ readQueueSize int }
+
+func (o withReadQueueSize) applyEndpoint(e *Endpoint) { e.readQueueSize = o.readQueueSize }
+
+func WithReadQueueSize(readQueueSize int) EndpointOption {
+	if readQueueSize <= 0 {
+		panic("read queue size must be greater than zero")
+	}
+	return withReadQueueSize{readQueueSize: readQueueSize}
+}
+
+type withAckDelay struct{ ackDelay time.Duration }
+
+func (o withAckDelay) applyEndpoint(e *Endpoint) { e.ackDelay = o.ackDelay }
+
+// WithAckDelay holds back standalone acks written by all conns of an endpoint for up to ackDelay, such that they are
+// written out in batches. It must be shorter than the update period of the endpoint, as acks held back for longer
+// would have our peers resend packets that were read. NewEndpoint panics otherwise.
+func WithAckDelay(ackDelay time.Duration) EndpointOption {
+	if ackDelay < 0 {
+		panic("ack delay must not be negative")
+	}
+	return withAckDelay{ackDelay: ackDelay}
+}
+
+type withThreadPinning struct{ pin ThreadPinner }
+
+func (o withThreadPinning) applyEndpoint(e *Endpoint) {
+	e.lockThreads = true
+	e.pin = o.pin
+}
+
+// WithThreadPinning locks the goroutine reading from the socket of an endpoint, and each of its read workers, to
+// their own OS thread. pin, if not nil, is then called from each of them such that it may set their CPU affinity.
+func WithThreadPinning(pin ThreadPinner) EndpointOption { return withThreadPinning{pin: pin} }
+
+type withAckBatcher struct{ ab *ackBatcher }
+
+func (o withAckBatcher) applyConn(c *Conn) { c.ab = o.ab }
+
+type withWriteStats struct{ ws *writeStats }
+
+func (o withWriteStats) applyConn(c *Conn) { c.ews = o.ws }
+
+type withTaps struct{ taps *tapSet }
+
+func (o withTaps) applyConn(c *Conn) { c.etaps = o.taps }
+
+type withTTL struct{ ttl int }
+
+func (o withTTL) applyEndpoint(e *Endpoint) { e.ttl = o.ttl }
+
+// WithTTL sets the IP TTL, or hop limit for IPv6, of all datagrams an endpoint writes. Should it fail to be set,
+// the error is reported to the error handler.
+func WithTTL(ttl int) EndpointOption {
+	if ttl <= 0 || ttl > 255 {
+		panic("ttl must be between 1 and 255")
+	}
+	return withTTL{ttl: ttl}
+}
+
+type withPlatformTuning struct{}
+
+func (o withPlatformTuning) applyEndpoint(e *Endpoint) { e.tunePlatform = true }
+
+func WithPlatformTuning() EndpointOption { return withPlatformTuning{} }
+
+type withSourcePinning struct{}
+
+func (o withSourcePinning) applyEndpoint(e *Endpoint) { e.pinning = true }
+
+// WithSourcePinning has an endpoint write datagrams to each peer from the local address the peer last sent a datagram
+// to, rather than from whichever address the routing table picks. This is needed by endpoints bound to the wildcard
+// address of a multi-homed host, whose replies would otherwise be dropped by peers for being sent from an address
+// they did not target. Errors enabling it, such as on platforms not supporting it, are reported to the error handler.
+func WithSourcePinning() EndpointOption { return withSourcePinning{} }
+
+type withAmplificationLimit struct{ amplification int }
+
+func (o withAmplificationLimit) applyConn(c *Conn)         { c.amplification = o.amplification }
+func (o withAmplificationLimit) applyEndpoint(e *Endpoint) { e.amplification = o.amplification }
+
+func WithAmplificationLimit(factor int) Option {
+	if factor <= 0 {
+		panic("amplification factor must be greater than zero")
+	}
+	return withAmplificationLimit{amplification: factor}
+}
+
+type withQuota struct{ quota Quota }
+
+func (o withQuota) applyConn(c *Conn)         { q := o.quota; c.quota = &q }
+func (o withQuota) applyEndpoint(e *Endpoint) { q := o.quota; e.quota = &q }
+
+func WithQuota(quota Quota) Option {
+	if quota.Interval < 0 {
+		panic("quota interval must not be negative")
+	}
+	return withQuota{quota: quota}
+}
+
+type withErrorBudget struct{ budget ErrorBudget }
+
+func (o withErrorBudget) applyConn(c *Conn)         { b := o.budget; c.budget = &b }
+func (o withErrorBudget) applyEndpoint(e *Endpoint) { b := o.budget; e.errorBudget = &b }
+
+// WithErrorBudget trips the circuit breaker of each conn should its peer run into more transmit errors or protocol
+// anomalies per interval than budget allows.
+func WithErrorBudget(budget ErrorBudget) Option {
+	if budget.Interval <= 0 {
+		panic("error budget interval must be positive")
+	}
+	if budget.Cooldown < 0 {
+		panic("error budget cooldown must not be negative")
+	}
+	if budget.TransmitErrors < 0 || budget.Anomalies < 0 {
+		panic("error budget limits must not be negative")
+	}
+	if budget.TransmitErrors == 0 && budget.Anomalies == 0 {
+		panic("error budget must limit transmit errors or anomalies")
+	}
+	return withErrorBudget{budget: budget}
+}
+
+type withRateLimit struct{ limit RateLimit }
+
+func (o withRateLimit) applyConn(c *Conn)         { c.limiter = newTokenBucket(o.limit) }
+func (o withRateLimit) applyEndpoint(e *Endpoint) { l := o.limit; e.rateLimit = &l }
+
+// WithRateLimit limits payload bytes written to each peer to rate bytes per second, while allowing up to burst
+// bytes to be written at once after having been idle.
+func WithRateLimit(rate float64, burst int) Option {
+	if rate <= 0 {
+		panic("rate limit must be positive")
+	}
+	if burst <= 0 {
+		panic("rate limit burst must be positive")
+	}
+	return withRateLimit{limit: RateLimit{Rate: rate, Burst: burst}}
+}
+
+type withEndpointRateLimit struct{ limit RateLimit }
+
+func (o withEndpointRateLimit) applyEndpoint(e *Endpoint) { e.limiter = newTokenBucket(o.limit) }
+
+// WithEndpointRateLimit limits payload bytes written to all peers combined to rate bytes per second, while allowing
+// up to burst bytes to be written at once after having been idle.
+func WithEndpointRateLimit(rate float64, burst int) EndpointOption {
+	if rate <= 0 {
+		panic("rate limit must be positive")
+	}
+	if burst <= 0 {
+		panic("rate limit burst must be positive")
+	}
+	return withEndpointRateLimit{limit: RateLimit{Rate: rate, Burst: burst}}
+}
+
+type withSharedRateLimit struct{ limiter *tokenBucket }
+
+func (o withSharedRateLimit) applyConn(c *Conn) { c.elimiter = o.limiter }
+
+type withAckSuppressionWindow struct{ window time.Duration }
+
+func (o withAckSuppressionWindow) applyConn(c *Conn)         { c.ackSuppression = o.window }
+func (o withAckSuppressionWindow) applyEndpoint(e *Endpoint) { e.ackSuppression = o.window }
+
+// WithAckSuppressionWindow sets how long standalone acks are held back for after Conn.ExpectWriteSoon is called.
+func WithAckSuppressionWindow(window time.Duration) Option {
+	if window <= 0 {
+		panic("ack suppression window must be positive")
+	}
+	return withAckSuppressionWindow{window: window}
+}
+
+type withDeliveryDelay struct{ ticks int }
+
+func (o withDeliveryDelay) applyConn(c *Conn)         { c.held = &heldPackets{delay: uint64(o.ticks)} }
+func (o withDeliveryDelay) applyEndpoint(e *Endpoint) { e.deliveryDelay = o.ticks }
+
+// WithDeliveryDelay holds back packets read from a peer from being delivered to their packet handlers until the
+// application calls Tick the given number of times, for deterministic lockstep simulations. Packets are delivered in
+// the order they were read.
+func WithDeliveryDelay(ticks int) Option {
+	if ticks <= 0 {
+		panic("delivery delay must be at least one tick")
+	}
+	return withDeliveryDelay{ticks: ticks}
+}
+
+type withResourceLimits struct{ limits ResourceLimits }
+
+func (o withResourceLimits) applyEndpoint(e *Endpoint) { e.resourceLimits = &o.limits }
+
+// WithResourceLimits bounds the number of conns, goroutines, pooled buffers, and bytes an endpoint and all of its
+// conns may hold onto at once. Once any of the limits are reached, packets read from new peers are dropped, and
+// writes to new peers fail with ErrResourceLimit. Conns are counted as holding onto what they held onto as of their
+// last update.
+func WithResourceLimits(limits ResourceLimits) EndpointOption {
+	if limits.MaxConns < 0 || limits.MaxGoroutines < 0 || limits.MaxBuffers < 0 || limits.MaxBytes < 0 {
+		panic("resource limits must not be negative")
+	}
+	return withResourceLimits{limits: limits}
+}
+
+type withConnIdleTimeout struct{ timeout time.Duration }
+
+func (o withConnIdleTimeout) applyEndpoint(e *Endpoint) { e.connIdleTimeout = o.timeout }
+
+// WithConnIdleTimeout has an endpoint evict conns that neither wrote nor read a packet for timeout, freeing up their
+// read and write queues and pooled buffers. Evicted peers are notified with DisconnectEvicted, and have a fresh conn
+// be created for them should they be written to or heard from again. The number of conns evicted is reported by
+// Endpoint.Evictions. Keepalives written or read count as packets, so conns kept alive are never evicted.
+func WithConnIdleTimeout(timeout time.Duration) EndpointOption {
+	if timeout <= 0 {
+		panic("conn idle timeout must be positive")
+	}
+	return withConnIdleTimeout{timeout: timeout}
+}
+
+type withOrderedDelivery struct{}
+
+func (o withOrderedDelivery) applyConn(c *Conn)         { c.ordered = &orderedPackets{} }
+func (o withOrderedDelivery) applyEndpoint(e *Endpoint) { e.orderedDelivery = true }
+
+// WithOrderedDelivery delivers reliable packets read from a peer to their packet handlers strictly in the order they
+// were sent, holding back those read ahead of a lost packet until it is resent. Unreliable packets are delivered as
+// soon as they are read. Delivery is not ordered for batch packet handlers.
+func WithOrderedDelivery() Option { return withOrderedDelivery{} }
+
+type withInitialWindowSize struct{ initialWindowSize uint16 }
+
+func (o withInitialWindowSize) applyConn(c *Conn)         { c.cwnd = o.initialWindowSize }
+func (o withInitialWindowSize) applyEndpoint(e *Endpoint) { e.initialWindowSize = o.initialWindowSize }
+
+func WithInitialWindowSize(initialWindowSize uint16) Option {
+	if initialWindowSize < ACKBitsetSize {
+		panic("initial window size must be at least the size of an ack bitset")
+	}
+	return withInitialWindowSize{initialWindowSize: initialWindowSize}
+}
+
+// WithoutSlowStart has the full read buffer of a peer be available to writes right away, which is useful on LANs.
+func WithoutSlowStart() Option { return withInitialWindowSize{initialWindowSize: math.MaxUint16} }
+
+type withBatchPacketHandler struct{ bph BatchPacketHandler }
+
+func (o withBatchPacketHandler) applyEndpoint(e *Endpoint) { e.bph = o.bph }
+
+// WithBatchPacketHandler sets a handler that is called once with all packets delivered from a peer out of a single
+// batch of datagrams read by an endpoint, in place of all other packet handlers.
+func WithBatchPacketHandler(bph BatchPacketHandler) EndpointOption {
+	return withBatchPacketHandler{bph: bph}
+}
+
+type withRawPacketHandler struct{ rawh RawPacketHandler }
+
+func (o withRawPacketHandler) applyEndpoint(e *Endpoint) { e.rawh = o.rawh }
+
+// WithRawPacketHandler sets a handler for raw datagrams, which start with RawPacketMarker, such that one socket may
+// serve both conns and connectionless traffic. By default, raw datagrams are treated as malformed packets.
+func WithRawPacketHandler(rawh RawPacketHandler) EndpointOption {
+	return withRawPacketHandler{rawh: rawh}
+}
+
+type withAckRanges struct{}
+
+func (o withAckRanges) applyConn(c *Conn)         { c.ackRanges = true }
+func (o withAckRanges) applyEndpoint(e *Endpoint) { e.ackRanges = true }
+
+// WithAckRanges has each conn describe which packets out of its entire read buffer it read to its peer once per
+// update using ack range frames, such that its peer stops resending packets whose acks were lost sooner on very lossy
+// links. Peers that do not know of ack range frames ignore them.
+func WithAckRanges() Option { return withAckRanges{} }
+
+type withPassiveRTT struct{ period time.Duration }
+
+func (o withPassiveRTT) applyConn(c *Conn)         { c.passiveRTTPeriod = o.period }
+func (o withPassiveRTT) applyEndpoint(e *Endpoint) { e.passiveRTTPeriod = o.period }
+
+// WithPassiveRTT has each conn sample the round-trip time to its peer using timestamps that its peer echoes should
+// acks not have sampled it for period, such as while the conn only reads from its peer and so writes no reliable
+// packets to be acked beyond standalone acks. Timestamps are only written while our peer is heard from. Peers echo
+// timestamps regardless of this option, while peers that do not know of timestamps ignore them.
+func WithPassiveRTT(period time.Duration) Option {
+	if period <= 0 {
+		panic("passive rtt period must be positive")
+	}
+	return withPassiveRTT{period: period}
+}
+
+type withUnsequencedAcks struct{}
+
+func (o withUnsequencedAcks) applyConn(c *Conn)         { c.unsequencedAcks = true }
+func (o withUnsequencedAcks) applyEndpoint(e *Endpoint) { e.unsequencedAcks = true }
+
+// WithUnsequencedAcks has standalone acks be written without consuming a sequence number. By default, standalone acks
+// of a full ack bitset consume one such that our peer acks them, and they are resent as is should they go unacked,
+// with acks written more eagerly until the newest packet read is acked again. Unsequenced acks take up no room in our
+// peer's read buffer and are never resent; should one be lost, our peer resends the packets it acked, and each
+// duplicate has an ack written for it straight away.
+func WithUnsequencedAcks() Option { return withUnsequencedAcks{} }
+
+type withProbeResponder struct{}
+
+func (o withProbeResponder) applyEndpoint(e *Endpoint) { e.probeResponder = true }
+
+// WithProbeResponder has an endpoint answer probes written by Endpoint.Probe from other endpoints without involving
+// the application, such that latency and clock offsets between endpoints may be measured using their own sockets.
+func WithProbeResponder() EndpointOption { return withProbeResponder{} }
+
+type withKeyer struct{ keyer Keyer }
+
+func (o withKeyer) applyEndpoint(e *Endpoint) { e.keyer = o.keyer }
+
+// WithKeyer sets how an endpoint maps the peers it reads datagrams from and writes packets to onto conns. By default,
+// every address gets its own conn.
+func WithKeyer(keyer Keyer) EndpointOption {
+	if keyer == nil {
+		panic("keyer must not be nil")
+	}
+	return withKeyer{keyer: keyer}
+}
+
+type withAckPolicy struct{ ackPolicy AckPolicy }
+
+func (o withAckPolicy) applyConn(c *Conn)         { c.ackPolicy = o.ackPolicy }
+func (o withAckPolicy) applyEndpoint(e *Endpoint) { e.ackPolicy = o.ackPolicy }
+
+func WithAckPolicy(ackPolicy AckPolicy) Option { return withAckPolicy{ackPolicy: ackPolicy} }
+
+type withQuotaExceededHook struct{ fn func() }
+
+func (o withQuotaExceededHook) applyConn(c *Conn) { c.onQuotaExceeded = o.fn }
+
+type withBreakerHook struct{ fn func(BreakerAction) }
+
+func (o withBreakerHook) applyConn(c *Conn) { c.onTripped = o.fn }
+
+type withResendsHook struct{ fn func() }
+
+func (o withResendsHook) applyConn(c *Conn) { c.onResendsExhausted = o.fn }
+
+type withInactivityHook struct{ fn func() }
+
+func (o withInactivityHook) applyConn(c *Conn) { c.onInactive = o.fn }
+
+type withUpdateHook struct{ fn func() }
+
+func (o withUpdateHook) applyConn(c *Conn) { c.onUpdate = o.fn }
+
+type withTokenHook struct{ fn func(ConnectToken) }
+
+func (o withTokenHook) applyConn(c *Conn) { c.onToken = o.fn }
+
+type withTokenKeyHook struct{ fn func(id uint32, retired bool) }
+
+func (o withTokenKeyHook) applyConn(c *Conn) { c.onTokenKey = o.fn }
+
+type withKeyring struct{ keyring *Keyring }
+
+func (o withKeyring) applyConn(c *Conn)         { c.keyring = o.keyring }
+func (o withKeyring) applyEndpoint(e *Endpoint) { e.keyring = o.keyring }
+
+// WithKeyring has connect tokens presented by peers verified against the keys of keyring. By default, presented
+// tokens are ignored.
+func WithKeyring(keyring *Keyring) Option {
+	if keyring == nil {
+		panic("keyring must not be nil")
+	}
+	return withKeyring{keyring: keyring}
+}
@@ -0,0 +1,145 @@
+package reliable
+
+import (
+	"sync"
+
+	"github.com/lithdew/reliable/sequence"
+)
+
+// orderedPacket is a reliable packet read from our peer ahead of a reliable packet our peer sent before it.
+type orderedPacket struct {
+	header PacketHeader
+	buf    []byte
+	pooled *Buffer // buffer buf was copied into, or nil should buf have been allocated
+	skip   bool    // whether or not the packet is not to be delivered, such as an ack or an unreassembled fragment
+}
+
+// orderedPackets holds back reliable packets read from our peer until all reliable packets our peer sent before them
+// were read, such that they are delivered strictly in the order they were sent.
+type orderedPackets struct {
+	mu       sync.Mutex
+	next     uint16                   // sequence number of the next reliable packet to be delivered
+	packets  map[uint16]orderedPacket // packets held back, keyed by sequence number
+	draining bool                     // whether or not a reader is delivering the packets that are no longer held back
+
+	// pending is the number of packets delivered that the application is yet to consume, which take up room in the
+	// window of packets that may be held back, if set.
+	pending func() int
+}
+
+// admitOrdered reports whether or not there is room to hold back the reliable packet described by header until it may
+// be delivered in order. Packets that are not admitted are dropped without being read, such that they get resent.
+func (c *Conn) admitOrdered(header PacketHeader) bool {
+	if c.ordered == nil || header.Unordered {
+		return true
+	}
+
+	c.ordered.mu.Lock()
+	defer c.ordered.mu.Unlock()
+
+	window := len(c.rq)
+	if c.ordered.pending != nil {
+		window -= c.ordered.pending()
+	}
+
+	return sequence.LT(header.Sequence, c.ordered.next) || int(header.Sequence-c.ordered.next) < window
+}
+
+// skipOrdered marks the reliable packet described by header as read without being delivered, such that the packets
+// read after it are not held back waiting for it. Packets that were not read, such as those dropped for being over
+// quota, are not marked, as our peer resends them.
+func (c *Conn) skipOrdered(header PacketHeader) {
+	if c.ordered == nil || header.Unordered {
+		return
+	}
+
+	c.mu.Lock()
+	read := c.rq[header.Sequence%uint16(len(c.rq))] == uint32(header.Sequence)
+	c.mu.Unlock()
+
+	if read {
+		c.order(orderedPacket{header: header, skip: true})
+	}
+}
+
+// order delivers p should it be the next packet to be delivered, along with the contiguous run of packets held back
+// after it. Otherwise, p is held back, having its payload copied into a pooled buffer as its payload is only valid
+// until order returns. Only one reader delivers packets at a time, and it does so without c.ordered.mu held, such
+// that packet handlers may call back into this conn.
+func (c *Conn) order(p orderedPacket) {
+	o := c.ordered
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	seq := p.header.Sequence
+
+	if _, held := o.packets[seq]; held || sequence.LT(seq, o.next) {
+		return
+	}
+
+	if seq != o.next || o.draining {
+		if o.packets == nil {
+			o.packets = make(map[uint16]orderedPacket)
+		}
+		o.packets[seq] = c.holdOrdered(p)
+		return
+	}
+
+	o.draining = true
+
+	for {
+		o.next++
+
+		o.mu.Unlock()
+		if !p.skip {
+			c.deliverNow(p.header, p.buf)
+		}
+		c.releaseOrdered(p)
+		o.mu.Lock()
+
+		var ok bool
+		if p, ok = o.packets[o.next]; !ok {
+			break
+		}
+		delete(o.packets, o.next)
+	}
+
+	o.draining = false
+}
+
+// holdOrdered copies the payload of p into a pooled buffer. Should the pool be exhausted, the payload is copied into
+// allocated memory instead, as the packet was already acked and may not be dropped.
+func (c *Conn) holdOrdered(p orderedPacket) orderedPacket {
+	if p.skip {
+		return p
+	}
+	if p.pooled = c.pool.Get(len(p.buf)); p.pooled != nil {
+		p.pooled.B = append(p.pooled.B[:0], p.buf...)
+		p.buf = p.pooled.B
+	} else {
+		p.buf = append(make([]byte, 0, len(p.buf)), p.buf...)
+	}
+	return p
+}
+
+func (c *Conn) releaseOrdered(p orderedPacket) {
+	if p.pooled != nil {
+		c.pool.Put(p.pooled)
+	}
+}
+
+// dropOrdered drops all packets held back from being delivered in order.
+func (c *Conn) dropOrdered() {
+	if c.ordered == nil {
+		return
+	}
+
+	c.ordered.mu.Lock()
+	defer c.ordered.mu.Unlock()
+
+	for seq, p := range c.ordered.packets {
+		c.releaseOrdered(p)
+		delete(c.ordered.packets, seq)
+	}
+}
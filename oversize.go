@@ -0,0 +1,68 @@
+package reliable
+
+import (
+	"context"
+	"net"
+)
+
+// OversizePolicy decides what a write does with a payload that is too large to fit in a single packet of the fragment
+// size set using WithFragmentSize. The latency consequences of each differ: fragments written on their own hold up
+// packets staged before them the least, while fragments staged alongside them take a single turn to write at once.
+type OversizePolicy uint8
+
+const (
+	OversizeFlush    OversizePolicy = iota // write out packets staged before the payload, and then its fragments
+	OversizeFragment                       // stage the fragments of the payload alongside packets staged before it
+	OversizeError                          // fail the write with ErrPacketTooLarge, writing nothing
+)
+
+// WriteReliablePacketPolicy writes buf reliably to our peer, applying policy should buf be too large to fit in a
+// single packet. As conns stage no packets, OversizeFlush and OversizeFragment both split buf into fragments.
+func (c *Conn) WriteReliablePacketPolicy(buf []byte, policy OversizePolicy) error {
+	return c.writePacketPolicy(true, buf, policy)
+}
+
+// WriteUnreliablePacketPolicy writes buf unreliably to our peer, applying policy should buf be too large to fit in a
+// single packet. As conns stage no packets, OversizeFlush and OversizeFragment both split buf into fragments.
+func (c *Conn) WriteUnreliablePacketPolicy(buf []byte, policy OversizePolicy) error {
+	return c.writePacketPolicy(false, buf, policy)
+}
+
+func (c *Conn) writePacketPolicy(reliable bool, buf []byte, policy OversizePolicy) error {
+	if policy == OversizeError && c.fragmented(len(buf)) {
+		return ErrPacketTooLarge
+	}
+	return c.writePacket(context.Background(), reliable, buf)
+}
+
+// WriteReliablePacketPolicy stages a copy of buf to be written as a reliable packet, applying policy should buf be
+// too large to fit in a single packet.
+func (w *Writer) WriteReliablePacketPolicy(buf []byte, policy OversizePolicy) error {
+	return w.stage(true, buf, policy)
+}
+
+// WriteUnreliablePacketPolicy stages a copy of buf to be written as an unreliable packet, applying policy should
+// buf be too large to fit in a single packet.
+func (w *Writer) WriteUnreliablePacketPolicy(buf []byte, policy OversizePolicy) error {
+	return w.stage(false, buf, policy)
+}
+
+func (e *Endpoint) WriteReliablePacketPolicy(buf []byte, addr net.Addr, policy OversizePolicy) error {
+	conn := e.getConn(addr, nil)
+	if conn == nil {
+		return e.errNoConn()
+	}
+	defer conn.unref()
+
+	return conn.WriteReliablePacketPolicy(buf, policy)
+}
+
+func (e *Endpoint) WriteUnreliablePacketPolicy(buf []byte, addr net.Addr, policy OversizePolicy) error {
+	conn := e.getConn(addr, nil)
+	if conn == nil {
+		return e.errNoConn()
+	}
+	defer conn.unref()
+
+	return conn.WriteUnreliablePacketPolicy(buf, policy)
+}
@@ -19,11 +19,20 @@ type writtenPacket struct {
 	buf     *Buffer   // pooled contents of this packet
 	acked   bool      // whether or not this packet was acked
 	written time.Time // last time the packet was written
+	sent    time.Time // first time the packet was written
+	ackedAt time.Time // when the packet was acked
 	resent  byte      // total number of times this packet was resent
+	ack     bool      // whether or not this packet is a standalone ack
 }
 
-func (p writtenPacket) shouldResend(now time.Time, resendTimeout time.Duration) bool {
-	return !p.acked && p.resent < 10 && now.Sub(p.written) >= resendTimeout
+func (p writtenPacket) shouldResend(now time.Time, resendTimeout time.Duration, maxResends byte) bool {
+	return !p.acked && p.resent < maxResends && now.Sub(p.written) >= resendTimeout
+}
+
+// exhausted reports whether or not this packet went unacked for a resend timeout after having been resent the max
+// number of times.
+func (p writtenPacket) exhausted(now time.Time, resendTimeout time.Duration, maxResends byte) bool {
+	return !p.acked && p.resent >= maxResends && now.Sub(p.written) >= resendTimeout
 }
 
 type PacketHeaderFlag uint8
@@ -57,6 +66,13 @@ type PacketHeader struct {
 	ACKBits   uint32
 	Unordered bool
 	Empty     bool
+
+	// Fragments of a payload split up for being too large carry a fragment header following the ack bitset.
+
+	Fragment      bool   // whether or not the payload is a fragment of a larger payload
+	FragmentID    uint16 // id of the payload the fragment belongs to
+	FragmentIndex uint8  // index of the fragment within its payload
+	FragmentLast  uint8  // index of the last fragment of its payload
 }
 
 func (p PacketHeader) AppendTo(dst []byte) []byte {
@@ -81,6 +97,9 @@ func (p PacketHeader) AppendTo(dst []byte) []byte {
 	if p.Unordered {
 		flag = flag.Toggle(FlagUnordered)
 	}
+	if p.Fragment {
+		flag = flag.Toggle(FlagFragment)
+	}
 
 	diff := int(p.Sequence) - int(p.ACK)
 	if diff < 0 {
@@ -124,6 +143,13 @@ func (p PacketHeader) AppendTo(dst []byte) []byte {
 		dst = append(dst, uint8((p.ACKBits&0xFF000000)>>24))
 	}
 
+	// Marshal fragment header.
+
+	if p.Fragment {
+		dst = bytesutil.AppendUint16BE(dst, p.FragmentID)
+		dst = append(dst, p.FragmentIndex, p.FragmentLast)
+	}
+
 	return dst
 }
 
@@ -138,11 +164,16 @@ func UnmarshalPacketHeader(buf []byte) (header PacketHeader, leftover []byte, er
 
 	flag, buf = PacketHeaderFlag(buf[0]), buf[1:]
 
-	if flag.Toggled(FlagFragment) {
-		return header, buf, io.ErrUnexpectedEOF
+	header.Empty = flag.Toggled(FlagEmpty)
+	header.Fragment = flag.Toggled(FlagFragment)
+
+	// Control packets are never split into fragments, such that the first byte of a packet is never that of a raw
+	// datagram.
+
+	if header.Fragment && header.Empty {
+		return header, buf, ErrInvalidFragment
 	}
 
-	header.Empty = flag.Toggled(FlagEmpty)
 	header.Unordered = flag.Toggled(FlagUnordered)
 
 	if header.Unordered {
@@ -200,5 +231,21 @@ func UnmarshalPacketHeader(buf []byte) (header PacketHeader, leftover []byte, er
 		buf = buf[1:]
 	}
 
+	// Read fragment header.
+
+	if header.Fragment {
+		if len(buf) < fragmentHeaderSize {
+			return header, buf, io.ErrUnexpectedEOF
+		}
+
+		header.FragmentID = bytesutil.Uint16BE(buf[:2])
+		header.FragmentIndex, header.FragmentLast = buf[2], buf[3]
+		buf = buf[fragmentHeaderSize:]
+
+		if header.FragmentIndex > header.FragmentLast {
+			return header, buf, ErrInvalidFragment
+		}
+	}
+
 	return header, buf, nil
 }
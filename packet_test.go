@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/valyala/bytebufferpool"
+	"io"
 	"math"
 	"testing"
 	"testing/quick"
@@ -22,6 +23,43 @@ func TestEncodeDecodePacketHeader(t *testing.T) {
 	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
 }
 
+func TestEncodeDecodeFragmentHeader(t *testing.T) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+
+	f := func(seq, ack uint16, ackBits uint32, unordered bool, id uint16, index, last uint8) bool {
+		if index > last {
+			index, last = last, index
+		}
+		if unordered {
+			seq = 0
+		}
+
+		header := PacketHeader{
+			Sequence: seq, ACK: ack, ACKBits: ackBits, Unordered: unordered,
+			Fragment: true, FragmentID: id, FragmentIndex: index, FragmentLast: last,
+		}
+		buf.B = append(header.AppendTo(buf.B[:0]), "payload"...)
+		recovered, leftover, err := UnmarshalPacketHeader(buf.B)
+
+		return assert.NoError(t, err) && assert.Equal(t, "payload", string(leftover)) && assert.EqualValues(t, header, recovered) &&
+			assert.NotEqual(t, RawPacketMarker, buf.B[0])
+	}
+
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+
+	// Fragment headers that are truncated or whose index lies past that of the last fragment are malformed.
+
+	wire := PacketHeader{Fragment: true, FragmentIndex: 1, FragmentLast: 1}.AppendTo(nil)
+
+	_, _, err := UnmarshalPacketHeader(wire[:len(wire)-1])
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	wire[len(wire)-1] = 0
+	_, _, err = UnmarshalPacketHeader(wire)
+	require.Equal(t, ErrInvalidFragment, err)
+}
+
 func BenchmarkMarshalPacketHeader(b *testing.B) {
 	header := PacketHeader{Sequence: math.MaxUint16, ACK: math.MaxUint16, ACKBits: math.MaxUint32}
 
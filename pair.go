@@ -0,0 +1,73 @@
+package reliable
+
+import (
+	"fmt"
+	"github.com/lithdew/reliable/reliabletest"
+	"math"
+	"net"
+	"sync"
+)
+
+// Pair creates two conns to one another over an in-memory network, with their Run loops started and with packets
+// read off of the network handed to them. opts are applied to both conns. cleanup closes both conns and waits for
+// all goroutines started by Pair to exit. Pair is meant for tests and examples.
+func Pair(opts ...ConnOption) (a, b *Conn, cleanup func()) {
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	a = NewConn(ca, cb.LocalAddr(), opts...)
+	b = NewConn(cb, ca.LocalAddr(), opts...)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	for _, pair := range []struct {
+		conn *Conn
+		pc   net.PacketConn
+	}{{a, ca}, {b, cb}} {
+		conn, pc := pair.conn, pair.pc
+
+		go func() {
+			defer wg.Done()
+			conn.Run()
+		}()
+
+		go func() {
+			defer wg.Done()
+			readPair(conn, pc)
+		}()
+	}
+
+	cleanup = func() {
+		a.Close()
+		b.Close()
+
+		_ = ca.Close()
+		_ = cb.Close()
+
+		wg.Wait()
+	}
+
+	return a, b, cleanup
+}
+
+// readPair hands packets read off of pc to conn until pc is closed.
+func readPair(conn *Conn, pc net.PacketConn) {
+	buf := make([]byte, math.MaxUint16+1)
+
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		header, payload, err := UnmarshalPacketHeader(buf[:n])
+		if err == nil {
+			err = conn.Read(header, payload)
+		}
+		if err != nil && !isEOF(err) {
+			conn.reportError(fmt.Errorf("failed to read packet: %w", err))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package reliable
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPair(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	recv := make(chan string, 16)
+
+	a, b, cleanup := Pair(WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) {
+		recv <- string(buf)
+	}))
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, a.WriteReliablePacket([]byte(strconv.Itoa(i))))
+	}
+	require.NoError(t, b.WriteReliablePacket([]byte("b")))
+
+	got := make(map[string]bool)
+	for len(got) < 5 {
+		select {
+		case buf := <-recv:
+			got[buf] = true
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out with %d of 5 packets delivered", len(got))
+		}
+	}
+	require.Equal(t, map[string]bool{"0": true, "1": true, "2": true, "3": true, "b": true}, got)
+}
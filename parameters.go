@@ -0,0 +1,70 @@
+package reliable
+
+import (
+	"net"
+	"time"
+)
+
+// Parameters describes what a conn is operating with as of now, as derived from conditions of the link to its peer
+// and from what its peer presented, as opposed to what it was configured with. Parameters are meant to be logged, such
+// that mismatches between what was configured and what is in effect may be debugged.
+type Parameters struct {
+	Window           uint16 // max number of packets that may be in flight to our peer
+	CongestionWindow uint16 // number of packets the window has grown to since the conn was created
+	PeerReadBuffer   uint16 // size of our peer's read buffer assumed, which bounds the window
+
+	RTT           time.Duration // smoothed round-trip time to our peer, or zero if not yet sampled
+	RTTVar        time.Duration // variation of the round-trip time to our peer, or zero if not yet sampled
+	ResendTimeout time.Duration // how long packets go unacked before being resent, with backoff and stretching applied
+
+	KeepAlivePeriod  time.Duration // how long nothing may be written to our peer before a keepalive is, or zero if disabled
+	KeepAliveSettled bool          // whether or not an adaptive keepalive period stopped being probed for
+	FragmentSize     int           // max size of fragments payloads written are split into, or zero if disabled
+
+	PowerState PowerState // power state this conn was last set to
+	BadMode    bool       // whether or not fewer packets may be in flight and resends are stretched for bad conditions
+	Validated  bool       // whether or not our peer proved that it is reachable at its address
+	AckRanges  bool       // whether or not ack range frames are written to our peer
+
+	TokenKey      uint32 // id of the token key our peer's connect token was minted with, if Authenticated
+	Authenticated bool   // whether or not our peer presented a valid connect token
+}
+
+// Parameters returns the parameters this conn is operating with as of now.
+func (c *Conn) Parameters() Parameters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Parameters{
+		Window:           c.window(),
+		CongestionWindow: c.cwnd,
+		PeerReadBuffer:   uint16(len(c.rq)),
+
+		RTT:           c.rtt,
+		RTTVar:        c.rttvar,
+		ResendTimeout: c.effectiveResendTimeout(),
+
+		KeepAlivePeriod:  c.effectiveKeepAlivePeriod(),
+		KeepAliveSettled: c.keepAliveSettled,
+		FragmentSize:     c.fragmentSize,
+
+		PowerState: c.power,
+		BadMode:    c.bad,
+		Validated:  c.validated,
+		AckRanges:  c.ackRanges,
+
+		TokenKey:      c.tokenKey,
+		Authenticated: c.token != nil,
+	}
+}
+
+// Parameters returns the parameters the conn to addr is operating with as of now, reporting false should there be no
+// conn to addr.
+func (e *Endpoint) Parameters(addr net.Addr) (Parameters, bool) {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return Parameters{}, false
+	}
+	return conn.Parameters(), true
+}
@@ -0,0 +1,90 @@
+package reliable
+
+import (
+	"fmt"
+	"github.com/lithdew/bytesutil"
+	"io"
+	"time"
+)
+
+// Timestamps are control packets that let a conn sample the round-trip time to its peer while it writes no reliable
+// packets for its peer to ack, such as while it only reads. A timestamp carries the unix time in nanoseconds it was
+// written at as a big-endian 64-bit integer, and is answered with a timestamp echo carrying the same time followed by
+// how long our peer held onto the timestamp before echoing it in nanoseconds, also as a big-endian 64-bit integer.
+
+const (
+	timestampSize     = 1 + 8
+	timestampEchoSize = 1 + 8 + 8
+)
+
+// writeTimestampOnUpdate writes a timestamp to our peer as of now should passive rtt sampling be enabled, should no
+// round-trip time have been sampled for the passive rtt period, and should our peer have been heard from since the
+// last timestamp was written, such that our peer no longer being around does not have timestamps written to it.
+func (c *Conn) writeTimestampOnUpdate(now time.Time) error {
+	c.mu.Lock()
+	if c.passiveRTTPeriod == 0 || c.die || now.Sub(c.rttSampled) < c.passiveRTTPeriod ||
+		now.Sub(c.tsWritten) < c.passiveRTTPeriod || !c.lr.After(c.tsWritten) {
+		c.mu.Unlock()
+		return nil
+	}
+	c.tsWritten = now
+	c.mu.Unlock()
+
+	buf := make([]byte, 0, timestampSize)
+	buf = append(buf, byte(controlTimestamp))
+	buf = bytesutil.AppendUint64BE(buf, uint64(now.UnixNano()))
+
+	if err := c.writeControl(buf); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write timestamp: %w", err)
+	}
+
+	return nil
+}
+
+// readTimestamp echoes a timestamp from our peer straight away.
+func (c *Conn) readTimestamp(buf []byte, received time.Time) error {
+	if len(buf) < 8 {
+		return fmt.Errorf("failed to read timestamp: %w", io.ErrUnexpectedEOF)
+	}
+
+	echo := make([]byte, 0, timestampEchoSize)
+	echo = append(echo, byte(controlTimestampEcho))
+	echo = append(echo, buf[:8]...)
+	echo = bytesutil.AppendUint64BE(echo, uint64(time.Since(received)))
+
+	if err := c.writeControl(echo); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to write timestamp echo: %w", err)
+	}
+
+	return nil
+}
+
+// readTimestampEcho samples the round-trip time to our peer off an echo of the last timestamp written to our peer,
+// not counting how long our peer held onto the timestamp. Echoes of any other timestamp are ignored, as they are either
+// stale or were never asked for.
+func (c *Conn) readTimestampEcho(buf []byte, received time.Time) error {
+	if len(buf) < 16 {
+		return fmt.Errorf("failed to read timestamp echo: %w", io.ErrUnexpectedEOF)
+	}
+
+	written := time.Unix(0, int64(bytesutil.Uint64BE(buf[:8])))
+	held := time.Duration(bytesutil.Uint64BE(buf[8:16]))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tsWritten.IsZero() || !written.Equal(c.tsWritten) {
+		return nil
+	}
+	c.tsWritten = time.Time{}
+
+	sample := received.Sub(written) - held
+	if sample <= 0 || held < 0 {
+		return nil
+	}
+
+	c.trackRTT(sample)
+	c.stats.PassiveRTTSamples++
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package reliable
+
+import (
+	"github.com/lithdew/bytesutil"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPassiveRTT(t *testing.T) {
+	pc := reliabletest.NewFaultConn(nil)
+
+	c := NewConn(pc, nil, WithPassiveRTT(10*time.Millisecond))
+
+	// A timestamp is written once the round-trip time went unsampled for the period, and at most once per period.
+
+	now := time.Now()
+	require.NoError(t, c.writeTimestampOnUpdate(now))
+	require.Equal(t, 1, pc.Writes())
+	require.NoError(t, c.writeTimestampOnUpdate(now.Add(5*time.Millisecond)))
+	require.Equal(t, 1, pc.Writes())
+
+	// Echoes sample the round-trip time, not counting how long our peer held onto the timestamp.
+
+	echo := bytesutil.AppendUint64BE(nil, uint64(now.UnixNano()))
+	echo = bytesutil.AppendUint64BE(echo, uint64(5*time.Millisecond))
+
+	require.NoError(t, c.readTimestampEcho(echo, now.Add(25*time.Millisecond)))
+	require.Equal(t, 20*time.Millisecond, c.RTT())
+	require.EqualValues(t, 1, c.Stats().PassiveRTTSamples)
+
+	// Stale echoes are ignored.
+
+	require.NoError(t, c.readTimestampEcho(echo, now.Add(50*time.Millisecond)))
+	require.EqualValues(t, 1, c.Stats().PassiveRTTSamples)
+
+	// No timestamp is written while the round-trip time was sampled recently, nor while our peer was not heard from
+	// since the last timestamp was written.
+
+	require.NoError(t, c.writeTimestampOnUpdate(time.Now()))
+	require.Equal(t, 1, pc.Writes())
+
+	require.NoError(t, c.writeTimestampOnUpdate(time.Now().Add(time.Hour)))
+	require.Equal(t, 2, pc.Writes())
+	require.NoError(t, c.writeTimestampOnUpdate(time.Now().Add(2*time.Hour)))
+	require.Equal(t, 2, pc.Writes())
+
+	// Timestamps from our peer are echoed straight away.
+
+	require.NoError(t, c.readTimestamp(echo[:8], time.Now()))
+	require.Equal(t, 3, pc.Writes())
+
+	require.Error(t, c.readTimestamp(echo[:7], time.Now()))
+	require.Error(t, c.readTimestampEcho(echo[:15], time.Now()))
+}
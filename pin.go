@@ -0,0 +1,105 @@
+package reliable
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+)
+
+// ErrSourcePinningUnsupported is returned when enabling source pinning on a socket that is not a UDP socket.
+var ErrSourcePinningUnsupported = errors.New("source pinning can only be enabled on udp sockets")
+
+// sourcePin is the local address our peer last sent a datagram to, which all datagrams written to our peer are then
+// sent from. This matters to sockets bound to the wildcard address of a multi-homed host, whose datagrams would
+// otherwise be sent from whichever address the routing table picks.
+type sourcePin struct {
+	ip  net.IP
+	oob []byte // control message setting the source address of a datagram to ip
+}
+
+// enableSourcePinning has conn report the destination address of every datagram read from it.
+func enableSourcePinning(conn net.PacketConn) error {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		return ErrSourcePinningUnsupported
+	}
+
+	if isIPv4(udp.LocalAddr()) {
+		if err := ipv4.NewPacketConn(udp).SetControlMessage(ipv4.FlagDst, true); err != nil {
+			return fmt.Errorf("failed to enable reading destination addresses: %w", err)
+		}
+		return nil
+	}
+
+	if err := ipv6.NewPacketConn(udp).SetControlMessage(ipv6.FlagDst, true); err != nil {
+		return fmt.Errorf("failed to enable reading destination addresses: %w", err)
+	}
+
+	return nil
+}
+
+// newDstBuffer returns a buffer large enough to read the control message carrying the destination address of a
+// datagram read from a socket bound to local.
+func newDstBuffer(local net.Addr) []byte {
+	if isIPv4(local) {
+		return ipv4.NewControlMessage(ipv4.FlagDst)
+	}
+	return ipv6.NewControlMessage(ipv6.FlagDst)
+}
+
+// parseDst returns the destination address carried by control message oob read alongside a datagram from a socket
+// bound to local, or nil should there be none.
+func parseDst(local net.Addr, oob []byte) net.IP {
+	if len(oob) == 0 {
+		return nil
+	}
+
+	if isIPv4(local) {
+		var cm ipv4.ControlMessage
+		if err := cm.Parse(oob); err != nil {
+			return nil
+		}
+		return cm.Dst
+	}
+
+	var cm ipv6.ControlMessage
+	if err := cm.Parse(oob); err != nil {
+		return nil
+	}
+	return cm.Dst
+}
+
+// pinSource has all datagrams written to our peer be sent from ip, should ip not already be pinned.
+func (c *Conn) pinSource(ip net.IP) {
+	if pin, _ := c.src.Load().(sourcePin); pin.ip.Equal(ip) {
+		return
+	}
+
+	var oob []byte
+	if isIPv4(c.conn.LocalAddr()) {
+		oob = (&ipv4.ControlMessage{Src: ip}).Marshal()
+	} else {
+		oob = (&ipv6.ControlMessage{Src: ip}).Marshal()
+	}
+
+	c.src.Store(sourcePin{ip: ip, oob: oob})
+}
+
+// sourceOOB returns the control message setting the source address of datagrams written to our peer, or nil should
+// no source address be pinned.
+func (c *Conn) sourceOOB() []byte {
+	pin, _ := c.src.Load().(sourcePin)
+	return pin.oob
+}
+
+// writeDatagram writes buf to addr over conn along with control message oob, which is nil should no source address
+// be pinned.
+func writeDatagram(conn net.PacketConn, buf, oob []byte, addr net.Addr) (int, error) {
+	if oob != nil {
+		n, _, err := conn.(*net.UDPConn).WriteMsgUDP(buf, oob, addr.(*net.UDPAddr))
+		return n, err
+	}
+	return conn.WriteTo(buf, addr)
+}
@@ -0,0 +1,50 @@
+package reliable
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// tunePlatform applies OS-specific fixes to the socket underlying conn:
+//
+//  1. On Windows, SIO_UDP_CONNRESET is disabled so that an ICMP port unreachable message from one peer does not fail
+//     the next read on a socket shared with other peers.
+//  2. On Linux and Android, path MTU discovery is enabled by having the don't-fragment bit set on all packets,
+//     including IPv4 packets written from dual-stack sockets.
+//  3. On Darwin and iOS, SO_NET_SERVICE_TYPE is set to mark packets as responsive multimedia traffic.
+//
+// On other platforms, or should conn not expose its underlying socket, nothing is done.
+func tunePlatform(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to get raw socket: %w", err)
+	}
+
+	var serr error
+
+	err = rc.Control(func(fd uintptr) {
+		serr = tuneSocket(fd, conn.LocalAddr())
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to access raw socket: %w", err)
+	}
+
+	if serr != nil {
+		return fmt.Errorf("failed to tune socket: %w", serr)
+	}
+
+	return nil
+}
+
+// isIPv4 reports whether or not addr is an IPv4 UDP address.
+func isIPv4(addr net.Addr) bool {
+	udp, ok := addr.(*net.UDPAddr)
+	return ok && udp.IP.To4() != nil
+}
@@ -0,0 +1,15 @@
+package reliable
+
+import (
+	"net"
+	"syscall"
+)
+
+const (
+	soNetServiceType = 0x1116 // SO_NET_SERVICE_TYPE
+	netServiceTypeRV = 5      // NET_SERVICE_TYPE_RV, responsive multimedia audio/video
+)
+
+func tuneSocket(fd uintptr, _ net.Addr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soNetServiceType, netServiceTypeRV)
+}
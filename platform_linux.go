@@ -0,0 +1,25 @@
+package reliable
+
+import (
+	"net"
+	"syscall"
+)
+
+func tuneSocket(fd uintptr, addr net.Addr) error {
+	if isIPv4(addr) {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}
+
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IP_PMTUDISC_DO); err != nil {
+		return err
+	}
+
+	// Dual-stack sockets also write to IPv4 peers, whose packets are only covered by IP_MTU_DISCOVER.
+
+	v6only, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY)
+	if err != nil || v6only != 0 {
+		return err
+	}
+
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+}
@@ -0,0 +1,36 @@
+package reliable
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestTuneSocketDualStack(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::]:0")
+	if err != nil {
+		t.Skipf("ipv6 is not available: %v", err)
+	}
+	defer conn.Close()
+
+	require.NoError(t, tunePlatform(conn))
+
+	// Path MTU discovery is enabled for both the IPv6 and IPv4 peers of a dual-stack socket.
+
+	rc, err := conn.(*net.UDPConn).SyscallConn()
+	require.NoError(t, err)
+
+	var v4, v6 int
+	var v4err, v6err error
+
+	require.NoError(t, rc.Control(func(fd uintptr) {
+		v4, v4err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+		v6, v6err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER)
+	}))
+
+	require.NoError(t, v4err)
+	require.NoError(t, v6err)
+	require.Equal(t, syscall.IP_PMTUDISC_DO, v4)
+	require.Equal(t, syscall.IP_PMTUDISC_DO, v6)
+}
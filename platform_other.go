@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package reliable
+
+import "net"
+
+func tuneSocket(uintptr, net.Addr) error { return nil }
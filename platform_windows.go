@@ -0,0 +1,26 @@
+package reliable
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const sioUDPConnReset = syscall.IOC_IN | syscall.IOC_VENDOR | 12 // SIO_UDP_CONNRESET
+
+func tuneSocket(fd uintptr, _ net.Addr) error {
+	flag := uint32(0)
+	ret := uint32(0)
+
+	return syscall.WSAIoctl(
+		syscall.Handle(fd),
+		sioUDPConnReset,
+		(*byte)(unsafe.Pointer(&flag)),
+		uint32(unsafe.Sizeof(flag)),
+		nil,
+		0,
+		&ret,
+		nil,
+		0,
+	)
+}
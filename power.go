@@ -0,0 +1,114 @@
+package reliable
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// PowerState is reported by applications to have a conn trade off latency for fewer radio wakeups while the
+// application is in the background.
+type PowerState uint8
+
+const (
+	PowerForeground PowerState = iota // packets are written immediately and unacked packets are resent aggressively
+	PowerBackground                   // packets are deferred to the next update and unacked packets are resent less often
+)
+
+// backgroundResendFactor is how many times longer the resend timeout is while a conn is in the background.
+const backgroundResendFactor = 4
+
+func (s PowerState) String() string {
+	switch s {
+	case PowerForeground:
+		return "foreground"
+	case PowerBackground:
+		return "background"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// SetPowerState sets the power state of this conn. While in the background, standalone acks and packets carrying
+// payloads are held back and written out together on the next update, in as few syscalls as the underlying socket
+// allows, and unacked packets are resent less often. Deferred packets are written out immediately once this conn is
+// brought back to the foreground.
+func (c *Conn) SetPowerState(state PowerState) error {
+	c.mu.Lock()
+	c.power = state
+	if state == PowerBackground && c.coalesced == nil {
+		c.coalesced = newAckBatcher(c.conn, c.pool, func(_ net.Addr, err error) { c.reportError(err) }, c.trackSyscall)
+	}
+	c.mu.Unlock()
+
+	if state != PowerForeground {
+		return nil
+	}
+
+	c.flushCoalesced()
+
+	return c.flushDeferredAcks()
+}
+
+// coalescer returns what packets carrying payloads are to be held back in until the next update, or nil should they
+// be written out immediately.
+func (c *Conn) coalescer() *ackBatcher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.power != PowerBackground {
+		return nil
+	}
+
+	return c.coalesced
+}
+
+// flushCoalesced writes out packets carrying payloads that were held back while in the background. Unreliable packets
+// that fail to be written are dropped, while reliable ones get resent.
+func (c *Conn) flushCoalesced() {
+	c.mu.Lock()
+	bg := c.coalesced
+	c.mu.Unlock()
+
+	if bg != nil {
+		bg.flush()
+	}
+}
+
+// dropCoalesced releases packets that were held back while in the background without writing them out.
+func (c *Conn) dropCoalesced() {
+	c.mu.Lock()
+	bg := c.coalesced
+	c.mu.Unlock()
+
+	if bg != nil {
+		bg.drop()
+	}
+}
+
+// deferAcks reports whether or not acks should be held back, either until the next update while in the background
+// or until the ack suppression window passes, marking them as deferred if so.
+func (c *Conn) deferAcks() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.power != PowerBackground && !c.acksSuppressed(time.Now()) {
+		return false
+	}
+	c.acksDeferred = true
+
+	return true
+}
+
+func (c *Conn) flushDeferredAcks() error {
+	c.mu.Lock()
+	deferred := c.acksDeferred
+	c.acksDeferred = false
+	c.mu.Unlock()
+
+	if !deferred {
+		return nil
+	}
+
+	return c.writeAcks()
+}
@@ -0,0 +1,92 @@
+package reliable
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrBuffersExhausted is returned by writes made while every preallocated buffer is in use.
+var ErrBuffersExhausted = errors.New("preallocated buffers exhausted")
+
+// ErrPacketTooLarge is returned by writes of packets that do not fit in a preallocated buffer.
+var ErrPacketTooLarge = errors.New("packet too large for preallocated buffers")
+
+// maxPacketHeaderSize is the max number of bytes a marshaled packet header takes up.
+const maxPacketHeaderSize = 1 + 2 + 2 + ACKBitsetSize/8
+
+// BufferPool hands out the buffers that packets are marshaled into and that datagrams read are queued up in, such
+// that applications may supply their own allocators. Get returns an empty buffer that size bytes are to be appended
+// to, or nil should no such buffer be available, in which case the write fails with ErrBuffersExhausted or the
+// datagram is dropped. Buffers are put back once they are no longer used. Pools must be safe for concurrent use.
+type BufferPool interface {
+	Get(size int) *Buffer
+	Put(b *Buffer)
+}
+
+// NewBufferPool returns a BufferPool backed by pool, which allocates buffers on demand and grows them as needed. It
+// is the default BufferPool, backed by a new Pool.
+func NewBufferPool(pool *Pool) BufferPool { return dynamicPool{pool: pool} }
+
+type dynamicPool struct{ pool *Pool }
+
+func (p dynamicPool) Get(int) *Buffer { return p.pool.Get() }
+func (p dynamicPool) Put(b *Buffer)   { p.pool.Put(b) }
+
+// fits reports whether or not n bytes fit into a single buffer of p, such that writes of packets too large for it
+// fail with ErrPacketTooLarge rather than ErrBuffersExhausted.
+func fits(p BufferPool, n int) bool {
+	if p, ok := p.(*fixedPool); ok {
+		return n <= p.size
+	}
+	return true
+}
+
+// fixedPool is a BufferPool of a fixed number of buffers of a fixed size, all carved out of a single slab that is
+// allocated up front, such that no buffers are ever allocated afterwards.
+type fixedPool struct {
+	size int
+
+	mu   sync.Mutex
+	free []*Buffer
+}
+
+func newFixedPool(count, size int) *fixedPool {
+	slab := make([]byte, count*size)
+	bufs := make([]Buffer, count)
+
+	p := &fixedPool{size: size, free: make([]*Buffer, count)}
+	for i := range bufs {
+		bufs[i].B = slab[i*size : i*size : (i+1)*size]
+		p.free[i] = &bufs[i]
+	}
+
+	return p
+}
+
+func (p *fixedPool) Get(size int) *Buffer {
+	if size > p.size {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.free) == 0 {
+		return nil
+	}
+
+	b := p.free[len(p.free)-1]
+	p.free[len(p.free)-1] = nil
+	p.free = p.free[:len(p.free)-1]
+
+	return b
+}
+
+func (p *fixedPool) Put(b *Buffer) {
+	b.B = b.B[:0]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.free = append(p.free, b)
+}
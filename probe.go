@@ -0,0 +1,135 @@
+package reliable
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Probes are raw datagrams that endpoints exchange to measure the latency and clock offset between them, such that a
+// fleet may be measured over the very sockets it serves traffic on. Probes are laid out as follows, with all integers
+// being big-endian:
+//
+//   marker   uint8  RawPacketMarker
+//   type     uint8  probeRequest or probeResponse
+//   id       uint32 id of the probe picked by the prober
+//   sent     int64  unix time in nanoseconds the prober sent the request at
+//   received int64  unix time in nanoseconds the responder received the request at, or zero in requests
+//   replied  int64  unix time in nanoseconds the responder sent the response at, or zero in requests
+//
+// Requests are as large as responses, such that responders never send more than they receive.
+
+const (
+	probeRequest  byte = 1
+	probeResponse byte = 2
+)
+
+const probeSize = 2 + 4 + 3*8
+
+// ProbeResult is the outcome of probing a peer.
+type ProbeResult struct {
+	RTT    time.Duration // round-trip time to the peer, not counting how long the peer took to respond
+	Offset time.Duration // how far ahead the clock of the peer is of ours, assuming symmetric paths
+}
+
+type probeReply struct {
+	received time.Time // when the response was read
+	buf      []byte    // copy of the response
+}
+
+// dispatchProbe answers buf should buf be a probe request and should this endpoint respond to probes, or hands buf off
+// to the prober waiting on it should buf be a probe response. It reports whether or not buf was a probe.
+func (e *Endpoint) dispatchProbe(addr net.Addr, buf []byte) bool {
+	if len(buf) != probeSize || !IsRawPacket(buf) {
+		return false
+	}
+
+	switch buf[1] {
+	case probeRequest:
+		if !e.probeResponder {
+			return false
+		}
+
+		received := time.Now()
+
+		resp := make([]byte, probeSize)
+		copy(resp, buf[:2+4+8])
+		resp[1] = probeResponse
+		binary.BigEndian.PutUint64(resp[14:], uint64(received.UnixNano()))
+		binary.BigEndian.PutUint64(resp[22:], uint64(time.Now().UnixNano()))
+
+		if err := e.WriteRawPacket(resp, addr); err != nil && e.eh != nil {
+			e.eh(addr, fmt.Errorf("failed to respond to probe: %w", err))
+		}
+
+		return true
+	case probeResponse:
+		e.pmu.Lock()
+		ch, ok := e.probes[binary.BigEndian.Uint32(buf[2:])]
+		e.pmu.Unlock()
+
+		if !ok {
+			return false
+		}
+
+		select {
+		case ch <- probeReply{received: time.Now(), buf: append([]byte(nil), buf...)}:
+		default:
+		}
+
+		return true
+	default:
+		return false
+	}
+}
+
+// Probe measures the round-trip time and clock offset to the endpoint at addr, which must respond to probes. It
+// returns ctx.Err() should ctx be done before a response arrives. As probes are sent once and unreliably, callers
+// should give up on them after a timeout, and probe a few times to average out jitter.
+func (e *Endpoint) Probe(ctx context.Context, addr net.Addr) (ProbeResult, error) {
+	ch := make(chan probeReply, 1)
+
+	e.pmu.Lock()
+	if e.probes == nil {
+		e.probes = make(map[uint32]chan probeReply)
+	}
+	id := e.nextProbe
+	e.nextProbe++
+	e.probes[id] = ch
+	e.pmu.Unlock()
+
+	defer func() {
+		e.pmu.Lock()
+		delete(e.probes, id)
+		e.pmu.Unlock()
+	}()
+
+	sent := time.Now()
+
+	req := make([]byte, probeSize)
+	req[0], req[1] = RawPacketMarker, probeRequest
+	binary.BigEndian.PutUint32(req[2:], id)
+	binary.BigEndian.PutUint64(req[6:], uint64(sent.UnixNano()))
+
+	if err := e.WriteRawPacket(req, addr); err != nil {
+		return ProbeResult{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ProbeResult{}, ctx.Err()
+	case reply := <-ch:
+		received := int64(binary.BigEndian.Uint64(reply.buf[14:]))
+		replied := int64(binary.BigEndian.Uint64(reply.buf[22:]))
+
+		// The round-trip time is measured off of our monotonic clock, while the offset is measured off of the wall
+		// clocks of both ends, as in NTP.
+
+		held := time.Duration(replied - received)
+		offset := (time.Duration(received-sent.UnixNano()) + time.Duration(replied-reply.received.UnixNano())) / 2
+
+		return ProbeResult{RTT: reply.received.Sub(sent) - held, Offset: offset}, nil
+	}
+}
@@ -0,0 +1,129 @@
+package reliable
+
+import (
+	"fmt"
+	"time"
+)
+
+// Profile is a preset of options tuned for a kind of workload. A profile is only a starting point: options passed
+// after a profile override the options it presets.
+type Profile uint8
+
+const (
+	ProfileDefault  Profile = iota // low latency for interactive workloads, being the defaults of every option
+	ProfileBulk                    // throughput over latency for bulk transfers, such as pushing files
+	ProfileRealtime                // the lowest latency possible for realtime workloads, such as multiplayer games
+	ProfileLossy                   // quick recovery from burst losses, such as the outages of Wi-Fi networks
+)
+
+func (p Profile) String() string {
+	switch p {
+	case ProfileDefault:
+		return "default"
+	case ProfileBulk:
+		return "bulk"
+	case ProfileRealtime:
+		return "realtime"
+	case ProfileLossy:
+		return "lossy"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(p))
+	}
+}
+
+// options returns the options preset by this profile. Options that only apply to endpoints are skipped when the
+// profile is applied to a conn.
+func (p Profile) options() []EndpointOption {
+	switch p {
+	case ProfileBulk:
+		return []EndpointOption{
+			// Write acks for all peers out in batches, and only ack full ack bitsets right away, leaving the rest
+			// to be piggybacked or written on the next update.
+
+			WithAckDelay(1 * time.Millisecond),
+			WithAckPolicy(DelayedAckPolicy{}),
+
+			// Fill our peer's read buffer right away, and give delayed acks time to arrive before resending, while
+			// still deriving the resend timeout from the round-trip time.
+
+			WithoutSlowStart(),
+			WithMinResendTimeout(DefaultMinResendTimeout + 2*DefaultUpdatePeriod),
+
+			// Read more datagrams from the socket per syscall.
+
+			WithReadBatchSize(4 * DefaultReadBatchSize),
+		}
+	case ProfileRealtime:
+		return []EndpointOption{
+			// Ack every packet right away, and only hold back acks for a moment should a write be expected soon.
+
+			WithAckDelay(0),
+			WithAckPolicy(EveryPacketAckPolicy{}),
+			WithAckSuppressionWindow(1 * time.Millisecond),
+
+			// Check for and resend lost packets sooner, and keep few packets in flight to a fresh peer.
+
+			WithUpdatePeriod(DefaultUpdatePeriod / 10),
+			WithMinResendTimeout(DefaultMinResendTimeout / 2),
+			WithInitialWindowSize(ACKBitsetSize),
+		}
+	case ProfileLossy:
+		return []EndpointOption{
+			// Describe which packets were read out of the entire read buffer, such that packets whose acks were lost
+			// during an outage stop being resent as soon as it is over.
+
+			WithAckRanges(),
+
+			// Keep more packets in flight and acked, such that writes do not stall on packets lost during an outage.
+
+			WithReadBufferSize(4 * DefaultReadBufferSize),
+			WithWriteBufferSize(4 * DefaultWriteBufferSize),
+
+			// Check for lost packets more often, such that recovery starts soon after an outage is over, while
+			// resending packets that went unacked during it a read buffer at a time rather than all at once.
+
+			WithUpdatePeriod(DefaultUpdatePeriod / 4),
+			WithResendPacing(int(DefaultReadBufferSize)),
+
+			// Keep on resending packets through back-to-back outages rather than giving up on them after a few
+			// resends, failing the conn should a packet go unacked for too long instead.
+
+			WithMaxPacketResends(255),
+		}
+	default:
+		return nil
+	}
+}
+
+// checkProfile panics should a profile not be the first option passed, as it would otherwise silently override the
+// options passed before it.
+func checkProfile(i int, opt interface{}) {
+	if _, ok := opt.(withProfile); ok && i > 0 {
+		panic("profile must be passed before any other option")
+	}
+}
+
+type withProfile struct{ profile Profile }
+
+func (o withProfile) applyConn(c *Conn) {
+	for _, opt := range o.profile.options() {
+		if opt, ok := opt.(ConnOption); ok {
+			opt.applyConn(c)
+		}
+	}
+}
+
+func (o withProfile) applyEndpoint(e *Endpoint) {
+	for _, opt := range o.profile.options() {
+		opt.applyEndpoint(e)
+	}
+}
+
+// WithProfile presets options tuned for a kind of workload. It must be passed before any other option, such that
+// the options passed after it override the options it presets.
+func WithProfile(profile Profile) Option {
+	if profile > ProfileLossy {
+		panic("unknown profile")
+	}
+	return withProfile{profile: profile}
+}
@@ -0,0 +1,124 @@
+package reliable
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProfileBulk(t *testing.T) {
+	conn := reliabletest.NewNetwork(0).Listen()
+	defer conn.Close()
+
+	e := NewEndpoint(conn, WithProfile(ProfileBulk))
+	require.Equal(t, 1*time.Millisecond, e.ackDelay)
+	require.NotNil(t, e.ab)
+	require.Equal(t, 4*DefaultReadBatchSize, e.readBatchSize)
+
+	c := NewConn(nil, nil, WithProfile(ProfileBulk))
+	require.Equal(t, DelayedAckPolicy{}, c.ackPolicy)
+	require.EqualValues(t, len(c.rq), c.window())
+	require.Equal(t, DefaultMinResendTimeout+2*DefaultUpdatePeriod, c.minResendTimeout)
+
+	// The resend timeout is still derived from the round-trip time, rather than fixed.
+
+	require.False(t, c.fixedResendTimeout)
+	require.Equal(t, DefaultMinResendTimeout+2*DefaultUpdatePeriod, c.currentResendTimeout())
+
+	c.trackRTT(200 * time.Millisecond)
+	require.Greater(t, int64(c.currentResendTimeout()), int64(DefaultMinResendTimeout+2*DefaultUpdatePeriod))
+}
+
+func TestProfileIsOverriddenByLaterOptions(t *testing.T) {
+	c := NewConn(nil, nil, WithProfile(ProfileBulk), WithResendTimeout(10*time.Millisecond), WithAckPolicy(BitsetAckPolicy{}))
+	require.Equal(t, 10*time.Millisecond, c.resendTimeout)
+	require.Equal(t, BitsetAckPolicy{}, c.ackPolicy)
+
+	c = NewConn(nil, nil, WithProfile(ProfileDefault))
+	require.Equal(t, DefaultResendTimeout, c.resendTimeout)
+	require.EqualValues(t, DefaultInitialWindowSize, c.window())
+
+	require.Panics(t, func() { WithProfile(ProfileLossy + 1) })
+
+	// Profiles are starting points, and may not override options passed before them.
+
+	require.Panics(t, func() { NewConn(nil, nil, WithResendTimeout(10*time.Millisecond), WithProfile(ProfileBulk)) })
+}
+
+func TestProfileRealtime(t *testing.T) {
+	conn := reliabletest.NewNetwork(0).Listen()
+	defer conn.Close()
+
+	e := NewEndpoint(conn, WithAckDelay(1*time.Millisecond))
+	require.NotNil(t, e.ab)
+
+	e = NewEndpoint(conn, WithProfile(ProfileRealtime))
+	require.Zero(t, e.ackDelay)
+	require.Nil(t, e.ab)
+
+	c := NewConn(nil, nil, WithProfile(ProfileRealtime))
+	require.Equal(t, EveryPacketAckPolicy{}, c.ackPolicy)
+	require.Equal(t, 1*time.Millisecond, c.ackSuppression)
+	require.Equal(t, DefaultUpdatePeriod/10, c.updatePeriod)
+	require.Equal(t, DefaultMinResendTimeout/2, c.minResendTimeout)
+	require.False(t, c.fixedResendTimeout)
+	require.EqualValues(t, ACKBitsetSize, c.window())
+}
+
+func TestProfileLossy(t *testing.T) {
+	c := NewConn(nil, nil, WithProfile(ProfileLossy))
+	require.True(t, c.ackRanges)
+	require.Len(t, c.rq, int(4*DefaultReadBufferSize))
+	require.Len(t, c.wq, int(4*DefaultWriteBufferSize))
+	require.Equal(t, DefaultUpdatePeriod/4, c.updatePeriod)
+	require.Equal(t, int(DefaultReadBufferSize), c.resendPacing)
+	require.True(t, c.failResends)
+	require.EqualValues(t, 255, c.maxResends)
+}
+
+func TestProfileLossyRecoversFromBurstLoss(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	link := reliabletest.WiFiBurstLoss(200 * time.Millisecond)
+	network.SetLink(ca.LocalAddr(), cb.LocalAddr(), link)
+	network.SetLink(cb.LocalAddr(), ca.LocalAddr(), link)
+
+	var received uint64
+
+	a := NewEndpoint(ca, WithProfile(ProfileLossy))
+	b := NewEndpoint(cb, WithProfile(ProfileLossy), WithPacketHandler(func(net.Addr, uint16, []byte) {
+		atomic.AddUint64(&received, 1)
+	}))
+
+	go a.Listen()
+	go b.Listen()
+
+	// Packets are written for long enough to run into a few outages.
+
+	count := uint64(0)
+	for start := time.Now(); time.Since(start) < 1*time.Second; count++ {
+		require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadUint64(&received) == count }, 10*time.Second, time.Millisecond)
+
+	// Packets were lost to outages, and acked using ack range frames.
+
+	require.NotZero(t, a.lookupConn(cb.LocalAddr()).Stats().Overhead.ResentBytes)
+	require.NotZero(t, b.lookupConn(ca.LocalAddr()).Stats().AckRangeFrames)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
@@ -0,0 +1,112 @@
+package reliable
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StalledTransferError is returned by writes of fragmented payloads, and reported to the error handler for payloads
+// partially read, whose throughput stayed under the progress floor set using WithProgressWatchdog for its grace
+// period, such that a dead transfer does not hold onto reassembly memory and window space indefinitely.
+type StalledTransferError struct {
+	ID    uint16 // id of the fragmented payload
+	Bytes int    // number of payload bytes written or read before the transfer was failed
+}
+
+func (e *StalledTransferError) Error() string {
+	return fmt.Sprintf("transfer of fragmented payload %d stalled after %d bytes", e.ID, e.Bytes)
+}
+
+// progressFloor is the min throughput fragmented payloads may be written or read at for longer than grace.
+type progressFloor struct {
+	rate  int           // min number of payload bytes per second
+	grace time.Duration // how long throughput may stay under rate
+}
+
+// progress tracks the throughput of a transfer over grace periods.
+type progress struct {
+	since time.Time // when the current grace period started
+	base  int       // number of payload bytes transferred as of since
+}
+
+// stalled reports whether or not fewer than the floor's worth of bytes were transferred over the grace period that
+// ended as of now, given that bytes were transferred so far, starting a new grace period otherwise.
+func (f *progressFloor) stalled(p *progress, bytes int, now time.Time) bool {
+	elapsed := now.Sub(p.since)
+	if elapsed < f.grace {
+		return false
+	}
+	if float64(bytes-p.base) < float64(f.rate)*elapsed.Seconds() {
+		return true
+	}
+	p.since, p.base = now, bytes
+	return false
+}
+
+// transfer is a fragmented payload being written to our peer.
+type transfer struct {
+	progress
+	bytes   int                // number of payload bytes written so far
+	stalled bool               // whether or not the watchdog failed this transfer
+	cancel  context.CancelFunc // gives up on writing the rest of the fragments
+}
+
+// watchTransfer registers a fragmented payload with the given id about to be written to our peer with the watchdog
+// should one be set, returning a context that is done once the watchdog fails it along with a func to be called
+// once the payload is written.
+func (c *Conn) watchTransfer(ctx context.Context, id uint16) (context.Context, *transfer, func()) {
+	if c.watchdog == nil {
+		return ctx, nil, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := &transfer{progress: progress{since: time.Now()}, cancel: cancel}
+
+	c.mu.Lock()
+	if c.transfers == nil {
+		c.transfers = make(map[uint16]*transfer)
+	}
+	c.transfers[id] = t
+	c.mu.Unlock()
+
+	return ctx, t, func() {
+		c.mu.Lock()
+		delete(c.transfers, id)
+		c.mu.Unlock()
+
+		cancel()
+	}
+}
+
+// checkProgress fails fragmented payloads being written or partially read whose throughput stayed under the progress
+// floor for its grace period as of now. Writes of failed payloads give up on writing the rest of their fragments,
+// while partially read payloads are dropped and reported to the error handler.
+func (c *Conn) checkProgress(now time.Time) {
+	if c.watchdog == nil {
+		return
+	}
+
+	var stalled []*StalledTransferError
+
+	c.mu.Lock()
+	for _, t := range c.transfers {
+		if !t.stalled && c.watchdog.stalled(&t.progress, t.bytes, now) {
+			t.stalled = true
+			t.cancel()
+			c.stats.TransfersStalled++
+		}
+	}
+	for id, p := range c.partial {
+		if !p.aborted && c.watchdog.stalled(&p.progress, p.size, now) {
+			delete(c.partial, id)
+			c.stats.TransfersStalled++
+			stalled = append(stalled, &StalledTransferError{ID: id, Bytes: p.size})
+		}
+	}
+	c.mu.Unlock()
+
+	for _, err := range stalled {
+		c.reportError(err)
+	}
+}
@@ -0,0 +1,141 @@
+package reliable
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ReadOrdering decides whether or not the datagrams read from a single peer are processed by the read workers of an
+// endpoint in the order they were read, and so whether or not packet handlers are invoked for them in that order.
+type ReadOrdering uint8
+
+const (
+	ReadOrderingFIFO     ReadOrdering = iota // datagrams from a single peer are processed one worker at a time, in order
+	ReadOrderingParallel                     // datagrams from a single peer may be processed by many workers at once
+)
+
+func (o ReadOrdering) String() string {
+	switch o {
+	case ReadOrderingFIFO:
+		return "fifo"
+	case ReadOrderingParallel:
+		return "parallel"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(o))
+	}
+}
+
+// readQueue holds datagrams read by an Endpoint for a single conn that have yet to be processed.
+type readQueue struct {
+	mu        sync.Mutex
+	bufs      []*Buffer
+	scheduled bool // whether or not this queue is awaiting or being processed by a worker
+}
+
+// push queues up buf, reporting whether or not buf was queued, and whether or not this queue needs to be scheduled
+// to be processed by a worker.
+func (q *readQueue) push(buf *Buffer, limit int) (queued bool, schedule bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.bufs) >= limit {
+		return false, false
+	}
+
+	q.bufs = append(q.bufs, buf)
+
+	if q.scheduled {
+		return true, false
+	}
+	q.scheduled = true
+
+	return true, true
+}
+
+// drain moves all queued datagrams into dst.
+func (q *readQueue) drain(dst []*Buffer) []*Buffer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dst = append(dst, q.bufs...)
+	for i := range q.bufs {
+		q.bufs[i] = nil
+	}
+	q.bufs = q.bufs[:0]
+
+	return dst
+}
+
+// pop dequeues the oldest queued datagram, reporting whether or not datagrams remain queued, in which case the queue
+// remains scheduled.
+func (q *readQueue) pop() (buf *Buffer, pending bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	buf = q.bufs[0]
+	q.bufs[0] = nil
+	q.bufs = q.bufs[1:]
+
+	pending = len(q.bufs) > 0
+	q.scheduled = pending
+
+	return buf, pending
+}
+
+// done marks the queue as no longer being processed, reporting whether or not datagrams have been queued up in the
+// meantime, in which case the queue remains scheduled.
+func (q *readQueue) done() (pending bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending = len(q.bufs) > 0
+	q.scheduled = pending
+
+	return pending
+}
+
+// readScheduler hands out conns that have datagrams queued up to workers in the order they were scheduled. A conn is
+// only ever scheduled once at a time, so that datagrams from a single peer are processed in the order they were read.
+// Workers are able to call schedule while the scheduler is closing, such that queued datagrams are never dropped.
+type readScheduler struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	conns []*Conn
+	die   bool
+}
+
+func (s *readScheduler) schedule(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns = append(s.conns, conn)
+	s.cond.Signal()
+}
+
+func (s *readScheduler) next() (*Conn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.die && len(s.conns) == 0 {
+		s.cond.Wait()
+	}
+
+	if len(s.conns) == 0 {
+		return nil, false
+	}
+
+	conn := s.conns[0]
+	s.conns[0] = nil
+	s.conns = s.conns[1:]
+
+	return conn, true
+}
+
+// close stops all workers once every conn awaiting a worker has been processed.
+func (s *readScheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.die = true
+	s.cond.Broadcast()
+}
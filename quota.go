@@ -0,0 +1,84 @@
+package reliable
+
+import (
+	"net"
+	"time"
+)
+
+type QuotaAction uint8
+
+const (
+	QuotaThrottle   QuotaAction = iota // refuse writes and drop reads until the quota resets
+	QuotaDisconnect                    // close the conn
+)
+
+// Quota bounds the number of payload bytes that may be written to and read from a peer, either over the lifetime
+// of a conn or per interval.
+type Quota struct {
+	SendBytes uint64        // max number of payload bytes that may be written, or zero if unlimited
+	RecvBytes uint64        // max number of payload bytes that may be read, or zero if unlimited
+	Interval  time.Duration // interval after which usage resets, or zero should the quota be for the conn's lifetime
+
+	// OnExceeded is called once per interval the first time a quota is exceeded, and decides what happens to the
+	// conn for the rest of the interval. Should it be nil, the conn is throttled.
+	OnExceeded func(addr net.Addr, usage QuotaUsage) QuotaAction
+}
+
+type QuotaUsage struct {
+	SendBytes uint64    // payload bytes written in the current interval
+	RecvBytes uint64    // payload bytes read in the current interval
+	Since     time.Time // when the current interval started
+}
+
+// chargeQuota charges n payload bytes that are about to be written or read against the quota of this conn,
+// reporting whether or not they are allowed, and whether or not this conn should be closed.
+func (c *Conn) chargeQuota(send bool, n int) (allowed bool, disconnect bool) {
+	c.mu.Lock()
+
+	if c.quota == nil {
+		c.mu.Unlock()
+		return true, false
+	}
+
+	now := time.Now()
+	if c.quota.Interval > 0 && now.Sub(c.quotaUsage.Since) >= c.quota.Interval {
+		c.quotaUsage = QuotaUsage{Since: now}
+		c.quotaExceeded = false
+	}
+
+	if c.quotaExceeded {
+		action := c.quotaAction
+		c.mu.Unlock()
+		return false, action == QuotaDisconnect
+	}
+
+	usage, limit := &c.quotaUsage.RecvBytes, c.quota.RecvBytes
+	if send {
+		usage, limit = &c.quotaUsage.SendBytes, c.quota.SendBytes
+	}
+
+	if limit == 0 || *usage+uint64(n) <= limit {
+		*usage += uint64(n)
+		c.mu.Unlock()
+		return true, false
+	}
+
+	c.quotaExceeded = true
+	snapshot, fn := c.quotaUsage, c.quota.OnExceeded
+	c.mu.Unlock()
+
+	if c.onQuotaExceeded != nil {
+		c.onQuotaExceeded()
+	}
+
+	action := QuotaThrottle
+	if fn != nil {
+		action = fn(c.peer(), snapshot)
+	}
+
+	c.mu.Lock()
+	c.quotaAction = action
+	c.mu.Unlock()
+
+	return false, action == QuotaDisconnect
+}
@@ -0,0 +1,28 @@
+package reliable
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lockedRand is a source of pseudo-random numbers that is safe for concurrent use, such that a single source may be
+// shared by all conns of an endpoint. All randomness the protocol uses, such as for jittering timers, is drawn from
+// it so that simulations and tests may be made reproducible using WithRandSource.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(src rand.Source) *lockedRand {
+	return &lockedRand{r: rand.New(src)}
+}
+
+// jitter returns d shifted randomly by up to fraction of d in either direction.
+func (r *lockedRand) jitter(d time.Duration, fraction float64) time.Duration {
+	r.mu.Lock()
+	f := r.r.Float64()
+	r.mu.Unlock()
+
+	return d + time.Duration((2*f-1)*fraction*float64(d))
+}
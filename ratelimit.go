@@ -0,0 +1,131 @@
+package reliable
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit bounds the rate at which payload bytes may be written using a token bucket. Standalone acks are never
+// limited, so that limiting bulk data does not delay acknowledgements.
+type RateLimit struct {
+	Rate  float64 // sustained number of payload bytes that may be written per second
+	Burst int     // max number of payload bytes that may be written at once after having been idle
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64   // number of payload bytes that may be written right away, which goes negative once reserved ahead
+	last   time.Time // when tokens was last refilled
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// reserve takes n tokens out of the bucket, returning how long it takes for the bucket to have refilled the tokens
+// that were taken before they were available.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+}
+
+// tryReserve takes n tokens out of the bucket should they be available as of now, reporting whether or not they
+// were. Should n exceed the burst, the tokens are taken once the bucket is full.
+func (b *tokenBucket) tryReserve(n int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if b.tokens < float64(n) && b.tokens < float64(b.limit.Burst) {
+		return false
+	}
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// refund puts n tokens taken out of the bucket back in.
+func (b *tokenBucket) refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += float64(n)
+}
+
+// refill adds the tokens accrued since the bucket was last refilled as of now, up to the burst. It must be called
+// with b.mu held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// tryThrottle draws n payload bytes from the rate limits of this conn and its endpoint should they be available
+// right away, reporting false without drawing any otherwise.
+func (c *Conn) tryThrottle(n int) bool {
+	now := time.Now()
+
+	if c.limiter != nil && !c.limiter.tryReserve(n, now) {
+		return false
+	}
+	if c.elimiter != nil && !c.elimiter.tryReserve(n, now) {
+		if c.limiter != nil {
+			c.limiter.refund(n)
+		}
+		return false
+	}
+
+	return true
+}
+
+// throttle waits until n payload bytes may be written under the rate limits of this conn and its endpoint. It
+// reports false should this conn be closed while waiting.
+func (c *Conn) throttle(n int) bool {
+	if c.limiter == nil && c.elimiter == nil {
+		return true
+	}
+
+	now := time.Now()
+
+	var wait time.Duration
+	for _, b := range [...]*tokenBucket{c.limiter, c.elimiter} {
+		if b == nil {
+			continue
+		}
+		if d := b.reserve(n, now); d > wait {
+			wait = d
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	c.stats.RateLimited++
+	c.stats.RateLimitWaitTotal += wait
+	c.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-c.exit:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
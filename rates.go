@@ -0,0 +1,110 @@
+package reliable
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	shortRateWindow = 1 * time.Second  // window rates reported in ConnStats.Rates1s are averaged over
+	longRateWindow  = 10 * time.Second // window rates reported in ConnStats.Rates10s are averaged over
+)
+
+// Rates are rates of traffic between us and our peer, averaged over a window of time.
+type Rates struct {
+	WritePackets float64 // datagrams written per second
+	WriteBytes   float64 // bytes written per second, not counting ip and udp headers
+	ReadPackets  float64 // packets read per second
+	ReadBytes    float64 // payload bytes read per second
+	Loss         float64 // fraction of reliable packets written that had to be resent
+}
+
+// rateWindow maintains rates of traffic as exponentially weighted moving averages over a window of time, such that
+// rates take up constant memory no matter how much traffic there is. Events are weighed by e^(-age/window), such
+// that a steady rate is fully reported after a few windows.
+type rateWindow struct {
+	window time.Duration
+	last   time.Time // when the averages were last decayed
+
+	writePackets float64
+	writeBytes   float64
+	readPackets  float64
+	readBytes    float64
+	lost         float64 // reliable packets that had to be resent
+	sampled      float64 // reliable packets that either had to be resent or were acked without being resent
+}
+
+// decay decays the averages of w to now. Times before the last decay are treated as the last decay.
+func (w *rateWindow) decay(now time.Time) {
+	if w.last.IsZero() {
+		w.last = now
+		return
+	}
+
+	elapsed := now.Sub(w.last)
+	if elapsed <= 0 {
+		return
+	}
+	w.last = now
+
+	factor := math.Exp(-elapsed.Seconds() / w.window.Seconds())
+
+	w.writePackets *= factor
+	w.writeBytes *= factor
+	w.readPackets *= factor
+	w.readBytes *= factor
+	w.lost *= factor
+	w.sampled *= factor
+}
+
+func (w rateWindow) rates(now time.Time) Rates {
+	w.decay(now)
+
+	rates := Rates{
+		WritePackets: w.writePackets / w.window.Seconds(),
+		WriteBytes:   w.writeBytes / w.window.Seconds(),
+		ReadPackets:  w.readPackets / w.window.Seconds(),
+		ReadBytes:    w.readBytes / w.window.Seconds(),
+	}
+	if w.sampled > 0 {
+		rates.Loss = w.lost / w.sampled
+	}
+
+	return rates
+}
+
+// rateSampler maintains rates of traffic between us and our peer over the windows reported in ConnStats.
+type rateSampler struct {
+	short rateWindow
+	long  rateWindow
+}
+
+func newRateSampler() rateSampler {
+	return rateSampler{short: rateWindow{window: shortRateWindow}, long: rateWindow{window: longRateWindow}}
+}
+
+func (s *rateSampler) wrote(now time.Time, n int) {
+	for _, w := range [...]*rateWindow{&s.short, &s.long} {
+		w.decay(now)
+		w.writePackets++
+		w.writeBytes += float64(n)
+	}
+}
+
+func (s *rateSampler) read(now time.Time, n int) {
+	for _, w := range [...]*rateWindow{&s.short, &s.long} {
+		w.decay(now)
+		w.readPackets++
+		w.readBytes += float64(n)
+	}
+}
+
+func (s *rateSampler) sampleLoss(now time.Time, lost bool) {
+	for _, w := range [...]*rateWindow{&s.short, &s.long} {
+		w.decay(now)
+		w.sampled++
+		if lost {
+			w.lost++
+		}
+	}
+}
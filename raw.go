@@ -0,0 +1,48 @@
+package reliable
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// RawPacketMarker is the first byte of raw datagrams, which are not packets of this protocol. It is a combination of
+// header flags that packets of this protocol never start with, such that one socket may serve both conns and
+// connectionless traffic, such as server browser queries.
+const RawPacketMarker byte = 0xFF
+
+// RawPacketHandler handles a raw datagram read by an endpoint. It is called from the goroutine reading from the
+// socket before any conn is looked up or created, so it must not block, and buf is only valid until it returns.
+//
+// The source address of raw datagrams is not validated, such that replies to them may be reflected onto third
+// parties by peers spoofing their address. Replies should thus be no larger than the datagrams they reply to.
+type RawPacketHandler func(addr net.Addr, buf []byte)
+
+// IsRawPacket reports whether or not buf is a raw datagram rather than a packet of this protocol.
+func IsRawPacket(buf []byte) bool {
+	return len(buf) > 0 && buf[0] == RawPacketMarker
+}
+
+// dispatchRaw hands buf off to the raw packet handler should buf be a raw datagram and should a raw packet handler
+// be set, reporting whether or not it did.
+func (e *Endpoint) dispatchRaw(addr net.Addr, buf []byte) bool {
+	if e.rawh == nil || !IsRawPacket(buf) {
+		return false
+	}
+	e.rawh(addr, buf)
+	return true
+}
+
+// WriteRawPacket writes buf to addr as is, bypassing the protocol and any conn to addr. Raw datagrams meant to be
+// handled by the raw packet handler of another endpoint should start with RawPacketMarker.
+func (e *Endpoint) WriteRawPacket(buf []byte, addr net.Addr) error {
+	start := time.Now()
+	n, err := e.conn.WriteTo(buf, addr)
+	e.ws.add(time.Since(start), err == nil && n != len(buf))
+
+	if err != nil {
+		return fmt.Errorf("failed to write raw packet: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package reliable
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ReadLoopReader is the index a ThreadPinner is called with from the goroutine reading from the socket of an
+// endpoint, as opposed to from one of its read workers.
+const ReadLoopReader = -1
+
+// ThreadPinner is called from each goroutine of the read loop of an endpoint once it is locked to its own OS thread,
+// such that it may set the CPU affinity of the thread, such as using unix.SchedSetaffinity. worker is the index of
+// the read worker it is called from, or ReadLoopReader.
+type ThreadPinner func(worker int)
+
+// LoopUtilization describes how busy a goroutine of the read loop of an endpoint has been.
+type LoopUtilization struct {
+	Busy  time.Duration // total amount of time spent processing datagrams
+	Total time.Duration // total amount of time since the goroutine started, or zero if it has not
+}
+
+// Fraction returns the fraction of time the goroutine spent processing datagrams.
+func (u LoopUtilization) Fraction() float64 {
+	if u.Total <= 0 {
+		return 0
+	}
+	return float64(u.Busy) / float64(u.Total)
+}
+
+type ReadLoopStats struct {
+	Reader  LoopUtilization   // goroutine reading datagrams from the socket and queuing them up for workers
+	Workers []LoopUtilization // goroutines processing queued up datagrams, by index
+}
+
+// ReadLoopStats returns how busy each goroutine of the read loop of this endpoint has been.
+func (e *Endpoint) ReadLoopStats() ReadLoopStats {
+	stats := ReadLoopStats{Workers: make([]LoopUtilization, len(e.meters)-1)}
+
+	stats.Reader = e.meters[0].snapshot()
+	for i := range stats.Workers {
+		stats.Workers[i] = e.meters[i+1].snapshot()
+	}
+
+	return stats
+}
+
+// loopMeter measures the utilization of a goroutine of the read loop.
+type loopMeter struct {
+	start int64 // unix time in nanoseconds the goroutine started at, or zero if it has not
+	busy  int64 // total nanoseconds spent processing datagrams
+}
+
+func (m *loopMeter) started() {
+	atomic.StoreInt64(&m.busy, 0)
+	atomic.StoreInt64(&m.start, time.Now().UnixNano())
+}
+
+func (m *loopMeter) track(since time.Time) {
+	atomic.AddInt64(&m.busy, int64(time.Since(since)))
+}
+
+func (m *loopMeter) snapshot() LoopUtilization {
+	start := atomic.LoadInt64(&m.start)
+	if start == 0 {
+		return LoopUtilization{}
+	}
+	return LoopUtilization{
+		Busy:  time.Duration(atomic.LoadInt64(&m.busy)),
+		Total: time.Duration(time.Now().UnixNano() - start),
+	}
+}
+
+// pinThread locks the calling goroutine of the read loop to its OS thread should thread pinning be enabled, returning
+// a function that unlocks it.
+func (e *Endpoint) pinThread(worker int) (unpin func()) {
+	if !e.lockThreads {
+		return func() {}
+	}
+
+	runtime.LockOSThread()
+	if e.pin != nil {
+		e.pin(worker)
+	}
+
+	return runtime.UnlockOSThread
+}
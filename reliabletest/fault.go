@@ -0,0 +1,118 @@
+// Package reliabletest provides transports for testing code built on top of reliable.
+package reliabletest
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// FaultConn wraps a net.PacketConn, allowing for calls to WriteTo and ReadFrom to be scripted to fail at chosen
+// packet counts. Should the wrapped conn be nil, writes that are not scripted to fail are discarded, and reads that
+// are not scripted to fail return io.EOF.
+type FaultConn struct {
+	net.PacketConn
+
+	mu          sync.Mutex
+	writes      int           // total number of calls to WriteTo so far
+	reads       int           // total number of calls to ReadFrom so far
+	writeFaults map[int]fault // faults keyed by the 1-indexed call to WriteTo they are injected into
+	readFaults  map[int]fault // faults keyed by the 1-indexed call to ReadFrom they are injected into
+}
+
+type fault struct {
+	err   error
+	short bool // whether or not the write should be short rather than fail
+}
+
+func NewFaultConn(conn net.PacketConn) *FaultConn {
+	return &FaultConn{
+		PacketConn:  conn,
+		writeFaults: make(map[int]fault),
+		readFaults:  make(map[int]fault),
+	}
+}
+
+// FailWrite scripts the n'th call to WriteTo, counting from one, to fail with err.
+func (c *FaultConn) FailWrite(n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeFaults[n] = fault{err: err}
+}
+
+// ShortWrite scripts the n'th call to WriteTo, counting from one, to report having written one byte less than it
+// was given without an error.
+func (c *FaultConn) ShortWrite(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeFaults[n] = fault{short: true}
+}
+
+// FailRead scripts the n'th call to ReadFrom, counting from one, to fail with err.
+func (c *FaultConn) FailRead(n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readFaults[n] = fault{err: err}
+}
+
+// Writes returns the total number of calls made to WriteTo so far.
+func (c *FaultConn) Writes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writes
+}
+
+// Reads returns the total number of calls made to ReadFrom so far.
+func (c *FaultConn) Reads() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reads
+}
+
+func (c *FaultConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.writes++
+	f, faulty := c.writeFaults[c.writes]
+	delete(c.writeFaults, c.writes)
+	c.mu.Unlock()
+
+	if faulty {
+		if f.short {
+			if len(buf) == 0 {
+				return 0, nil
+			}
+			buf = buf[:len(buf)-1]
+		} else {
+			return 0, f.err
+		}
+	}
+
+	if c.PacketConn == nil {
+		return len(buf), nil
+	}
+
+	return c.PacketConn.WriteTo(buf, addr)
+}
+
+func (c *FaultConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	c.reads++
+	f, faulty := c.readFaults[c.reads]
+	delete(c.readFaults, c.reads)
+	c.mu.Unlock()
+
+	if faulty {
+		return 0, nil, f.err
+	}
+
+	if c.PacketConn == nil {
+		return 0, nil, io.EOF
+	}
+
+	return c.PacketConn.ReadFrom(buf)
+}
@@ -0,0 +1,41 @@
+package reliabletest
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"syscall"
+	"testing"
+)
+
+func TestFaultConn(t *testing.T) {
+	c := NewFaultConn(nil)
+	c.FailWrite(2, syscall.ENOBUFS)
+	c.ShortWrite(3)
+	c.FailRead(1, syscall.EAGAIN)
+
+	buf := []byte("hello")
+
+	n, err := c.WriteTo(buf, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+
+	_, err = c.WriteTo(buf, nil)
+	require.Equal(t, syscall.ENOBUFS, err)
+
+	n, err = c.WriteTo(buf, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(buf)-1, n)
+
+	n, err = c.WriteTo(buf, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+
+	_, _, err = c.ReadFrom(buf)
+	require.Equal(t, syscall.EAGAIN, err)
+
+	_, _, err = c.ReadFrom(buf)
+	require.Equal(t, io.EOF, err)
+
+	require.Equal(t, 4, c.Writes())
+	require.Equal(t, 2, c.Reads())
+}
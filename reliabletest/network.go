@@ -0,0 +1,305 @@
+package reliabletest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultInboxSize is the max number of datagrams queued up to be read from a PacketConn before further datagrams
+// sent to it are dropped, mimicking a full socket receive buffer.
+const DefaultInboxSize = 1024
+
+var errClosed = errors.New("use of closed network connection")
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Link describes the conditions datagrams sent from one PacketConn to another are subject to.
+type Link struct {
+	Loss    float64       // probability in [0, 1] that a datagram is dropped
+	Latency time.Duration // how long it takes for a datagram to be delivered
+	MTU     int           // max size of a datagram in bytes that gets delivered, or zero if unlimited
+
+	// Outages model burst losses, during which every datagram is dropped. The time between one outage ending and the
+	// next starting is exponentially distributed, and the duration of each outage is uniformly distributed.
+
+	OutageInterval time.Duration // mean time between outages, or zero if there are none
+	OutageMin      time.Duration // min duration of an outage
+	OutageMax      time.Duration // max duration of an outage
+
+	// BindingTimeout models a NAT in front of the receiving end of the link, which drops datagrams sent over the link
+	// should the receiving end have sent nothing back over the opposite link for longer than the timeout.
+
+	BindingTimeout time.Duration // how long a NAT binding lasts without outbound traffic, or zero if there is no NAT
+}
+
+// WiFiBurstLoss returns a link modeling the burst losses of a congested or roaming Wi-Fi network, which drops every
+// datagram for 100 to 300ms about every interval.
+func WiFiBurstLoss(interval time.Duration) Link {
+	return Link{
+		Latency:        2 * time.Millisecond,
+		OutageInterval: interval,
+		OutageMin:      100 * time.Millisecond,
+		OutageMax:      300 * time.Millisecond,
+	}
+}
+
+// outage is the current or next outage of a link.
+type outage struct {
+	start time.Time
+	end   time.Time
+}
+
+// schedule schedules the next outage of link to start some time after from.
+func (o *outage) schedule(rng *rand.Rand, link Link, from time.Time) {
+	o.start = from.Add(time.Duration(rng.ExpFloat64() * float64(link.OutageInterval)))
+
+	length := link.OutageMin
+	if link.OutageMax > link.OutageMin {
+		length += time.Duration(rng.Int63n(int64(link.OutageMax - link.OutageMin + 1)))
+	}
+	o.end = o.start.Add(length)
+}
+
+// Network is a simulated in-memory network of PacketConns, where datagrams sent between any two PacketConns are
+// subject to the conditions of the link between them.
+type Network struct {
+	mu    sync.Mutex
+	rng   *rand.Rand
+	port  int
+	conns map[string]*PacketConn
+	links map[[2]string]Link
+
+	outages map[[2]string]*outage
+	sent    map[[2]string]time.Time // when a datagram was last sent over each link
+}
+
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		rng:   rand.New(rand.NewSource(seed)),
+		conns: make(map[string]*PacketConn),
+		links: make(map[[2]string]Link),
+
+		outages: make(map[[2]string]*outage),
+		sent:    make(map[[2]string]time.Time),
+	}
+}
+
+// Listen creates a new PacketConn on this network with a unique loopback address.
+func (n *Network) Listen() *PacketConn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.port++
+
+	c := &PacketConn{
+		net:     n,
+		addr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: n.port},
+		inbox:   make(chan datagram, DefaultInboxSize),
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+
+	n.conns[c.addr.String()] = c
+
+	return c
+}
+
+// SetLink sets the conditions datagrams sent from one address to another are subject to. Links are one-way, and
+// datagrams sent over links that have not been set are delivered immediately without loss.
+func (n *Network) SetLink(from, to net.Addr, link Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := [2]string{from.String(), to.String()}
+
+	n.links[key] = link
+	delete(n.outages, key)
+}
+
+func (n *Network) send(from net.Addr, to net.Addr, buf []byte) {
+	key := [2]string{from.String(), to.String()}
+
+	now := time.Now()
+
+	n.mu.Lock()
+	dst := n.conns[to.String()]
+	link := n.links[key]
+	lost := (link.Loss > 0 && n.rng.Float64() < link.Loss) || (link.MTU > 0 && len(buf) > link.MTU)
+	if !lost && link.OutageInterval > 0 {
+		lost = n.down(key, link, now)
+	}
+	if !lost && link.BindingTimeout > 0 {
+		lost = now.Sub(n.sent[[2]string{key[1], key[0]}]) > link.BindingTimeout
+	}
+	n.sent[key] = now
+	n.mu.Unlock()
+
+	if dst == nil || lost {
+		return
+	}
+
+	dg := datagram{addr: from, buf: append([]byte(nil), buf...)}
+
+	if link.Latency <= 0 {
+		dst.deliver(dg)
+		return
+	}
+
+	time.AfterFunc(link.Latency, func() { dst.deliver(dg) })
+}
+
+// down reports whether or not the link of the given key is in the middle of an outage as of now. It must be called
+// with n.mu held.
+func (n *Network) down(key [2]string, link Link, now time.Time) bool {
+	o := n.outages[key]
+	if o == nil {
+		o = &outage{}
+		o.schedule(n.rng, link, now)
+		n.outages[key] = o
+	}
+
+	for !now.Before(o.end) {
+		o.schedule(n.rng, link, o.end)
+	}
+
+	return !now.Before(o.start)
+}
+
+func (n *Network) remove(c *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conns[c.addr.String()] == c {
+		delete(n.conns, c.addr.String())
+	}
+}
+
+type datagram struct {
+	addr net.Addr
+	buf  []byte
+}
+
+// PacketConn is a net.PacketConn on a simulated Network. Write deadlines are accepted but ignored, as writes never
+// block.
+type PacketConn struct {
+	net  *Network
+	addr *net.UDPAddr
+
+	inbox chan datagram
+	done  chan struct{}
+	once  sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time     // read deadline
+	changed  chan struct{} // closed to wake up readers once the read deadline changes
+}
+
+func (c *PacketConn) deliver(dg datagram) {
+	select {
+	case <-c.done:
+	case c.inbox <- dg:
+	default: // inbox is full
+	}
+}
+
+func (c *PacketConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, c.opError("read", timeoutError{})
+			}
+
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case dg := <-c.inbox:
+			stopTimer(timer)
+			return copy(buf, dg.buf), dg.addr, nil
+		case <-c.done:
+			stopTimer(timer)
+			return 0, nil, c.opError("read", errClosed)
+		case <-timeout:
+			return 0, nil, c.opError("read", timeoutError{})
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+func (c *PacketConn) WriteTo(buf []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, c.opError("write", errClosed)
+	default:
+	}
+
+	c.net.send(c.addr, addr, buf)
+
+	return len(buf), nil
+}
+
+func (c *PacketConn) Close() error {
+	closed := false
+
+	c.once.Do(func() {
+		close(c.done)
+		c.net.remove(c)
+		closed = true
+	})
+
+	if !closed {
+		return c.opError("close", errClosed)
+	}
+
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return nil
+}
+
+func (c *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.addr, Err: err}
+}
@@ -0,0 +1,178 @@
+package reliabletest
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetworkDeliversOverLinks(t *testing.T) {
+	network := NewNetwork(0)
+
+	a, b := network.Listen(), network.Listen()
+	defer a.Close()
+	defer b.Close()
+
+	network.SetLink(a.LocalAddr(), b.LocalAddr(), Link{Latency: 10 * time.Millisecond})
+	network.SetLink(b.LocalAddr(), a.LocalAddr(), Link{Loss: 1})
+
+	start := time.Now()
+
+	_, err := a.WriteTo([]byte("hello"), b.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+
+	n, addr, err := b.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, a.LocalAddr(), addr)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(10*time.Millisecond))
+
+	_, err = b.WriteTo([]byte("hello"), a.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, a.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+
+	_, _, err = a.ReadFrom(buf)
+
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	require.True(t, netErr.Timeout())
+}
+
+func TestPacketConnCloseUnblocksReads(t *testing.T) {
+	c := NewNetwork(0).Listen()
+
+	errs := make(chan error)
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+
+	require.NoError(t, c.Close())
+	require.Error(t, <-errs)
+	require.Error(t, c.Close())
+
+	_, err := c.WriteTo(nil, c.LocalAddr())
+	require.Error(t, err)
+}
+
+func TestPacketConnDeadlineWakesReads(t *testing.T) {
+	c := NewNetwork(0).Listen()
+	defer c.Close()
+
+	errs := make(chan error)
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, c.SetDeadline(time.Now()))
+
+	var netErr net.Error
+	require.True(t, errors.As(<-errs, &netErr))
+	require.True(t, netErr.Timeout())
+}
+
+func TestNetworkDropsDatagramsLargerThanMTU(t *testing.T) {
+	network := NewNetwork(0)
+
+	a, b := network.Listen(), network.Listen()
+	defer a.Close()
+	defer b.Close()
+
+	network.SetLink(a.LocalAddr(), b.LocalAddr(), Link{MTU: 4})
+
+	_, err := a.WriteTo([]byte("hello"), b.LocalAddr())
+	require.NoError(t, err)
+	_, err = a.WriteTo([]byte("hey"), b.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+
+	n, _, err := b.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hey", string(buf[:n]))
+}
+
+func TestNetworkOutages(t *testing.T) {
+	network := NewNetwork(0)
+
+	key := [2]string{"a", "b"}
+	link := Link{OutageInterval: 100 * time.Millisecond, OutageMin: 50 * time.Millisecond, OutageMax: 50 * time.Millisecond}
+
+	// Outages last at least as long as they are set to, as outages that start right after another merge into it, and
+	// make up about as much of the time as their interval says.
+
+	start := time.Unix(0, 0)
+
+	down, run := 0, 0
+	for i := 0; i < 60000; i++ {
+		if network.down(key, link, start.Add(time.Duration(i)*time.Millisecond)) {
+			down++
+			run++
+			continue
+		}
+		if run > 0 {
+			require.GreaterOrEqual(t, run, 50)
+		}
+		run = 0
+	}
+
+	require.InDelta(t, 1.0/3, float64(down)/60000, 0.05)
+
+	// Datagrams sent over a link in the middle of an outage are dropped.
+
+	a, b := network.Listen(), network.Listen()
+	defer a.Close()
+	defer b.Close()
+
+	network.SetLink(a.LocalAddr(), b.LocalAddr(), Link{OutageInterval: time.Nanosecond, OutageMin: time.Hour, OutageMax: time.Hour})
+
+	_, err := a.WriteTo([]byte("hello"), b.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, b.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+
+	_, _, err = b.ReadFrom(make([]byte, 16))
+	require.Error(t, err)
+}
+
+func TestNetworkBindingTimeout(t *testing.T) {
+	network := NewNetwork(0)
+
+	a, b := network.Listen(), network.Listen()
+	defer a.Close()
+	defer b.Close()
+
+	network.SetLink(b.LocalAddr(), a.LocalAddr(), Link{BindingTimeout: 20 * time.Millisecond})
+
+	buf := make([]byte, 16)
+
+	// Datagrams are only let through a NAT while its binding, refreshed by outbound datagrams, has not expired.
+
+	_, err := b.WriteTo([]byte("hello"), a.LocalAddr())
+	require.NoError(t, err)
+
+	_, err = a.WriteTo([]byte("ping"), b.LocalAddr())
+	require.NoError(t, err)
+	_, err = b.WriteTo([]byte("hey"), a.LocalAddr())
+	require.NoError(t, err)
+
+	n, _, err := a.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hey", string(buf[:n]))
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = b.WriteTo([]byte("hello"), a.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, a.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, _, err = a.ReadFrom(buf)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package reliable
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultMaxPacketResends is the max number of times an unacked packet is resent by default, after which it is no
+// longer resent.
+const DefaultMaxPacketResends = 10
+
+// maxResendBackoff is the max number of times the resend timeout is doubled while resent packets go unacked.
+const maxResendBackoff = 3
+
+// ErrTooManyRetransmits is the error a conn fails with should a packet go unacked after having been resent the max
+// number of times set using WithMaxPacketResends, which most likely means that our peer is gone.
+var ErrTooManyRetransmits = errors.New("packet went unacked after being resent too many times")
+
+// resendsExhausted reports that a packet of this conn went unacked after having been resent the max number of times,
+// and then fails this conn. It is called once per conn.
+func (c *Conn) resendsExhausted() {
+	c.reportError(ErrTooManyRetransmits)
+
+	if c.onResendsExhausted != nil {
+		c.onResendsExhausted()
+		return
+	}
+
+	go c.disconnectExhausted()
+}
+
+// disconnectExhausted notifies our peer that this conn gave up on resending packets to it, and then closes this conn.
+// It must not be called from a reader or writer of this conn, as closing this conn waits for them.
+func (c *Conn) disconnectExhausted() {
+	if err := c.disconnect(DisconnectMaxRetries, ErrTooManyRetransmits.Error()); err != nil {
+		c.reportError(err)
+	}
+}
+
+// RTT returns the smoothed round-trip time to our peer, or zero should it not yet have been sampled.
+func (c *Conn) RTT() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rtt
+}
+
+// effectiveResendTimeout returns the current resend timeout stretched for being in the background or in bad mode. It
+// must be called with c.mu held.
+func (c *Conn) effectiveResendTimeout() time.Duration {
+	resendTimeout := c.currentResendTimeout()
+	if c.power == PowerBackground {
+		resendTimeout *= backgroundResendFactor
+	}
+	if c.bad {
+		resendTimeout *= badModeResendFactor
+	}
+	return resendTimeout
+}
+
+// currentResendTimeout returns how long unacked packets go unacked before being resent. Once the round-trip time to
+// our peer is sampled, it is derived from it as in RFC 6298 unless a fixed resend timeout was set, and is doubled
+// each update packets get resent until acks again sample the round-trip time. Until then, the initial resend timeout
+// is used, raised to the min resend timeout should it be lower.
+func (c *Conn) currentResendTimeout() time.Duration {
+	if c.fixedResendTimeout {
+		return c.resendTimeout
+	}
+	if c.rtt == 0 {
+		if c.resendTimeout < c.minResendTimeout {
+			return c.minResendTimeout
+		}
+		return c.resendTimeout
+	}
+
+	timeout := c.rtt + 4*c.rttvar
+	if timeout < c.minResendTimeout {
+		timeout = c.minResendTimeout
+	}
+
+	return timeout << c.resendBackoff
+}
+
+// backOffResends doubles the resend timeout derived from the round-trip time to our peer after packets got resent,
+// such that a timeout that fell below the round-trip time does not have every packet resent before its ack arrives.
+// Acks of resent packets do not sample the round-trip time, which would otherwise never be sampled again.
+func (c *Conn) backOffResends() {
+	if c.resendBackoff < maxResendBackoff {
+		c.resendBackoff++
+	}
+}
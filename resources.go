@@ -0,0 +1,191 @@
+package reliable
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrResourceLimit is returned by writes of an endpoint to a new peer should the endpoint be at one of its resource
+// limits. Packets read from new peers are dropped in that case.
+var ErrResourceLimit = errors.New("endpoint is at its resource limits")
+
+// Resources describes what a conn or an endpoint currently holds onto, for capacity planning.
+type Resources struct {
+	Conns      int // number of conns held, which is always one for a conn
+	Goroutines int // number of goroutines running on its behalf
+	Timers     int // number of timers and tickers held
+	Buffers    int // number of pooled buffers held
+	Bytes      int // number of bytes held by pooled buffers, read and write queues, and payloads held back
+}
+
+func (r Resources) add(o Resources) Resources {
+	r.Conns += o.Conns
+	r.Goroutines += o.Goroutines
+	r.Timers += o.Timers
+	r.Buffers += o.Buffers
+	r.Bytes += o.Bytes
+	return r
+}
+
+func (r Resources) sub(o Resources) Resources {
+	r.Conns -= o.Conns
+	r.Goroutines -= o.Goroutines
+	r.Timers -= o.Timers
+	r.Buffers -= o.Buffers
+	r.Bytes -= o.Bytes
+	return r
+}
+
+// ResourceLimits bounds what an endpoint and all of its conns may hold onto at once. Once an endpoint is at any of its
+// limits, it refuses to create conns to new peers. Fields left as zero leave their limits unbounded.
+type ResourceLimits struct {
+	MaxConns      int // max number of conns
+	MaxGoroutines int // max number of goroutines
+	MaxBuffers    int // max number of pooled buffers
+	MaxBytes      int // max number of bytes
+}
+
+// reached reports whether or not r is at any of the limits l.
+func (l ResourceLimits) reached(r Resources) bool {
+	return (l.MaxConns > 0 && r.Conns >= l.MaxConns) ||
+		(l.MaxGoroutines > 0 && r.Goroutines >= l.MaxGoroutines) ||
+		(l.MaxBuffers > 0 && r.Buffers >= l.MaxBuffers) ||
+		(l.MaxBytes > 0 && r.Bytes >= l.MaxBytes)
+}
+
+// indexBytes is the number of bytes an entry of a read or write queue takes up.
+const indexBytes = 4
+
+// Resources returns what this conn currently holds onto.
+func (c *Conn) Resources() Resources {
+	r := Resources{Conns: 1, Goroutines: int(atomic.LoadInt32(&c.goroutines))}
+
+	if atomic.LoadUint32(&c.running) == 1 {
+		r.Timers++ // update ticker
+	}
+
+	c.mu.Lock()
+	if c.writeTimer != nil {
+		r.Timers++
+	}
+	if c.suppressTimer != nil {
+		r.Timers++
+	}
+	for i := range c.wqe {
+		if c.wqe[i].buf != nil {
+			r.Buffers++
+			r.Bytes += cap(c.wqe[i].buf.B)
+		}
+	}
+	for _, p := range c.partial {
+		r.Bytes += p.size
+	}
+	r.Bytes += indexBytes * (len(c.wq) + len(c.rq))
+	c.mu.Unlock()
+
+	c.inbox.mu.Lock()
+	for _, b := range c.inbox.bufs {
+		r.Buffers++
+		r.Bytes += cap(b.B)
+	}
+	c.inbox.mu.Unlock()
+
+	if c.held != nil {
+		c.held.mu.Lock()
+		for _, p := range c.held.packets {
+			r.Buffers++
+			r.Bytes += cap(p.buf.B)
+		}
+		c.held.mu.Unlock()
+	}
+
+	if c.ordered != nil {
+		c.ordered.mu.Lock()
+		for _, p := range c.ordered.packets {
+			if p.pooled != nil {
+				r.Buffers++
+			}
+			r.Bytes += cap(p.buf)
+		}
+		c.ordered.mu.Unlock()
+	}
+
+	return r
+}
+
+// Resources returns what this endpoint and all of its conns currently hold onto.
+func (e *Endpoint) Resources() Resources {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	r := Resources{Goroutines: int(atomic.LoadInt32(&e.goroutines))}
+	for _, conn := range conns {
+		r = r.add(conn.Resources())
+	}
+	return r
+}
+
+// admitConn reports whether or not a conn may be created to a new peer without this endpoint going past its resource
+// limits. Conns are counted as holding onto what they held onto as of their last update, such that admitting a conn
+// does not take every conn into account. It must be called with e.mu held.
+func (e *Endpoint) admitConn() bool {
+	if e.resourceLimits == nil {
+		return true
+	}
+
+	r := e.held
+	r.Goroutines += int(atomic.LoadInt32(&e.goroutines))
+
+	return !e.resourceLimits.reached(r)
+}
+
+// addConn adds conn to the conns of this endpoint, counting what it holds onto against the resource limits. It must
+// be called with e.mu held.
+func (e *Endpoint) addConn(conn *Conn) {
+	e.conns[conn.key] = conn
+
+	if e.resourceLimits == nil {
+		return
+	}
+
+	conn.reported = conn.Resources()
+	e.held = e.held.add(conn.reported)
+}
+
+// removeConn removes conn from the conns of this endpoint, no longer counting what it holds onto against the resource
+// limits. It must be called with e.mu held.
+func (e *Endpoint) removeConn(conn *Conn) {
+	delete(e.conns, conn.key)
+
+	e.held = e.held.sub(conn.reported)
+	conn.reported = Resources{}
+}
+
+// countConn counts what conn currently holds onto against the resource limits in place of what it held onto when it
+// was last counted. It is called on every update of conn, and does nothing should conn no longer be held.
+func (e *Endpoint) countConn(conn *Conn) {
+	r := conn.Resources()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conns[conn.key] != conn {
+		return
+	}
+
+	e.held = e.held.add(r.sub(conn.reported))
+	conn.reported = r
+}
+
+// errNoConn returns the error writes fail with should no conn to their peer be gotten.
+func (e *Endpoint) errNoConn() error {
+	if e.drainingOrClosing() {
+		return io.EOF
+	}
+	return ErrResourceLimit
+}
@@ -0,0 +1,23 @@
+package reliable
+
+import "time"
+
+// QueuedPacket is a packet awaiting transmission to a peer.
+type QueuedPacket struct {
+	Seq     uint16    // sequence number of the packet
+	Buf     []byte    // contents of the packet including its header, which must not be modified nor retained
+	Written time.Time // last time the packet was written
+	Resent  byte      // total number of times the packet was resent
+}
+
+// Scheduler decides the order in which queued packets are transmitted to a peer.
+type Scheduler interface {
+	// Next returns the index of the packet in queue that should be transmitted next. queue is never empty, and is
+	// ordered by sequence number from oldest to newest.
+	Next(queue []QueuedPacket) int
+}
+
+// FIFOScheduler transmits queued packets from oldest to newest. It is the default scheduler.
+type FIFOScheduler struct{}
+
+func (FIFOScheduler) Next([]QueuedPacket) int { return 0 }
@@ -0,0 +1,91 @@
+// Package sequence provides wrap-safe math over the 16-bit sequence numbers packets are tagged with.
+//
+// Sequence numbers wrap around back to zero after 65535. A sequence number a is considered to be newer than b should
+// a be ahead of b by at most 32768, such that comparisons remain correct across wraparound so long as the two
+// sequence numbers being compared are never more than 32768 apart.
+package sequence
+
+import "github.com/lithdew/seq"
+
+// GT returns whether or not a is newer than b.
+func GT(a, b uint16) bool { return seq.GT(a, b) }
+
+// GTE returns whether or not a is newer than or equal to b.
+func GTE(a, b uint16) bool { return seq.GTE(a, b) }
+
+// LT returns whether or not a is older than b.
+func LT(a, b uint16) bool { return seq.LT(a, b) }
+
+// LTE returns whether or not a is older than or equal to b.
+func LTE(a, b uint16) bool { return seq.LTE(a, b) }
+
+// Max returns the newer of a and b.
+func Max(a, b uint16) uint16 {
+	if GT(a, b) {
+		return a
+	}
+	return b
+}
+
+// Min returns the older of a and b.
+func Min(a, b uint16) uint16 {
+	if LT(a, b) {
+		return a
+	}
+	return b
+}
+
+// Distance returns how far ahead b is of a, which is negative should b be older than a.
+func Distance(a, b uint16) int {
+	d := int(b - a)
+	if d > int(seq.HalfMaxUint16) {
+		d -= 1 << 16
+	}
+	return d
+}
+
+// Unwrap returns the 64-bit logical number whose lower 16 bits are s that lies closest to the logical number ref,
+// without going below zero.
+func Unwrap(ref uint64, s uint16) uint64 {
+	d := Distance(uint16(ref), s)
+	if d < 0 && uint64(-d) > ref {
+		d += 1 << 16
+	}
+	return ref + uint64(d)
+}
+
+// InWindow returns whether or not s lies within the size sequence numbers starting at start.
+func InWindow(s, start, size uint16) bool {
+	return s-start < size
+}
+
+// Index returns the index s is stored at in a ring buffer of size entries. size must be a divisor of 65536 for
+// indices to remain consistent across wraparound.
+func Index(s, size uint16) uint16 {
+	return s % size
+}
+
+// Range is the half-open range of sequence numbers [Start, End), which may wrap around.
+type Range struct {
+	Start uint16
+	End   uint16
+}
+
+// Len returns the number of sequence numbers in r.
+func (r Range) Len() uint16 {
+	return r.End - r.Start
+}
+
+// Contains returns whether or not s lies within r.
+func (r Range) Contains(s uint16) bool {
+	return InWindow(s, r.Start, r.Len())
+}
+
+// Each calls fn for every sequence number in r from oldest to newest, stopping early should fn return false.
+func (r Range) Each(fn func(s uint16) bool) {
+	for s := r.Start; s != r.End; s++ {
+		if !fn(s) {
+			return
+		}
+	}
+}
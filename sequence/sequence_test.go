@@ -0,0 +1,55 @@
+package sequence
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestComparisonsWrapAround(t *testing.T) {
+	require.True(t, GT(0, 65535))
+	require.True(t, LT(65535, 0))
+	require.True(t, GTE(1, 1))
+	require.True(t, LTE(1, 1))
+	require.True(t, GT(32768, 0))
+	require.False(t, GT(32769, 0))
+
+	require.EqualValues(t, 0, Max(65535, 0))
+	require.EqualValues(t, 65535, Min(65535, 0))
+}
+
+func TestDistance(t *testing.T) {
+	require.Equal(t, 1, Distance(65535, 0))
+	require.Equal(t, -1, Distance(0, 65535))
+	require.Equal(t, 32767, Distance(0, 32767))
+	require.Equal(t, 32768, Distance(0, 32768))
+	require.Equal(t, -32767, Distance(0, 32769))
+}
+
+func TestUnwrap(t *testing.T) {
+	require.EqualValues(t, 5, Unwrap(0, 5))
+	require.EqualValues(t, 65535, Unwrap(0, 65535))
+	require.EqualValues(t, 1<<16, Unwrap(65535, 0))
+	require.EqualValues(t, 1<<16-1, Unwrap(1<<16+2, 65535))
+	require.EqualValues(t, 4<<16|10, Unwrap(3<<16|65530, 10))
+	require.EqualValues(t, 3<<16|10, Unwrap(3<<16|20, 10))
+}
+
+func TestInWindowAndRange(t *testing.T) {
+	require.True(t, InWindow(2, 65534, 8))
+	require.False(t, InWindow(6, 65534, 8))
+	require.False(t, InWindow(65533, 65534, 8))
+
+	r := Range{Start: 65534, End: 2}
+	require.EqualValues(t, 4, r.Len())
+	require.True(t, r.Contains(0))
+	require.False(t, r.Contains(2))
+
+	var seen []uint16
+	r.Each(func(s uint16) bool {
+		seen = append(seen, s)
+		return s != 0
+	})
+	require.Equal(t, []uint16{65534, 65535, 0}, seen)
+
+	require.EqualValues(t, 3, Index(65535, 4))
+}
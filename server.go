@@ -0,0 +1,176 @@
+package reliable
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// AcceptFunc is called by a server once a conn to a new peer is established, returning the packet handler for all
+// packets read from the peer, or nil to refuse the peer. It is called from the read loop of the server, so it must
+// not block.
+type AcceptFunc func(addr net.Addr) PacketHandler
+
+// Server is a facade over an endpoint that serves many peers, each with its own packet handler. Its endpoint is
+// available through Endpoint for anything the facade does not cover.
+type Server struct {
+	e  *Endpoint
+	pc net.PacketConn
+
+	accept      AcceptFunc
+	unsubscribe func()
+
+	mu    sync.Mutex
+	peers map[string]acceptedPeer // peers that were accepted, keyed by address
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// acceptedPeer is a peer a server accepted along with its packet handler.
+type acceptedPeer struct {
+	addr net.Addr
+	ph   PacketHandler
+}
+
+// Serve binds a server to the UDP address addr. See NewServer.
+func Serve(addr string, accept AcceptFunc, opts ...EndpointOption) (*Server, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return NewServer(pc, accept, opts...), nil
+}
+
+// NewServer starts serving peers over pc, having accept decide which packet handler each new peer gets. opts are
+// applied to the endpoint of the server, and must not set a packet handler.
+func NewServer(pc net.PacketConn, accept AcceptFunc, opts ...EndpointOption) *Server {
+	s := &Server{pc: pc, accept: accept, peers: make(map[string]acceptedPeer)}
+
+	s.e = NewEndpoint(pc, append(opts[:len(opts):len(opts)], WithPacketHandler(s.handle))...)
+	s.unsubscribe = s.e.Subscribe(s.track)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.e.Listen()
+	}()
+
+	return s
+}
+
+// track accepts peers as their conns are established, and forgets about them once their conns are closed. Conns
+// are established before any of their packets are handled, so no packet of an accepted peer goes unhandled.
+func (s *Server) track(event ConnEvent) {
+	key := event.Addr.String()
+
+	switch event.Type {
+	case ConnEstablished:
+		ph := s.accept(event.Addr)
+		if ph == nil {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.refuse(event.Addr)
+			}()
+			return
+		}
+
+		s.mu.Lock()
+		s.peers[key] = acceptedPeer{addr: event.Addr, ph: ph}
+		s.mu.Unlock()
+	case ConnClosed, ConnFailed, ConnPeerClosed, ConnEvicted:
+		s.mu.Lock()
+		delete(s.peers, key)
+		s.mu.Unlock()
+	}
+}
+
+// refuse notifies the peer at addr that it was refused, and clears its conn.
+func (s *Server) refuse(addr net.Addr) {
+	if err := s.e.CloseConnWithError(addr, 0, "connection refused"); err != nil && s.e.eh != nil {
+		s.e.eh(addr, err)
+	}
+}
+
+func (s *Server) handle(addr net.Addr, seq uint16, buf []byte) {
+	s.mu.Lock()
+	p, ok := s.peers[addr.String()]
+	s.mu.Unlock()
+
+	if ok {
+		p.ph(addr, seq, buf)
+	}
+}
+
+// Addr returns the local address the server is bound to.
+func (s *Server) Addr() net.Addr { return s.e.Addr() }
+
+// Endpoint returns the endpoint the server is a facade over.
+func (s *Server) Endpoint() *Endpoint { return s.e }
+
+// Peers returns the addresses of all peers that are accepted.
+func (s *Server) Peers() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]net.Addr, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p.addr)
+	}
+	return peers
+}
+
+// Send writes buf reliably to the peer at addr.
+func (s *Server) Send(addr net.Addr, buf []byte) error { return s.e.WriteReliablePacket(buf, addr) }
+
+// SendUnreliable writes buf unreliably to the peer at addr.
+func (s *Server) SendUnreliable(addr net.Addr, buf []byte) error {
+	return s.e.WriteUnreliablePacket(buf, addr)
+}
+
+// Shutdown flushes the conns of all peers, notifies each of them that the server is shutting down, and closes the
+// server. Peers whose conns could not be flushed before ctx is done are notified and closed regardless, in which
+// case ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.e.mu.Lock()
+	conns := make([]*Conn, 0, len(s.e.conns))
+	for _, conn := range s.e.conns {
+		conns = append(conns, conn)
+	}
+	s.e.mu.Unlock()
+
+	var err error
+	for _, conn := range conns {
+		if ferr := conn.Flush(ctx); ferr != nil && !isEOF(ferr) && err == nil {
+			err = ferr
+		}
+		_ = conn.CloseWithError(0, "server shutting down")
+	}
+
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Close closes the server straight away, along with its socket.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		err := s.pc.Close()
+		s.wg.Wait()
+		s.unsubscribe()
+
+		if cerr := s.e.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			s.closeErr = fmt.Errorf("failed to close server: %w", err)
+		}
+	})
+
+	return s.closeErr
+}
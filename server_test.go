@@ -0,0 +1,76 @@
+package reliable
+
+import (
+	"context"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerAndClient(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	// The server echoes back everything its peers send it.
+
+	var s *Server
+	s = NewServer(network.Listen(), func(addr net.Addr) PacketHandler {
+		return func(addr net.Addr, _ uint16, buf []byte) {
+			require.NoError(t, s.Send(addr, buf))
+		}
+	})
+
+	recv := make(chan string, 16)
+
+	c := NewClient(network.Listen(), s.Addr(), func(_ net.Addr, _ uint16, buf []byte) {
+		recv <- string(buf)
+	})
+
+	require.NoError(t, c.SendString("hello"))
+	require.NoError(t, c.SendJSON(map[string]int{"n": 1}))
+
+	for _, expected := range []string{"hello", `{"n":1}`} {
+		select {
+		case buf := <-recv:
+			require.Equal(t, expected, buf)
+		case <-time.After(1 * time.Second):
+			t.Fatal("timed out waiting for an echo")
+		}
+	}
+
+	require.Equal(t, []net.Addr{c.LocalAddr()}, s.Peers())
+	require.Zero(t, c.Reconnects())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	require.NoError(t, c.Shutdown(ctx))
+	require.NoError(t, s.Shutdown(ctx))
+}
+
+func TestServerRefusesPeers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	s := NewServer(network.Listen(), func(net.Addr) PacketHandler { return nil })
+	defer func() { require.NoError(t, s.Close()) }()
+
+	c := NewClient(network.Listen(), s.Addr(), func(net.Addr, uint16, []byte) {
+		t.Fatal("refused client was written to")
+	})
+	defer func() { require.NoError(t, c.Close()) }()
+
+	// A refused client has its conn closed by the server, and reconnects on its next write.
+
+	require.NoError(t, c.SendString("hello"))
+	require.Eventually(t, func() bool { return c.Conn() == nil }, 1*time.Second, time.Millisecond)
+	require.Empty(t, s.Peers())
+
+	require.NoError(t, c.SendString("hello"))
+	require.Equal(t, 1, c.Reconnects())
+}
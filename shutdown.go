@@ -0,0 +1,96 @@
+package reliable
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ShutdownError is returned by Endpoint.Shutdown should reliable packets written to some peers not have been acked
+// by the time it gave up waiting.
+type ShutdownError struct {
+	Unconfirmed []net.Addr // peers that did not ack all reliable packets written to them, sorted by address
+	Err         error      // why waiting was given up on, such as ctx.Err() or io.EOF for conns that were closed
+}
+
+func (e *ShutdownError) Error() string {
+	addrs := make([]string, 0, len(e.Unconfirmed))
+	for _, addr := range e.Unconfirmed {
+		addrs = append(addrs, addr.String())
+	}
+	return fmt.Sprintf("unacked packets to %d peer(s) (%s): %s", len(addrs), strings.Join(addrs, ", "), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error { return e.Err }
+
+// Shutdown gracefully shuts down this endpoint. Conns to new peers stop being created, while existing conns keep on
+// reading acks and resending unacked packets until every reliable packet written to their peers is acked, or until
+// ctx is done. The socket of this endpoint is then closed, followed by this endpoint. Should some peers not have
+// acked all reliable packets written to them in time, a *ShutdownError listing them is returned.
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	atomic.StoreUint32(&e.draining, 1)
+
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		err *ShutdownError
+	)
+
+	wg.Add(len(conns))
+
+	for _, conn := range conns {
+		conn := conn
+
+		go func() {
+			defer wg.Done()
+
+			ferr := conn.Flush(ctx)
+			if ferr == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err == nil {
+				err = &ShutdownError{Err: ferr}
+			}
+			err.Unconfirmed = append(err.Unconfirmed, conn.peer())
+		}()
+	}
+
+	wg.Wait()
+
+	cerr := e.conn.Close()
+	e.Close()
+
+	if err != nil {
+		sort.Slice(err.Unconfirmed, func(i, j int) bool {
+			return err.Unconfirmed[i].String() < err.Unconfirmed[j].String()
+		})
+		return err
+	}
+
+	if cerr != nil && !isEOF(cerr) {
+		return fmt.Errorf("failed to close socket: %w", cerr)
+	}
+
+	return nil
+}
+
+// drainingOrClosing reports whether or not this endpoint is shutting down or closing, such that no new conns are to
+// be created.
+func (e *Endpoint) drainingOrClosing() bool {
+	return atomic.LoadUint32(&e.draining) == 1 || atomic.LoadUint32(&e.closing) == 1
+}
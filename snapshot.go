@@ -0,0 +1,442 @@
+package reliable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SnapshotMagic prefixes every stream of snapshots, with its last byte being the version of the format.
+const SnapshotMagic = "RLSNAP\x01"
+
+// maxSnapshotSize is the max size in bytes of a snapshot record, guarding against corrupt size prefixes.
+const maxSnapshotSize = 1 << 16
+
+// ErrBadSnapshotStream is returned when reading snapshots from a stream that does not start with SnapshotMagic.
+var ErrBadSnapshotStream = errors.New("not a stream of snapshots")
+
+// Snapshot is the stats of a conn as of some point in time.
+type Snapshot struct {
+	Time  time.Time
+	Addr  string // address of the conn's peer
+	Stats ConnStats
+}
+
+// SnapshotWriter writes snapshots to an io.Writer in a compact binary format. Each snapshot is written as a
+// length-prefixed record of varints, such that readers may skip over fields appended onto records in the future.
+type SnapshotWriter struct {
+	w      *bufio.Writer
+	buf    []byte
+	header bool // whether or not SnapshotMagic was written yet
+}
+
+func NewSnapshotWriter(w io.Writer) *SnapshotWriter {
+	return &SnapshotWriter{w: bufio.NewWriter(w)}
+}
+
+// Write writes and flushes out snapshot s.
+func (w *SnapshotWriter) Write(s Snapshot) error {
+	if !w.header {
+		if _, err := w.w.WriteString(SnapshotMagic); err != nil {
+			return fmt.Errorf("failed to write snapshot header: %w", err)
+		}
+		w.header = true
+	}
+
+	w.buf = appendSnapshot(w.buf[:0], s)
+
+	var size [binary.MaxVarintLen64]byte
+	if _, err := w.w.Write(size[:binary.PutUvarint(size[:], uint64(len(w.buf)))]); err != nil {
+		return fmt.Errorf("failed to write snapshot size: %w", err)
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := w.w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// SnapshotReader reads snapshots written by a SnapshotWriter.
+type SnapshotReader struct {
+	r      *bufio.Reader
+	buf    []byte
+	header bool // whether or not SnapshotMagic was read yet
+}
+
+func NewSnapshotReader(r io.Reader) *SnapshotReader {
+	return &SnapshotReader{r: bufio.NewReader(r)}
+}
+
+// Read reads the next snapshot, returning io.EOF should there be no more snapshots to read.
+func (r *SnapshotReader) Read() (Snapshot, error) {
+	if !r.header {
+		magic := make([]byte, len(SnapshotMagic))
+		if _, err := io.ReadFull(r.r, magic); err != nil {
+			if err == io.EOF { // empty stream
+				return Snapshot{}, err
+			}
+			return Snapshot{}, fmt.Errorf("failed to read snapshot header: %w", err)
+		}
+		if string(magic) != SnapshotMagic {
+			return Snapshot{}, ErrBadSnapshotStream
+		}
+		r.header = true
+	}
+
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if err == io.EOF {
+			return Snapshot{}, err
+		}
+		return Snapshot{}, fmt.Errorf("failed to read snapshot size: %w", err)
+	}
+	if size > maxSnapshotSize {
+		return Snapshot{}, fmt.Errorf("snapshot size %d exceeds max of %d bytes", size, maxSnapshotSize)
+	}
+
+	if uint64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read snapshot: %w", io.ErrUnexpectedEOF)
+	}
+
+	s, err := unmarshalSnapshot(r.buf)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	return s, nil
+}
+
+// ReadTimeSeries reads all snapshots from r, grouping them by the address of the peer they were taken of in the order
+// they were written in.
+func ReadTimeSeries(r io.Reader) (map[string][]Snapshot, error) {
+	series := make(map[string][]Snapshot)
+
+	sr := NewSnapshotReader(r)
+	for {
+		s, err := sr.Read()
+		if err == io.EOF {
+			return series, nil
+		}
+		if err != nil {
+			return series, err
+		}
+		series[s.Addr] = append(series[s.Addr], s)
+	}
+}
+
+// SnapshotEvery writes a snapshot of the stats of every conn of this endpoint to w every interval until exit is
+// closed, or until a snapshot fails to be written.
+func (e *Endpoint) SnapshotEvery(w io.Writer, interval time.Duration, exit <-chan struct{}) error {
+	sw := NewSnapshotWriter(w)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exit:
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := e.Snapshot(sw); err != nil {
+			return err
+		}
+	}
+}
+
+// Snapshot writes a snapshot of the stats of every conn of this endpoint to w.
+func (e *Endpoint) Snapshot(w *SnapshotWriter) error {
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	now := time.Now()
+
+	for _, conn := range conns {
+		if err := w.Write(Snapshot{Time: now, Addr: conn.peer().String(), Stats: conn.Stats()}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendSnapshot(dst []byte, s Snapshot) []byte {
+	dst = appendVarint(dst, s.Time.UnixNano())
+	dst = appendUvarint(dst, uint64(len(s.Addr)))
+	dst = append(dst, s.Addr...)
+
+	st := s.Stats
+
+	dst = appendUvarint(dst, st.WriteWaits)
+	dst = appendVarint(dst, int64(st.WriteWaitTotal))
+	dst = appendVarint(dst, int64(st.WriteWaitMax))
+	dst = appendUvarint(dst, st.AmplificationDrops)
+	dst = appendUvarint(dst, st.Reordered)
+	dst = appendUvarint(dst, st.ReorderDepthTotal)
+	dst = appendUvarint(dst, uint64(st.ReorderDepthMax))
+	dst = appendUvarint(dst, st.RateLimited)
+	dst = appendVarint(dst, int64(st.RateLimitWaitTotal))
+	dst = appendUvarint(dst, st.AcksConfirmed)
+	dst = appendUvarint(dst, st.AcksLost)
+	dst = appendUvarint(dst, st.CongestionReports)
+	dst = appendUvarint(dst, st.HeldDrops)
+	dst = appendUvarint(dst, st.Stale)
+	dst = appendUvarint(dst, st.WritePacketNumber)
+	dst = appendUvarint(dst, st.ReadPacketNumber)
+
+	dst = appendUvarint(dst, st.Syscalls.Writes)
+	dst = appendUvarint(dst, st.Syscalls.ShortWrites)
+	dst = appendVarint(dst, int64(st.Syscalls.Total))
+	dst = appendVarint(dst, int64(st.Syscalls.Max))
+	for _, n := range st.Syscalls.Latency {
+		dst = appendUvarint(dst, n)
+	}
+
+	for _, h := range [...]*LatencyHistogram{
+		&st.Lifecycle.Queue, &st.Lifecycle.Transmit, &st.Lifecycle.Ack, &st.Lifecycle.Release,
+	} {
+		dst = appendUvarint(dst, h.Count)
+		dst = appendVarint(dst, int64(h.Total))
+		for _, n := range h.Buckets {
+			dst = appendUvarint(dst, n)
+		}
+	}
+
+	dst = appendVarint(dst, int64(st.RTT))
+
+	dst = appendBool(dst, st.AppLimited)
+
+	// Fields below were appended to the format after its first version.
+
+	dst = appendUvarint(dst, st.TransmitErrors)
+	dst = appendUvarint(dst, st.Anomalies)
+	dst = appendUvarint(dst, st.BreakerTrips)
+	dst = appendBool(dst, st.BreakerOpen)
+
+	dst = appendUvarint(dst, st.Overhead.Datagrams)
+	dst = appendUvarint(dst, st.Overhead.PayloadBytes)
+	dst = appendUvarint(dst, st.Overhead.HeaderBytes)
+	dst = appendUvarint(dst, st.Overhead.ResentBytes)
+	dst = appendUvarint(dst, st.Overhead.AckBytes)
+	dst = appendUvarint(dst, st.Overhead.ControlBytes)
+
+	dst = appendUvarint(dst, st.AckRangeFrames)
+
+	dst = appendUvarint(dst, st.BadModeEntries)
+	dst = appendBool(dst, st.BadMode)
+
+	dst = appendUvarint(dst, st.FragmentedWrites)
+	dst = appendUvarint(dst, st.Reassembled)
+	dst = appendUvarint(dst, st.ReassemblyDrops)
+
+	dst = appendUvarint(dst, st.KeepAlives)
+
+	dst = appendUvarint(dst, st.ReliableWrites)
+	dst = appendUvarint(dst, st.UnreliableWrites)
+	dst = appendUvarint(dst, st.PacketsRead)
+	dst = appendUvarint(dst, st.ReliableReads)
+	dst = appendUvarint(dst, st.UnreliableReads)
+	dst = appendUvarint(dst, st.BytesRead)
+	dst = appendUvarint(dst, st.PacketsAcked)
+	dst = appendUvarint(dst, st.Resends)
+	dst = appendUvarint(dst, st.Duplicates)
+	dst = appendUvarint(dst, uint64(st.InFlight))
+
+	dst = appendUvarint(dst, uint64(st.PartialMessages))
+	dst = appendUvarint(dst, uint64(st.PartialFragments))
+
+	// Rates are not written, as they may be derived from the counters of consecutive snapshots.
+
+	return dst
+}
+
+func unmarshalSnapshot(buf []byte) (s Snapshot, err error) {
+	d := snapshotDecoder{buf: buf}
+
+	s.Time = time.Unix(0, d.varint())
+	s.Addr = string(d.bytes(int(d.uvarint())))
+
+	st := &s.Stats
+
+	st.WriteWaits = d.uvarint()
+	st.WriteWaitTotal = time.Duration(d.varint())
+	st.WriteWaitMax = time.Duration(d.varint())
+	st.AmplificationDrops = d.uvarint()
+	st.Reordered = d.uvarint()
+	st.ReorderDepthTotal = d.uvarint()
+	st.ReorderDepthMax = uint16(d.uvarint())
+	st.RateLimited = d.uvarint()
+	st.RateLimitWaitTotal = time.Duration(d.varint())
+	st.AcksConfirmed = d.uvarint()
+	st.AcksLost = d.uvarint()
+	st.CongestionReports = d.uvarint()
+	st.HeldDrops = d.uvarint()
+	st.Stale = d.uvarint()
+	st.WritePacketNumber = d.uvarint()
+	st.ReadPacketNumber = d.uvarint()
+
+	st.Syscalls.Writes = d.uvarint()
+	st.Syscalls.ShortWrites = d.uvarint()
+	st.Syscalls.Total = time.Duration(d.varint())
+	st.Syscalls.Max = time.Duration(d.varint())
+	for i := range st.Syscalls.Latency {
+		st.Syscalls.Latency[i] = d.uvarint()
+	}
+
+	for _, h := range [...]*LatencyHistogram{
+		&st.Lifecycle.Queue, &st.Lifecycle.Transmit, &st.Lifecycle.Ack, &st.Lifecycle.Release,
+	} {
+		h.Count = d.uvarint()
+		h.Total = time.Duration(d.varint())
+		for i := range h.Buckets {
+			h.Buckets[i] = d.uvarint()
+		}
+	}
+
+	st.RTT = time.Duration(d.varint())
+	st.AppLimited = d.byte() != 0
+
+	// Records written before fields were appended to the format leave them as zero.
+
+	if d.more() {
+		st.TransmitErrors = d.uvarint()
+		st.Anomalies = d.uvarint()
+		st.BreakerTrips = d.uvarint()
+		st.BreakerOpen = d.byte() != 0
+
+		st.Overhead.Datagrams = d.uvarint()
+		st.Overhead.PayloadBytes = d.uvarint()
+		st.Overhead.HeaderBytes = d.uvarint()
+		st.Overhead.ResentBytes = d.uvarint()
+		st.Overhead.AckBytes = d.uvarint()
+		st.Overhead.ControlBytes = d.uvarint()
+	}
+
+	if d.more() {
+		st.AckRangeFrames = d.uvarint()
+	}
+
+	if d.more() {
+		st.BadModeEntries = d.uvarint()
+		st.BadMode = d.byte() != 0
+	}
+
+	if d.more() {
+		st.FragmentedWrites = d.uvarint()
+		st.Reassembled = d.uvarint()
+		st.ReassemblyDrops = d.uvarint()
+	}
+
+	if d.more() {
+		st.KeepAlives = d.uvarint()
+	}
+
+	if d.more() {
+		st.ReliableWrites = d.uvarint()
+		st.UnreliableWrites = d.uvarint()
+		st.PacketsRead = d.uvarint()
+		st.ReliableReads = d.uvarint()
+		st.UnreliableReads = d.uvarint()
+		st.BytesRead = d.uvarint()
+		st.PacketsAcked = d.uvarint()
+		st.Resends = d.uvarint()
+		st.Duplicates = d.uvarint()
+		st.InFlight = uint16(d.uvarint())
+	}
+
+	if d.more() {
+		st.PartialMessages = int(d.uvarint())
+		st.PartialFragments = int(d.uvarint())
+	}
+
+	return s, d.err
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(dst []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+// snapshotDecoder decodes the fields of a snapshot, latching onto the first error it comes across.
+type snapshotDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) bytes(n int) []byte {
+	if d.err == nil && (n < 0 || n > len(d.buf)) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if d.err != nil {
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// more reports whether or not there are fields left to be decoded.
+func (d *snapshotDecoder) more() bool {
+	return d.err == nil && len(d.buf) > 0
+}
+
+func (d *snapshotDecoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+package reliable
+
+import (
+	"bytes"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	stats := ConnStats{
+		WriteWaits:         1,
+		WriteWaitTotal:     2 * time.Millisecond,
+		WriteWaitMax:       3 * time.Millisecond,
+		AmplificationDrops: 4,
+		Reordered:          5,
+		ReorderDepthTotal:  6,
+		ReorderDepthMax:    7,
+		RateLimited:        8,
+		RateLimitWaitTotal: 9 * time.Millisecond,
+		AcksConfirmed:      10,
+		AcksLost:           11,
+		CongestionReports:  12,
+		HeldDrops:          13,
+		Stale:              14,
+		WritePacketNumber:  1 << 40,
+		ReadPacketNumber:   1 << 41,
+		RTT:                15 * time.Millisecond,
+		AppLimited:         true,
+		TransmitErrors:     16,
+		Anomalies:          17,
+		BreakerTrips:       18,
+		BreakerOpen:        true,
+		Overhead: OverheadStats{
+			Datagrams:    19,
+			PayloadBytes: 20,
+			HeaderBytes:  21,
+			ResentBytes:  22,
+			AckBytes:     23,
+			ControlBytes: 24,
+		},
+		AckRangeFrames: 25,
+		BadModeEntries: 26,
+		BadMode:        true,
+
+		FragmentedWrites: 27,
+		Reassembled:      28,
+		ReassemblyDrops:  29,
+
+		KeepAlives: 30,
+
+		ReliableWrites:   31,
+		UnreliableWrites: 32,
+		PacketsRead:      33,
+		ReliableReads:    34,
+		UnreliableReads:  35,
+		BytesRead:        36,
+		PacketsAcked:     37,
+		Resends:          38,
+		Duplicates:       39,
+		InFlight:         40,
+
+		PartialMessages:  41,
+		PartialFragments: 42,
+	}
+	stats.Syscalls.add(20*time.Microsecond, true)
+	stats.Lifecycle.Ack.add(30 * time.Millisecond)
+	stats.Lifecycle.Release.add(40 * time.Millisecond)
+
+	snapshots := []Snapshot{
+		{Time: time.Unix(0, 1), Addr: "127.0.0.1:1", Stats: stats},
+		{Time: time.Unix(0, 2), Addr: "127.0.0.1:2"},
+		{Time: time.Unix(0, 3), Addr: "127.0.0.1:1", Stats: ConnStats{Stale: 1}},
+	}
+
+	var buf bytes.Buffer
+
+	w := NewSnapshotWriter(&buf)
+	for _, s := range snapshots {
+		require.NoError(t, w.Write(s))
+	}
+
+	series, err := ReadTimeSeries(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	require.Equal(t, []Snapshot{snapshots[0], snapshots[2]}, series["127.0.0.1:1"])
+	require.Equal(t, []Snapshot{snapshots[1]}, series["127.0.0.1:2"])
+
+	// Truncated streams and streams that are not of snapshots fail to be read.
+
+	_, err = ReadTimeSeries(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.Error(t, err)
+
+	_, err = NewSnapshotReader(bytes.NewReader([]byte("not snapshots"))).Read()
+	require.Equal(t, ErrBadSnapshotStream, err)
+
+	_, err = NewSnapshotReader(bytes.NewReader(nil)).Read()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestSnapshotReadsRecordsWithoutAppendedFields(t *testing.T) {
+	s := Snapshot{Time: time.Unix(0, 1), Addr: "127.0.0.1:1", Stats: ConnStats{Stale: 1}}
+
+	// Appended fields that are all zero take up a byte each.
+
+	buf := appendSnapshot(nil, s)
+	buf = buf[:len(buf)-29]
+
+	decoded, err := unmarshalSnapshot(buf)
+	require.NoError(t, err)
+	require.Equal(t, s, decoded)
+}
+
+func TestEndpointSnapshotEvery(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+
+	a := NewEndpoint(ca)
+	b := NewEndpoint(cb)
+
+	go a.Listen()
+	go b.Listen()
+
+	require.NoError(t, a.WriteReliablePacket([]byte("hello"), cb.LocalAddr()))
+
+	var (
+		mu  sync.Mutex
+		buf bytes.Buffer
+	)
+
+	exit := make(chan struct{})
+	done := make(chan error)
+
+	go func() { done <- a.SnapshotEvery(lockedWriter{&mu, &buf}, 1*time.Millisecond, exit) }()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		series, err := ReadTimeSeries(bytes.NewReader(buf.Bytes()))
+		return err == nil && len(series[cb.LocalAddr().String()]) >= 3
+	}, 1*time.Second, 1*time.Millisecond)
+
+	close(exit)
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	series, err := ReadTimeSeries(&buf)
+	mu.Unlock()
+	require.NoError(t, err)
+
+	snapshots := series[cb.LocalAddr().String()]
+	for i := 1; i < len(snapshots); i++ {
+		require.False(t, snapshots[i].Time.Before(snapshots[i-1].Time))
+	}
+	require.EqualValues(t, 1, snapshots[len(snapshots)-1].Stats.WritePacketNumber)
+
+	require.NoError(t, ca.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, cb.SetDeadline(time.Now().Add(1*time.Millisecond)))
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	require.NoError(t, ca.Close())
+	require.NoError(t, cb.Close())
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (w lockedWriter) Write(buf []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(buf)
+}
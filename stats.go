@@ -0,0 +1,243 @@
+package reliable
+
+import (
+	"sync"
+	"time"
+)
+
+// ConnStats is a snapshot of the statistics of a conn. Statistics are kept in a fixed-size struct updated while the
+// conn's mutex is held by the hot path anyway, such that collecting them neither allocates nor takes extra locks.
+type ConnStats struct {
+	ReliableWrites   uint64 // total number of reliable payloads written
+	UnreliableWrites uint64 // total number of unreliable payloads written
+	PacketsRead      uint64 // total number of packets read from our peer, acks and control packets included
+	ReliableReads    uint64 // total number of reliable packets read, duplicates included
+	UnreliableReads  uint64 // total number of unreliable packets read that carried a payload
+	BytesRead        uint64 // total number of payload bytes read from our peer
+
+	PacketsAcked uint64 // total number of reliable packets written that our peer acked
+	Resends      uint64 // total number of times unacked packets were resent
+	Duplicates   uint64 // total number of reliable packets dropped for having already been read
+
+	WriteWaits     uint64        // total number of reliable writes that had to wait for their turn to write
+	WriteWaitTotal time.Duration // total amount of time reliable writes spent waiting for their turn to write
+	WriteWaitMax   time.Duration // longest amount of time a single reliable write spent waiting for its turn to write
+
+	AmplificationDrops uint64 // total number of packets not sent to keep under the amplification limit
+
+	Reordered         uint64 // total number of reliable packets that arrived after a newer packet did
+	ReorderDepthTotal uint64 // sum of how many sequence numbers behind the newest packet each reordered packet was
+	ReorderDepthMax   uint16 // furthest a reordered packet was behind the newest packet in sequence numbers
+
+	RateLimited        uint64        // total number of writes that were delayed by a rate limit
+	RateLimitWaitTotal time.Duration // total amount of time writes were delayed by a rate limit
+
+	ResendsInterleaved uint64 // total number of resends that waited for fresh writes to take their turns
+	WritesInterleaved  uint64 // total number of fresh writes that waited for resends to take their turns
+
+	AcksConfirmed uint64 // total number of standalone acks that our peer acked in turn
+	AcksLost      uint64 // total number of standalone acks that our peer did not ack in time, and were resent
+
+	AckRangeFrames uint64 // total number of ack range frames written
+
+	FragmentedWrites uint64 // total number of payloads written split into fragments
+	Reassembled      uint64 // total number of payloads read reassembled out of fragments
+	ReassemblyDrops  uint64 // total number of partially read payloads dropped for timing out or to make room for others
+	TransfersStalled uint64 // total number of fragmented payloads written or read failed by the progress watchdog
+
+	CongestionReports uint64 // total number of congestion signals reported by the application
+
+	HeldDrops uint64 // total number of packets dropped for there being no buffer to hold them back from delivery in
+
+	Stale uint64 // total number of reliable packets dropped for lying outside of the reorder tolerance of the newest one
+
+	TransmitErrors uint64 // total number of writes to our peer that failed, after being retried should they be transient
+	Anomalies      uint64 // total number of stale packets, acks of packets that were never written, and invalid tokens
+	BreakerTrips   uint64 // total number of times the error budget was exceeded
+
+	BadModeEntries uint64 // total number of times conditions turned bad, putting this conn into bad mode
+
+	KeepAlives uint64 // total number of keepalives written for nothing having been written to our peer for a while
+
+	InFlight uint16 // number of reliable packets written that are yet to be acked
+
+	PartialMessages  int // number of payloads being reassembled of which only some fragments were read so far
+	PartialFragments int // number of fragments read so far of payloads being reassembled
+
+	WritePacketNumber uint64 // 64-bit logical number the next reliable packet written will be assigned
+	ReadPacketNumber  uint64 // 64-bit logical number following that of the newest reliable packet read
+
+	Syscalls WriteStats // latency of write syscalls made to our peer
+
+	Overhead OverheadStats // bytes written to our peer by what they were spent on
+
+	Lifecycle LifecycleStats // latency of each stage of the lifecycle of reliable packets written to our peer
+
+	RTT time.Duration // smoothed round-trip time to our peer, or zero if not yet sampled
+
+	PassiveRTTSamples uint64 // total number of round-trip times sampled off timestamp echoes rather than acks
+
+	BreakerOpen bool // whether or not writes and resends are stopped for the error budget having been exceeded
+
+	BadMode bool // whether or not fewer packets may be in flight and resends are stretched for conditions being bad
+
+	AppLimited bool // whether or not writes were limited by the application having nothing to send as of the last update
+
+	Rates1s  Rates // rates of traffic to and from our peer averaged over the last second
+	Rates10s Rates // rates of traffic to and from our peer averaged over the last 10 seconds
+}
+
+// ReorderDepthMean returns how many sequence numbers behind the newest packet reordered packets were on average.
+func (s ConnStats) ReorderDepthMean() float64 {
+	if s.Reordered == 0 {
+		return 0
+	}
+	return float64(s.ReorderDepthTotal) / float64(s.Reordered)
+}
+
+// OverheadStats breaks down the bytes written to our peer, not counting ip and udp headers, by what they were spent
+// on, such that the cost of the protocol on top of the application payload may be quantified.
+type OverheadStats struct {
+	Datagrams uint64 // total number of datagrams written, each of which costs 28 bytes of ipv4 and udp headers, or 48 on ipv6
+
+	PayloadBytes uint64 // total number of application payload bytes written, counting each packet once
+	HeaderBytes  uint64 // total number of bytes of headers of packets carrying application payloads, counting each packet once
+	ResentBytes  uint64 // total number of bytes of packets resent, headers included
+	AckBytes     uint64 // total number of bytes of standalone acks
+	ControlBytes uint64 // total number of bytes of control packets, such as close notifications
+}
+
+// WireBytes returns the total number of bytes written to our peer, not counting ip and udp headers.
+func (s OverheadStats) WireBytes() uint64 {
+	return s.PayloadBytes + s.HeaderBytes + s.ResentBytes + s.AckBytes + s.ControlBytes
+}
+
+// Amplification returns how many bytes were written to our peer per application payload byte, or zero should no
+// application payload have been written.
+func (s OverheadStats) Amplification() float64 {
+	if s.PayloadBytes == 0 {
+		return 0
+	}
+	return float64(s.WireBytes()) / float64(s.PayloadBytes)
+}
+
+// wireKind is what a packet written to our peer is spent on.
+type wireKind uint8
+
+const (
+	wirePayload wireKind = iota // a packet carrying an application payload, written for the first time
+	wireResend                  // a reliable packet resent for not having been acked in time
+	wireAck                     // a standalone ack
+	wireControl                 // a control packet
+)
+
+// trackWire tracks a packet of n bytes of the given kind carrying payload bytes of application payload as having
+// been written to our peer.
+func (c *Conn) trackWire(kind wireKind, n, payload int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ls = time.Now()
+	c.rates.wrote(c.ls, n)
+
+	s := &c.stats.Overhead
+	s.Datagrams++
+
+	switch kind {
+	case wirePayload:
+		s.PayloadBytes += uint64(payload)
+		s.HeaderBytes += uint64(n - payload)
+	case wireResend:
+		s.ResentBytes += uint64(n)
+	case wireAck:
+		s.AckBytes += uint64(n)
+	case wireControl:
+		s.ControlBytes += uint64(n)
+	}
+}
+
+// trackReadWire tracks a packet described by header carrying n bytes of payload as having been read from our peer.
+func (c *Conn) trackReadWire(header PacketHeader, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rates.read(time.Now(), n)
+
+	c.stats.PacketsRead++
+	c.stats.BytesRead += uint64(n)
+
+	switch {
+	case !header.Unordered:
+		c.stats.ReliableReads++
+	case !header.Empty:
+		c.stats.UnreliableReads++
+	}
+}
+
+// trackWritten tracks a payload as having been written to our peer.
+func (c *Conn) trackWritten(reliable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reliable {
+		c.stats.ReliableWrites++
+	} else {
+		c.stats.UnreliableWrites++
+	}
+}
+
+// WriteLatencyBuckets is the number of buckets write syscall latencies are sorted into. Bucket i counts writes that
+// took less than 2^i microseconds, with the last bucket also counting all writes that took any longer.
+const WriteLatencyBuckets = 16
+
+// WriteStats describes how long write syscalls made to a socket took to return.
+type WriteStats struct {
+	Writes      uint64        // total number of write syscalls made, retries included
+	ShortWrites uint64        // total number of write syscalls that wrote out only part of a packet
+	Total       time.Duration // total amount of time spent in write syscalls
+	Max         time.Duration // longest amount of time a single write syscall took
+
+	Latency [WriteLatencyBuckets]uint64 // number of write syscalls per latency bucket
+}
+
+// Mean returns how long write syscalls took on average.
+func (s WriteStats) Mean() time.Duration {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Writes)
+}
+
+func (s *WriteStats) add(took time.Duration, short bool) {
+	s.Writes++
+	if short {
+		s.ShortWrites++
+	}
+
+	s.Total += took
+	if took > s.Max {
+		s.Max = took
+	}
+
+	s.Latency[latencyBucket(took, WriteLatencyBuckets)]++
+}
+
+// writeStats is WriteStats shared by all conns of an Endpoint.
+type writeStats struct {
+	mu sync.Mutex
+	s  WriteStats
+}
+
+func (w *writeStats) add(took time.Duration, short bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.s.add(took, short)
+}
+
+func (w *writeStats) snapshot() WriteStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.s
+}
@@ -0,0 +1,209 @@
+package reliable
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// streamChunkSize is the max number of bytes of a stream written in a single reliable packet should the conn of the
+// stream not split payloads into fragments.
+const streamChunkSize = 1024
+
+// stream adapts a conn into a net.Conn carrying a byte stream over its reliable packets.
+type stream struct {
+	c *Conn
+
+	in     chan []byte   // payloads of reliable packets yet to be read, in the order our peer sent them
+	closed chan struct{} // closed once this stream is closed
+	once   sync.Once
+
+	rmu  sync.Mutex
+	rbuf []byte // remainder of the payload last taken off of in that is yet to be read
+	rd   streamDeadline
+
+	wmu sync.Mutex
+}
+
+// NewStream adapts c into a net.Conn whose writes are split up into reliable packets, and whose reads return the
+// payloads of reliable packets read from our peer in the order they were sent. NewStream takes over handling of the
+// reliable packets of c, and has them delivered in order, so it must be called before c reads any packets.
+//
+// Packets read are buffered until a read buffer's worth of them are yet to be read, after which packets read from our
+// peer are dropped without being read or acked until the stream is read from, such that they get resent and writes of
+// our peer block once our read buffer fills up. Reading packets from our peer never blocks on the stream, so that an
+// endpoint c belongs to keeps on reading packets from its other peers.
+func NewStream(c *Conn) net.Conn {
+	s := &stream{c: c, in: make(chan []byte, len(c.rq)), closed: make(chan struct{})}
+	s.rd.expired = make(chan struct{})
+
+	c.mu.Lock()
+	c.rph = s.handle
+	if c.ordered == nil {
+		c.ordered = &orderedPackets{}
+	}
+	c.mu.Unlock()
+
+	c.ordered.mu.Lock()
+	c.ordered.pending = func() int { return len(s.in) }
+	c.ordered.mu.Unlock()
+
+	return s
+}
+
+// handle copies the payload of a reliable packet read from our peer into the stream. Packets are only read while
+// those buffered in the stream and those held back ahead of them fit in a read buffer, so there is always room for it.
+func (s *stream) handle(_ net.Addr, _ uint16, buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+
+	select {
+	case s.in <- append(make([]byte, 0, len(buf)), buf...):
+	case <-s.closed:
+	case <-s.c.exit:
+	}
+}
+
+// Read reads bytes read from our peer, returning io.EOF once the stream or its conn is closed and all bytes read
+// before were read, or os.ErrDeadlineExceeded should the read deadline pass.
+func (s *stream) Read(b []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// Bytes already read from our peer are read out before the stream being closed is reported.
+
+	for len(s.rbuf) == 0 {
+		select {
+		case s.rbuf = <-s.in:
+			continue
+		default:
+		}
+
+		select {
+		case s.rbuf = <-s.in:
+		case <-s.closed:
+			return 0, io.EOF
+		case <-s.c.exit:
+			return 0, io.EOF
+		case <-s.rd.done():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(b, s.rbuf)
+	s.rbuf = s.rbuf[n:]
+
+	return n, nil
+}
+
+// Write writes b to our peer split up into reliable packets, blocking while our peer's read buffer is full. It
+// returns the number of bytes written before a write of a packet failed should one fail.
+func (s *stream) Write(b []byte) (n int, err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	chunk := streamChunkSize
+	if s.c.fragmentSize > 0 {
+		chunk = s.c.fragmentSize
+	}
+
+	for n < len(b) {
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+
+		if err := s.c.WriteReliablePacket(b[n:end]); err != nil {
+			return n, err
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+// Close closes the stream along with its conn, unblocking all reads.
+func (s *stream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	s.c.Close()
+	return nil
+}
+
+func (s *stream) LocalAddr() net.Addr  { return s.c.conn.LocalAddr() }
+func (s *stream) RemoteAddr() net.Addr { return s.c.peer() }
+
+func (s *stream) SetDeadline(t time.Time) error {
+	s.rd.set(t)
+	return s.c.SetWriteDeadline(t)
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.rd.set(t)
+	return nil
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	return s.c.SetWriteDeadline(t)
+}
+
+// streamDeadline is a deadline that pending reads of a stream wait on.
+type streamDeadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	gen     uint64        // bumped every time the deadline is set, such that timers of former deadlines do nothing
+	expired chan struct{} // closed once the deadline passes
+}
+
+// set has the deadline pass at t, or never pass should t be zero.
+func (d *streamDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.gen++
+
+	select {
+	case <-d.expired:
+		d.expired = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.expired)
+		return
+	}
+
+	gen := d.gen
+
+	d.timer = time.AfterFunc(dur, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if d.gen == gen {
+			close(d.expired)
+		}
+	})
+}
+
+func (d *streamDeadline) done() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.expired
+}
@@ -0,0 +1,126 @@
+package reliable
+
+import (
+	"bytes"
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	a, b, cleanup := Pair(WithUpdatePeriod(10 * time.Millisecond))
+	defer cleanup()
+
+	sa, sb := NewStream(a), NewStream(b)
+	require.Equal(t, b.conn.LocalAddr(), sa.RemoteAddr())
+	require.Equal(t, a.conn.LocalAddr(), sa.LocalAddr())
+
+	payload := make([]byte, 256*1024)
+	_, err := rand.New(rand.NewSource(0)).Read(payload)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := sa.Write(payload)
+		errs <- err
+	}()
+
+	// The byte stream is read back intact, regardless of how it was split up into packets.
+
+	got := make([]byte, len(payload))
+	_, err = io.ReadFull(sb, got)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(payload, got))
+	require.NoError(t, <-errs)
+
+	require.NoError(t, sb.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = sb.Read(got)
+	require.Equal(t, os.ErrDeadlineExceeded, err)
+
+	require.NoError(t, sb.SetReadDeadline(time.Time{}))
+	require.NoError(t, sb.Close())
+	_, err = sb.Read(got)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	a, b, cleanup := Pair(WithReadBufferSize(32), WithWriteBufferSize(32), WithUpdatePeriod(10*time.Millisecond))
+	defer cleanup()
+
+	sa, sb := NewStream(a), NewStream(b)
+
+	// Writes block once the reader falls behind by more than what the reading conn buffers.
+
+	payload := make([]byte, 256*streamChunkSize)
+
+	require.NoError(t, sa.SetWriteDeadline(time.Now().Add(200*time.Millisecond)))
+	n, err := sa.Write(payload)
+	require.Equal(t, os.ErrDeadlineExceeded, err)
+	require.Greater(t, n, 0)
+	require.Less(t, n, len(payload))
+
+	// Bytes written before writes blocked are all read once the reader catches up.
+
+	got := make([]byte, n)
+	_, err = io.ReadFull(sb, got)
+	require.NoError(t, err)
+}
+
+func TestStreamDoesNotBlockReads(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), nil, WithReadBufferSize(32))
+	s := NewStream(c)
+
+	// Packets read once the stream falls a read buffer's worth of packets behind are dropped without being read,
+	// rather than blocking the reader until the stream is read from.
+
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2*len(c.rq); i++ {
+			if err := c.Read(PacketHeader{Sequence: uint16(i)}, []byte{byte(i)}); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "reading packets blocked on the stream")
+	}
+
+	require.Len(t, s.(*stream).in, len(c.rq))
+	require.NotEqual(t, uint32(len(c.rq)), c.rq[0])
+	require.EqualValues(t, len(c.rq), c.Stats().ReliableReads)
+
+	// Packets dropped are read once resent after the stream catches up.
+
+	got := make([]byte, len(c.rq))
+	_, err := io.ReadFull(s, got)
+	require.NoError(t, err)
+
+	for i := len(c.rq); i < 2*len(c.rq); i++ {
+		require.NoError(t, c.Read(PacketHeader{Sequence: uint16(i)}, []byte{byte(i)}))
+	}
+
+	_, err = io.ReadFull(s, got)
+	require.NoError(t, err)
+	for i := range got {
+		require.EqualValues(t, len(c.rq)+i, got[i])
+	}
+
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,48 @@
+package reliable
+
+import "time"
+
+// ExpectWriteSoon hints that the application is about to write a packet to our peer. Standalone acks that would
+// otherwise be written within the ack suppression window are held back, such that they get piggybacked onto the
+// packet instead. Acks still held back once the window passes are written out regardless.
+func (c *Conn) ExpectWriteSoon() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.die {
+		return
+	}
+
+	c.suppressUntil = time.Now().Add(c.ackSuppression)
+
+	if c.suppressTimer == nil {
+		c.suppressTimer = time.AfterFunc(c.ackSuppression, c.flushSuppressedAcks)
+	} else {
+		c.suppressTimer.Reset(c.ackSuppression)
+	}
+}
+
+// acksSuppressed reports whether or not the application hinted that it is about to write a packet.
+func (c *Conn) acksSuppressed(now time.Time) bool {
+	return now.Before(c.suppressUntil)
+}
+
+func (c *Conn) flushSuppressedAcks() {
+	if !c.enter() {
+		return
+	}
+	defer c.leave()
+
+	if err := c.flushDeferredAcks(); err != nil {
+		c.reportError(err)
+	}
+}
+
+func (c *Conn) stopSuppressTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.suppressTimer != nil {
+		c.suppressTimer.Stop()
+	}
+}
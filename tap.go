@@ -0,0 +1,122 @@
+package reliable
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Observation is a copy of either a protocol event that occurred on, or a payload delivered by, an observed conn.
+type Observation struct {
+	Addr      net.Addr
+	Event     Event
+	Delivered bool   // whether or not this is a delivered payload rather than a protocol event
+	Payload   []byte // copy of the delivered payload, or nil for protocol events
+}
+
+// Tap is a read-only observer of conns. Observations are queued up on C, and dropped should C be full, such that a
+// slow observer never holds up the conns it observes.
+type Tap struct {
+	C <-chan Observation
+
+	ch      chan Observation
+	set     *tapSet
+	dropped uint64
+}
+
+// Dropped returns the number of observations dropped so far because C was full.
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Close detaches this tap from the conns it observes and closes C.
+func (t *Tap) Close() {
+	t.set.remove(t)
+}
+
+// tapSet is the set of taps attached to a conn or to all conns of an endpoint.
+type tapSet struct {
+	n    int32 // number of attached taps, checked before anything is copied
+	mu   sync.RWMutex
+	taps map[*Tap]struct{}
+}
+
+func (s *tapSet) add(size int) *Tap {
+	ch := make(chan Observation, size)
+	t := &Tap{C: ch, ch: ch, set: s}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.taps == nil {
+		s.taps = make(map[*Tap]struct{})
+	}
+	s.taps[t] = struct{}{}
+	atomic.StoreInt32(&s.n, int32(len(s.taps)))
+
+	return t
+}
+
+func (s *tapSet) remove(t *Tap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.taps[t]; !exists {
+		return
+	}
+	delete(s.taps, t)
+	atomic.StoreInt32(&s.n, int32(len(s.taps)))
+
+	close(t.ch)
+}
+
+func (s *tapSet) active() bool {
+	return s != nil && atomic.LoadInt32(&s.n) > 0
+}
+
+func (s *tapSet) observe(o Observation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for t := range s.taps {
+		if o.Payload != nil {
+			o.Payload = append([]byte(nil), o.Payload...)
+		}
+
+		select {
+		case t.ch <- o:
+		default:
+			atomic.AddUint64(&t.dropped, 1)
+		}
+	}
+}
+
+// Tap attaches a new read-only observer to this conn, queueing up to size observations at once.
+func (c *Conn) Tap(size int) *Tap {
+	return c.taps.add(size)
+}
+
+func (c *Conn) observe(o Observation) {
+	if !c.taps.active() && !c.etaps.active() {
+		return
+	}
+
+	o.Addr = c.peer()
+	if o.Event.Time.IsZero() {
+		o.Event.Time = time.Now()
+	}
+
+	if c.taps.active() {
+		c.taps.observe(o)
+	}
+	if c.etaps.active() {
+		c.etaps.observe(o)
+	}
+}
+
+// Tap attaches a new read-only observer to all conns of this endpoint, including conns created afterwards,
+// queueing up to size observations at once.
+func (e *Endpoint) Tap(size int) *Tap {
+	return e.taps.add(size)
+}
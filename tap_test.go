@@ -0,0 +1,77 @@
+package reliable
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"testing"
+)
+
+func TestConnTap(t *testing.T) {
+	c := NewConn(reliabletest.NewFaultConn(nil), nil)
+
+	tap := c.Tap(2)
+
+	require.NoError(t, c.WriteReliablePacket([]byte("hello")))
+
+	buf := []byte("world")
+	require.NoError(t, c.Read(PacketHeader{Sequence: 0, Unordered: true}, buf))
+
+	// The tap only has room for the send and the receive, so the delivery is dropped.
+
+	o := <-tap.C
+	require.False(t, o.Delivered)
+	require.Equal(t, EventSend, o.Event.Type)
+	require.EqualValues(t, 5, o.Event.Size)
+
+	o = <-tap.C
+	require.False(t, o.Delivered)
+	require.Equal(t, EventRecv, o.Event.Type)
+
+	require.EqualValues(t, 1, tap.Dropped())
+
+	require.NoError(t, c.Read(PacketHeader{Sequence: 1, Unordered: true}, buf))
+	<-tap.C
+
+	o = <-tap.C
+	require.True(t, o.Delivered)
+	require.Equal(t, []byte("world"), o.Payload)
+
+	// Payloads are copied, so observers may not modify what the packet handler sees.
+
+	o.Payload[0] = 'x'
+	require.Equal(t, []byte("world"), buf)
+
+	tap.Close()
+	tap.Close()
+
+	_, open := <-tap.C
+	require.False(t, open)
+
+	require.NoError(t, c.WriteReliablePacket(nil))
+	require.False(t, c.taps.active())
+}
+
+func TestEndpointTapObservesNewConns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	network := reliabletest.NewNetwork(0)
+
+	ca, cb := network.Listen(), network.Listen()
+	defer ca.Close()
+	defer cb.Close()
+
+	e := NewEndpoint(ca)
+
+	tap := e.Tap(8)
+	defer tap.Close()
+
+	require.NoError(t, e.WriteUnreliablePacket(nil, cb.LocalAddr()))
+
+	o := <-tap.C
+	require.Equal(t, EventSend, o.Event.Type)
+	require.Equal(t, cb.LocalAddr(), o.Addr)
+
+	e.clearConns()
+	require.NoError(t, e.Close())
+}
@@ -0,0 +1,437 @@
+package reliable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connect tokens are minted by whoever holds a token key, such as an auth service external to the server, and are
+// presented by clients to servers sharing the key using Conn.PresentToken. A token is laid out as follows, with all
+// integers being big-endian:
+//
+//   magic     [4]byte   TokenMagic
+//   version   uint8     TokenVersion
+//   key id    uint32    id of the token key the token was minted with
+//   issued    int64     unix time in seconds the token was minted at
+//   expires   int64     unix time in seconds the token expires at
+//   client id uint64    id of the client assigned by the minter
+//   addr size uint8
+//   addr      []byte    address of the client the token is bound to as formatted by net.Addr.String, or empty
+//   data size uint16
+//   data      []byte    opaque user data
+//   mac       [32]byte  HMAC-SHA256 of everything before it, keyed with the secret of the token key
+//
+// Servers look the token key up by its id, such that keys may be rotated while tokens minted with older keys are
+// still in circulation.
+
+// TokenMagic prefixes every connect token.
+const TokenMagic = "RLTK"
+
+// TokenVersion is the version of the connect token format minted by this package.
+const TokenVersion = 1
+
+// MaxTokenUserDataSize is the max number of bytes of user data a connect token may carry.
+const MaxTokenUserDataSize = 512
+
+const (
+	tokenHeaderSize = len(TokenMagic) + 1 + 4 + 8 + 8 + 8
+	tokenMACSize    = sha256.Size
+)
+
+var (
+	ErrTokenMalformed    = errors.New("connect token is malformed")
+	ErrTokenVersion      = errors.New("connect token is of an unsupported version")
+	ErrTokenUnknownKey   = errors.New("connect token was minted with an unknown key")
+	ErrTokenForged       = errors.New("connect token failed its integrity check")
+	ErrTokenExpired      = errors.New("connect token expired")
+	ErrTokenAddrMismatch = errors.New("connect token is bound to a different address")
+)
+
+type ConnectToken struct {
+	ClientID uint64    // id of the client assigned by the minter
+	Addr     string    // address of the client the token is bound to, or empty should it be usable from anywhere
+	Issued   time.Time // when the token was minted, at a granularity of seconds
+	Expires  time.Time // when the token expires, at a granularity of seconds
+	UserData []byte    // opaque data passed on from the minter to servers, such as permissions
+}
+
+// TokenKey is a secret shared by minters and servers that connect tokens are integrity-protected with.
+type TokenKey struct {
+	ID     uint32
+	Secret []byte // should be at least 32 random bytes
+}
+
+// MintToken returns token as a connect token integrity-protected with key.
+func MintToken(key TokenKey, token ConnectToken) ([]byte, error) {
+	if len(token.Addr) > math.MaxUint8 {
+		return nil, fmt.Errorf("connect token address may be at most %d bytes", math.MaxUint8)
+	}
+	if len(token.UserData) > MaxTokenUserDataSize {
+		return nil, fmt.Errorf("connect token user data may be at most %d bytes", MaxTokenUserDataSize)
+	}
+
+	buf := make([]byte, 0, tokenHeaderSize+1+len(token.Addr)+2+len(token.UserData)+tokenMACSize)
+
+	var b [8]byte
+
+	buf = append(buf, TokenMagic...)
+	buf = append(buf, TokenVersion)
+	binary.BigEndian.PutUint32(b[:4], key.ID)
+	buf = append(buf, b[:4]...)
+	binary.BigEndian.PutUint64(b[:], uint64(token.Issued.Unix()))
+	buf = append(buf, b[:]...)
+	binary.BigEndian.PutUint64(b[:], uint64(token.Expires.Unix()))
+	buf = append(buf, b[:]...)
+	binary.BigEndian.PutUint64(b[:], token.ClientID)
+	buf = append(buf, b[:]...)
+	buf = append(buf, uint8(len(token.Addr)))
+	buf = append(buf, token.Addr...)
+	binary.BigEndian.PutUint16(b[:2], uint16(len(token.UserData)))
+	buf = append(buf, b[:2]...)
+	buf = append(buf, token.UserData...)
+
+	return append(buf, tokenMAC(key.Secret, buf)...), nil
+}
+
+func tokenMAC(secret, buf []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(buf)
+	return mac.Sum(nil)
+}
+
+// tokenKeyID returns the id of the token key buf was minted with, checking that buf is of a supported version.
+func tokenKeyID(buf []byte) (uint32, error) {
+	if len(buf) < tokenHeaderSize+1+2+tokenMACSize || string(buf[:len(TokenMagic)]) != TokenMagic {
+		return 0, ErrTokenMalformed
+	}
+	if buf[len(TokenMagic)] != TokenVersion {
+		return 0, ErrTokenVersion
+	}
+	return binary.BigEndian.Uint32(buf[len(TokenMagic)+1:]), nil
+}
+
+// openToken checks that buf was minted with key, and then unmarshals it.
+func openToken(key TokenKey, buf []byte) (token ConnectToken, err error) {
+	body, mac := buf[:len(buf)-tokenMACSize], buf[len(buf)-tokenMACSize:]
+	if !hmac.Equal(mac, tokenMAC(key.Secret, body)) {
+		return token, ErrTokenForged
+	}
+
+	body = body[len(TokenMagic)+1+4:]
+
+	token.Issued = time.Unix(int64(binary.BigEndian.Uint64(body)), 0)
+	token.Expires = time.Unix(int64(binary.BigEndian.Uint64(body[8:])), 0)
+	token.ClientID = binary.BigEndian.Uint64(body[16:])
+	body = body[24:]
+
+	n := int(body[0])
+	if len(body) < 1+n+2 {
+		return token, ErrTokenMalformed
+	}
+	token.Addr, body = string(body[1:1+n]), body[1+n:]
+
+	n = int(binary.BigEndian.Uint16(body))
+	if len(body) != 2+n || n > MaxTokenUserDataSize {
+		return token, ErrTokenMalformed
+	}
+	if n > 0 {
+		token.UserData = append([]byte(nil), body[2:]...)
+	}
+
+	return token, nil
+}
+
+// Keyring holds the token keys connect tokens are minted and verified with. The newest key added is the current key
+// tokens are minted with, while older keys are kept around to verify tokens minted before they were rotated out.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    []TokenKey           // keys from newest to oldest
+	retires map[uint32]time.Time // when keys rotated out with an overlap period stop verifying tokens
+}
+
+func NewKeyring(current TokenKey, previous ...TokenKey) *Keyring {
+	k := &Keyring{}
+	for i := len(previous) - 1; i >= 0; i-- {
+		k.Rotate(previous[i])
+	}
+	k.Rotate(current)
+	return k
+}
+
+// Rotate makes key the current key tokens are minted with, replacing any key of the same id.
+func (k *Keyring) Rotate(key TokenKey) {
+	if len(key.Secret) == 0 {
+		panic("token key secret must not be empty")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := make([]TokenKey, 0, len(k.keys)+1)
+	keys = append(keys, key)
+	for _, existing := range k.keys {
+		if existing.ID != key.ID {
+			keys = append(keys, existing)
+		}
+	}
+	k.keys = keys
+
+	delete(k.retires, key.ID)
+}
+
+// RotateWithOverlap makes key the current key tokens are minted with as Rotate does, and retires every older key once
+// overlap elapses, such that peers have the overlap period to present tokens minted with key. Older keys already due
+// to be retired sooner are retired as scheduled.
+func (k *Keyring) RotateWithOverlap(key TokenKey, overlap time.Duration) {
+	if overlap < 0 {
+		panic("token key overlap period must not be negative")
+	}
+
+	k.Rotate(key)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	at := time.Now().Add(overlap)
+
+	if k.retires == nil {
+		k.retires = make(map[uint32]time.Time)
+	}
+	for _, existing := range k.keys[1:] {
+		if retires, ok := k.retires[existing.ID]; !ok || at.Before(retires) {
+			k.retires[existing.ID] = at
+		}
+	}
+}
+
+// Retire removes the key of the given id, such that tokens minted with it no longer verify. The current key may not
+// be retired.
+func (k *Keyring) Retire(id uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := k.keys[:1:1]
+	for _, existing := range k.keys[1:] {
+		if existing.ID != id {
+			keys = append(keys, existing)
+		}
+	}
+	k.keys = keys
+
+	delete(k.retires, id)
+}
+
+// Mint returns token as a connect token integrity-protected with the current key.
+func (k *Keyring) Mint(token ConnectToken) ([]byte, error) {
+	k.mu.RLock()
+	key := k.keys[0]
+	k.mu.RUnlock()
+
+	return MintToken(key, token)
+}
+
+// Verify checks that buf is a connect token minted with one of the keys of this keyring that has not expired as of
+// now, and that it is bound to addr should it be bound to an address, returning the token.
+func (k *Keyring) Verify(buf []byte, addr net.Addr, now time.Time) (ConnectToken, error) {
+	id, err := tokenKeyID(buf)
+	if err != nil {
+		return ConnectToken{}, err
+	}
+
+	key, ok := k.key(id, now)
+	if !ok {
+		return ConnectToken{}, ErrTokenUnknownKey
+	}
+
+	token, err := openToken(key, buf)
+	if err != nil {
+		return ConnectToken{}, err
+	}
+
+	if !now.Before(token.Expires) {
+		return ConnectToken{}, ErrTokenExpired
+	}
+	if token.Addr != "" && (addr == nil || addr.String() != token.Addr) {
+		return ConnectToken{}, ErrTokenAddrMismatch
+	}
+
+	return token, nil
+}
+
+func (k *Keyring) key(id uint32, now time.Time) (TokenKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if retires, ok := k.retires[id]; ok && !now.Before(retires) {
+		return TokenKey{}, false
+	}
+
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return TokenKey{}, false
+}
+
+// Current returns the id of the current key tokens are minted with.
+func (k *Keyring) Current() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.keys[0].ID
+}
+
+// PresentToken presents a connect token to our peer, such as one minted by an auth service. The token is sent once and
+// unreliably as a control packet, so it should be presented again should our peer not act on it.
+func (c *Conn) PresentToken(token []byte) error {
+	buf := make([]byte, 0, 1+len(token))
+	buf = append(buf, byte(controlToken))
+	buf = append(buf, token...)
+
+	if err := c.writeControl(buf); err != nil {
+		return fmt.Errorf("failed to write connect token: %w", err)
+	}
+
+	return nil
+}
+
+// readToken verifies a connect token presented by our peer should a keyring be set. A valid token bound to the
+// address of our peer proves that our peer is reachable at it, lifting the amplification limit. Invalid tokens are
+// counted as protocol anomalies.
+func (c *Conn) readToken(buf []byte) {
+	if c.keyring == nil {
+		return
+	}
+
+	token, err := c.keyring.Verify(buf, c.peer(), time.Now())
+	if err != nil {
+		c.trackFault(faultAnomaly)
+		c.reportError(fmt.Errorf("failed to verify connect token: %w", err))
+		return
+	}
+
+	id, _ := tokenKeyID(buf)
+
+	c.mu.Lock()
+	c.token = &token
+	c.tokenKey = id
+	c.tokenKeyState = tokenKeyCurrent
+	if token.Addr != "" {
+		c.validated = true
+	}
+	c.mu.Unlock()
+
+	if c.onToken != nil {
+		c.onToken(token)
+	}
+}
+
+// Token returns the connect token our peer presented, reporting false should it not have presented a valid one.
+func (c *Conn) Token() (ConnectToken, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == nil {
+		return ConnectToken{}, false
+	}
+	return *c.token, true
+}
+
+// Token returns the connect token the peer at addr presented, reporting false should there be no conn to addr or
+// should the peer not have presented a valid one.
+func (e *Endpoint) Token(addr net.Addr) (ConnectToken, bool) {
+	conn := e.lookupConn(addr)
+
+	if conn == nil {
+		return ConnectToken{}, false
+	}
+	return conn.Token()
+}
+
+type tokenKeyState uint8
+
+const (
+	tokenKeyCurrent tokenKeyState = iota // the token was minted with the current key
+	tokenKeyStale                        // the token was minted with a key since rotated out
+	tokenKeyRetired                      // the token was minted with a key since retired
+)
+
+// checkTokenKey reports should the key the connect token our peer presented was minted with have been rotated out or
+// retired since, such that our peer is still authenticated with old key material and should present a token minted
+// with the current key. Each change is reported once per token presented.
+func (c *Conn) checkTokenKey(now time.Time) {
+	if c.keyring == nil || c.onTokenKey == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.token == nil {
+		c.mu.Unlock()
+		return
+	}
+	id, reported := c.tokenKey, c.tokenKeyState
+	c.mu.Unlock()
+
+	state := tokenKeyCurrent
+	if _, ok := c.keyring.key(id, now); !ok {
+		state = tokenKeyRetired
+	} else if c.keyring.Current() != id {
+		state = tokenKeyStale
+	}
+
+	if state <= reported {
+		return
+	}
+
+	c.mu.Lock()
+	if c.tokenKey != id || c.tokenKeyState != reported {
+		c.mu.Unlock()
+		return
+	}
+	c.tokenKeyState = state
+	c.mu.Unlock()
+
+	c.onTokenKey(id, state == tokenKeyRetired)
+}
+
+// TokenKey returns the id of the token key the connect token our peer presented was minted with, reporting false
+// should it not have presented a valid one.
+func (c *Conn) TokenKey() (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokenKey, c.token != nil
+}
+
+// StaleConns returns the addresses of peers that authenticated with connect tokens minted with a key other than the
+// current key of the keyring of this endpoint, such as after it was rotated.
+func (e *Endpoint) StaleConns() []net.Addr {
+	if e.keyring == nil {
+		return nil
+	}
+
+	current := e.keyring.Current()
+
+	e.mu.Lock()
+	conns := make([]*Conn, 0, len(e.conns))
+	for _, conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	var addrs []net.Addr
+	for _, conn := range conns {
+		if id, ok := conn.TokenKey(); ok && id != current {
+			addrs = append(addrs, conn.peer())
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,196 @@
+package reliable
+
+import (
+	"github.com/lithdew/reliable/reliabletest"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenMintAndVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+
+	old := TokenKey{ID: 1, Secret: []byte("old secret")}
+	cur := TokenKey{ID: 2, Secret: []byte("current secret")}
+
+	token := ConnectToken{
+		ClientID: 42,
+		Addr:     addr.String(),
+		Issued:   now,
+		Expires:  now.Add(time.Minute),
+		UserData: []byte("admin"),
+	}
+
+	minted, err := MintToken(old, token)
+	require.NoError(t, err)
+
+	// Tokens minted with a key rotated out still verify until the key is retired.
+
+	k := NewKeyring(cur, old)
+
+	verified, err := k.Verify(minted, addr, now)
+	require.NoError(t, err)
+	require.Equal(t, token, verified)
+
+	k.Retire(old.ID)
+	_, err = k.Verify(minted, addr, now)
+	require.Equal(t, ErrTokenUnknownKey, err)
+
+	minted, err = k.Mint(token)
+	require.NoError(t, err)
+
+	_, err = k.Verify(minted, addr, now)
+	require.NoError(t, err)
+
+	_, err = k.Verify(minted, addr, now.Add(time.Minute))
+	require.Equal(t, ErrTokenExpired, err)
+
+	_, err = k.Verify(minted, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4321}, now)
+	require.Equal(t, ErrTokenAddrMismatch, err)
+
+	forged := append([]byte(nil), minted...)
+	forged[len(forged)-tokenMACSize-1] ^= 1
+	_, err = k.Verify(forged, addr, now)
+	require.Equal(t, ErrTokenForged, err)
+
+	future := append([]byte(nil), minted...)
+	future[len(TokenMagic)] = TokenVersion + 1
+	_, err = k.Verify(future, addr, now)
+	require.Equal(t, ErrTokenVersion, err)
+
+	_, err = k.Verify(minted[:len(minted)/2], addr, now)
+	require.Equal(t, ErrTokenMalformed, err)
+
+	_, err = MintToken(cur, ConnectToken{UserData: make([]byte, MaxTokenUserDataSize+1)})
+	require.Error(t, err)
+
+	require.Panics(t, func() { k.Rotate(TokenKey{ID: 3}) })
+}
+
+func TestConnPresentedTokenValidatesPeer(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	k := NewKeyring(TokenKey{ID: 1, Secret: []byte("secret")})
+
+	unbound, err := k.Mint(ConnectToken{ClientID: 1, Expires: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+
+	bound, err := k.Mint(ConnectToken{ClientID: 1, Addr: addr.String(), Expires: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+
+	c := NewConn(reliabletest.NewFaultConn(nil), addr, WithKeyring(k), WithAmplificationLimit(1))
+
+	// Invalid tokens are protocol anomalies, while tokens not bound to the address of our peer authenticate our peer
+	// without proving that it is reachable at its address.
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlToken)}, unbound[1:]...)))
+	require.EqualValues(t, 1, c.Stats().Anomalies)
+
+	_, ok := c.Token()
+	require.False(t, ok)
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlToken)}, unbound...)))
+
+	token, ok := c.Token()
+	require.True(t, ok)
+	require.EqualValues(t, 1, token.ClientID)
+	require.False(t, c.validated)
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlToken)}, bound...)))
+	require.True(t, c.validated)
+}
+
+func TestKeyringRotateWithOverlap(t *testing.T) {
+	old := TokenKey{ID: 1, Secret: []byte("old secret")}
+	cur := TokenKey{ID: 2, Secret: []byte("current secret")}
+
+	k := NewKeyring(old)
+
+	minted, err := k.Mint(ConnectToken{Expires: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	// Tokens minted with keys rotated out with an overlap period verify until the overlap period elapses.
+
+	k.RotateWithOverlap(cur, time.Minute)
+	require.EqualValues(t, cur.ID, k.Current())
+
+	_, err = k.Verify(minted, nil, time.Now())
+	require.NoError(t, err)
+
+	_, err = k.Verify(minted, nil, time.Now().Add(time.Minute))
+	require.Equal(t, ErrTokenUnknownKey, err)
+
+	// Rotating again with a longer overlap period does not postpone retiring older keys, while rotating an older key
+	// back in cancels its retirement.
+
+	k.RotateWithOverlap(TokenKey{ID: 3, Secret: []byte("newest secret")}, time.Hour)
+
+	_, err = k.Verify(minted, nil, time.Now().Add(time.Minute))
+	require.Equal(t, ErrTokenUnknownKey, err)
+
+	k.Rotate(old)
+
+	_, err = k.Verify(minted, nil, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	require.Panics(t, func() { k.RotateWithOverlap(cur, -1) })
+}
+
+func TestConnReportsRotatedTokenKeys(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	old := TokenKey{ID: 1, Secret: []byte("old secret")}
+	cur := TokenKey{ID: 2, Secret: []byte("current secret")}
+
+	k := NewKeyring(old)
+
+	type report struct {
+		id      uint32
+		retired bool
+	}
+
+	var reports []report
+
+	c := NewConn(reliabletest.NewFaultConn(nil), addr, WithKeyring(k), withTokenKeyHook{fn: func(id uint32, retired bool) {
+		reports = append(reports, report{id: id, retired: retired})
+	}})
+
+	minted, err := k.Mint(ConnectToken{Expires: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlToken)}, minted...)))
+
+	id, ok := c.TokenKey()
+	require.True(t, ok)
+	require.EqualValues(t, old.ID, id)
+
+	c.checkTokenKey(time.Now())
+	require.Empty(t, reports)
+
+	// Rotating the key out and then retiring it are each reported once.
+
+	k.RotateWithOverlap(cur, time.Minute)
+
+	c.checkTokenKey(time.Now())
+	c.checkTokenKey(time.Now())
+	require.Equal(t, []report{{id: old.ID}}, reports)
+
+	c.checkTokenKey(time.Now().Add(time.Minute))
+	c.checkTokenKey(time.Now().Add(time.Minute))
+	require.Equal(t, []report{{id: old.ID}, {id: old.ID, retired: true}}, reports)
+
+	// Presenting a token minted with the current key stops reports until the key is rotated out again.
+
+	minted, err = k.Mint(ConnectToken{Expires: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	require.NoError(t, c.readControl(append([]byte{byte(controlToken)}, minted...)))
+
+	c.checkTokenKey(time.Now().Add(time.Minute))
+	require.Len(t, reports, 2)
+
+	k.Rotate(TokenKey{ID: 3, Secret: []byte("newest secret")})
+
+	c.checkTokenKey(time.Now())
+	require.Equal(t, report{id: cur.ID}, reports[2])
+}
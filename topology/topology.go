@@ -0,0 +1,130 @@
+// Package topology wires up simulated peers running reliable Endpoints over an in-memory network, where every link
+// between two peers may be given its own loss and latency.
+package topology
+
+import (
+	"github.com/lithdew/reliable"
+	"github.com/lithdew/reliable/reliabletest"
+	"net"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Peers int   // number of peers
+	Seed  int64 // seed for the source of randomness deciding which datagrams are lost
+
+	Loss    [][]float64       // Loss[i][j] is the probability a datagram sent from peer i to peer j is lost, if set
+	Latency [][]time.Duration // Latency[i][j] is how long it takes a datagram sent from peer i to reach peer j, if set
+
+	Options func(peer int) []reliable.EndpointOption // options for the endpoint of each peer, if set
+}
+
+type Peer struct {
+	Index    int
+	Conn     *reliabletest.PacketConn
+	Endpoint *reliable.Endpoint
+}
+
+func (p *Peer) Addr() net.Addr {
+	return p.Conn.LocalAddr()
+}
+
+type Topology struct {
+	Network *reliabletest.Network
+	Peers   []*Peer
+
+	wg sync.WaitGroup
+}
+
+// New creates peers on a simulated network according to cfg. The peers do not start listening for packets until
+// Start is called.
+func New(cfg Config) *Topology {
+	if cfg.Loss != nil && !isSquare(len(cfg.Loss), cfg.Peers, func(i int) int { return len(cfg.Loss[i]) }) {
+		panic("loss matrix must be of size peers x peers")
+	}
+	if cfg.Latency != nil && !isSquare(len(cfg.Latency), cfg.Peers, func(i int) int { return len(cfg.Latency[i]) }) {
+		panic("latency matrix must be of size peers x peers")
+	}
+
+	t := &Topology{Network: reliabletest.NewNetwork(cfg.Seed), Peers: make([]*Peer, cfg.Peers)}
+
+	for i := range t.Peers {
+		conn := t.Network.Listen()
+
+		var opts []reliable.EndpointOption
+		if cfg.Options != nil {
+			opts = cfg.Options(i)
+		}
+
+		t.Peers[i] = &Peer{Index: i, Conn: conn, Endpoint: reliable.NewEndpoint(conn, opts...)}
+	}
+
+	for i, from := range t.Peers {
+		for j, to := range t.Peers {
+			var link reliabletest.Link
+			if cfg.Loss != nil {
+				link.Loss = cfg.Loss[i][j]
+			}
+			if cfg.Latency != nil {
+				link.Latency = cfg.Latency[i][j]
+			}
+			t.Network.SetLink(from.Addr(), to.Addr(), link)
+		}
+	}
+
+	return t
+}
+
+func isSquare(rows, size int, cols func(i int) int) bool {
+	if rows != size {
+		return false
+	}
+	for i := 0; i < rows; i++ {
+		if cols(i) != size {
+			return false
+		}
+	}
+	return true
+}
+
+// Start has every peer start listening for packets.
+func (t *Topology) Start() {
+	for _, peer := range t.Peers {
+		peer := peer
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			peer.Endpoint.Listen()
+		}()
+	}
+}
+
+// Each calls fn for every peer concurrently, waiting for all calls to return.
+func (t *Topology) Each(fn func(peer *Peer)) {
+	var wg sync.WaitGroup
+	wg.Add(len(t.Peers))
+
+	for _, peer := range t.Peers {
+		peer := peer
+
+		go func() {
+			defer wg.Done()
+			fn(peer)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Close shuts down every peer.
+func (t *Topology) Close() {
+	for _, peer := range t.Peers {
+		_ = peer.Conn.Close()
+	}
+	for _, peer := range t.Peers {
+		_ = peer.Endpoint.Close()
+	}
+	t.wg.Wait()
+}
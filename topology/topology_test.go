@@ -0,0 +1,97 @@
+package topology
+
+import (
+	"fmt"
+	"github.com/lithdew/reliable"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func matrix(peers int, fill func(i, j int) float64) [][]float64 {
+	m := make([][]float64, peers)
+	for i := range m {
+		m[i] = make([]float64, peers)
+		for j := range m[i] {
+			m[i][j] = fill(i, j)
+		}
+	}
+	return m
+}
+
+func TestTopologyDeliversReliablePacketsOverLossyLinks(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	const peers = 4
+	const count = 64
+
+	received := make([]uint64, peers)
+
+	topo := New(Config{
+		Peers: peers,
+		Loss:  matrix(peers, func(i, j int) float64 { return 0.1 }),
+		Options: func(peer int) []reliable.EndpointOption {
+			return []reliable.EndpointOption{
+				reliable.WithUpdatePeriod(5 * time.Millisecond),
+				reliable.WithResendTimeout(10 * time.Millisecond),
+				reliable.WithPacketHandler(func(net.Addr, uint16, []byte) { atomic.AddUint64(&received[peer], 1) }),
+			}
+		},
+	})
+
+	topo.Start()
+	defer topo.Close()
+
+	topo.Each(func(peer *Peer) {
+		for _, other := range topo.Peers {
+			if other == peer {
+				continue
+			}
+			for i := 0; i < count; i++ {
+				require.NoError(t, peer.Endpoint.WriteReliablePacket([]byte("hello"), other.Addr()))
+			}
+		}
+	})
+
+	require.Eventually(t, func() bool {
+		for i := range received {
+			if atomic.LoadUint64(&received[i]) < count*(peers-1) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewPanicsOnMismatchedMatrix(t *testing.T) {
+	require.Panics(t, func() { New(Config{Peers: 2, Loss: [][]float64{{0, 0}}}) })
+	require.Panics(t, func() { New(Config{Peers: 2, Latency: [][]time.Duration{{0}, {0}}}) })
+}
+
+func Example() {
+	received := make(chan string, 1)
+
+	topo := New(Config{
+		Peers:   2,
+		Latency: [][]time.Duration{{0, 5 * time.Millisecond}, {5 * time.Millisecond, 0}},
+		Options: func(peer int) []reliable.EndpointOption {
+			return []reliable.EndpointOption{
+				reliable.WithPacketHandler(func(_ net.Addr, _ uint16, buf []byte) { received <- string(buf) }),
+			}
+		},
+	})
+
+	topo.Start()
+	defer topo.Close()
+
+	if err := topo.Peers[0].Endpoint.WriteReliablePacket([]byte("hello"), topo.Peers[1].Addr()); err != nil {
+		panic(err)
+	}
+
+	fmt.Println(<-received)
+
+	// Output: hello
+}
@@ -0,0 +1,37 @@
+package reliable
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+)
+
+// ErrTTLUnsupported is returned when setting the TTL of datagrams written to a socket that is not a UDP socket.
+var ErrTTLUnsupported = errors.New("ttl can only be set on udp sockets")
+
+// SetTTL sets the IP TTL, or hop limit for IPv6, of all datagrams this endpoint writes from here on.
+func (e *Endpoint) SetTTL(ttl int) error {
+	return setTTL(e.conn, ttl)
+}
+
+func setTTL(conn net.PacketConn, ttl int) error {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		return ErrTTLUnsupported
+	}
+
+	if isIPv4(udp.LocalAddr()) {
+		if err := ipv4.NewPacketConn(udp).SetTTL(ttl); err != nil {
+			return fmt.Errorf("failed to set ttl: %w", err)
+		}
+		return nil
+	}
+
+	if err := ipv6.NewPacketConn(udp).SetHopLimit(ttl); err != nil {
+		return fmt.Errorf("failed to set hop limit: %w", err)
+	}
+
+	return nil
+}
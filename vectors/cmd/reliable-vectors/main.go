@@ -0,0 +1,45 @@
+// Command reliable-vectors writes golden test vectors for the wire format of packets to stdout, or verifies vectors
+// read from a file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/lithdew/reliable/vectors"
+	"os"
+)
+
+func main() {
+	verify := flag.String("verify", "", "path to vectors to verify rather than generate")
+	flag.Parse()
+
+	if err := run(*verify); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(path string) error {
+	if path == "" {
+		return vectors.Write(os.Stdout, vectors.Generate())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open vectors: %w", err)
+	}
+	defer f.Close()
+
+	vs, err := vectors.Read(f)
+	if err != nil {
+		return err
+	}
+
+	if err := vectors.Verify(vs); err != nil {
+		return err
+	}
+
+	fmt.Printf("verified %d vectors\n", len(vs))
+
+	return nil
+}
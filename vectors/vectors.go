@@ -0,0 +1,201 @@
+// Package vectors generates and verifies golden, byte-level test vectors for the wire format of packets, such that
+// implementations in other languages and future versions of this package may prove byte-for-byte compatibility.
+//
+// Vectors currently cover packet headers of reliable packets, unreliable packets, standalone acks, control packets,
+// and fragments, as well as malformed packets that must be rejected.
+package vectors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/lithdew/reliable"
+	"io"
+	"math"
+)
+
+type Vector struct {
+	Name    string
+	Header  reliable.PacketHeader // decoded header, or the zero header should the vector be invalid
+	Payload []byte                // payload following the header
+	Wire    []byte                // encoded packet, being the header followed by the payload
+	Invalid bool                  // whether or not decoding the header in Wire must fail
+}
+
+type jsonVector struct {
+	Name      string `json:"name"`
+	Sequence  uint16 `json:"sequence"`
+	ACK       uint16 `json:"ack"`
+	ACKBits   uint32 `json:"ack_bits"`
+	Unordered bool   `json:"unordered"`
+	Empty     bool   `json:"empty"`
+
+	Fragment      bool   `json:"fragment,omitempty"`
+	FragmentID    uint16 `json:"fragment_id,omitempty"`
+	FragmentIndex uint8  `json:"fragment_index,omitempty"`
+	FragmentLast  uint8  `json:"fragment_last,omitempty"`
+
+	Payload string `json:"payload"`
+	Wire    string `json:"wire"`
+	Invalid bool   `json:"invalid,omitempty"`
+}
+
+// MarshalJSON encodes v with its payload and wire bytes hex-encoded.
+func (v Vector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonVector{
+		Name:      v.Name,
+		Sequence:  v.Header.Sequence,
+		ACK:       v.Header.ACK,
+		ACKBits:   v.Header.ACKBits,
+		Unordered: v.Header.Unordered,
+		Empty:     v.Header.Empty,
+
+		Fragment:      v.Header.Fragment,
+		FragmentID:    v.Header.FragmentID,
+		FragmentIndex: v.Header.FragmentIndex,
+		FragmentLast:  v.Header.FragmentLast,
+
+		Payload: hex.EncodeToString(v.Payload),
+		Wire:    hex.EncodeToString(v.Wire),
+		Invalid: v.Invalid,
+	})
+}
+
+func (v *Vector) UnmarshalJSON(buf []byte) error {
+	var j jsonVector
+	if err := json.Unmarshal(buf, &j); err != nil {
+		return err
+	}
+
+	payload, err := hex.DecodeString(j.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to decode payload of vector %q: %w", j.Name, err)
+	}
+
+	wire, err := hex.DecodeString(j.Wire)
+	if err != nil {
+		return fmt.Errorf("failed to decode wire bytes of vector %q: %w", j.Name, err)
+	}
+
+	header := reliable.PacketHeader{Sequence: j.Sequence, ACK: j.ACK, ACKBits: j.ACKBits, Unordered: j.Unordered, Empty: j.Empty}
+	header.Fragment, header.FragmentID, header.FragmentIndex, header.FragmentLast = j.Fragment, j.FragmentID, j.FragmentIndex, j.FragmentLast
+
+	*v = Vector{
+		Name:    j.Name,
+		Header:  header,
+		Payload: payload,
+		Wire:    wire,
+		Invalid: j.Invalid,
+	}
+
+	return nil
+}
+
+// Generate returns a deterministic set of vectors covering every encoding of the ack and ack bitset of a packet
+// header, along with malformed packets.
+func Generate() []Vector {
+	var vs []Vector
+
+	valid := func(name string, header reliable.PacketHeader, payload []byte) {
+		wire := append(header.AppendTo(nil), payload...)
+		vs = append(vs, Vector{Name: name, Header: header, Payload: payload, Wire: wire})
+	}
+
+	invalid := func(name string, wire []byte) {
+		vs = append(vs, Vector{Name: name, Wire: wire, Invalid: true})
+	}
+
+	bitsets := []uint32{0x00000000, 0xFFFFFFFF, 0x000000FF, 0x0000FF00, 0x00FF0000, 0xFF000000, 0x12345678}
+	sequences := []uint16{0, 1, 255, 256, math.MaxUint16}
+	distances := []uint16{0, 1, 255, 256, math.MaxUint16}
+
+	for _, s := range sequences {
+		for _, d := range distances {
+			for _, bits := range bitsets {
+				name := fmt.Sprintf("reliable/seq=%d/ack=seq-%d/ack_bits=%08x", s, d, bits)
+				valid(name, reliable.PacketHeader{Sequence: s, ACK: s - d, ACKBits: bits}, []byte("payload"))
+			}
+		}
+	}
+
+	for _, ack := range []uint16{0, 255, 256, math.MaxUint16} {
+		for _, bits := range bitsets {
+			name := fmt.Sprintf("unreliable/ack=%d/ack_bits=%08x", ack, bits)
+			valid(name, reliable.PacketHeader{ACK: ack, ACKBits: bits, Unordered: true}, []byte("payload"))
+
+			name = fmt.Sprintf("ack/ack=%d/ack_bits=%08x", ack, bits)
+			valid(name, reliable.PacketHeader{ACK: ack, ACKBits: bits, Unordered: true, Empty: true}, nil)
+		}
+	}
+
+	valid("reliable/empty_payload", reliable.PacketHeader{Sequence: 7, ACK: 3, ACKBits: 0x0F}, nil)
+
+	// A close notification is a control packet of type 0x00 carrying a 16-bit code followed by a reason.
+
+	// Fragments of a payload carry a fragment header of a 16-bit payload id, the index of the fragment, and the index of
+	// the last fragment of the payload, following the ack bitset.
+
+	valid("fragment/reliable", reliable.PacketHeader{Sequence: 7, ACK: 3, ACKBits: 0x0F, Fragment: true, FragmentID: 513, FragmentIndex: 1, FragmentLast: 2}, []byte("payload"))
+	valid("fragment/unreliable", reliable.PacketHeader{ACK: 41, ACKBits: 0xFFFFFFFF, Unordered: true, Fragment: true, FragmentID: math.MaxUint16, FragmentIndex: 255, FragmentLast: 255}, []byte("payload"))
+
+	valid("control/close", reliable.PacketHeader{ACK: 41, ACKBits: 0xFFFFFFFF, Unordered: true, Empty: true}, append([]byte{0x00, 0x01, 0x2C}, "kicked: server restart"...))
+
+	invalid("malformed/empty", []byte{})
+	invalid("malformed/truncated_flag_and_sequence", []byte{0x00, 0x00})
+	invalid("malformed/missing_fragment_header", []byte{byte(reliable.FlagFragment | reliable.FlagACKEncoded), 0x00, 0x01, 0x00, 0x00, 0x00})
+	invalid("malformed/fragment_index_past_last", []byte{byte(reliable.FlagFragment | reliable.FlagACKEncoded), 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01})
+	invalid("malformed/fragmented_control_packet", []byte{byte(reliable.FlagFragment | reliable.FlagEmpty | reliable.FlagUnordered | reliable.FlagACKEncoded), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	invalid("malformed/missing_ack", []byte{0x00, 0x00, 0x01})
+	invalid("malformed/missing_encoded_ack", []byte{byte(reliable.FlagACKEncoded), 0x00, 0x01})
+	invalid("malformed/missing_ack_bits", []byte{byte(reliable.FlagACKEncoded | reliable.FlagA | reliable.FlagB), 0x00, 0x01, 0x00, 0xAA})
+
+	return vs
+}
+
+// Verify checks that every vector encodes and decodes byte-for-byte the way it describes, returning an error for
+// the first vector that does not.
+func Verify(vs []Vector) error {
+	for _, v := range vs {
+		header, payload, err := reliable.UnmarshalPacketHeader(v.Wire)
+
+		if v.Invalid {
+			if err == nil {
+				return fmt.Errorf("vector %q: expected decoding to fail, but it decoded to %+v", v.Name, header)
+			}
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("vector %q: failed to decode: %w", v.Name, err)
+		}
+		if header != v.Header {
+			return fmt.Errorf("vector %q: decoded header %+v, expected %+v", v.Name, header, v.Header)
+		}
+		if !bytes.Equal(payload, v.Payload) {
+			return fmt.Errorf("vector %q: decoded payload %x, expected %x", v.Name, payload, v.Payload)
+		}
+
+		if wire := append(v.Header.AppendTo(nil), v.Payload...); !bytes.Equal(wire, v.Wire) {
+			return fmt.Errorf("vector %q: encoded %x, expected %x", v.Name, wire, v.Wire)
+		}
+	}
+
+	return nil
+}
+
+// Write writes vs to w as indented JSON.
+func Write(w io.Writer, vs []Vector) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(vs)
+}
+
+// Read reads vectors written using Write from r.
+func Read(r io.Reader) ([]Vector, error) {
+	var vs []Vector
+	if err := json.NewDecoder(r).Decode(&vs); err != nil {
+		return nil, fmt.Errorf("failed to decode vectors: %w", err)
+	}
+	return vs, nil
+}
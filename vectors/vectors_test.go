@@ -0,0 +1,42 @@
+package vectors
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestGoldenVectors(t *testing.T) {
+	f, err := os.Open("testdata/vectors.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	golden, err := Read(f)
+	require.NoError(t, err)
+	require.NoError(t, Verify(golden))
+
+	// The wire format must not change without the golden vectors being regenerated.
+
+	var expected, actual bytes.Buffer
+	require.NoError(t, Write(&expected, golden))
+	require.NoError(t, Write(&actual, Generate()))
+	require.Equal(t, expected.String(), actual.String())
+}
+
+func TestVerifyCatchesMismatches(t *testing.T) {
+	vs := Generate()
+
+	v := vs[0]
+	v.Wire = append([]byte(nil), v.Wire...)
+	v.Wire[len(v.Wire)-1] ^= 0xFF
+	require.Error(t, Verify([]Vector{v}))
+
+	v = vs[0]
+	v.Header.ACK++
+	require.Error(t, Verify([]Vector{v}))
+
+	v = vs[len(vs)-1]
+	v.Invalid = false
+	require.Error(t, Verify([]Vector{v}))
+}
@@ -0,0 +1,278 @@
+package reliable
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// writerBatchSize is the max number of packets a Writer stages before flushing them out to its conn.
+const writerBatchSize = 32
+
+// Writer stages packets written by a single goroutine, and writes them out to its conn in batches. A batch of
+// reliable packets takes a single turn to write rather than one turn per packet, such that many producers writing
+// to the same peer do not contend over the conn for every packet.
+//
+// A Writer is not safe for concurrent use. Each producer goroutine should get its own using Conn.Writer.
+type Writer struct {
+	c      *Conn
+	staged []stagedPacket
+
+	// flushed is the header of the last fragment flushed out of a reliable payload whose remaining fragments have yet
+	// to be, or a header without its fragment flag set should there be no such payload.
+	flushed PacketHeader
+}
+
+type stagedPacket struct {
+	header PacketHeader // whether or not the packet is unordered, and its fragment header should it be a fragment
+	buf    *Buffer
+}
+
+// Writer returns a new Writer staging packets to be written to c.
+func (c *Conn) Writer() *Writer {
+	return &Writer{c: c}
+}
+
+// WriteReliablePacket stages a copy of buf to be written as a reliable packet, flushing all staged packets should
+// there be a full batch of them. Payloads too large to fit in a single packet are written as with OversizeFlush.
+func (w *Writer) WriteReliablePacket(buf []byte) error {
+	return w.stage(true, buf, OversizeFlush)
+}
+
+// WriteUnreliablePacket stages a copy of buf to be written as an unreliable packet, flushing all staged packets
+// should there be a full batch of them. Payloads too large to fit in a single packet are written as with
+// OversizeFlush.
+func (w *Writer) WriteUnreliablePacket(buf []byte) error {
+	return w.stage(false, buf, OversizeFlush)
+}
+
+func (w *Writer) stage(reliable bool, buf []byte, policy OversizePolicy) error {
+	if w.c.fragmented(len(buf)) {
+		switch policy {
+		case OversizeError:
+			return ErrPacketTooLarge
+		case OversizeFragment:
+			return w.stageFragments(reliable, buf)
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return w.c.writePacket(context.Background(), reliable, buf)
+	}
+
+	return w.stagePacket(PacketHeader{Unordered: !reliable}, buf)
+}
+
+// stageFragments splits buf into fragments of the fragment size, and stages each of them as its own packet. Should a
+// fragment fail to be staged, the fragments of buf that are still staged are unstaged, and our peer is told to drop
+// those of them that were already flushed out.
+func (w *Writer) stageFragments(reliable bool, buf []byte) error {
+	header, count, err := w.c.nextFragmentHeader(reliable, len(buf))
+	if err != nil {
+		return err
+	}
+
+	size := w.c.fragmentSize
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		header.FragmentIndex = uint8(i)
+		if err := w.stagePacket(header, buf[i*size:end]); err != nil {
+			w.unstageFragments(header.FragmentID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unstageFragments unstages every fragment of the payload with the given id that is still staged, aborting the
+// payload should some of its fragments have already been flushed out.
+func (w *Writer) unstageFragments(id uint16) {
+	for n := len(w.staged); n > 0; n-- {
+		p := w.staged[n-1]
+		if !p.header.Fragment || p.header.FragmentID != id {
+			break
+		}
+		w.c.pool.Put(p.buf)
+		w.staged[n-1].buf = nil
+		w.staged = w.staged[:n-1]
+	}
+
+	if w.flushed.Fragment && w.flushed.FragmentID == id {
+		w.c.abortFragments(id)
+		w.flushed = PacketHeader{}
+	}
+}
+
+func (w *Writer) stagePacket(header PacketHeader, buf []byte) error {
+	size := maxPacketHeaderSize + len(buf)
+	if header.Fragment {
+		size += fragmentHeaderSize
+	}
+
+	if !fits(w.c.pool, size) {
+		return ErrPacketTooLarge
+	}
+
+	b := w.c.pool.Get(size)
+	if b == nil {
+		return ErrBuffersExhausted
+	}
+	b.B = append(b.B, buf...)
+
+	w.staged = append(w.staged, stagedPacket{header: header, buf: b})
+	if len(w.staged) < writerBatchSize {
+		return nil
+	}
+
+	return w.Flush()
+}
+
+// Flush writes out all staged packets in the order they were staged. Should a packet fail to be written, the
+// packets staged after it are dropped, and our peer is told to drop the fragments it was sent of a reliable payload
+// whose remaining fragments were dropped.
+func (w *Writer) Flush() error {
+	defer func() {
+		for i := range w.staged {
+			w.c.pool.Put(w.staged[i].buf)
+			w.staged[i].buf = nil
+		}
+		w.staged = w.staged[:0]
+	}()
+
+	if len(w.staged) == 0 {
+		return nil
+	}
+
+	n, err := w.c.writeStaged(w.staged)
+
+	for _, p := range w.staged[:n] {
+		if p.header.Fragment && !p.header.Unordered {
+			w.flushed = p.header
+		}
+		if p.header.Fragment && p.header.FragmentIndex == p.header.FragmentLast {
+			w.flushed = PacketHeader{}
+		}
+	}
+
+	if err != nil && w.flushed.Fragment {
+		w.c.abortFragments(w.flushed.FragmentID)
+		w.flushed = PacketHeader{}
+	}
+
+	return err
+}
+
+// writeStaged writes out packets in order, returning how many of them were written before one failed to be. A reliable
+// packet that failed to be transmitted counts as written, as it was queued to be resent.
+func (c *Conn) writeStaged(packets []stagedPacket) (int, error) {
+	start := time.Now()
+
+	size := 0
+	for _, p := range packets {
+		size += len(p.buf.B)
+	}
+
+	if c.writeDeadlineExceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if c.breakerOpen() {
+		return 0, ErrCircuitOpen
+	}
+
+	if allowed, disconnect := c.chargeQuota(true, size); !allowed {
+		if disconnect {
+			c.Close()
+			return 0, io.EOF
+		}
+		return 0, ErrQuotaExceeded
+	}
+
+	c.takeWriteTurn()
+
+	if !c.throttle(size) {
+		return 0, io.EOF
+	}
+
+	if !c.enter() {
+		return 0, io.EOF
+	}
+	defer c.leave()
+
+	c.mu.Lock()
+	ticket := c.takeTicket()
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.releaseTicket(ticket)
+		c.mu.Unlock()
+	}()
+
+	for i, p := range packets {
+		reliable := !p.header.Unordered
+
+		n := len(p.buf.B)
+		if p.header.Fragment {
+			n += fragmentHeaderSize
+		}
+
+		b, err := c.getBuffer(n)
+		if err != nil {
+			return i, err
+		}
+
+		var (
+			idx     uint16
+			ack     uint16
+			ackBits uint32
+		)
+
+		c.mu.Lock()
+		if reliable {
+			idx, ack, ackBits, err = c.waitForTurn(context.Background(), ticket)
+		} else {
+			ack, ackBits = c.nextAckDetails()
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			c.pool.Put(b)
+			return i, err
+		}
+
+		turn := time.Now()
+
+		c.trackAcked(ack)
+
+		header := p.header
+		header.Sequence, header.ACK, header.ACKBits = idx, ack, ackBits
+
+		if err := c.writeBuffer(b, header, p.buf.B); err != nil {
+			if reliable {
+				return i + 1, err
+			}
+			return i, err
+		}
+
+		// Fragmented payloads are counted as written once their last fragment is.
+
+		if !header.Fragment || header.FragmentIndex == header.FragmentLast {
+			c.trackWritten(reliable)
+		}
+
+		if reliable {
+			c.trackLifecycleWrite(turn.Sub(start), time.Since(turn))
+		}
+	}
+
+	return len(packets), nil
+}